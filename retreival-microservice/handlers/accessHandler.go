@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/url"
+
+	"retreival/models"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// AccessHandler shares files, tags and folders with other users and groups.
+// Routes with :id work on a file, routes with :name on a tag and routes with
+// :folderId on a folder.
+type AccessHandler struct {
+	access *services.AccessService
+	log    *zap.Logger
+}
+
+func NewAccessHandler(access *services.AccessService) *AccessHandler {
+	return &AccessHandler{access: access, log: utils.GetLogger()}
+}
+
+func (ah *AccessHandler) Grant(c *fiber.Ctx) error {
+	target, ok := accessTarget(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file, tag or folder"})
+	}
+	var request models.AccessRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	entry, err := ah.access.Grant(ownerID, target, request)
+	if err != nil {
+		return ah.accessError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"entry": entry})
+}
+
+func (ah *AccessHandler) List(c *fiber.Ctx) error {
+	target, ok := accessTarget(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file, tag or folder"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	entries, err := ah.access.List(ownerID, target)
+	if err != nil {
+		return ah.accessError(c, err)
+	}
+	return c.JSON(fiber.Map{"entries": entries})
+}
+
+func (ah *AccessHandler) Revoke(c *fiber.Ctx) error {
+	target, ok := accessTarget(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file, tag or folder"})
+	}
+	entryID, err := c.ParamsInt("entryId")
+	if err != nil || entryID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid entry id"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	if err := ah.access.Revoke(ownerID, target, uint(entryID)); err != nil {
+		return ah.accessError(c, err)
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+func (ah *AccessHandler) accessError(c *fiber.Ctx, err error) error {
+	switch err {
+	case utils.ErrInvalidPermission, utils.ErrInvalidPrincipal, utils.ErrShareWithSelf:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case utils.ErrUserNotFound, utils.ErrGroupNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return folderCommandError(c, ah.log, err)
+	}
+}
+
+func accessTarget(c *fiber.Ctx) (models.AccessTarget, bool) {
+	if name := c.Params("name"); name != "" {
+		tag, err := url.PathUnescape(name)
+		return models.AccessTarget{Tag: tag}, err == nil && tag != ""
+	}
+	if c.Params("folderId") != "" {
+		folderID, err := c.ParamsInt("folderId")
+		return models.AccessTarget{FolderID: uint(folderID)}, err == nil && folderID > 0
+	}
+	fileID, err := c.ParamsInt("id")
+	return models.AccessTarget{FileID: uint(fileID)}, err == nil && fileID > 0
+}
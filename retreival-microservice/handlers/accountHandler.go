@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+	"retreival/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type AccountHandler struct {
+	verification *services.VerificationService
+	userRepo     *repositories.UserRepository
+	validator    *validation.Validator
+	log          *zap.Logger
+}
+
+func NewAccountHandler(verification *services.VerificationService, userRepo *repositories.UserRepository, validator *validation.Validator) *AccountHandler {
+	if validator == nil {
+		validator = defaultValidator
+	}
+	return &AccountHandler{
+		verification: verification,
+		userRepo:     userRepo,
+		validator:    validator,
+		log:          utils.GetLogger(),
+	}
+}
+
+func (ah *AccountHandler) VerifyEmail(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		var body struct {
+			Token string `json:"token"`
+		}
+		_ = c.BodyParser(&body)
+		token = body.Token
+	}
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Token is required"})
+	}
+
+	if _, err := ah.verification.VerifyEmail(token); err != nil {
+		return ah.tokenError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Email verified successfully"})
+}
+
+func (ah *AccountHandler) ResendVerification(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(uint)
+
+	user, err := ah.userRepo.GetUserByID(userID)
+	if err != nil || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+	if user.EmailVerified {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Email already verified"})
+	}
+
+	if err := ah.verification.SendVerificationEmail(user); err != nil {
+		ah.log.Error("Failed to send verification email", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Failed to send verification email"})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Verification email sent"})
+}
+
+func (ah *AccountHandler) ForgotPassword(c *fiber.Ctx) error {
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	if err := ah.validator.ValidateEmail("email", request.Email); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if err := ah.verification.RequestPasswordReset(request.Email); err != nil {
+		ah.log.Error("Failed to request password reset", zap.Error(err))
+	}
+
+	// Same answer whether or not the email is known.
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "If the email is registered, a reset link has been sent"})
+}
+
+func (ah *AccountHandler) ResetPassword(c *fiber.Ctx) error {
+	var request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&request); err != nil || request.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+
+	if err := ah.validator.ValidatePassword("password", request.Password, "", ""); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if err := ah.verification.ResetPassword(request.Token, request.Password); err != nil {
+		if err == utils.ErrPasswordRequired {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Password is required"})
+		}
+		return ah.tokenError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Password reset successfully"})
+}
+
+func (ah *AccountHandler) tokenError(c *fiber.Ctx, err error) error {
+	switch err {
+	case utils.ErrTokenExpired:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Token expired"})
+	case utils.ErrTokenAlreadyUsed:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Token already used"})
+	case utils.ErrInvalidToken, utils.ErrInvalidTokenClaims:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid token"})
+	default:
+		ah.log.Error("Failed to redeem token", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Request failed"})
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"retreival/models"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type APIKeyHandler struct {
+	apiKeys *services.APIKeyService
+	log     *zap.Logger
+}
+
+func NewAPIKeyHandler(apiKeys *services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{apiKeys: apiKeys, log: utils.GetLogger()}
+}
+
+func (ah *APIKeyHandler) CreateKey(c *fiber.Ctx) error {
+	var request models.APIKeyRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	rawKey, key, err := ah.apiKeys.CreateKey(userID, request)
+	if err != nil {
+		switch err {
+		case utils.ErrAPIKeyNameRequired, utils.ErrInvalidAPIKeyScope, utils.ErrAPIKeyExpiryInPast:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+		default:
+			ah.log.Error("Failed to create api key", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Failed to create api key"})
+		}
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"api_key": models.ConvertAPIKeyToResponse(*key),
+		"key":     rawKey,
+	})
+}
+
+func (ah *APIKeyHandler) ListKeys(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(uint)
+
+	keys, err := ah.apiKeys.ListKeys(userID)
+	if err != nil {
+		ah.log.Error("Failed to list api keys", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Failed to list api keys"})
+	}
+
+	response := make([]models.APIKeyResponse, len(keys))
+	for i, key := range keys {
+		response[i] = models.ConvertAPIKeyToResponse(key)
+	}
+	return c.JSON(fiber.Map{"api_keys": response})
+}
+
+func (ah *APIKeyHandler) RevokeKey(c *fiber.Ctx) error {
+	keyID, err := c.ParamsInt("id")
+	if err != nil || keyID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid api key id"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	if err := ah.apiKeys.RevokeKey(userID, uint(keyID)); err != nil {
+		if err == utils.ErrAPIKeyNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "API key not found"})
+		}
+		ah.log.Error("Failed to revoke api key", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Failed to revoke api key"})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"errors"
+	"math"
+	"strconv"
+
 	"retreival/utils"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,15 +20,28 @@ func (uh *UserHandler) Login(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
 	}
 
-	token, err := uh.UserService.Login(loginRequest.Identifier, loginRequest.Password)
+	if err := uh.validator().ValidateLogin(loginRequest.Identifier, loginRequest.Password); err != nil {
+		return validationFailed(c, err)
+	}
+
+	result, err := uh.UserService.Login(loginRequest.Identifier, loginRequest.Password, c.IP())
 	if err != nil {
-		if err == utils.ErrUserNotFound {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
-		} else if err == utils.ErrIncorrectPassword {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Incorrect password"})
+		var lockout *utils.LockoutError
+		if errors.As(err, &lockout) {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(lockout.RetryAfter.Seconds()))))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"message": "Too many failed login attempts, try again later"})
+		} else if err == utils.ErrInvalidCredentials {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid credentials"})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Login failed"})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"token": token})
+	if result.TwoFactorRequired {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"two_factor_required": true,
+			"challenge_token":     result.ChallengeToken,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"token": result.Token})
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"retreival/handlers"
 	"retreival/models"
@@ -45,7 +46,14 @@ func TestUserHandler_Login(t *testing.T) {
 	userRepository := repositories.NewUserRepository(db)
 	secretKey := "702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b"
 	jwtService := services.NewJWTService(secretKey)
-	userService := services.NewUserService(*userRepository, utils.GetLogger(), *jwtService)
+	throttle := services.NewLoginThrottleService(repositories.NewInMemoryLoginAttemptStore(), services.LockoutPolicy{
+		AccountMaxAttempts: 3,
+		IPMaxAttempts:      100,
+		LockoutDuration:    time.Minute,
+		DelayAfter:         3,
+		Window:             time.Minute,
+	})
+	userService := services.NewUserService(*userRepository, utils.GetLogger(), *jwtService, throttle, repositories.NewUserTokenRepository(db))
 
 	app := fiber.New()
 	userHandler := handlers.UserHandler{UserService: userService}
@@ -99,7 +107,7 @@ func TestUserHandler_Login(t *testing.T) {
 		assert.Equal(t, "Invalid request body", responseBody["message"])
 	})
 
-	t.Run("User not found - 404 Not Found", func(t *testing.T) {
+	t.Run("User not found - 401 Unauthorized", func(t *testing.T) {
 		loginData := map[string]string{"identifier": "nonexistentuser", "password": "password"}
 		requestBody, _ := json.Marshal(loginData)
 		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(requestBody))
@@ -111,7 +119,7 @@ func TestUserHandler_Login(t *testing.T) {
 		}
 		defer resp.Body.Close()
 
-		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 
 		var responseBody map[string]interface{}
 		err = json.NewDecoder(resp.Body).Decode(&responseBody)
@@ -119,7 +127,7 @@ func TestUserHandler_Login(t *testing.T) {
 			t.Fatalf("Failed to decode response body: %s", err.Error())
 		}
 
-		assert.Equal(t, "User not found", responseBody["message"])
+		assert.Equal(t, "Invalid credentials", responseBody["message"])
 	})
 
 	t.Run("Incorrect password - 401 Unauthorized", func(t *testing.T) {
@@ -142,6 +150,37 @@ func TestUserHandler_Login(t *testing.T) {
 			t.Fatalf("Failed to decode response body: %s", err.Error())
 		}
 
-		assert.Equal(t, "Incorrect password", responseBody["message"])
+		assert.Equal(t, "Invalid credentials", responseBody["message"])
+	})
+
+	t.Run("Repeated failures - 429 Too Many Requests", func(t *testing.T) {
+		loginData := map[string]string{"identifier": "lockeduser", "password": "wrong"}
+		requestBody, _ := json.Marshal(loginData)
+
+		// Unknown identifiers share one counter, and nonexistentuser already
+		// counted the first failure.
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(requestBody))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Request failed: %s", err.Error())
+			}
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(requestBody))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err.Error())
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.NotEmpty(t, resp.Header.Get("Retry-After"))
 	})
 }
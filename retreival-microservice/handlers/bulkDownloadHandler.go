@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"bufio"
+
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type BulkDownloadHandler struct {
+	downloads *services.BulkDownloadService
+	log       *zap.Logger
+}
+
+func NewBulkDownloadHandler(downloads *services.BulkDownloadService) *BulkDownloadHandler {
+	return &BulkDownloadHandler{downloads: downloads, log: utils.GetLogger()}
+}
+
+// DownloadArchive streams the files matching the search filters as a ZIP
+// archive. Errors once the archive started can only cut it short.
+func (bh *BulkDownloadHandler) DownloadArchive(c *fiber.Ctx) error {
+	query, err := parseFileQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(query.Tags) == 0 && query.Name == "" && query.Query == "" && !query.SharedWithMe {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Either 'tags', 'name', 'q' or 'shared_with_me' is required"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	files, err := bh.downloads.Prepare(ownerID, query)
+	switch err {
+	case nil:
+	case utils.ErrFileNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "No files match"})
+	case utils.ErrDownloadTooLarge:
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	case utils.ErrInvalidFileQuery:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file request"})
+	case utils.ErrStoreUnavailable:
+		return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{"error": "Store did not answer in time"})
+	default:
+		bh.log.Error("Failed to list files for archive", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "File request failed"})
+	}
+
+	c.Attachment("files.zip")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := bh.downloads.WriteArchive(w, ownerID, files); err != nil {
+			bh.log.Error("Bulk download cut short", zap.Uint("ownerID", ownerID), zap.Error(err))
+		}
+		_ = w.Flush()
+	})
+	return nil
+}
@@ -0,0 +1,49 @@
+package handlers_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"retreival/handlers"
+	"retreival/models"
+	"retreival/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticArchiveSource struct{}
+
+func (staticArchiveSource) ListArchiveFiles(ownerID uint, query *models.FileQuery, maxFiles int) ([]models.FileInfo, error) {
+	return []models.FileInfo{{ID: 1, FileName: "a.txt", FileSize: 5}}, nil
+}
+
+func (staticArchiveSource) DownloadFile(ownerID, fileID uint, version int) (*models.FileContent, error) {
+	return &models.FileContent{FileName: "a.txt", Content: []byte("hello")}, nil
+}
+
+func TestBulkDownloadHandler_DownloadArchive(t *testing.T) {
+	downloads := services.NewBulkDownloadService(staticArchiveSource{}, services.DefaultBulkDownloadLimits())
+	app := fiber.New()
+	app.Get("/file/archive", handlers.NewBulkDownloadHandler(downloads).DownloadArchive)
+
+	// Test case: a filter is required
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/file/archive", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	// Test case: the archive is streamed
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/file/archive?tags=work", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+
+	body, _ := io.ReadAll(resp.Body)
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	assert.NoError(t, err)
+	assert.Len(t, zr.File, 1)
+}
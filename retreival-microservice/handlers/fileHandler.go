@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"retreival/models"
 	"retreival/services"
 	"retreival/utils"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
@@ -12,30 +18,113 @@ import (
 
 type FileHandler struct {
 	fileService *services.FileService
+	quota       *services.QuotaService
 	log         *zap.Logger
 }
 
-func NewFileHandler(fileService *services.FileService) *FileHandler {
+// NewFileHandler creates the handler. quota may be nil to accept uploads
+// without checking storage quotas.
+func NewFileHandler(fileService *services.FileService, quota *services.QuotaService) *FileHandler {
 	log := utils.GetLogger()
-	return &FileHandler{fileService, log}
+	return &FileHandler{fileService, quota, log}
 }
 
 func (fh *FileHandler) UploadFile(c *fiber.Ctx) error {
-	fileData, err := fh.fileService.ExtractFileDataAndMetadata(c)
+	parts, err := fh.fileService.ExtractFileDataAndMetadata(c)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file data or metadata"})
 	}
+	ownerID, _ := c.Locals("user_id").(uint)
 
-	err = fh.fileService.ProcessFileUpload(fileData)
-	if err != nil {
-		if err == utils.ErrFileSizeExceedsLimit {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "size limit excced"})
+	// Each file succeeds or fails on its own; the request only fails when
+	// none of them could be uploaded.
+	results := make([]models.UploadResult, 0, len(parts))
+	uploaded, failedStatus, failedError := 0, 0, ""
+	for _, part := range parts {
+		result, status := fh.uploadPart(ownerID, part)
+		results = append(results, result)
+		if status == fiber.StatusOK {
+			uploaded++
+		} else if failedStatus == 0 {
+			failedStatus, failedError = status, result.Error
+		}
+	}
+
+	if uploaded == 0 {
+		return c.Status(failedStatus).JSON(fiber.Map{"error": failedError, "files": results})
+	}
+	return c.JSON(fiber.Map{"message": "Files uploaded successfully", "files": results})
+}
+
+// uploadPart sends one file of an upload to the store and returns its
+// result with the status code it would fail the request with.
+func (fh *FileHandler) uploadPart(ownerID uint, part services.UploadPart) (models.UploadResult, int) {
+	result := models.UploadResult{FileName: part.FileName, Status: models.UploadStatusRejected}
+	if part.Err != nil {
+		return uploadFailure(result, part.Err)
+	}
+	fileData := part.FileData
+	fileData.OwnerID = ownerID
+	result.FileType, result.FileSize = fileData.FileType, fileData.FileSize
+
+	var err error
+	if fh.quota != nil {
+		fileData.QuotaBytes, err = fh.quota.Reserve(ownerID, fileData.FileSize)
+		if err != nil && err != utils.ErrQuotaExceeded {
+			fh.log.Error("Failed to check storage quota", zap.Error(err))
+		}
+		if err != nil {
+			return uploadFailure(result, err)
+		}
+	}
+
+	if part.Archive {
+		// The store reports each extracted file on the status queue, which
+		// does not count the archive against the pending uploads.
+		result.Entries, err = fh.fileService.ExtractArchive(fileData)
+		if fh.quota != nil {
+			fh.quota.Release(ownerID, fileData.FileSize)
+		}
+		if err != nil {
+			fh.log.Error("Failed to extract archive", zap.String("fileName", part.FileName), zap.Error(err))
+			return uploadFailure(result, err)
+		}
+		result.Status = models.UploadExtracted
+		return result, fiber.StatusOK
+	}
+
+	if err = fh.fileService.ProcessFileUpload(fileData); err != nil {
+		if fh.quota != nil {
+			fh.quota.Release(ownerID, fileData.FileSize)
 		}
 		fh.log.Error("Failed to process file upload", zap.Error(err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to process file upload"})
+		return uploadFailure(result, err)
 	}
+	result.Status = models.UploadAccepted
+	return result, fiber.StatusOK
+}
 
-	return c.JSON(fiber.Map{"message": "Files uploaded successfully"})
+func uploadFailure(result models.UploadResult, err error) (models.UploadResult, int) {
+	status := fiber.StatusInternalServerError
+	switch err {
+	case utils.ErrFileTypeNotAllowed, utils.ErrFileTypeMismatch:
+		status, result.Error = fiber.StatusUnsupportedMediaType, err.Error()
+	case utils.ErrQuotaExceeded:
+		status, result.Error = fiber.StatusRequestEntityTooLarge, "Storage quota exceeded"
+	case utils.ErrFileSizeExceedsLimit:
+		status, result.Error = fiber.StatusBadRequest, "size limit excced"
+	case utils.ErrNotAnArchive:
+		status, result.Error = fiber.StatusBadRequest, err.Error()
+	case utils.ErrInvalidFileQuery:
+		status, result.Error = fiber.StatusBadRequest, "Invalid archive"
+	case utils.ErrArchiveTooLarge:
+		status, result.Error = fiber.StatusRequestEntityTooLarge, err.Error()
+	case utils.ErrStoreUnavailable:
+		status, result.Error = fiber.StatusGatewayTimeout, "Store did not answer in time"
+	default:
+		result.Error = "Failed to process file upload"
+	}
+	return result, status
 }
 
 func (fh *FileHandler) GetFile(c *fiber.Ctx) error {
@@ -50,6 +139,7 @@ func (fh *FileHandler) GetFile(c *fiber.Ctx) error {
 
 	request.Name = name
 	request.Tags = strings.Split(tags, ",")
+	request.OwnerID, _ = c.Locals("user_id").(uint)
 
 	err := fh.fileService.PublishFileRequest(&request, "file-request-queue")
 	if err != nil {
@@ -69,3 +159,467 @@ func (fh *FileHandler) RetrieveFileNames(c *fiber.Ctx) error {
 
 	return c.JSON(fiber.Map{"fileNames": fileNames})
 }
+
+func (fh *FileHandler) SearchFiles(c *fiber.Ctx) error {
+	query, err := parseFileQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	result, err := fh.fileService.SearchFiles(ownerID, query)
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(result)
+}
+
+// parseFileQuery reads the search filters from the query string. Sort,
+// order and the value ranges are checked by the store.
+func parseFileQuery(c *fiber.Ctx) (*models.FileQuery, error) {
+	query := &models.FileQuery{
+		Query:    c.Query("q"),
+		Name:     c.Query("name"),
+		TagMatch: c.Query("tag_match"),
+		FileType: c.Query("type"),
+		Sort:     c.Query("sort"),
+		Order:    c.Query("order"),
+		Cursor:   c.Query("cursor"),
+	}
+	if tags := c.Query("tags"); tags != "" {
+		query.Tags = strings.Split(tags, ",")
+	}
+	for name, value := range c.Queries() {
+		if key, ok := strings.CutPrefix(name, "meta."); ok {
+			if query.Metadata == nil {
+				query.Metadata = map[string]string{}
+			}
+			query.Metadata[key] = value
+		}
+	}
+	if err := models.ValidateMetadata(query.Metadata); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if query.CreatedAfter, err = parseTimeParam(c, "created_after"); err != nil {
+		return nil, err
+	}
+	if query.CreatedBefore, err = parseTimeParam(c, "created_before"); err != nil {
+		return nil, err
+	}
+	if query.MinSize, err = parseSizeParam(c, "min_size"); err != nil {
+		return nil, err
+	}
+	if query.MaxSize, err = parseSizeParam(c, "max_size"); err != nil {
+		return nil, err
+	}
+	if shared := c.Query("shared_with_me"); shared != "" {
+		if query.SharedWithMe, err = strconv.ParseBool(shared); err != nil {
+			return nil, errors.New("invalid shared_with_me")
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit <= 0 {
+			return nil, errors.New("invalid limit")
+		}
+	}
+	return query, nil
+}
+
+// parseTimeParam accepts RFC 3339 timestamps and plain dates.
+func parseTimeParam(c *fiber.Ctx, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s", name)
+}
+
+func parseSizeParam(c *fiber.Ctx, name string) (*int64, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid %s", name)
+	}
+	return &size, nil
+}
+
+func (fh *FileHandler) DeleteFile(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	file, err := fh.fileService.DeleteFile(ownerID, uint(fileID))
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "File moved to trash", "file": file})
+}
+
+func (fh *FileHandler) ListTrash(c *fiber.Ctx) error {
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	files, err := fh.fileService.ListTrash(ownerID)
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"files": files})
+}
+
+func (fh *FileHandler) RestoreFile(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	file, err := fh.fileService.RestoreFile(ownerID, uint(fileID))
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "File restored", "file": file})
+}
+
+func (fh *FileHandler) ListVersions(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	versions, err := fh.fileService.ListVersions(ownerID, uint(fileID))
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"versions": versions})
+}
+
+// DownloadFile sends a version of a file. It answers conditional requests
+// with If-None-Match or If-Modified-Since and single byte ranges.
+func (fh *FileHandler) DownloadFile(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	version := c.QueryInt("version", 0)
+	if version < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid version"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	return serveFile(c, fh.fileService, fh.log, ownerID, uint(fileID), version, true)
+}
+
+// GetThumbnail sends the thumbnail of an image. Thumbnails are rendered
+// after the upload, so a new image answers 503 for a moment.
+func (fh *FileHandler) GetThumbnail(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	version := c.QueryInt("version", 0)
+	if version < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid version"})
+	}
+	var size int
+	if value := c.Query("size"); value != "" {
+		if size, err = strconv.Atoi(value); err != nil || size <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid size"})
+		}
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	thumbnail, err := fh.fileService.GetThumbnail(ownerID, uint(fileID), version, size)
+	switch err {
+	case nil:
+	case utils.ErrThumbnailNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Thumbnail not found"})
+	case utils.ErrThumbnailPending:
+		c.Set(fiber.HeaderRetryAfter, "5")
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return fh.commandError(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, thumbnail.FileType)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	c.Set(fiber.HeaderETag, `"`+thumbnail.ETag+`"`)
+	c.Set(fiber.HeaderLastModified, thumbnail.ModifiedAt.UTC().Format(http.TimeFormat))
+	if notModified(c, thumbnail) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	return c.Send(thumbnail.Content)
+}
+
+// serveFile sends a version of a file, answering conditional requests and,
+// with ranges, single byte ranges.
+func serveFile(c *fiber.Ctx, files *services.FileService, log *zap.Logger, ownerID, fileID uint, version int, ranges bool) error {
+	// Plain downloads need a single call to the store; the others look at
+	// the version first and then fetch only what is sent.
+	rangeHeader := c.Get(fiber.HeaderRange)
+	if !ranges {
+		rangeHeader = ""
+	}
+	if rangeHeader == "" && c.Get(fiber.HeaderIfNoneMatch) == "" && c.Get(fiber.HeaderIfModifiedSince) == "" {
+		content, err := files.DownloadFile(ownerID, fileID, version)
+		if err != nil {
+			return fileCommandError(c, log, err)
+		}
+		setDownloadHeaders(c, content, ranges)
+		return c.Send(content.Content)
+	}
+
+	info, err := files.StatFile(ownerID, fileID, version)
+	if err != nil {
+		return fileCommandError(c, log, err)
+	}
+	setDownloadHeaders(c, info, ranges)
+	if notModified(c, info) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	var byteRange *models.ByteRange
+	if rangeHeader != "" && ifRangeMatches(c, info) {
+		byteRange, err = models.ParseByteRange(rangeHeader, info.FileSize)
+		if err == utils.ErrRangeNotSatisfiable {
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", info.FileSize))
+			return c.Status(fiber.StatusRequestedRangeNotSatisfiable).JSON(fiber.Map{"error": "Range not satisfiable"})
+		}
+	}
+
+	// The version is pinned, so a version uploaded in between is not mixed in.
+	if byteRange == nil {
+		content, err := files.DownloadFile(ownerID, fileID, info.Version)
+		if err != nil {
+			return fileCommandError(c, log, err)
+		}
+		return c.Send(content.Content)
+	}
+	content, err := files.DownloadRange(ownerID, fileID, info.Version, byteRange)
+	if err != nil {
+		return fileCommandError(c, log, err)
+	}
+	end := content.Offset + int64(len(content.Content)) - 1
+	c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", content.Offset, end, content.FileSize))
+	return c.Status(fiber.StatusPartialContent).Send(content.Content)
+}
+
+func setDownloadHeaders(c *fiber.Ctx, content *models.FileContent, ranges bool) {
+	c.Attachment(content.FileName)
+	c.Set("X-File-Version", strconv.Itoa(content.Version))
+	if ranges {
+		c.Set(fiber.HeaderAcceptRanges, "bytes")
+	}
+	if content.ETag != "" {
+		c.Set(fiber.HeaderETag, `"`+content.ETag+`"`)
+	}
+	if !content.ModifiedAt.IsZero() {
+		c.Set(fiber.HeaderLastModified, content.ModifiedAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since without it.
+func notModified(c *fiber.Ctx, content *models.FileContent) bool {
+	if header := c.Get(fiber.HeaderIfNoneMatch); header != "" {
+		return etagMatches(header, content.ETag, false)
+	}
+	since, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince))
+	return err == nil && !content.ModifiedAt.Truncate(time.Second).After(since)
+}
+
+// ifRangeMatches reports whether the range of a request with If-Range may
+// be sent; otherwise the whole content is.
+func ifRangeMatches(c *fiber.Ctx, content *models.FileContent) bool {
+	header := c.Get(fiber.HeaderIfRange)
+	if header == "" {
+		return true
+	}
+	if strings.HasPrefix(header, `"`) || strings.HasPrefix(header, "W/") {
+		return etagMatches(header, content.ETag, true)
+	}
+	date, err := http.ParseTime(header)
+	return err == nil && content.ModifiedAt.Truncate(time.Second).Equal(date)
+}
+
+// etagMatches compares a list of entity tags with etag. The strong
+// comparison used by If-Range never matches weak tags.
+func etagMatches(header, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" && !strong {
+			return true
+		}
+		if weak, ok := strings.CutPrefix(tag, "W/"); ok {
+			if strong {
+				continue
+			}
+			tag = weak
+		}
+		if tag == `"`+etag+`"` {
+			return true
+		}
+	}
+	return false
+}
+
+func (fh *FileHandler) RestoreVersion(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	version, err := c.ParamsInt("version")
+	if err != nil || version <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid version"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	file, err := fh.fileService.RestoreVersion(ownerID, uint(fileID), version)
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "File version restored", "file": file})
+}
+
+func (fh *FileHandler) ListTags(c *fiber.Ctx) error {
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	tags, err := fh.fileService.ListTags(ownerID)
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"tags": tags})
+}
+
+func (fh *FileHandler) AddTags(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	var request models.TagsPayload
+	if err := c.BodyParser(&request); err != nil || len(request.Tags) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "tags is required"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	file, err := fh.fileService.AddTags(ownerID, uint(fileID), request.Tags)
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Tags added", "file": file})
+}
+
+func (fh *FileHandler) RemoveTag(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	tag, err := url.PathUnescape(c.Params("tag"))
+	if err != nil || tag == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid tag"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	file, err := fh.fileService.RemoveTags(ownerID, uint(fileID), []string{tag})
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Tag removed", "file": file})
+}
+
+func (fh *FileHandler) RenameTag(c *fiber.Ctx) error {
+	name, err := url.PathUnescape(c.Params("name"))
+	if err != nil || name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid tag"})
+	}
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&request); err != nil || request.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name is required"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	tag, err := fh.fileService.RenameTag(ownerID, name, request.Name)
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Tag renamed", "tag": tag})
+}
+
+func (fh *FileHandler) MergeTags(c *fiber.Ctx) error {
+	var request models.MergeTagsPayload
+	if err := c.BodyParser(&request); err != nil || len(request.Sources) == 0 || request.Target == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "sources and target are required"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	tag, err := fh.fileService.MergeTags(ownerID, request.Sources, request.Target)
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Tags merged", "tag": tag})
+}
+
+func (fh *FileHandler) UpdateMetadata(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	var patch models.MetadataPatch
+	if err := c.BodyParser(&patch); err != nil || len(patch) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Body must be an object of string or null values"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	file, err := fh.fileService.UpdateMetadata(ownerID, uint(fileID), patch)
+	if err != nil {
+		return fh.commandError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Metadata updated", "file": file})
+}
+
+func (fh *FileHandler) commandError(c *fiber.Ctx, err error) error {
+	return fileCommandError(c, fh.log, err)
+}
+
+func fileCommandError(c *fiber.Ctx, log *zap.Logger, err error) error {
+	switch err {
+	case utils.ErrFileNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "File not found"})
+	case utils.ErrInvalidFileQuery:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file request"})
+	case utils.ErrFileConflict:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "File request conflicts with the current state"})
+	case utils.ErrStoreUnavailable:
+		return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{"error": "Store did not answer in time"})
+	default:
+		log.Error("File request failed", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "File request failed"})
+	}
+}
@@ -0,0 +1,117 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"retreival/handlers"
+	"retreival/models"
+	"retreival/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileHandler_SearchFilesRejectsInvalidFilters(t *testing.T) {
+	fileService := services.NewFileService(services.RabbitMQService{}, 1024, nil, nil)
+	fileHandler := handlers.NewFileHandler(fileService, nil)
+
+	app := fiber.New()
+	app.Get("/file/search", fileHandler.SearchFiles)
+
+	for _, query := range []string{
+		"created_after=yesterday",
+		"created_before=2024-13-01",
+		"min_size=-1",
+		"max_size=big",
+		"limit=0",
+		"meta.bad%20key=x",
+	} {
+		t.Run(query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/file/search?"+query, nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
+
+func TestFileHandler_UploadFileReportsEachFile(t *testing.T) {
+	fileTypes := services.NewFileTypeService(services.FileTypePolicy{Denied: []string{"application/pdf"}})
+	fileService := services.NewFileService(services.RabbitMQService{}, 1024, fileTypes, nil)
+	fileHandler := handlers.NewFileHandler(fileService, nil)
+
+	app := fiber.New()
+	app.Post("/file/upload", fileHandler.UploadFile)
+
+	upload := func(fields map[string]string, files map[string][]byte) *http.Response {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for name, value := range fields {
+			_ = writer.WriteField(name, value)
+		}
+		for name, content := range files {
+			part, _ := writer.CreateFormFile("file", name)
+			_, _ = part.Write(content)
+		}
+		_ = writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/file/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	// Test case: every file is reported when none could be uploaded
+	resp := upload(nil, map[string][]byte{
+		"a.pdf": []byte("%PDF-1.4 first"),
+		"b.pdf": []byte("%PDF-1.4 second"),
+	})
+	assert.Equal(t, fiber.StatusUnsupportedMediaType, resp.StatusCode)
+
+	var result struct {
+		Files []models.UploadResult `json:"files"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Len(t, result.Files, 2)
+	for _, file := range result.Files {
+		assert.Equal(t, models.UploadStatusRejected, file.Status)
+		assert.Equal(t, "file type is not allowed", file.Error)
+	}
+
+	// Test case: extracting needs an archive
+	resp = upload(map[string]string{"extract": "true"}, map[string][]byte{"notes.txt": []byte("hello")})
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	// Test case: a request without files is invalid
+	resp = upload(map[string]string{"tags": "work"}, nil)
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+}
+
+func TestFileHandler_GetThumbnailRejectsInvalidParams(t *testing.T) {
+	fileService := services.NewFileService(services.RabbitMQService{}, 1024, nil, nil)
+	fileHandler := handlers.NewFileHandler(fileService, nil)
+
+	app := fiber.New()
+	app.Get("/file/:id/thumbnail", fileHandler.GetThumbnail)
+
+	for _, target := range []string{
+		"/file/abc/thumbnail",
+		"/file/0/thumbnail",
+		"/file/1/thumbnail?size=0",
+		"/file/1/thumbnail?size=large",
+		"/file/1/thumbnail?version=-1",
+	} {
+		t.Run(target, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"strconv"
+
+	"retreival/models"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type FolderHandler struct {
+	files *services.FileService
+	log   *zap.Logger
+}
+
+func NewFolderHandler(files *services.FileService) *FolderHandler {
+	return &FolderHandler{files: files, log: utils.GetLogger()}
+}
+
+func (fh *FolderHandler) CreateFolder(c *fiber.Ctx) error {
+	var request models.FolderRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if !models.ValidFolderName(request.Name) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": utils.ErrInvalidFolderName.Error()})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	folder, err := fh.files.CreateFolder(ownerID, request.Name, request.ParentID)
+	if err != nil {
+		return folderCommandError(c, fh.log, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"folder": folder})
+}
+
+// ListFolder lists a folder's subfolders and then its files, each by name.
+// Without an id it lists the root.
+func (fh *FolderHandler) ListFolder(c *fiber.Ctx) error {
+	var folderID int
+	if c.Params("id") != "" {
+		var err error
+		if folderID, err = c.ParamsInt("id"); err != nil || folderID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid folder id"})
+		}
+	}
+	var limit int
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid limit"})
+		}
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	listing, err := fh.files.ListFolder(ownerID, uint(folderID), limit, c.Query("cursor"))
+	if err != nil {
+		return folderCommandError(c, fh.log, err)
+	}
+	return c.JSON(listing)
+}
+
+func (fh *FolderHandler) RenameFolder(c *fiber.Ctx) error {
+	folderID, err := c.ParamsInt("id")
+	if err != nil || folderID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid folder id"})
+	}
+	var request models.FolderRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if !models.ValidFolderName(request.Name) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": utils.ErrInvalidFolderName.Error()})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	folder, err := fh.files.RenameFolder(ownerID, uint(folderID), request.Name)
+	if err != nil {
+		return folderCommandError(c, fh.log, err)
+	}
+	return c.JSON(fiber.Map{"folder": folder})
+}
+
+// MoveFolder moves a folder below parent_id, or to the root when it is null.
+func (fh *FolderHandler) MoveFolder(c *fiber.Ctx) error {
+	folderID, err := c.ParamsInt("id")
+	if err != nil || folderID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid folder id"})
+	}
+	var request models.FolderRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	folder, err := fh.files.MoveFolder(ownerID, uint(folderID), request.ParentID)
+	if err != nil {
+		return folderCommandError(c, fh.log, err)
+	}
+	return c.JSON(fiber.Map{"folder": folder})
+}
+
+func (fh *FolderHandler) DeleteFolder(c *fiber.Ctx) error {
+	folderID, err := c.ParamsInt("id")
+	if err != nil || folderID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid folder id"})
+	}
+	recursive := c.QueryBool("recursive")
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	if err := fh.files.DeleteFolder(ownerID, uint(folderID), recursive); err != nil {
+		return folderCommandError(c, fh.log, err)
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// ResolvePath finds the folder or file of a path like /projects/report.pdf.
+func (fh *FolderHandler) ResolvePath(c *fiber.Ctx) error {
+	path := c.Query("path")
+	if len(path) < 2 || path[0] != '/' {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "'path' must start with a slash"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	result, err := fh.files.ResolvePath(ownerID, path)
+	if err != nil {
+		return folderCommandError(c, fh.log, err)
+	}
+	return c.JSON(result)
+}
+
+// MoveFile moves a file into folder_id, or to the root when it is null.
+func (fh *FolderHandler) MoveFile(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	var request models.MoveFilePayload
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	ownerID, _ := c.Locals("user_id").(uint)
+
+	file, err := fh.files.MoveFile(ownerID, uint(fileID), request.FolderID)
+	if err != nil {
+		return folderCommandError(c, fh.log, err)
+	}
+	return c.JSON(fiber.Map{"file": file})
+}
+
+func folderCommandError(c *fiber.Ctx, log *zap.Logger, err error) error {
+	switch err {
+	case utils.ErrFolderNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Folder not found"})
+	case utils.ErrFolderNameInUse, utils.ErrFolderNotEmpty:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return fileCommandError(c, log, err)
+	}
+}
@@ -0,0 +1,49 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"retreival/handlers"
+	"retreival/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFolderHandler_RejectsInvalidRequests(t *testing.T) {
+	fileService := services.NewFileService(services.RabbitMQService{}, 1024, nil, nil)
+	folderHandler := handlers.NewFolderHandler(fileService)
+
+	app := fiber.New()
+	app.Post("/folders", folderHandler.CreateFolder)
+	app.Get("/folders/resolve", folderHandler.ResolvePath)
+	app.Get("/folders/:id", folderHandler.ListFolder)
+	app.Patch("/folders/:id", folderHandler.RenameFolder)
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"empty name", http.MethodPost, "/folders", `{"name":""}`},
+		{"slash in name", http.MethodPost, "/folders", `{"name":"a/b"}`},
+		{"dot name", http.MethodPost, "/folders", `{"name":".."}`},
+		{"rename to slash", http.MethodPatch, "/folders/1", `{"name":"a/b"}`},
+		{"bad id", http.MethodGet, "/folders/abc", ""},
+		{"bad limit", http.MethodGet, "/folders/1?limit=0", ""},
+		{"relative path", http.MethodGet, "/folders/resolve?path=docs", ""},
+		{"no path", http.MethodGet, "/folders/resolve", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"retreival/models"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type GroupHandler struct {
+	groups *services.GroupService
+	log    *zap.Logger
+}
+
+func NewGroupHandler(groups *services.GroupService) *GroupHandler {
+	return &GroupHandler{groups: groups, log: utils.GetLogger()}
+}
+
+func (gh *GroupHandler) CreateGroup(c *fiber.Ctx) error {
+	var request models.GroupRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	group, err := gh.groups.CreateGroup(userID, request)
+	if err != nil {
+		return gh.groupError(c, err, "Failed to create group")
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"group": models.ConvertGroupToResponse(*group)})
+}
+
+func (gh *GroupHandler) ListGroups(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(uint)
+
+	groups, err := gh.groups.ListGroups(userID)
+	if err != nil {
+		return gh.groupError(c, err, "Failed to list groups")
+	}
+
+	response := make([]models.GroupResponse, len(groups))
+	for i, group := range groups {
+		response[i] = models.ConvertGroupToResponse(group)
+	}
+	return c.JSON(fiber.Map{"groups": response})
+}
+
+func (gh *GroupHandler) DeleteGroup(c *fiber.Ctx) error {
+	groupID, err := c.ParamsInt("id")
+	if err != nil || groupID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid group id"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	if err := gh.groups.DeleteGroup(userID, uint(groupID)); err != nil {
+		return gh.groupError(c, err, "Failed to delete group")
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+func (gh *GroupHandler) ListMembers(c *fiber.Ctx) error {
+	groupID, err := c.ParamsInt("id")
+	if err != nil || groupID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid group id"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	members, err := gh.groups.ListMembers(userID, uint(groupID))
+	if err != nil {
+		return gh.groupError(c, err, "Failed to list group members")
+	}
+	return c.JSON(fiber.Map{"members": members})
+}
+
+func (gh *GroupHandler) AddMember(c *fiber.Ctx) error {
+	groupID, err := c.ParamsInt("id")
+	if err != nil || groupID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid group id"})
+	}
+	var request models.GroupMemberRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	user, err := gh.groups.AddMember(userID, uint(groupID), request.User)
+	if err != nil {
+		return gh.groupError(c, err, "Failed to add group member")
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"user_id": user.ID, "username": user.Username})
+}
+
+// RemoveMember removes a member; members leave a group by removing
+// themselves.
+func (gh *GroupHandler) RemoveMember(c *fiber.Ctx) error {
+	groupID, err := c.ParamsInt("id")
+	if err != nil || groupID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid group id"})
+	}
+	memberID, err := c.ParamsInt("userId")
+	if err != nil || memberID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user id"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	if err := gh.groups.RemoveMember(userID, uint(groupID), uint(memberID)); err != nil {
+		return gh.groupError(c, err, "Failed to remove group member")
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+func (gh *GroupHandler) groupError(c *fiber.Ctx, err error, message string) error {
+	switch err {
+	case utils.ErrInvalidGroupName:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case utils.ErrGroupNotFound, utils.ErrUserNotFound, utils.ErrGroupMemberNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": err.Error()})
+	case utils.ErrNotGroupOwner:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": err.Error()})
+	case utils.ErrAlreadyGroupMember, utils.ErrGroupOwnerCannotLeave:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": err.Error()})
+	default:
+		gh.log.Error(message, zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": message})
+	}
+}
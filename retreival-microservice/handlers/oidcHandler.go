@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type OIDCHandler struct {
+	oidc *services.OIDCService
+	log  *zap.Logger
+}
+
+func NewOIDCHandler(oidc *services.OIDCService) *OIDCHandler {
+	return &OIDCHandler{oidc: oidc, log: utils.GetLogger()}
+}
+
+// Login redirects the browser to the identity provider. API clients can pass
+// ?redirect=false to receive the URL as JSON instead.
+func (oh *OIDCHandler) Login(c *fiber.Ctx) error {
+	authURL, err := oh.oidc.Begin(c.UserContext())
+	if err != nil {
+		oh.log.Error("Failed to start OIDC login", zap.Error(err))
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"message": "Identity provider unavailable"})
+	}
+
+	if c.Query("redirect") == "false" {
+		return c.JSON(fiber.Map{"authorization_url": authURL})
+	}
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+func (oh *OIDCHandler) Callback(c *fiber.Ctx) error {
+	if providerErr := c.Query("error"); providerErr != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Login was rejected by the identity provider", "error": providerErr})
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Missing state or code"})
+	}
+
+	result, err := oh.oidc.Callback(c.UserContext(), state, code)
+	if err != nil {
+		switch err {
+		case utils.ErrOIDCInvalidState:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Login session expired, please start again"})
+		case utils.ErrOIDCExchangeFailed, utils.ErrOIDCInvalidIDToken:
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Login with the identity provider failed"})
+		case utils.ErrEmailExist:
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "An account with this email already exists"})
+		default:
+			oh.log.Error("OIDC callback failed", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Login failed"})
+		}
+	}
+
+	if result.TwoFactorRequired {
+		return c.JSON(fiber.Map{
+			"two_factor_required": true,
+			"challenge_token":     result.ChallengeToken,
+		})
+	}
+	return c.JSON(fiber.Map{"token": result.Token})
+}
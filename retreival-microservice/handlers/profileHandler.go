@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"retreival/models"
+	"retreival/services"
+	"retreival/utils"
+	"retreival/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type ProfileHandler struct {
+	profile      *services.ProfileService
+	verification *services.VerificationService
+	validator    *validation.Validator
+	log          *zap.Logger
+}
+
+func NewProfileHandler(profile *services.ProfileService, verification *services.VerificationService, validator *validation.Validator) *ProfileHandler {
+	if validator == nil {
+		validator = defaultValidator
+	}
+	return &ProfileHandler{
+		profile:      profile,
+		verification: verification,
+		validator:    validator,
+		log:          utils.GetLogger(),
+	}
+}
+
+func (ph *ProfileHandler) GetProfile(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(uint)
+
+	user, err := ph.profile.GetProfile(userID)
+	if err != nil {
+		return ph.error(c, err)
+	}
+
+	return c.JSON(fiber.Map{"user": models.ConvertUserToProfileResponse(*user)})
+}
+
+func (ph *ProfileHandler) UpdateProfile(c *fiber.Ctx) error {
+	var request models.UpdateProfileRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	if err := ph.validator.ValidateProfile(&request); err != nil {
+		return validationFailed(c, err)
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	user, err := ph.profile.UpdateProfile(userID, request)
+	if err != nil {
+		return ph.error(c, err)
+	}
+
+	return c.JSON(fiber.Map{"user": models.ConvertUserToProfileResponse(*user)})
+}
+
+func (ph *ProfileHandler) ChangeEmail(c *fiber.Ctx) error {
+	var request models.ChangeEmailRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	if err := ph.validator.ValidateEmail("email", request.Email); err != nil {
+		return validationFailed(c, err)
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	if err := ph.profile.ChangeEmail(userID, request); err != nil {
+		return ph.error(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Confirmation email sent to the new address"})
+}
+
+func (ph *ProfileHandler) ConfirmEmailChange(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		var body struct {
+			Token string `json:"token"`
+		}
+		_ = c.BodyParser(&body)
+		token = body.Token
+	}
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Token is required"})
+	}
+
+	if _, err := ph.verification.ConfirmEmailChange(token); err != nil {
+		return ph.error(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Email changed successfully"})
+}
+
+func (ph *ProfileHandler) ChangePassword(c *fiber.Ctx) error {
+	var request models.ChangePasswordRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	user, err := ph.profile.GetProfile(userID)
+	if err != nil {
+		return ph.error(c, err)
+	}
+	if err := ph.validator.ValidatePassword("new_password", request.NewPassword, user.Username, user.Email); err != nil {
+		return validationFailed(c, err)
+	}
+
+	token, err := ph.profile.ChangePassword(userID, request)
+	if err != nil {
+		return ph.error(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Password changed successfully", "token": token})
+}
+
+func (ph *ProfileHandler) DeleteAccount(c *fiber.Ctx) error {
+	var request models.DeleteAccountRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	if err := ph.profile.DeleteAccount(userID, request.Password); err != nil {
+		return ph.error(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Account deleted"})
+}
+
+func (ph *ProfileHandler) error(c *fiber.Ctx, err error) error {
+	switch err {
+	case utils.ErrIncorrectPassword:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Incorrect password"})
+	case utils.ErrEmailExist:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "this email already exist"})
+	case utils.ErrEmailUnchanged:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "This is already your email"})
+	case utils.ErrTokenExpired:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Token expired"})
+	case utils.ErrTokenAlreadyUsed:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Token already used"})
+	case utils.ErrInvalidToken, utils.ErrInvalidTokenClaims:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid token"})
+	case utils.ErrUserNotFound:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	default:
+		ph.log.Error("Profile request failed", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Request failed"})
+	}
+}
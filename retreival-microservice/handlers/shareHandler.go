@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"math"
+	"strings"
+
+	"retreival/models"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type ShareHandler struct {
+	shares *services.ShareService
+	files  *services.FileService
+	log    *zap.Logger
+}
+
+func NewShareHandler(shares *services.ShareService, files *services.FileService) *ShareHandler {
+	return &ShareHandler{shares: shares, files: files, log: utils.GetLogger()}
+}
+
+func (sh *ShareHandler) CreateLink(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	var request models.ShareLinkRequest
+	if err := c.BodyParser(&request); err != nil && len(c.Body()) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	content, err := sh.files.StatFile(userID, uint(fileID), 0)
+	if err != nil {
+		return fileCommandError(c, sh.log, err)
+	}
+	// Files shared with the user are not theirs to share further.
+	if content.OwnerID != userID {
+		return fileCommandError(c, sh.log, utils.ErrFileNotFound)
+	}
+
+	url, link, err := sh.shares.CreateLink(userID, uint(fileID), request)
+	if err != nil {
+		switch err {
+		case utils.ErrInvalidShareExpiry, utils.ErrInvalidShareLimit:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		default:
+			sh.log.Error("Failed to create share link", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create share link"})
+		}
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"share_link": models.ConvertShareLinkToResponse(*link),
+		"url":        url,
+	})
+}
+
+func (sh *ShareHandler) ListLinks(c *fiber.Ctx) error {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file id"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	links, err := sh.shares.ListLinks(userID, uint(fileID))
+	if err != nil {
+		sh.log.Error("Failed to list share links", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list share links"})
+	}
+
+	response := make([]models.ShareLinkResponse, len(links))
+	for i, link := range links {
+		response[i] = models.ConvertShareLinkToResponse(link)
+	}
+	return c.JSON(fiber.Map{"share_links": response})
+}
+
+func (sh *ShareHandler) ListAccesses(c *fiber.Ctx) error {
+	fileID, linkID, ok := shareLinkParams(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid share link id"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	accesses, err := sh.shares.ListAccesses(userID, fileID, linkID)
+	if err == utils.ErrShareLinkNotFound {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Share link not found"})
+	}
+	if err != nil {
+		sh.log.Error("Failed to list share link accesses", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list share link accesses"})
+	}
+	return c.JSON(fiber.Map{"accesses": accesses})
+}
+
+func (sh *ShareHandler) RevokeLink(c *fiber.Ctx) error {
+	fileID, linkID, ok := shareLinkParams(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid share link id"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	if err := sh.shares.RevokeLink(userID, fileID, linkID); err != nil {
+		if err == utils.ErrShareLinkNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Share link not found"})
+		}
+		sh.log.Error("Failed to revoke share link", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke share link"})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// shareGrantCookie carries the download grant of a share link, so browsers
+// and players send it with the ranges they request.
+const shareGrantCookie = "share_grant"
+
+// Download sends the file of a share link to anyone holding its token. The
+// password comes in X-Share-Password or as the password of Basic auth, which
+// makes browsers ask for it. The grant of a counted download is sent back in
+// X-Share-Grant and a cookie; ranges that bring it are not counted again.
+func (sh *ShareHandler) Download(c *fiber.Ctx) error {
+	client := services.ShareClient{
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		Resume:    resumesDownload(c.Get(fiber.HeaderRange)),
+		Grant:     c.Get("X-Share-Grant"),
+	}
+	if client.Grant == "" {
+		client.Grant = c.Cookies(shareGrantCookie)
+	}
+	link, grant, err := sh.shares.Open(c.Params("token"), sharePassword(c), client)
+	switch err {
+	case nil:
+	case utils.ErrInvalidToken, utils.ErrInvalidTokenClaims, utils.ErrShareLinkNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Share link not found"})
+	case utils.ErrTokenExpired, utils.ErrShareLinkRevoked, utils.ErrShareLinkExhausted:
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{"error": err.Error()})
+	case utils.ErrSharePasswordRequired, utils.ErrSharePasswordInvalid:
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="Shared file"`)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	case utils.ErrShareLinkLocked:
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": err.Error()})
+	default:
+		sh.log.Error("Failed to open share link", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to open share link"})
+	}
+
+	if grant != client.Grant {
+		c.Set("X-Share-Grant", grant)
+		c.Cookie(&fiber.Cookie{
+			Name:     shareGrantCookie,
+			Value:    grant,
+			Path:     c.Path(),
+			MaxAge:   int(services.ShareDownloadGrantTTL.Seconds()),
+			Secure:   c.Protocol() == "https",
+			HTTPOnly: true,
+			SameSite: fiber.CookieSameSiteLaxMode,
+		})
+	}
+	return serveFile(c, sh.files, sh.log, link.UserID, link.FileID, 0, true)
+}
+
+// resumesDownload reports whether a Range header asks for a single range that
+// does not start at the first byte. Requests for several ranges are sent the
+// whole file, so they count like plain downloads.
+func resumesDownload(header string) bool {
+	if header == "" {
+		return false
+	}
+	byteRange, err := models.ParseByteRange(header, math.MaxInt64)
+	return err == nil && byteRange != nil && byteRange.Offset > 0
+}
+
+func shareLinkParams(c *fiber.Ctx) (uint, uint, bool) {
+	fileID, err := c.ParamsInt("id")
+	if err != nil || fileID <= 0 {
+		return 0, 0, false
+	}
+	linkID, err := c.ParamsInt("linkId")
+	if err != nil || linkID <= 0 {
+		return 0, 0, false
+	}
+	return uint(fileID), uint(linkID), true
+}
+
+func sharePassword(c *fiber.Ctx) string {
+	if password := c.Get("X-Share-Password"); password != "" {
+		return password
+	}
+	encoded, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Basic ")
+	if !ok {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ""
+	}
+	_, password, _ := strings.Cut(string(decoded), ":")
+	return password
+}
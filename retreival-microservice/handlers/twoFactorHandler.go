@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type TwoFactorHandler struct {
+	totp *services.TOTPService
+	log  *zap.Logger
+}
+
+func NewTwoFactorHandler(totp *services.TOTPService) *TwoFactorHandler {
+	return &TwoFactorHandler{totp: totp, log: utils.GetLogger()}
+}
+
+func (th *TwoFactorHandler) Setup(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(uint)
+
+	secret, uri, err := th.totp.Setup(userID)
+	if err != nil {
+		return th.error(c, err)
+	}
+
+	return c.JSON(fiber.Map{"secret": secret, "provisioning_uri": uri})
+}
+
+func (th *TwoFactorHandler) Enable(c *fiber.Ctx) error {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	codes, err := th.totp.Enable(userID, request.Code)
+	if err != nil {
+		return th.error(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+}
+
+func (th *TwoFactorHandler) Disable(c *fiber.Ctx) error {
+	var request struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	if err := th.totp.Disable(userID, request.Password, request.Code); err != nil {
+		return th.error(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
+
+func (th *TwoFactorHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	codes, err := th.totp.RegenerateRecoveryCodes(userID, request.Code)
+	if err != nil {
+		return th.error(c, err)
+	}
+
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+func (th *TwoFactorHandler) Login(c *fiber.Ctx) error {
+	var request struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	if err := c.BodyParser(&request); err != nil || request.ChallengeToken == "" || request.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
+	}
+
+	token, err := th.totp.CompleteLogin(request.ChallengeToken, request.Code, c.IP())
+	if err != nil {
+		return th.error(c, err)
+	}
+
+	return c.JSON(fiber.Map{"token": token})
+}
+
+func (th *TwoFactorHandler) error(c *fiber.Ctx, err error) error {
+	var lockout *utils.LockoutError
+	if errors.As(err, &lockout) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(lockout.RetryAfter.Seconds()))))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"message": "Too many failed attempts, try again later"})
+	}
+
+	switch err {
+	case utils.ErrInvalidTwoFactorCode:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid code"})
+	case utils.ErrIncorrectPassword:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Incorrect password"})
+	case utils.ErrTokenExpired, utils.ErrInvalidToken, utils.ErrInvalidTokenClaims, utils.ErrTokenAlreadyUsed:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid or expired challenge"})
+	case utils.ErrTwoFactorAlreadyEnabled:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "Two-factor authentication is already enabled"})
+	case utils.ErrTwoFactorNotEnabled:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Two-factor authentication is not enabled"})
+	case utils.ErrTwoFactorNotSetUp:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Call /user/2fa/setup first"})
+	case utils.ErrUserNotFound:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	default:
+		th.log.Error("Two-factor request failed", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Request failed"})
+	}
+}
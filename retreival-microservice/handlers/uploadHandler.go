@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"retreival/models"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const offsetOctetStream = "application/offset+octet-stream"
+
+// UploadHandler serves resumable uploads with the tus 1.0 core protocol and
+// its creation, termination and expiration extensions.
+type UploadHandler struct {
+	uploads *services.UploadService
+	log     *zap.Logger
+}
+
+func NewUploadHandler(uploads *services.UploadService) *UploadHandler {
+	return &UploadHandler{uploads: uploads, log: utils.GetLogger()}
+}
+
+// TusResumable answers every request with the protocol version and rejects
+// requests for other versions.
+func (uh *UploadHandler) TusResumable(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", models.TusVersion)
+	if c.Method() == fiber.MethodOptions {
+		return c.Next()
+	}
+	if c.Get("Tus-Resumable") != models.TusVersion {
+		c.Set("Tus-Version", models.TusVersion)
+		return c.SendStatus(fiber.StatusPreconditionFailed)
+	}
+	return c.Next()
+}
+
+func (uh *UploadHandler) Options(c *fiber.Ctx) error {
+	c.Set("Tus-Version", models.TusVersion)
+	c.Set("Tus-Extension", "creation,creation-with-upload,termination,expiration")
+	if maxSize := uh.uploads.MaxSize(); maxSize > 0 {
+		c.Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (uh *UploadHandler) CreateUpload(c *fiber.Ctx) error {
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Upload-Length is required"})
+	}
+	metadata, err := services.ParseUploadMetadata(c.Get("Upload-Metadata"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid Upload-Metadata"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	session, err := uh.uploads.Create(userID, length, metadata)
+	if err != nil {
+		return uh.uploadError(c, err)
+	}
+	c.Location(c.BaseURL() + c.Path() + "/" + session.ID)
+
+	// With creation-with-upload the body holds the first bytes.
+	if c.Get(fiber.HeaderContentType) == offsetOctetStream && session.Offset < session.Length {
+		session, err = uh.uploads.Append(userID, session.ID, 0, requestBody(c))
+		if err != nil {
+			return uh.uploadError(c, err)
+		}
+	}
+	uh.setUploadHeaders(c, session)
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+func (uh *UploadHandler) GetOffset(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(uint)
+	session, err := uh.uploads.Get(userID, c.Params("id"))
+	if err != nil {
+		return uh.uploadError(c, err)
+	}
+
+	uh.setUploadHeaders(c, session)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func (uh *UploadHandler) PatchUpload(c *fiber.Ctx) error {
+	if c.Get(fiber.HeaderContentType) != offsetOctetStream {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": "Content-Type must be " + offsetOctetStream})
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Upload-Offset is required"})
+	}
+	userID, _ := c.Locals("user_id").(uint)
+
+	session, err := uh.uploads.Append(userID, c.Params("id"), offset, requestBody(c))
+	if err != nil {
+		return uh.uploadError(c, err)
+	}
+
+	uh.setUploadHeaders(c, session)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (uh *UploadHandler) TerminateUpload(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(uint)
+	if err := uh.uploads.Terminate(userID, c.Params("id")); err != nil {
+		return uh.uploadError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (uh *UploadHandler) setUploadHeaders(c *fiber.Ctx, session *models.UploadSession) {
+	c.Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+	if session.Offset < session.Length {
+		c.Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (uh *UploadHandler) uploadError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, utils.ErrUploadNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Upload not found"})
+	case errors.Is(err, utils.ErrUploadOffsetMismatch):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, utils.ErrFileSizeExceedsLimit), errors.Is(err, utils.ErrUploadTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, utils.ErrQuotaExceeded):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": "Storage quota exceeded"})
+	case errors.Is(err, utils.ErrFileTypeNotAllowed), errors.Is(err, utils.ErrFileTypeMismatch):
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, utils.ErrInvalidUploadMetadata), errors.Is(err, utils.ErrInvalidMetadata), errors.Is(err, utils.ErrTagTooLong),
+		errors.Is(err, utils.ErrInvalidFolderID):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	uh.log.Error("Failed to handle upload", zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to process file upload"})
+}
+
+// requestBody reads the body as it arrives when the server streams request
+// bodies.
+func requestBody(c *fiber.Ctx) io.Reader {
+	if stream := c.Context().RequestBodyStream(); stream != nil {
+		return stream
+	}
+	return bytes.NewReader(c.Body())
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type UsageHandler struct {
+	quota *services.QuotaService
+	log   *zap.Logger
+}
+
+func NewUsageHandler(quota *services.QuotaService) *UsageHandler {
+	return &UsageHandler{quota: quota, log: utils.GetLogger()}
+}
+
+func (uh *UsageHandler) GetUsage(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(uint)
+
+	usage, err := uh.quota.GetUsage(userID)
+	if err != nil {
+		if err == utils.ErrUserNotFound {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+		}
+		uh.log.Error("Failed to load storage usage", zap.Uint("UserID", userID), zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Failed to load usage"})
+	}
+
+	return c.JSON(fiber.Map{"usage": usage})
+}
@@ -4,16 +4,31 @@ import (
 	"retreival/models"
 	"retreival/services"
 	"retreival/utils"
+	"retreival/validation"
 
 	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
 )
 
 type UserHandler struct {
-	UserService *services.UserService
+	UserService  *services.UserService
+	Verification *services.VerificationService
+	Validator    *validation.Validator
+	log          *zap.Logger
 }
 
-func NewUserHandler(userService *services.UserService) *UserHandler {
-	return &UserHandler{UserService: userService}
+// NewUserHandler creates the handler. verification may be nil, in which case
+// no confirmation mail is sent on registration; a nil validator falls back to
+// the default password policy.
+func NewUserHandler(userService *services.UserService, verification *services.VerificationService, validator *validation.Validator) *UserHandler {
+	return &UserHandler{UserService: userService, Verification: verification, Validator: validator, log: utils.GetLogger()}
+}
+
+func (uh *UserHandler) validator() *validation.Validator {
+	if uh.Validator == nil {
+		return defaultValidator
+	}
+	return uh.Validator
 }
 
 func (uh *UserHandler) RegisterUser(c *fiber.Ctx) error {
@@ -22,6 +37,10 @@ func (uh *UserHandler) RegisterUser(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request body"})
 	}
 
+	if err := uh.validator().ValidateRegistration(&userReq); err != nil {
+		return validationFailed(c, err)
+	}
+
 	user := models.ConvertUserRegistrationRequestToUser(userReq)
 
 	newUser, token, err := uh.UserService.RegisterUser(user)
@@ -35,6 +54,12 @@ func (uh *UserHandler) RegisterUser(c *fiber.Ctx) error {
 		}
 	}
 
+	if uh.Verification != nil {
+		if err := uh.Verification.SendVerificationEmail(newUser); err != nil {
+			uh.log.Error("Failed to send verification email", zap.Uint("UserID", newUser.ID), zap.Error(err))
+		}
+	}
+
 	response := models.ConvertUserToUserRegistrationResponse(*newUser)
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"user": response, "token": token})
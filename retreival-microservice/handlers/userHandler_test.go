@@ -43,10 +43,10 @@ func TestUserHandler_RegisterUser(t *testing.T) {
 	userRepository := repositories.NewUserRepository(db)
 	secretKey := "702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b"
 	jwtService := services.NewJWTService(secretKey)
-	userService := services.NewUserService(*userRepository, utils.GetLogger(), *jwtService)
+	userService := services.NewUserService(*userRepository, utils.GetLogger(), *jwtService, nil, repositories.NewUserTokenRepository(db))
 
 	app := fiber.New()
-	userHandler := handlers.NewUserHandler(userService)
+	userHandler := handlers.NewUserHandler(userService, nil, nil)
 	app.Post("/register", userHandler.RegisterUser)
 
 	t.Run("Valid registration - 201 Created", func(t *testing.T) {
@@ -136,4 +136,39 @@ func TestUserHandler_RegisterUser(t *testing.T) {
 
 		assert.Equal(t, "this username already exist", responseBody["message"])
 	})
+
+	t.Run("Invalid fields - 400 Bad Request", func(t *testing.T) {
+		userData := models.UserRegistrationRequest{
+			Username: "",
+			Email:    "not-an-email",
+			Password: "short",
+		}
+		requestBody, _ := json.Marshal(userData)
+
+		req := httptest.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(requestBody))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err.Error())
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var responseBody struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Field string `json:"field"`
+				Code  string `json:"code"`
+			} `json:"errors"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&responseBody)
+		if err != nil {
+			t.Fatalf("Failed to decode response body: %s", err.Error())
+		}
+
+		assert.Equal(t, "Validation failed", responseBody.Message)
+		assert.Len(t, responseBody.Errors, 3)
+	})
 }
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"errors"
+
+	"retreival/validation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var defaultValidator = validation.NewValidator(validation.DefaultPasswordPolicy())
+
+// validationFailed writes the field level errors of err as a 400 response.
+func validationFailed(c *fiber.Ctx, err error) error {
+	var fieldErrs validation.Errors
+	if !errors.As(err, &fieldErrs) {
+		fieldErrs = validation.Errors{}
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"message": "Validation failed",
+		"errors":  fieldErrs,
+	})
+}
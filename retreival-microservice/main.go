@@ -4,6 +4,8 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"retreival/handlers"
 	"retreival/middleware"
@@ -11,9 +13,11 @@ import (
 	"retreival/repositories"
 	"retreival/services"
 	"retreival/utils"
+	"retreival/validation"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/streadway/amqp"
+	"go.uber.org/zap"
 
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
@@ -26,6 +30,44 @@ type Config struct {
 	SecretKey   string
 	FileLimit   string
 	RabbitmqUrl string
+
+	DefaultQuotaBytes string
+
+	UploadAllowedTypes       string
+	UploadDeniedTypes        string
+	UploadRejectTypeMismatch string
+	UploadExpiryHours        string
+
+	DownloadArchiveMaxFiles string
+	DownloadArchiveMaxBytes string
+
+	LoginMaxAttempts    string
+	LoginIPMaxAttempts  string
+	LoginLockoutMinutes string
+
+	BaseURL      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	MailLogFile  string
+
+	PasswordMinLength        string
+	PasswordRequireMixedCase string
+	PasswordRequireDigit     string
+	PasswordRequireSymbol    string
+	PasswordDenylistFile     string
+
+	TOTPIssuer       string
+	RequireTwoFactor string
+
+	OIDCProviderName string
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       string
 }
 
 func LoadConfig() Config {
@@ -40,7 +82,114 @@ func LoadConfig() Config {
 		SecretKey:   os.Getenv("SECRET_KEY"),
 		FileLimit:   os.Getenv("FILE_LIMIT"),
 		RabbitmqUrl: os.Getenv("RABBITMQ_URL"),
+
+		DefaultQuotaBytes: os.Getenv("DEFAULT_QUOTA_BYTES"),
+
+		UploadAllowedTypes:       os.Getenv("UPLOAD_ALLOWED_TYPES"),
+		UploadDeniedTypes:        os.Getenv("UPLOAD_DENIED_TYPES"),
+		UploadRejectTypeMismatch: os.Getenv("UPLOAD_REJECT_TYPE_MISMATCH"),
+		UploadExpiryHours:        os.Getenv("UPLOAD_EXPIRY_HOURS"),
+
+		DownloadArchiveMaxFiles: os.Getenv("DOWNLOAD_ARCHIVE_MAX_FILES"),
+		DownloadArchiveMaxBytes: os.Getenv("DOWNLOAD_ARCHIVE_MAX_BYTES"),
+
+		LoginMaxAttempts:    os.Getenv("LOGIN_MAX_ATTEMPTS"),
+		LoginIPMaxAttempts:  os.Getenv("LOGIN_IP_MAX_ATTEMPTS"),
+		LoginLockoutMinutes: os.Getenv("LOGIN_LOCKOUT_MINUTES"),
+
+		BaseURL:      os.Getenv("APP_BASE_URL"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     os.Getenv("SMTP_PORT"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:     os.Getenv("SMTP_FROM"),
+		MailLogFile:  os.Getenv("MAIL_LOG_FILE"),
+
+		PasswordMinLength:        os.Getenv("PASSWORD_MIN_LENGTH"),
+		PasswordRequireMixedCase: os.Getenv("PASSWORD_REQUIRE_MIXED_CASE"),
+		PasswordRequireDigit:     os.Getenv("PASSWORD_REQUIRE_DIGIT"),
+		PasswordRequireSymbol:    os.Getenv("PASSWORD_REQUIRE_SYMBOL"),
+		PasswordDenylistFile:     os.Getenv("PASSWORD_DENYLIST_FILE"),
+
+		TOTPIssuer:       os.Getenv("TOTP_ISSUER"),
+		RequireTwoFactor: os.Getenv("REQUIRE_2FA_FOR_FILES"),
+
+		OIDCProviderName: os.Getenv("OIDC_PROVIDER_NAME"),
+		OIDCIssuerURL:    os.Getenv("OIDC_ISSUER_URL"),
+		OIDCClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		OIDCScopes:       os.Getenv("OIDC_SCOPES"),
+	}
+}
+
+// atoiOrDefault parses value as an int, falling back to def when it is unset
+// or invalid.
+func atoiOrDefault(value string, def int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+func newMailer(config Config) services.Mailer {
+	if config.SMTPHost == "" {
+		return services.NewLogMailer(config.MailLogFile)
 	}
+	return services.NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.SMTPFrom)
+}
+
+func passwordPolicy(config Config) validation.PasswordPolicy {
+	policy := validation.DefaultPasswordPolicy()
+	policy.MinLength = atoiOrDefault(config.PasswordMinLength, policy.MinLength)
+	policy.RequireMixedCase, _ = strconv.ParseBool(config.PasswordRequireMixedCase)
+	policy.RequireDigit, _ = strconv.ParseBool(config.PasswordRequireDigit)
+	policy.RequireSymbol, _ = strconv.ParseBool(config.PasswordRequireSymbol)
+
+	if config.PasswordDenylistFile != "" {
+		denylist, err := validation.LoadDenylist(config.PasswordDenylistFile)
+		if err != nil {
+			log.Fatal("Failed to load password denylist:", err)
+		}
+		policy.Denylist = denylist
+	}
+	return policy
+}
+
+func lockoutPolicy(config Config) services.LockoutPolicy {
+	policy := services.DefaultLockoutPolicy()
+	policy.AccountMaxAttempts = atoiOrDefault(config.LoginMaxAttempts, policy.AccountMaxAttempts)
+	policy.IPMaxAttempts = atoiOrDefault(config.LoginIPMaxAttempts, policy.IPMaxAttempts)
+	policy.LockoutDuration = time.Duration(atoiOrDefault(config.LoginLockoutMinutes, int(policy.LockoutDuration.Minutes()))) * time.Minute
+	return policy
+}
+
+func fileTypePolicy(config Config) services.FileTypePolicy {
+	split := func(value string) []string {
+		var types []string
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+		return types
+	}
+	rejectMismatch, _ := strconv.ParseBool(config.UploadRejectTypeMismatch)
+	return services.FileTypePolicy{
+		Allowed:        split(config.UploadAllowedTypes),
+		Denied:         split(config.UploadDeniedTypes),
+		RejectMismatch: rejectMismatch,
+	}
+}
+
+func bulkDownloadLimits(config Config) services.BulkDownloadLimits {
+	limits := services.DefaultBulkDownloadLimits()
+	limits.MaxFiles = atoiOrDefault(config.DownloadArchiveMaxFiles, limits.MaxFiles)
+	if maxBytes, err := strconv.ParseInt(config.DownloadArchiveMaxBytes, 10, 64); err == nil {
+		limits.MaxBytes = maxBytes
+	}
+	return limits
 }
 
 func main() {
@@ -52,15 +201,27 @@ func main() {
 	}
 	logger := utils.GetLogger()
 
-	err = db.AutoMigrate(&models.User{})
+	err = db.AutoMigrate(&models.User{}, &models.LoginAttempt{}, &models.UserToken{}, &models.RecoveryCode{}, &models.APIKey{}, &models.UserIdentity{}, &models.OIDCLoginState{}, &models.Plan{}, &models.StorageUsage{}, &models.UploadSession{}, &models.ShareLink{}, &models.ShareLinkAccess{}, &models.Group{}, &models.GroupMember{})
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
 	userRepo := repositories.NewUserRepository(db)
 	jwt := services.NewJWTService(config.SecretKey)
-	userService := services.NewUserService(*userRepo, logger, *jwt)
-	handler := handlers.NewUserHandler(userService)
+	loginThrottle := services.NewLoginThrottleService(repositories.NewLoginAttemptRepository(db), lockoutPolicy(config))
+	tokenRepo := repositories.NewUserTokenRepository(db)
+	userService := services.NewUserService(*userRepo, logger, *jwt, loginThrottle, tokenRepo)
+	verificationService := services.NewVerificationService(userRepo, tokenRepo, jwt, loginThrottle, newMailer(config), config.BaseURL)
+	validator := validation.NewValidator(passwordPolicy(config))
+	handler := handlers.NewUserHandler(userService, verificationService, validator)
+	accountHandler := handlers.NewAccountHandler(verificationService, userRepo, validator)
+	if config.TOTPIssuer == "" {
+		config.TOTPIssuer = "mani-task"
+	}
+	totpService := services.NewTOTPService(userRepo, repositories.NewRecoveryCodeRepository(db), jwt, tokenRepo, loginThrottle, config.TOTPIssuer)
+	twoFactorHandler := handlers.NewTwoFactorHandler(totpService)
+	apiKeyService := services.NewAPIKeyService(repositories.NewAPIKeyRepository(db))
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
 	conn, err := amqp.Dial(config.RabbitmqUrl)
 	if err != nil {
 		log.Fatal("Failed to connect to rabbitmq err is ", err)
@@ -73,16 +234,150 @@ func main() {
 	// defer conn.Close()
 	// defer ch.Close()
 	fileLimitInt, _ := strconv.Atoi(config.FileLimit)
-	fileService := services.NewFileService(*rabbitService, fileLimitInt)
-	fileHandler := handlers.NewFileHandler(fileService)
-	app := fiber.New()
+	fileTypeService := services.NewFileTypeService(fileTypePolicy(config))
+	groupService := services.NewGroupService(repositories.NewGroupRepository(db), userRepo)
+	groupHandler := handlers.NewGroupHandler(groupService)
+	fileService := services.NewFileService(*rabbitService, fileLimitInt, fileTypeService, groupService)
+	accessHandler := handlers.NewAccessHandler(services.NewAccessService(fileService, groupService))
+	folderHandler := handlers.NewFolderHandler(fileService)
+	defaultQuota, _ := strconv.ParseInt(config.DefaultQuotaBytes, 10, 64)
+	quotaService := services.NewQuotaService(repositories.NewQuotaRepository(db), userRepo, defaultQuota)
+	if err := quotaService.EnsureDefaultPlan(); err != nil {
+		log.Fatal("Failed to create default plan:", err)
+	}
+	statusMsgs, err := rabbitService.ConsumeQueue(services.FileStatusQueue)
+	if err != nil {
+		log.Fatal("Failed to consume upload statuses:", err)
+	}
+	go func() {
+		for msg := range statusMsgs {
+			if err := quotaService.HandleUploadStatus(msg.Body); err != nil {
+				logger.Error("Failed to apply upload status", zap.Error(err))
+			}
+		}
+	}()
+	fileHandler := handlers.NewFileHandler(fileService, quotaService)
+	uploadExpiry := time.Duration(atoiOrDefault(config.UploadExpiryHours, 24)) * time.Hour
+	uploadService := services.NewUploadService(repositories.NewUploadSessionRepository(db), rabbitService, fileTypeService, quotaService, int64(fileLimitInt), uploadExpiry)
+	uploadHandler := handlers.NewUploadHandler(uploadService)
+	go func() {
+		for range time.Tick(time.Hour) {
+			_ = uploadService.ExpireSessions()
+			_ = loginThrottle.Prune()
+		}
+	}()
+	bulkDownloadHandler := handlers.NewBulkDownloadHandler(services.NewBulkDownloadService(fileService, bulkDownloadLimits(config)))
+	shareHandler := handlers.NewShareHandler(services.NewShareService(repositories.NewShareLinkRepository(db), jwt, config.BaseURL), fileService)
+	usageHandler := handlers.NewUsageHandler(quotaService)
+	profileService := services.NewProfileService(userRepo, verificationService, rabbitService, jwt)
+	profileHandler := handlers.NewProfileHandler(profileService, verificationService, validator)
+	// Resumable uploads read their bodies as they arrive.
+	app := fiber.New(fiber.Config{StreamRequestBody: true})
+
+	auth := middleware.JWTAuthMiddleware(jwt, apiKeyService, userRepo, logger)
+	session := middleware.RequireSession()
+	// File endpoints hold user documents; compliance may require MFA for them.
+	fileAuth := []fiber.Handler{auth}
+	if requireTwoFactor, _ := strconv.ParseBool(config.RequireTwoFactor); requireTwoFactor {
+		fileAuth = append(fileAuth, middleware.RequireTwoFactor(userRepo, logger))
+	}
+	withFileAuth := func(h ...fiber.Handler) []fiber.Handler {
+		return append(append([]fiber.Handler{}, fileAuth...), h...)
+	}
+
+	// Share links are opened by people without an account.
+	app.Get("/s/:token", shareHandler.Download)
 
 	v1 := app.Group("/api/v1")
 	v1.Post("/user/register", handler.RegisterUser)
 	v1.Post("/user/login", handler.Login)
-	v1.Post("/file", middleware.JWTAuthMiddleware(jwt, logger), fileHandler.UploadFile)
-	v1.Get("/file", middleware.JWTAuthMiddleware(jwt, logger), fileHandler.GetFile)
-	v1.Get("/file/names", middleware.JWTAuthMiddleware(jwt, logger), fileHandler.RetrieveFileNames)
+	v1.Get("/user/verify", accountHandler.VerifyEmail)
+	v1.Post("/user/verify", accountHandler.VerifyEmail)
+	v1.Post("/user/login/2fa", twoFactorHandler.Login)
+	if config.OIDCIssuerURL != "" {
+		provider := services.NewOIDCProvider(services.OIDCConfig{
+			Name:         config.OIDCProviderName,
+			IssuerURL:    config.OIDCIssuerURL,
+			ClientID:     config.OIDCClientID,
+			ClientSecret: config.OIDCClientSecret,
+			RedirectURL:  config.OIDCRedirectURL,
+			Scopes:       strings.Fields(config.OIDCScopes),
+		})
+		oidcHandler := handlers.NewOIDCHandler(services.NewOIDCService(provider, userRepo, repositories.NewUserIdentityRepository(db), jwt, tokenRepo))
+		v1.Get("/user/oidc/login", oidcHandler.Login)
+		v1.Get("/user/oidc/callback", oidcHandler.Callback)
+	}
+	v1.Post("/user/verify/resend", auth, session, accountHandler.ResendVerification)
+	v1.Get("/user/me", auth, session, profileHandler.GetProfile)
+	v1.Patch("/user/me", auth, session, profileHandler.UpdateProfile)
+	v1.Delete("/user/me", auth, session, profileHandler.DeleteAccount)
+	v1.Post("/user/me/email", auth, session, profileHandler.ChangeEmail)
+	v1.Get("/user/me/email/confirm", profileHandler.ConfirmEmailChange)
+	v1.Post("/user/me/email/confirm", profileHandler.ConfirmEmailChange)
+	v1.Post("/user/me/password", auth, session, profileHandler.ChangePassword)
+	v1.Post("/user/password/forgot", accountHandler.ForgotPassword)
+	v1.Post("/user/password/reset", accountHandler.ResetPassword)
+	v1.Post("/user/2fa/setup", auth, session, twoFactorHandler.Setup)
+	v1.Post("/user/2fa/enable", auth, session, twoFactorHandler.Enable)
+	v1.Post("/user/2fa/disable", auth, session, twoFactorHandler.Disable)
+	v1.Post("/user/2fa/recovery-codes", auth, session, twoFactorHandler.RegenerateRecoveryCodes)
+	v1.Get("/user/usage", auth, usageHandler.GetUsage)
+	v1.Post("/user/api-keys", auth, session, apiKeyHandler.CreateKey)
+	v1.Get("/user/api-keys", auth, session, apiKeyHandler.ListKeys)
+	v1.Delete("/user/api-keys/:id", auth, session, apiKeyHandler.RevokeKey)
+
+	v1.Post("/groups", auth, session, groupHandler.CreateGroup)
+	v1.Get("/groups", auth, session, groupHandler.ListGroups)
+	v1.Delete("/groups/:id", auth, session, groupHandler.DeleteGroup)
+	v1.Get("/groups/:id/members", auth, session, groupHandler.ListMembers)
+	v1.Post("/groups/:id/members", auth, session, groupHandler.AddMember)
+	v1.Delete("/groups/:id/members/:userId", auth, session, groupHandler.RemoveMember)
+	v1.Post("/file", withFileAuth(middleware.RequireScope(models.ScopeUpload), middleware.RequireVerifiedEmail(userRepo, logger), fileHandler.UploadFile)...)
+	uploads := v1.Group("/file/uploads", uploadHandler.TusResumable)
+	uploads.Options("", uploadHandler.Options)
+	uploads.Post("", withFileAuth(middleware.RequireScope(models.ScopeUpload), middleware.RequireVerifiedEmail(userRepo, logger), uploadHandler.CreateUpload)...)
+	uploads.Head("/:id", withFileAuth(middleware.RequireScope(models.ScopeUpload), uploadHandler.GetOffset)...)
+	uploads.Patch("/:id", withFileAuth(middleware.RequireScope(models.ScopeUpload), uploadHandler.PatchUpload)...)
+	uploads.Delete("/:id", withFileAuth(middleware.RequireScope(models.ScopeUpload), uploadHandler.TerminateUpload)...)
+	v1.Get("/file", withFileAuth(middleware.RequireScope(models.ScopeRead), fileHandler.GetFile)...)
+	v1.Get("/file/names", withFileAuth(middleware.RequireScope(models.ScopeRead), fileHandler.RetrieveFileNames)...)
+	v1.Get("/file/search", withFileAuth(middleware.RequireScope(models.ScopeRead), fileHandler.SearchFiles)...)
+	v1.Get("/file/archive", withFileAuth(middleware.RequireScope(models.ScopeRead), bulkDownloadHandler.DownloadArchive)...)
+	v1.Get("/file/tags", withFileAuth(middleware.RequireScope(models.ScopeRead), fileHandler.ListTags)...)
+	v1.Post("/file/tags/merge", withFileAuth(middleware.RequireScope(models.ScopeUpload), fileHandler.MergeTags)...)
+	v1.Patch("/file/tags/:name", withFileAuth(middleware.RequireScope(models.ScopeUpload), fileHandler.RenameTag)...)
+	v1.Get("/file/tags/:name/acl", withFileAuth(middleware.RequireScope(models.ScopeRead), accessHandler.List)...)
+	v1.Post("/file/tags/:name/acl", withFileAuth(middleware.RequireScope(models.ScopeUpload), accessHandler.Grant)...)
+	v1.Delete("/file/tags/:name/acl/:entryId", withFileAuth(middleware.RequireScope(models.ScopeUpload), accessHandler.Revoke)...)
+	v1.Get("/file/trash", withFileAuth(middleware.RequireScope(models.ScopeRead), fileHandler.ListTrash)...)
+	v1.Delete("/file/:id", withFileAuth(middleware.RequireScope(models.ScopeDelete), fileHandler.DeleteFile)...)
+	v1.Post("/file/:id/restore", withFileAuth(middleware.RequireScope(models.ScopeDelete), fileHandler.RestoreFile)...)
+	v1.Post("/file/:id/tags", withFileAuth(middleware.RequireScope(models.ScopeUpload), fileHandler.AddTags)...)
+	v1.Delete("/file/:id/tags/:tag", withFileAuth(middleware.RequireScope(models.ScopeUpload), fileHandler.RemoveTag)...)
+	v1.Patch("/file/:id/metadata", withFileAuth(middleware.RequireScope(models.ScopeUpload), fileHandler.UpdateMetadata)...)
+	v1.Get("/file/:id/versions", withFileAuth(middleware.RequireScope(models.ScopeRead), fileHandler.ListVersions)...)
+	v1.Get("/file/:id/download", withFileAuth(middleware.RequireScope(models.ScopeRead), fileHandler.DownloadFile)...)
+	v1.Get("/file/:id/thumbnail", withFileAuth(middleware.RequireScope(models.ScopeRead), fileHandler.GetThumbnail)...)
+	v1.Post("/file/:id/versions/:version/restore", withFileAuth(middleware.RequireScope(models.ScopeUpload), fileHandler.RestoreVersion)...)
+	v1.Post("/file/:id/share", withFileAuth(middleware.RequireScope(models.ScopeUpload), shareHandler.CreateLink)...)
+	v1.Get("/file/:id/share", withFileAuth(middleware.RequireScope(models.ScopeRead), shareHandler.ListLinks)...)
+	v1.Get("/file/:id/share/:linkId/accesses", withFileAuth(middleware.RequireScope(models.ScopeRead), shareHandler.ListAccesses)...)
+	v1.Delete("/file/:id/share/:linkId", withFileAuth(middleware.RequireScope(models.ScopeUpload), shareHandler.RevokeLink)...)
+	v1.Post("/file/:id/move", withFileAuth(middleware.RequireScope(models.ScopeUpload), folderHandler.MoveFile)...)
+	v1.Get("/file/:id/acl", withFileAuth(middleware.RequireScope(models.ScopeRead), accessHandler.List)...)
+	v1.Post("/file/:id/acl", withFileAuth(middleware.RequireScope(models.ScopeUpload), accessHandler.Grant)...)
+	v1.Delete("/file/:id/acl/:entryId", withFileAuth(middleware.RequireScope(models.ScopeUpload), accessHandler.Revoke)...)
+
+	v1.Post("/folders", withFileAuth(middleware.RequireScope(models.ScopeUpload), folderHandler.CreateFolder)...)
+	v1.Get("/folders", withFileAuth(middleware.RequireScope(models.ScopeRead), folderHandler.ListFolder)...)
+	v1.Get("/folders/resolve", withFileAuth(middleware.RequireScope(models.ScopeRead), folderHandler.ResolvePath)...)
+	v1.Get("/folders/:id", withFileAuth(middleware.RequireScope(models.ScopeRead), folderHandler.ListFolder)...)
+	v1.Patch("/folders/:id", withFileAuth(middleware.RequireScope(models.ScopeUpload), folderHandler.RenameFolder)...)
+	v1.Post("/folders/:id/move", withFileAuth(middleware.RequireScope(models.ScopeUpload), folderHandler.MoveFolder)...)
+	v1.Delete("/folders/:id", withFileAuth(middleware.RequireScope(models.ScopeDelete), folderHandler.DeleteFolder)...)
+	v1.Get("/folders/:folderId/acl", withFileAuth(middleware.RequireScope(models.ScopeRead), accessHandler.List)...)
+	v1.Post("/folders/:folderId/acl", withFileAuth(middleware.RequireScope(models.ScopeUpload), accessHandler.Grant)...)
+	v1.Delete("/folders/:folderId/acl/:entryId", withFileAuth(middleware.RequireScope(models.ScopeUpload), accessHandler.Revoke)...)
 
 	log.Fatal(app.Listen(":" + config.Port))
 }
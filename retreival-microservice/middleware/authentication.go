@@ -3,6 +3,7 @@ package middleware
 import (
 	"strings"
 
+	"retreival/repositories"
 	"retreival/services"
 	"retreival/utils"
 
@@ -10,8 +11,17 @@ import (
 	"go.uber.org/zap"
 )
 
-func JWTAuthMiddleware(jwtService *services.JWTService, log *zap.Logger) fiber.Handler {
+// JWTAuthMiddleware authenticates a request with a bearer JWT or, when
+// apiKeys is set, with an API key in the X-API-Key header. It stores the
+// caller in c.Locals("user_id") and, for API keys, the granted scopes in
+// c.Locals("scopes"). When users is set, JWTs of an older session version
+// than the user's are rejected.
+func JWTAuthMiddleware(jwtService *services.JWTService, apiKeys *services.APIKeyService, users *repositories.UserRepository, log *zap.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if rawKey := c.Get("X-API-Key"); rawKey != "" && apiKeys != nil {
+			return authenticateAPIKey(c, apiKeys, rawKey, log)
+		}
+
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -36,6 +46,23 @@ func JWTAuthMiddleware(jwtService *services.JWTService, log *zap.Logger) fiber.H
 		}
 
 		if token.Valid {
+			if userID, ok := services.UserIDFromToken(token); ok {
+				if users != nil {
+					user, err := users.GetUserByID(userID)
+					if err != nil {
+						log.Error("Failed to load user for session check", zap.Error(err))
+						return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+							"message": "Authentication failed",
+						})
+					}
+					if user == nil || user.SessionVersion != services.SessionVersionFromToken(token) {
+						return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+							"message": "Session expired",
+						})
+					}
+				}
+				c.Locals("user_id", userID)
+			}
 			return c.Next()
 		} else {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -44,3 +71,117 @@ func JWTAuthMiddleware(jwtService *services.JWTService, log *zap.Logger) fiber.H
 		}
 	}
 }
+
+func authenticateAPIKey(c *fiber.Ctx, apiKeys *services.APIKeyService, rawKey string, log *zap.Logger) error {
+	key, err := apiKeys.Authenticate(rawKey)
+	if err != nil {
+		switch err {
+		case utils.ErrAPIKeyExpired:
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"message": "API key expired",
+			})
+		case utils.ErrInvalidAPIKey:
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"message": "Invalid API key",
+			})
+		default:
+			log.Error("Failed to authenticate api key", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Authentication failed",
+			})
+		}
+	}
+
+	c.Locals("user_id", key.UserID)
+	c.Locals("api_key_id", key.ID)
+	c.Locals("scopes", key.ScopeList())
+	return c.Next()
+}
+
+// RequireScope lets API key requests through only when the key was granted
+// scope. JWT sessions are not restricted by scopes.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scopes, isAPIKey := c.Locals("scopes").([]string)
+		if !isAPIKey {
+			return c.Next()
+		}
+		for _, granted := range scopes {
+			if granted == scope {
+				return c.Next()
+			}
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "API key lacks the '" + scope + "' scope",
+		})
+	}
+}
+
+// RequireSession rejects API key requests, for endpoints that only a logged in
+// user may call, such as managing the keys themselves.
+func RequireSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, isAPIKey := c.Locals("scopes").([]string); isAPIKey {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "This endpoint does not accept API keys",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// RequireVerifiedEmail rejects users who have not confirmed their email yet.
+// It must run after JWTAuthMiddleware.
+func RequireVerifiedEmail(userRepo *repositories.UserRepository, log *zap.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, _ := c.Locals("user_id").(uint)
+
+		user, err := userRepo.GetUserByID(userID)
+		if err != nil {
+			log.Error("Failed to load user for verification check", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Failed to load user",
+			})
+		}
+		if user == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"message": "Unauthorized",
+			})
+		}
+		if !user.EmailVerified {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Email address is not verified",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireTwoFactor rejects users who have not enabled two-factor
+// authentication. It must run after JWTAuthMiddleware.
+func RequireTwoFactor(userRepo *repositories.UserRepository, log *zap.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, _ := c.Locals("user_id").(uint)
+
+		user, err := userRepo.GetUserByID(userID)
+		if err != nil {
+			log.Error("Failed to load user for two-factor check", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Failed to load user",
+			})
+		}
+		if user == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"message": "Unauthorized",
+			})
+		}
+		if !user.TOTPEnabled {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Two-factor authentication is required",
+			})
+		}
+
+		return c.Next()
+	}
+}
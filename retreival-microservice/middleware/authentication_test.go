@@ -0,0 +1,108 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"retreival/middleware"
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestJWTAuthMiddleware_APIKeyScopes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal("Failed to connect to SQLite:", err)
+	}
+	if err := db.AutoMigrate(&models.APIKey{}); err != nil {
+		t.Fatal("Failed to migrate schema:", err)
+	}
+
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	apiKeys := services.NewAPIKeyService(repositories.NewAPIKeyRepository(db))
+	readKey, _, err := apiKeys.CreateKey(7, models.APIKeyRequest{Name: "reader", Scopes: []string{models.ScopeRead}})
+	assert.NoError(t, err)
+	token, _ := jwtService.GenerateToken(7)
+
+	app := fiber.New()
+	auth := middleware.JWTAuthMiddleware(jwtService, apiKeys, nil, utils.GetLogger())
+	whoami := func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"user_id": c.Locals("user_id")})
+	}
+	app.Get("/read", auth, middleware.RequireScope(models.ScopeRead), whoami)
+	app.Post("/upload", auth, middleware.RequireScope(models.ScopeUpload), whoami)
+	app.Get("/keys", auth, middleware.RequireSession(), whoami)
+
+	request := func(method, path, header, value string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/read", "X-API-Key", readKey))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/upload", "X-API-Key", readKey))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/keys", "X-API-Key", readKey))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/read", "X-API-Key", "mk_invalid"))
+
+	// JWT sessions are not limited by scopes.
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/upload", "Authorization", "Bearer "+token))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/keys", "Authorization", "Bearer "+token))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/read", "", ""))
+}
+
+func TestJWTAuthMiddleware_SessionVersion(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal("Failed to connect to SQLite:", err)
+	}
+	if err := db.AutoMigrate(&models.User{}); err != nil {
+		t.Fatal("Failed to migrate schema:", err)
+	}
+
+	userRepo := repositories.NewUserRepository(db)
+	user, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com"})
+	assert.NoError(t, err)
+
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	oldToken, _ := jwtService.GenerateSessionToken(user.ID, 0)
+
+	app := fiber.New()
+	app.Get("/me", middleware.JWTAuthMiddleware(jwtService, nil, userRepo, utils.GetLogger()), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, request(oldToken))
+
+	// Test case: bumping the version signs out the old token
+	user.SessionVersion++
+	assert.NoError(t, userRepo.UpdateUser(user))
+	assert.Equal(t, http.StatusUnauthorized, request(oldToken))
+
+	newToken, _ := jwtService.GenerateSessionToken(user.ID, user.SessionVersion)
+	assert.Equal(t, http.StatusOK, request(newToken))
+}
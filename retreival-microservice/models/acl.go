@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+const (
+	FileActionGrantAccess  = "grant_access"
+	FileActionRevokeAccess = "revoke_access"
+	FileActionListAccess   = "list_access"
+)
+
+const (
+	PrincipalUser  = "user"
+	PrincipalGroup = "group"
+)
+
+const (
+	PermissionRead = "read"
+	// PermissionWrite includes read.
+	PermissionWrite = "write"
+)
+
+// AccessTarget is what access is granted on: a file, every file with a tag
+// or a folder and everything below it. Exactly one is set.
+type AccessTarget struct {
+	FileID   uint
+	Tag      string
+	FolderID uint
+}
+
+// AccessRequest shares a file, a tag or a folder with a user, named by username or
+// email, or with a group.
+type AccessRequest struct {
+	User       string `json:"user"`
+	GroupID    uint   `json:"group_id"`
+	Permission string `json:"permission"`
+}
+
+// ACLPayload is the payload of the access actions. They work on the
+// command's file, or on the owner's Tag or FolderID if one is set.
+type ACLPayload struct {
+	Tag           string `json:"tag,omitempty"`
+	FolderID      uint   `json:"folder_id,omitempty"`
+	EntryID       uint   `json:"entry_id,omitempty"`
+	PrincipalType string `json:"principal_type,omitempty"`
+	PrincipalID   uint   `json:"principal_id,omitempty"`
+	Permission    string `json:"permission,omitempty"`
+}
+
+// ACLEntryInfo is one grant of access to a file or a tag.
+type ACLEntryInfo struct {
+	ID            uint      `json:"id"`
+	FileID        uint      `json:"file_id,omitempty"`
+	Tag           string    `json:"tag,omitempty"`
+	FolderID      uint      `json:"folder_id,omitempty"`
+	PrincipalType string    `json:"principal_type"`
+	PrincipalID   uint      `json:"principal_id"`
+	Permission    string    `json:"permission"`
+	CreatedAt     time.Time `json:"created_at"`
+}
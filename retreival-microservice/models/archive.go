@@ -0,0 +1,44 @@
+package models
+
+const FileActionExtractArchive = "extract_archive"
+
+// ArchivePayload asks the store to expand an uploaded .zip, .tar or .tar.gz
+// into one file per entry. The entries' types are checked against the
+// upload type policy, which is passed along.
+type ArchivePayload struct {
+	File         FileData `json:"file"`
+	AllowedTypes []string `json:"allowed_types,omitempty"`
+	DeniedTypes  []string `json:"denied_types,omitempty"`
+	MaxFileSize  int64    `json:"max_file_size,omitempty"`
+}
+
+// ArchiveEntryResult is what the store did with one entry of an archive:
+// stored, rejected with a reason, or skipped because it is not a file or
+// its path is unsafe.
+type ArchiveEntryResult struct {
+	FileName   string `json:"file_name"`
+	FileType   string `json:"file_type,omitempty"`
+	FileSize   int64  `json:"file_size"`
+	Status     string `json:"status"`
+	Reason     string `json:"reason,omitempty"`
+	ScanStatus string `json:"scan_status,omitempty"`
+}
+
+const (
+	// UploadAccepted files were sent to the store, which reports the
+	// outcome on the upload status queue.
+	UploadAccepted = "accepted"
+	// UploadExtracted archives were expanded by the store; Entries tells
+	// what happened to each file.
+	UploadExtracted = "extracted"
+)
+
+// UploadResult is the outcome of one file of an upload request.
+type UploadResult struct {
+	FileName string               `json:"file_name"`
+	FileType string               `json:"file_type,omitempty"`
+	FileSize int64                `json:"file_size"`
+	Status   string               `json:"status"`
+	Error    string               `json:"error,omitempty"`
+	Entries  []ArchiveEntryResult `json:"entries,omitempty"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+
+	"retreival/utils"
+)
+
+// ByteRange is Length bytes of a file from Offset.
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// ParseByteRange reads an HTTP Range header of a single byte range for
+// content of size bytes. It returns nil for headers that are to be ignored,
+// which includes several ranges, so the whole content is sent instead.
+func ParseByteRange(header string, size int64) (*ByteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	// bytes=-500 is the last 500 bytes.
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		n = min(n, size)
+		if n == 0 {
+			return nil, utils.ErrRangeNotSatisfiable
+		}
+		return &ByteRange{Offset: size - n, Length: n}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return nil, nil
+		}
+		end = min(e, end)
+	}
+	if start >= size {
+		return nil, utils.ErrRangeNotSatisfiable
+	}
+	return &ByteRange{Offset: start, Length: end - start + 1}, nil
+}
@@ -0,0 +1,37 @@
+package models_test
+
+import (
+	"testing"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseByteRange(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		want   *models.ByteRange
+		err    error
+	}{
+		{"bytes=0-99", &models.ByteRange{Offset: 0, Length: 100}, nil},
+		{"bytes=900-", &models.ByteRange{Offset: 900, Length: 100}, nil},
+		{"bytes=900-5000", &models.ByteRange{Offset: 900, Length: 100}, nil},
+		{"bytes=-10", &models.ByteRange{Offset: 990, Length: 10}, nil},
+		{"bytes=-5000", &models.ByteRange{Offset: 0, Length: 1000}, nil},
+		{"bytes=1000-", nil, utils.ErrRangeNotSatisfiable},
+		{"bytes=-0", nil, utils.ErrRangeNotSatisfiable},
+		// Headers that are not a single valid range are ignored
+		{"bytes=0-1,5-6", nil, nil},
+		{"bytes=5-1", nil, nil},
+		{"items=0-1", nil, nil},
+		{"bytes=abc", nil, nil},
+	} {
+		t.Run(tc.header, func(t *testing.T) {
+			got, err := models.ParseByteRange(tc.header, 1000)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const FileActionUpdateMetadata = "update_metadata"
+
+const (
+	MaxMetadataKeys        = 32
+	MaxMetadataValueLength = 256
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// MetadataPatch changes the custom metadata of a file. Keys set to null are
+// removed.
+type MetadataPatch map[string]*string
+
+// ValidateMetadata checks the keys and values of custom metadata.
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > MaxMetadataKeys {
+		return fmt.Errorf("at most %d metadata keys are allowed", MaxMetadataKeys)
+	}
+	for key, value := range metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid metadata key %q", key)
+		}
+		if len(value) > MaxMetadataValueLength {
+			return fmt.Errorf("metadata value of %q is too long", key)
+		}
+	}
+	return nil
+}
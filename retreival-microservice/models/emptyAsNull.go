@@ -0,0 +1,40 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("empty_as_null", EmptyAsNullSerializer{})
+}
+
+// EmptyAsNullSerializer saves empty strings as NULL and reads NULL back as
+// an empty string. Unique columns then accept any number of rows without a
+// value, like users that signed in through a provider that sent no email.
+type EmptyAsNullSerializer struct{}
+
+func (EmptyAsNullSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("failed to scan string value: %#v", dbValue)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+func (EmptyAsNullSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	if value, _ := fieldValue.(string); value != "" {
+		return value, nil
+	}
+	return nil, nil
+}
@@ -1,15 +1,28 @@
 package models
 
+// FileData is an upload sent to the store on file-data-queue. FileBytes
+// carries the content, base64 encoded in the JSON message.
 type FileData struct {
-	FileName  string   `json:"file_name"`
-	FileType  string   `json:"file_type"`
-	FileSize  int64    `json:"file_size"`
-	FileTags  []string `json:"file_tags"`
-	FileBytes []byte   `json:"-"`
-	TagName   []string `json:"tag_name"`
-	Type      string   `json:"type"`
+	FileName   string   `json:"file_name"`
+	FileType   string   `json:"file_type"`
+	FileSize   int64    `json:"file_size"`
+	FileTags   []string `json:"file_tags"`
+	FileBytes  []byte   `json:"file_bytes"`
+	TagName    []string `json:"tag_name"`
+	Type       string   `json:"type"`
+	OwnerID    uint     `json:"owner_id"`
+	QuotaBytes int64    `json:"quota_bytes"`
+	// FolderID is the owner's folder the file is saved in, nil for the root.
+	FolderID *uint `json:"folder_id,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// DeclaredType is the type the client sent; FileType is detected from
+	// the content.
+	DeclaredType string `json:"declared_type,omitempty"`
 }
 type FileRequest struct {
-	Name string   `json:"name"`
-	Tags []string `json:"tags"`
+	Name     string   `json:"name"`
+	Tags     []string `json:"tags"`
+	OwnerID  uint     `json:"owner_id"`
+	GroupIDs []uint   `json:"group_ids,omitempty"`
 }
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	FileActionDelete    = "delete"
+	FileActionListTrash = "list_trash"
+	FileActionRestore   = "restore"
+
+	FileActionListVersions   = "list_versions"
+	FileActionDownload       = "download"
+	FileActionRestoreVersion = "restore_version"
+)
+
+const (
+	CommandStatusOK       = "ok"
+	CommandStatusNotFound = "not_found"
+	CommandStatusInvalid  = "invalid"
+	CommandStatusConflict = "conflict"
+	CommandStatusTooLarge = "too_large"
+	CommandStatusError    = "error"
+)
+
+// FileCommand asks the store to act on the files of OwnerID and the files
+// shared with OwnerID or GroupIDs. Payload holds the action specific
+// arguments.
+type FileCommand struct {
+	Action   string          `json:"action"`
+	OwnerID  uint            `json:"owner_id"`
+	GroupIDs []uint          `json:"group_ids,omitempty"`
+	FileID   uint            `json:"file_id,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+}
+
+// FileCommandReply is the store's answer to a FileCommand.
+type FileCommandReply struct {
+	Status string          `json:"status"`
+	Error  string          `json:"error,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// FileInfo is the metadata of a stored file.
+type FileInfo struct {
+	ID        uint              `json:"id"`
+	OwnerID   uint              `json:"owner_id"`
+	FolderID  *uint             `json:"folder_id"`
+	FileName  string            `json:"file_name"`
+	FileType  string            `json:"file_type"`
+	FileSize  int64             `json:"file_size"`
+	Version   int               `json:"version"`
+	Tags      []string          `json:"tags"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	DeletedAt *time.Time        `json:"deleted_at,omitempty"`
+}
+
+// VersionPayload selects a version for the download and restore_version
+// actions. Version 0 is the current version.
+type VersionPayload struct {
+	Version int `json:"version"`
+}
+
+// DownloadPayload selects the version and the bytes for the download action.
+// Without a Range the whole content is sent; with InfoOnly none of it.
+type DownloadPayload struct {
+	Version  int        `json:"version"`
+	Range    *ByteRange `json:"range,omitempty"`
+	InfoOnly bool       `json:"info_only,omitempty"`
+}
+
+// FileVersionInfo describes one upload of a file.
+type FileVersionInfo struct {
+	Version      int       `json:"version"`
+	FileType     string    `json:"file_type"`
+	DeclaredType string    `json:"declared_type,omitempty"`
+	FileSize     int64     `json:"file_size"`
+	Current      bool      `json:"current"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// FileContent is a downloaded version of a file. Content holds the requested
+// bytes, which start at Offset of the FileSize bytes of the version.
+type FileContent struct {
+	OwnerID    uint      `json:"owner_id"`
+	FileName   string    `json:"file_name"`
+	FileType   string    `json:"file_type"`
+	Version    int       `json:"version"`
+	FileSize   int64     `json:"file_size"`
+	Offset     int64     `json:"offset,omitempty"`
+	ETag       string    `json:"etag"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Content    []byte    `json:"content"`
+}
@@ -0,0 +1,25 @@
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"retreival/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileData_JSONCarriesContent(t *testing.T) {
+	content := []byte{0x00, 0xff, 'P', 'K', 0x03, 0x04, '\n'}
+	data, err := json.Marshal(models.FileData{FileName: "a.bin", FileSize: int64(len(content)), FileBytes: content})
+	require.NoError(t, err)
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.Equal(t, "AP9QSwMECg==", fields["file_bytes"])
+
+	var decoded models.FileData
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, content, decoded.FileBytes)
+}
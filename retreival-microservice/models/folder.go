@@ -0,0 +1,80 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	FileActionCreateFolder = "create_folder"
+	FileActionRenameFolder = "rename_folder"
+	FileActionMoveFolder   = "move_folder"
+	FileActionDeleteFolder = "delete_folder"
+	FileActionListFolder   = "list_folder"
+	FileActionMoveFile     = "move_file"
+	FileActionResolvePath  = "resolve_path"
+)
+
+const MaxFolderNameLength = 255
+
+// ValidFolderName reports whether name can name a folder. Names are path
+// segments, so they cannot hold slashes.
+func ValidFolderName(name string) bool {
+	return name != "" && name != "." && name != ".." && len(name) <= MaxFolderNameLength &&
+		strings.TrimSpace(name) == name && !strings.Contains(name, "/")
+}
+
+// FolderRequest creates, renames or moves a folder. A nil ParentID is the
+// root.
+type FolderRequest struct {
+	Name     string `json:"name"`
+	ParentID *uint  `json:"parent_id"`
+}
+
+// FolderPayload is the payload of the folder actions. A nil ParentID or
+// FolderID is the root of the caller's tree.
+type FolderPayload struct {
+	FolderID uint   `json:"folder_id,omitempty"`
+	ParentID *uint  `json:"parent_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	// Recursive deletes folders that are not empty. Their files go to the
+	// trash.
+	Recursive bool   `json:"recursive,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+	Cursor    string `json:"cursor,omitempty"`
+}
+
+// MoveFilePayload is the payload of the move_file action. A nil FolderID
+// moves the file to the root.
+type MoveFilePayload struct {
+	FolderID *uint `json:"folder_id"`
+}
+
+type PathPayload struct {
+	Path string `json:"path"`
+}
+
+type FolderInfo struct {
+	ID        uint      `json:"id"`
+	OwnerID   uint      `json:"owner_id"`
+	ParentID  *uint     `json:"parent_id"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FolderListing is one page of a folder's contents, subfolders before files.
+// Folder is nil for the root.
+type FolderListing struct {
+	Folder     *FolderInfo  `json:"folder"`
+	Folders    []FolderInfo `json:"folders"`
+	Files      []FileInfo   `json:"files"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// PathResult is what a path names: a folder or a file.
+type PathResult struct {
+	Folder *FolderInfo `json:"folder,omitempty"`
+	File   *FileInfo   `json:"file,omitempty"`
+}
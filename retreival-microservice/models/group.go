@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Group is a named set of users that files and tags can be shared with. The
+// owner manages the members and is a member too.
+type Group struct {
+	gorm.Model
+	OwnerID uint `gorm:"index"`
+	Name    string
+}
+
+type GroupMember struct {
+	ID        uint `gorm:"primarykey"`
+	GroupID   uint `gorm:"uniqueIndex:idx_group_member"`
+	UserID    uint `gorm:"uniqueIndex:idx_group_member;index"`
+	CreatedAt time.Time
+}
+
+type GroupRequest struct {
+	Name string `json:"name"`
+}
+
+// GroupMemberRequest names the user to add by username or email.
+type GroupMemberRequest struct {
+	User string `json:"user"`
+}
+
+type GroupResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	OwnerID   uint      `json:"owner_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type GroupMemberResponse struct {
+	UserID   uint      `json:"user_id"`
+	Username string    `json:"username"`
+	AddedAt  time.Time `json:"added_at"`
+}
+
+func ConvertGroupToResponse(group Group) GroupResponse {
+	return GroupResponse{
+		ID:        group.ID,
+		Name:      group.Name,
+		OwnerID:   group.OwnerID,
+		CreatedAt: group.CreatedAt,
+	}
+}
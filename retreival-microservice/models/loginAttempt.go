@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginAttempt tracks failed logins for a single throttling key, such as an
+// account identifier or a client IP.
+type LoginAttempt struct {
+	gorm.Model
+	Key           string `gorm:"uniqueIndex"`
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
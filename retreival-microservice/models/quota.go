@@ -0,0 +1,62 @@
+package models
+
+import "gorm.io/gorm"
+
+const DefaultPlan = "free"
+
+// Plan sets the storage quota for every user on it. A quota of 0 or less
+// means unlimited.
+type Plan struct {
+	gorm.Model
+	Name       string `gorm:"uniqueIndex"`
+	QuotaBytes int64
+}
+
+// StorageUsage is the retrieval side view of a user's storage. UsedBytes and
+// FileCount are reported by the store; PendingBytes are uploads that were
+// published but not confirmed yet.
+type StorageUsage struct {
+	gorm.Model
+	UserID       uint `gorm:"uniqueIndex"`
+	UsedBytes    int64
+	PendingBytes int64
+	FileCount    int64
+}
+
+const (
+	UploadStatusStored   = "stored"
+	UploadStatusRejected = "rejected"
+	// UploadStatusPurged only reports the new usage after files were purged.
+	UploadStatusPurged = "purged"
+
+	ScanInfected = "infected"
+)
+
+// UploadStatus is published by the store for every file it received.
+type UploadStatus struct {
+	OwnerID   uint   `json:"owner_id"`
+	FileName  string `json:"file_name"`
+	FileSize  int64  `json:"file_size"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+	UsedBytes int64  `json:"used_bytes"`
+	FileCount int64  `json:"file_count"`
+
+	// ScanStatus is the store's malware scan verdict; Signature names what
+	// an infected file contained.
+	ScanStatus string `json:"scan_status,omitempty"`
+	Signature  string `json:"signature,omitempty"`
+	// Archive names the archive a file was extracted from. Quota was
+	// reserved for the archive, not for its files.
+	Archive string `json:"archive,omitempty"`
+}
+
+// UsageResponse leaves AvailableBytes out for unlimited quotas.
+type UsageResponse struct {
+	Plan           string `json:"plan"`
+	QuotaBytes     int64  `json:"quota_bytes"`
+	UsedBytes      int64  `json:"used_bytes"`
+	PendingBytes   int64  `json:"pending_bytes"`
+	AvailableBytes *int64 `json:"available_bytes,omitempty"`
+	FileCount      int64  `json:"file_count"`
+}
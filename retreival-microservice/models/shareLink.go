@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TokenPurposeShareLink = "share_link"
+	// TokenPurposeShareDownload grants ranges of one counted download.
+	TokenPurposeShareDownload = "share_download"
+)
+
+// ShareLink lets anyone with its signed token download one file of UserID
+// until it expires, is revoked or reaches MaxDownloads (0 is unlimited).
+type ShareLink struct {
+	gorm.Model
+	UserID       uint   `gorm:"index"`
+	FileID       uint   `gorm:"index"`
+	JTI          string `gorm:"uniqueIndex"`
+	PasswordHash string
+	MaxDownloads int
+	Downloads    int
+	ExpiresAt    time.Time
+	LastUsedAt   *time.Time
+	RevokedAt    *time.Time
+}
+
+const (
+	ShareAccessDownloaded    = "downloaded"
+	ShareAccessWrongPassword = "wrong_password"
+	ShareAccessLimitReached  = "limit_reached"
+)
+
+// ShareLinkAccess records a use of a share link. GrantID is the jti of the
+// download grant issued with a counted download.
+type ShareLinkAccess struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	ShareLinkID uint      `gorm:"index" json:"share_link_id"`
+	Result      string    `json:"result"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	GrantID     string    `gorm:"index" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ShareLinkRequest struct {
+	ExpiresAt    *time.Time `json:"expires_at"`
+	Password     string     `json:"password"`
+	MaxDownloads int        `json:"max_downloads"`
+}
+
+type ShareLinkResponse struct {
+	ID                uint       `json:"id"`
+	FileID            uint       `json:"file_id"`
+	PasswordProtected bool       `json:"password_protected"`
+	MaxDownloads      int        `json:"max_downloads,omitempty"`
+	Downloads         int        `json:"downloads"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	LastUsedAt        *time.Time `json:"last_used_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+func ConvertShareLinkToResponse(link ShareLink) ShareLinkResponse {
+	return ShareLinkResponse{
+		ID:                link.ID,
+		FileID:            link.FileID,
+		PasswordProtected: link.PasswordHash != "",
+		MaxDownloads:      link.MaxDownloads,
+		Downloads:         link.Downloads,
+		ExpiresAt:         link.ExpiresAt,
+		LastUsedAt:        link.LastUsedAt,
+		CreatedAt:         link.CreatedAt,
+	}
+}
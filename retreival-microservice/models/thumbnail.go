@@ -0,0 +1,11 @@
+package models
+
+const FileActionThumbnail = "thumbnail"
+
+// ThumbnailPayload selects the version and the size in pixels for the
+// thumbnail action. The store sends the smallest thumbnail of at least Size
+// pixels; Version 0 is the current version and Size 0 the default size.
+type ThumbnailPayload struct {
+	Version int `json:"version"`
+	Size    int `json:"size"`
+}
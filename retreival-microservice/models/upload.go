@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// TusVersion is the tus resumable upload protocol version that is spoken.
+const TusVersion = "1.0.0"
+
+// UploadSession is a resumable upload. The bytes received so far have been
+// forwarded to the store as UploadChunks; Offset is how many there are.
+type UploadSession struct {
+	ID           string `gorm:"primarykey"`
+	UserID       uint   `gorm:"index"`
+	FileName     string
+	FileType     string
+	DeclaredType string
+	Tags         string // comma separated
+	Metadata     string // JSON object of custom metadata
+	FolderID     *uint
+	Length       int64
+	Offset       int64
+	QuotaBytes   int64
+	ExpiresAt    time.Time `gorm:"index"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// UploadChunk carries part of a resumable upload to the store. The store
+// saves the file when it receives the Final chunk, which has no bytes but
+// the file's metadata; Abort drops what was received.
+type UploadChunk struct {
+	UploadID string    `json:"upload_id"`
+	Offset   int64     `json:"offset"`
+	Bytes    []byte    `json:"bytes,omitempty"`
+	Final    bool      `json:"final,omitempty"`
+	Abort    bool      `json:"abort,omitempty"`
+	File     *FileData `json:"file,omitempty"`
+}
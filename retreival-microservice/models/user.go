@@ -9,10 +9,29 @@ import (
 type User struct {
 	gorm.Model
 	Username  string `gorm:"unique"`
-	Email     string `gorm:"unique"`
+	Email     string `gorm:"unique;serializer:empty_as_null"`
 	Password  string
 	FirstName string
 	LastName  string
+
+	EmailVerified   bool
+	EmailVerifiedAt *time.Time
+	// PendingEmail holds a requested new address until it is confirmed.
+	PendingEmail string
+
+	TOTPEnabled       bool
+	TOTPSecret        string
+	TOTPPendingSecret string
+	TOTPLastStep      int64
+
+	// Plan selects the storage quota; QuotaBytes overrides it for this user.
+	Plan       string
+	QuotaBytes *int64
+
+	// SessionVersion is signed into access tokens; bumping it signs the user
+	// out everywhere.
+	SessionVersion int
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -26,12 +45,40 @@ type UserRegistrationRequest struct {
 }
 
 type UserRegistrationResponse struct {
-	ID        uint      `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            uint      `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// UpdateProfileRequest changes only the fields that are present.
+type UpdateProfileRequest struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type UserProfileResponse struct {
+	UserRegistrationResponse
+	PendingEmail     string    `json:"pending_email,omitempty"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	UpdatedAt        time.Time `json:"updated_at"`
 }
 
 func ConvertUserRegistrationRequestToUser(req UserRegistrationRequest) User {
@@ -51,6 +98,17 @@ func ConvertUserToUserRegistrationResponse(user User) UserRegistrationResponse {
 		Email:     user.Email,
 		FirstName: user.FirstName,
 		LastName:  user.LastName,
-		CreatedAt: user.CreatedAt,
+
+		EmailVerified: user.EmailVerified,
+		CreatedAt:     user.CreatedAt,
+	}
+}
+
+func ConvertUserToProfileResponse(user User) UserProfileResponse {
+	return UserProfileResponse{
+		UserRegistrationResponse: ConvertUserToUserRegistrationResponse(user),
+		PendingEmail:             user.PendingEmail,
+		TwoFactorEnabled:         user.TOTPEnabled,
+		UpdatedAt:                user.UpdatedAt,
 	}
 }
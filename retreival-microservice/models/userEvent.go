@@ -0,0 +1,10 @@
+package models
+
+const UserEventDeleted = "user.deleted"
+
+// UserEvent is published on the user events queue so the store can react to
+// account changes.
+type UserEvent struct {
+	Event  string `json:"event"`
+	UserID uint   `json:"user_id"`
+}
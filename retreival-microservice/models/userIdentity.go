@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserIdentity links a local user to an account at an external identity
+// provider.
+type UserIdentity struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	Provider string `gorm:"uniqueIndex:idx_provider_subject"`
+	Subject  string `gorm:"uniqueIndex:idx_provider_subject"`
+	Email    string
+}
+
+// OIDCLoginState remembers an authorization request between the redirect to
+// the provider and its callback.
+type OIDCLoginState struct {
+	gorm.Model
+	State        string `gorm:"uniqueIndex"`
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	TokenPurposeTwoFactor     = "2fa_challenge"
+	TokenPurposeChangeEmail   = "change_email"
+)
+
+// UserToken records a signed one-time token so it can be redeemed only once.
+type UserToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	Purpose   string `gorm:"index"`
+	JTI       string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{
+		db:  db,
+		log: utils.GetLogger(),
+	}
+}
+
+func (ar *APIKeyRepository) CreateKey(key *models.APIKey) error {
+	return ar.db.Create(key).Error
+}
+
+// ListKeys returns the user's keys that have not been revoked.
+func (ar *APIKeyRepository) ListKeys(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := ar.db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+func (ar *APIKeyRepository) GetKeyByHash(hash string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := ar.db.Where("key_hash = ?", hash).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		ar.log.Warn("Error happend during loading api key",
+			zap.String("reason", "database_error"),
+		)
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RevokeKey revokes one of the user's keys. It reports false when the user
+// has no such active key.
+func (ar *APIKeyRepository) RevokeKey(userID, keyID uint) (bool, error) {
+	result := ar.db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func (ar *APIKeyRepository) TouchLastUsed(keyID uint, at time.Time) error {
+	return ar.db.Model(&models.APIKey{}).Where("id = ?", keyID).Update("last_used_at", at).Error
+}
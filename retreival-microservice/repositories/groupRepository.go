@@ -0,0 +1,105 @@
+package repositories
+
+import (
+	"errors"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type GroupRepository struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewGroupRepository(db *gorm.DB) *GroupRepository {
+	return &GroupRepository{
+		db:  db,
+		log: utils.GetLogger(),
+	}
+}
+
+// CreateGroup stores the group with its owner as the first member.
+func (gr *GroupRepository) CreateGroup(group *models.Group) error {
+	return gr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(group).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.GroupMember{GroupID: group.ID, UserID: group.OwnerID}).Error
+	})
+}
+
+func (gr *GroupRepository) GetGroupByID(groupID uint) (*models.Group, error) {
+	var group models.Group
+	if err := gr.db.First(&group, groupID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		gr.log.Warn("Error happend during loading group",
+			zap.String("reason", "database_error"),
+			zap.Uint("groupID", groupID),
+		)
+		return nil, err
+	}
+	return &group, nil
+}
+
+// ListGroups returns the groups the user is a member of.
+func (gr *GroupRepository) ListGroups(userID uint) ([]models.Group, error) {
+	var groups []models.Group
+	err := gr.db.Joins("JOIN group_members ON group_members.group_id = groups.id").
+		Where("group_members.user_id = ?", userID).
+		Order("groups.name").
+		Find(&groups).Error
+	return groups, err
+}
+
+// GroupIDs returns the ids of the groups the user is a member of.
+func (gr *GroupRepository) GroupIDs(userID uint) ([]uint, error) {
+	var ids []uint
+	err := gr.db.Model(&models.GroupMember{}).
+		Joins("JOIN groups ON groups.id = group_members.group_id AND groups.deleted_at IS NULL").
+		Where("group_members.user_id = ?", userID).
+		Pluck("group_members.group_id", &ids).Error
+	return ids, err
+}
+
+func (gr *GroupRepository) DeleteGroup(groupID uint) error {
+	return gr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", groupID).Delete(&models.GroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Group{}, groupID).Error
+	})
+}
+
+func (gr *GroupRepository) IsMember(groupID, userID uint) (bool, error) {
+	var count int64
+	err := gr.db.Model(&models.GroupMember{}).Where("group_id = ? AND user_id = ?", groupID, userID).Count(&count).Error
+	return count > 0, err
+}
+
+func (gr *GroupRepository) AddMember(member *models.GroupMember) error {
+	return gr.db.Create(member).Error
+}
+
+// RemoveMember reports false when the user is not a member of the group.
+func (gr *GroupRepository) RemoveMember(groupID, userID uint) (bool, error) {
+	result := gr.db.Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&models.GroupMember{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListMembers returns the members of the group with their usernames.
+func (gr *GroupRepository) ListMembers(groupID uint) ([]models.GroupMemberResponse, error) {
+	members := []models.GroupMemberResponse{}
+	err := gr.db.Model(&models.GroupMember{}).
+		Select("group_members.user_id, users.username, group_members.created_at AS added_at").
+		Joins("JOIN users ON users.id = group_members.user_id").
+		Where("group_members.group_id = ?", groupID).
+		Order("users.username").
+		Scan(&members).Error
+	return members, err
+}
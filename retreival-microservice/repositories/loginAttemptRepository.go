@@ -0,0 +1,170 @@
+package repositories
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttemptStore persists failed login counters. Get returns nil when no
+// attempt has been recorded for the key.
+//
+// AddFailure counts a failure at now in one step and returns the updated
+// attempt; a count whose last failure is older than window and that is not
+// locked starts over. Lock moves LockedUntil forward to until and never back.
+// DeleteExpired removes the attempts whose last failure is before cutoff and
+// that are not locked at now.
+type LoginAttemptStore interface {
+	Get(key string) (*models.LoginAttempt, error)
+	AddFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempt, error)
+	Lock(key string, until time.Time) error
+	Delete(key string) error
+	DeleteExpired(cutoff, now time.Time) error
+}
+
+type LoginAttemptRepository struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{
+		db:  db,
+		log: utils.GetLogger(),
+	}
+}
+
+func (lr *LoginAttemptRepository) Get(key string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	if err := lr.db.Where("key = ?", key).First(&attempt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		lr.log.Warn("Error happend during loading login attempts",
+			zap.String("reason", "database_error"),
+			zap.String("key", key),
+		)
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (lr *LoginAttemptRepository) AddFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	attempt := models.LoginAttempt{Key: key, Failures: 1, LastFailureAt: now}
+	err := lr.db.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures": gorm.Expr("CASE WHEN login_attempts.last_failure_at < ? AND login_attempts.locked_until < ? THEN 1 ELSE login_attempts.failures + 1 END",
+					now.Add(-window), now),
+				"last_failure_at": now,
+				"updated_at":      now,
+			}),
+		},
+		clause.Returning{},
+	).Create(&attempt).Error
+	if err != nil {
+		lr.log.Warn("Error happend during counting login failure",
+			zap.String("reason", "database_error"),
+			zap.String("key", key),
+		)
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (lr *LoginAttemptRepository) Lock(key string, until time.Time) error {
+	return lr.db.Model(&models.LoginAttempt{}).
+		Where("key = ? AND locked_until < ?", key, until).
+		Update("locked_until", until).Error
+}
+
+func (lr *LoginAttemptRepository) Delete(key string) error {
+	return lr.db.Unscoped().Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+func (lr *LoginAttemptRepository) DeleteExpired(cutoff, now time.Time) error {
+	err := lr.db.Unscoped().Where("last_failure_at < ? AND locked_until < ?", cutoff, now).Delete(&models.LoginAttempt{}).Error
+	if err != nil {
+		lr.log.Warn("Error happend during deleting expired login attempts",
+			zap.String("reason", "database_error"),
+		)
+	}
+	return err
+}
+
+// InMemoryLoginAttemptStore keeps login attempts in process memory. It is
+// meant for single instance deployments and tests.
+type InMemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempt
+}
+
+func NewInMemoryLoginAttemptStore() *InMemoryLoginAttemptStore {
+	return &InMemoryLoginAttemptStore{attempts: make(map[string]models.LoginAttempt)}
+}
+
+func (ms *InMemoryLoginAttemptStore) Get(key string) (*models.LoginAttempt, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	attempt, ok := ms.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	return &attempt, nil
+}
+
+func (ms *InMemoryLoginAttemptStore) AddFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	attempt := ms.attempts[key]
+	attempt.Key = key
+	if attempt.LastFailureAt.Before(now.Add(-window)) && attempt.LockedUntil.Before(now) {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	ms.attempts[key] = attempt
+	return &attempt, nil
+}
+
+func (ms *InMemoryLoginAttemptStore) Lock(key string, until time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	attempt, ok := ms.attempts[key]
+	if ok && attempt.LockedUntil.Before(until) {
+		attempt.LockedUntil = until
+		ms.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (ms *InMemoryLoginAttemptStore) Delete(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.attempts, key)
+	return nil
+}
+
+func (ms *InMemoryLoginAttemptStore) DeleteExpired(cutoff, now time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for key, attempt := range ms.attempts {
+		if attempt.LastFailureAt.Before(cutoff) && attempt.LockedUntil.Before(now) {
+			delete(ms.attempts, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package repositories
+
+import (
+	"errors"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type QuotaRepository struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewQuotaRepository(db *gorm.DB) *QuotaRepository {
+	return &QuotaRepository{
+		db:  db,
+		log: utils.GetLogger(),
+	}
+}
+
+func (qr *QuotaRepository) GetPlan(name string) (*models.Plan, error) {
+	var plan models.Plan
+	if err := qr.db.Where("name = ?", name).First(&plan).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		qr.log.Warn("Error happend during loading plan",
+			zap.String("reason", "database_error"),
+			zap.String("plan", name),
+		)
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// CreatePlanIfMissing stores the plan unless one with the same name exists.
+func (qr *QuotaRepository) CreatePlanIfMissing(plan *models.Plan) error {
+	return qr.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "name"}}, DoNothing: true}).Create(plan).Error
+}
+
+func (qr *QuotaRepository) GetUsage(userID uint) (*models.StorageUsage, error) {
+	var usage models.StorageUsage
+	if err := qr.db.Where("user_id = ?", userID).First(&usage).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		qr.log.Warn("Error happend during loading storage usage",
+			zap.String("reason", "database_error"),
+			zap.Uint("userID", userID),
+		)
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// ReservePending adds size to the pending bytes if used, pending and size
+// together stay within quota. It reports whether the space was reserved; a
+// quota of 0 or less always succeeds.
+func (qr *QuotaRepository) ReservePending(userID uint, size, quota int64) (bool, error) {
+	if err := qr.ensureUsage(userID); err != nil {
+		return false, err
+	}
+
+	query := qr.db.Model(&models.StorageUsage{}).Where("user_id = ?", userID)
+	if quota > 0 {
+		query = query.Where("used_bytes + pending_bytes + ? <= ?", size, quota)
+	}
+	result := query.Update("pending_bytes", gorm.Expr("pending_bytes + ?", size))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ReleasePending gives back a reservation, never going below zero.
+func (qr *QuotaRepository) ReleasePending(userID uint, size int64) error {
+	return qr.db.Model(&models.StorageUsage{}).
+		Where("user_id = ?", userID).
+		Update("pending_bytes", gorm.Expr("CASE WHEN pending_bytes > ? THEN pending_bytes - ? ELSE 0 END", size, size)).Error
+}
+
+// ApplyStatus releases the reservation of a confirmed or rejected upload and
+// takes over the usage reported by the store.
+func (qr *QuotaRepository) ApplyStatus(userID uint, size, usedBytes, fileCount int64) error {
+	if err := qr.ensureUsage(userID); err != nil {
+		return err
+	}
+	return qr.db.Model(&models.StorageUsage{}).
+		Where("user_id = ?", userID).
+		Updates(map[string]interface{}{
+			"pending_bytes": gorm.Expr("CASE WHEN pending_bytes > ? THEN pending_bytes - ? ELSE 0 END", size, size),
+			"used_bytes":    usedBytes,
+			"file_count":    fileCount,
+		}).Error
+}
+
+func (qr *QuotaRepository) ensureUsage(userID uint) error {
+	usage := models.StorageUsage{UserID: userID}
+	return qr.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "user_id"}}, DoNothing: true}).Create(&usage).Error
+}
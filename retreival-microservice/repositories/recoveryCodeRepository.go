@@ -0,0 +1,64 @@
+package repositories
+
+import (
+	"time"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type RecoveryCodeRepository struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewRecoveryCodeRepository(db *gorm.DB) *RecoveryCodeRepository {
+	return &RecoveryCodeRepository{
+		db:  db,
+		log: utils.GetLogger(),
+	}
+}
+
+// ReplaceCodes deletes every existing code of the user and stores the new
+// hashes in one transaction.
+func (rr *RecoveryCodeRepository) ReplaceCodes(userID uint, hashes []string) error {
+	return rr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: hash}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (rr *RecoveryCodeRepository) DeleteCodes(userID uint) error {
+	return rr.db.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+// UseCode redeems an unused code. It reports false when no such code exists.
+func (rr *RecoveryCodeRepository) UseCode(userID uint, hash string) (bool, error) {
+	result := rr.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		rr.log.Warn("Error happend during redeeming recovery code",
+			zap.String("reason", "database_error"),
+			zap.Uint("userID", userID),
+		)
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (rr *RecoveryCodeRepository) CountUnused(userID uint) (int64, error) {
+	var count int64
+	err := rr.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type ShareLinkRepository struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewShareLinkRepository(db *gorm.DB) *ShareLinkRepository {
+	return &ShareLinkRepository{
+		db:  db,
+		log: utils.GetLogger(),
+	}
+}
+
+func (sr *ShareLinkRepository) CreateLink(link *models.ShareLink) error {
+	return sr.db.Create(link).Error
+}
+
+func (sr *ShareLinkRepository) GetLinkByJTI(jti string) (*models.ShareLink, error) {
+	var link models.ShareLink
+	if err := sr.db.Where("jti = ?", jti).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		sr.log.Warn("Error happend during loading share link",
+			zap.String("reason", "database_error"),
+		)
+		return nil, err
+	}
+	return &link, nil
+}
+
+// ListLinks returns the links of one of the user's files that have not been
+// revoked.
+func (sr *ShareLinkRepository) ListLinks(userID, fileID uint) ([]models.ShareLink, error) {
+	var links []models.ShareLink
+	err := sr.db.Where("user_id = ? AND file_id = ? AND revoked_at IS NULL", userID, fileID).Order("created_at DESC").Find(&links).Error
+	return links, err
+}
+
+// RevokeLink revokes a link of one of the user's files. It reports false
+// when there is no such active link.
+func (sr *ShareLinkRepository) RevokeLink(userID, fileID, linkID uint) (bool, error) {
+	result := sr.db.Model(&models.ShareLink{}).
+		Where("id = ? AND user_id = ? AND file_id = ? AND revoked_at IS NULL", linkID, userID, fileID).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// ClaimDownload counts a download of the link. It reports false when the
+// link reached its limit, also when other requests claimed the last ones.
+func (sr *ShareLinkRepository) ClaimDownload(linkID uint, at time.Time) (bool, error) {
+	result := sr.db.Model(&models.ShareLink{}).
+		Where("id = ? AND (max_downloads = 0 OR downloads < max_downloads)", linkID).
+		Updates(map[string]interface{}{
+			"downloads":    gorm.Expr("downloads + 1"),
+			"last_used_at": at,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (sr *ShareLinkRepository) RecordAccess(access *models.ShareLinkAccess) error {
+	return sr.db.Create(access).Error
+}
+
+// ListAccesses returns the recorded uses of a link, newest first.
+func (sr *ShareLinkRepository) ListAccesses(linkID uint) ([]models.ShareLinkAccess, error) {
+	var accesses []models.ShareLinkAccess
+	err := sr.db.Where("share_link_id = ?", linkID).Order("created_at DESC").Find(&accesses).Error
+	return accesses, err
+}
+
+// CountAccesses counts the uses of a link with result since a time.
+func (sr *ShareLinkRepository) CountAccesses(linkID uint, result string, since time.Time) (int64, error) {
+	var count int64
+	err := sr.db.Model(&models.ShareLinkAccess{}).
+		Where("share_link_id = ? AND result = ? AND created_at >= ?", linkID, result, since).
+		Count(&count).Error
+	return count, err
+}
+
+// HasGrant reports whether a counted download of the link issued the grant
+// with the jti.
+func (sr *ShareLinkRepository) HasGrant(linkID uint, jti string) (bool, error) {
+	var count int64
+	err := sr.db.Model(&models.ShareLinkAccess{}).
+		Where("share_link_id = ? AND result = ? AND grant_id = ?", linkID, models.ShareAccessDownloaded, jti).
+		Count(&count).Error
+	return count > 0, err
+}
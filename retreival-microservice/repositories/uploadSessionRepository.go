@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type UploadSessionRepository struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewUploadSessionRepository(db *gorm.DB) *UploadSessionRepository {
+	return &UploadSessionRepository{
+		db:  db,
+		log: utils.GetLogger(),
+	}
+}
+
+func (ur *UploadSessionRepository) CreateSession(session *models.UploadSession) error {
+	return ur.db.Create(session).Error
+}
+
+func (ur *UploadSessionRepository) GetSession(id string) (*models.UploadSession, error) {
+	var session models.UploadSession
+	if err := ur.db.Where("id = ?", id).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		ur.log.Warn("Error happend during loading upload session",
+			zap.String("reason", "database_error"),
+		)
+		return nil, err
+	}
+	return &session, nil
+}
+
+// AdvanceOffset moves the session's offset from one value to another. It
+// reports false when the offset is no longer from, because another request
+// got there first.
+func (ur *UploadSessionRepository) AdvanceOffset(id string, from, to int64) (bool, error) {
+	result := ur.db.Model(&models.UploadSession{}).
+		Where("id = ? AND \"offset\" = ?", id, from).
+		Updates(map[string]interface{}{"offset": to, "updated_at": time.Now()})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (ur *UploadSessionRepository) SetFileType(id, fileType string) error {
+	return ur.db.Model(&models.UploadSession{}).Where("id = ?", id).Update("file_type", fileType).Error
+}
+
+func (ur *UploadSessionRepository) DeleteSession(id string) error {
+	return ur.db.Where("id = ?", id).Delete(&models.UploadSession{}).Error
+}
+
+func (ur *UploadSessionRepository) ListExpired(now time.Time) ([]models.UploadSession, error) {
+	var sessions []models.UploadSession
+	err := ur.db.Where("expires_at < ?", now).Find(&sessions).Error
+	return sessions, err
+}
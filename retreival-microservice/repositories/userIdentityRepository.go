@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type UserIdentityRepository struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewUserIdentityRepository(db *gorm.DB) *UserIdentityRepository {
+	return &UserIdentityRepository{
+		db:  db,
+		log: utils.GetLogger(),
+	}
+}
+
+func (ir *UserIdentityRepository) GetIdentity(provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	if err := ir.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		ir.log.Warn("Error happend during loading user identity",
+			zap.String("reason", "database_error"),
+			zap.String("provider", provider),
+		)
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (ir *UserIdentityRepository) CreateIdentity(identity *models.UserIdentity) error {
+	return ir.db.Create(identity).Error
+}
+
+func (ir *UserIdentityRepository) SaveState(state *models.OIDCLoginState) error {
+	return ir.db.Create(state).Error
+}
+
+// ConsumeState loads and deletes a login state so every state can only be
+// used for one callback. Expired states are reported as missing.
+func (ir *UserIdentityRepository) ConsumeState(value string) (*models.OIDCLoginState, error) {
+	var state models.OIDCLoginState
+	err := ir.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state = ?", value).First(&state).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&state).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if time.Now().After(state.ExpiresAt) {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+// DeleteExpiredStates removes states of logins that were never completed.
+func (ir *UserIdentityRepository) DeleteExpiredStates() error {
+	return ir.db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&models.OIDCLoginState{}).Error
+}
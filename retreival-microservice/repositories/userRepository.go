@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"errors"
+	"time"
 
 	"retreival/models"
 	"retreival/utils"
@@ -39,19 +40,22 @@ func (ur *UserRepository) CreateUser(user models.User) (*models.User, error) {
 		return nil, err
 	}
 
-	var existingEmailUser models.User
-	if err := ur.db.Where("email = ?", user.Email).First(&existingEmailUser).Error; err == nil {
-		ur.log.Warn("User registration failed - duplicate email",
-			zap.String("reason", "duplicate_email"),
-			zap.String("email", user.Email),
-		)
-		return nil, utils.ErrEmailExist
-	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
-		ur.log.Warn("Error happend during checking for duplicate email",
-			zap.String("reason", "unknown_error"),
-			zap.String("username", user.Email),
-		)
-		return nil, err
+	// Users without an email, created through SSO, do not conflict.
+	if user.Email != "" {
+		var existingEmailUser models.User
+		if err := ur.db.Where("email = ?", user.Email).First(&existingEmailUser).Error; err == nil {
+			ur.log.Warn("User registration failed - duplicate email",
+				zap.String("reason", "duplicate_email"),
+				zap.String("email", user.Email),
+			)
+			return nil, utils.ErrEmailExist
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			ur.log.Warn("Error happend during checking for duplicate email",
+				zap.String("reason", "unknown_error"),
+				zap.String("username", user.Email),
+			)
+			return nil, err
+		}
 	}
 
 	if err := ur.db.Create(&user).Error; err != nil {
@@ -89,3 +93,126 @@ func (ur *UserRepository) VerifyPassword(user *models.User, password string) boo
 		return false
 	}
 }
+
+func (ur *UserRepository) GetUserByID(id uint) (*models.User, error) {
+	var user models.User
+	if err := ur.db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		ur.log.Warn("Error happend during loading user",
+			zap.String("reason", "database_error"),
+			zap.Uint("userID", id),
+		)
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (ur *UserRepository) UpdateUser(user *models.User) error {
+	return ur.db.Save(user).Error
+}
+
+// ResetPassword redeems a password reset token and sets the new password in
+// one transaction, so a token is never used up without the password being
+// changed. It also signs the user out everywhere: the session version is
+// bumped and the API keys are revoked. It fails with ErrTokenAlreadyUsed when
+// another request redeemed the token first.
+func (ur *UserRepository) ResetPassword(userID, tokenID uint, hashedPassword string) error {
+	now := time.Now()
+	return ur.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.UserToken{}).
+			Where("id = ? AND used_at IS NULL", tokenID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return utils.ErrTokenAlreadyUsed
+		}
+
+		return setPassword(tx, userID, hashedPassword, now)
+	})
+}
+
+// ChangePassword sets a new password and signs the user out everywhere like
+// ResetPassword does.
+func (ur *UserRepository) ChangePassword(userID uint, hashedPassword string) error {
+	return ur.db.Transaction(func(tx *gorm.DB) error {
+		return setPassword(tx, userID, hashedPassword, time.Now())
+	})
+}
+
+// setPassword changes the password, bumps the session version and revokes
+// the API keys of the user.
+func setPassword(tx *gorm.DB, userID uint, hashedPassword string, now time.Time) error {
+	err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"password":        hashedPassword,
+		"session_version": gorm.Expr("session_version + 1"),
+	}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}
+
+// UseTOTPStep records step as the last authenticator step used. It reports
+// false when the step or a later one was used already.
+func (ur *UserRepository) UseTOTPStep(userID uint, step int64) (bool, error) {
+	result := ur.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteUser removes the user and every record that belongs to the account.
+// beforeCommit runs inside the transaction; when it fails nothing is deleted.
+func (ur *UserRepository) DeleteUser(userID uint, beforeCommit func() error) error {
+	return ur.db.Transaction(func(tx *gorm.DB) error {
+		owned := []interface{}{
+			&models.UserToken{},
+			&models.RecoveryCode{},
+			&models.APIKey{},
+			&models.UserIdentity{},
+			&models.StorageUsage{},
+			&models.GroupMember{},
+		}
+		for _, model := range owned {
+			if err := tx.Unscoped().Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		ownedLinks := tx.Model(&models.ShareLink{}).Unscoped().Select("id").Where("user_id = ?", userID)
+		if err := tx.Where("share_link_id IN (?)", ownedLinks).Delete(&models.ShareLinkAccess{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.ShareLink{}).Error; err != nil {
+			return err
+		}
+		ownedGroups := tx.Model(&models.Group{}).Select("id").Where("owner_id = ?", userID)
+		if err := tx.Where("group_id IN (?)", ownedGroups).Delete(&models.GroupMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("owner_id = ?", userID).Delete(&models.Group{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Unscoped().Delete(&models.User{}, userID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return utils.ErrUserNotFound
+		}
+
+		if beforeCommit != nil {
+			return beforeCommit()
+		}
+		return nil
+	})
+}
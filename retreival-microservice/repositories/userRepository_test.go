@@ -110,3 +110,71 @@ func prepareTestDatabase(t *testing.T) *gorm.DB {
 
 	return db
 }
+
+func TestUserRepository_UseTOTPStep(t *testing.T) {
+	db := prepareTestDatabase(t)
+	defer db.Migrator().DropTable(&models.User{})
+
+	userRepo := repositories.NewUserRepository(db)
+	user, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com", Password: "testpassword"})
+	assert.NoError(t, err)
+
+	// Test case: a step is used once
+	used, err := userRepo.UseTOTPStep(user.ID, 5)
+	assert.NoError(t, err)
+	assert.True(t, used)
+	used, err = userRepo.UseTOTPStep(user.ID, 5)
+	assert.NoError(t, err)
+	assert.False(t, used)
+
+	// Test case: earlier steps are rejected, later ones accepted
+	used, err = userRepo.UseTOTPStep(user.ID, 4)
+	assert.NoError(t, err)
+	assert.False(t, used)
+	used, err = userRepo.UseTOTPStep(user.ID, 6)
+	assert.NoError(t, err)
+	assert.True(t, used)
+}
+
+func TestUserRepository_DeleteUserShareLinks(t *testing.T) {
+	db := prepareTestDatabase(t)
+	if err := db.AutoMigrate(&models.UserToken{}, &models.RecoveryCode{}, &models.APIKey{}, &models.UserIdentity{}, &models.StorageUsage{}, &models.Group{}, &models.GroupMember{}, &models.ShareLink{}, &models.ShareLinkAccess{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+	defer db.Migrator().DropTable(&models.User{}, &models.ShareLink{}, &models.ShareLinkAccess{})
+
+	userRepo := repositories.NewUserRepository(db)
+	shareRepo := repositories.NewShareLinkRepository(db)
+	user, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com", Password: "testpassword"})
+	assert.NoError(t, err)
+	other, err := userRepo.CreateUser(models.User{Username: "otheruser", Email: "other@example.com", Password: "testpassword"})
+	assert.NoError(t, err)
+
+	links := []*models.ShareLink{
+		{UserID: user.ID, FileID: 1, JTI: "a"},
+		{UserID: user.ID, FileID: 2, JTI: "b"},
+		{UserID: other.ID, FileID: 3, JTI: "c"},
+	}
+	for _, link := range links {
+		assert.NoError(t, shareRepo.CreateLink(link))
+		assert.NoError(t, shareRepo.RecordAccess(&models.ShareLinkAccess{ShareLinkID: link.ID, Result: models.ShareAccessDownloaded}))
+	}
+	// Revoked links are deleted too.
+	_, err = shareRepo.RevokeLink(user.ID, 2, links[1].ID)
+	assert.NoError(t, err)
+
+	// Test case: the user's links and their accesses go with the account
+	assert.NoError(t, userRepo.DeleteUser(user.ID, nil))
+
+	var remaining []models.ShareLink
+	assert.NoError(t, db.Unscoped().Find(&remaining).Error)
+	assert.Len(t, remaining, 1)
+	assert.Equal(t, links[2].ID, remaining[0].ID)
+
+	accesses, err := shareRepo.ListAccesses(links[0].ID)
+	assert.NoError(t, err)
+	assert.Empty(t, accesses)
+	accesses, err = shareRepo.ListAccesses(links[2].ID)
+	assert.NoError(t, err)
+	assert.Len(t, accesses, 1)
+}
@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type UserTokenRepository struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewUserTokenRepository(db *gorm.DB) *UserTokenRepository {
+	return &UserTokenRepository{
+		db:  db,
+		log: utils.GetLogger(),
+	}
+}
+
+func (tr *UserTokenRepository) CreateToken(token *models.UserToken) error {
+	return tr.db.Create(token).Error
+}
+
+func (tr *UserTokenRepository) GetTokenByJTI(jti string) (*models.UserToken, error) {
+	var token models.UserToken
+	if err := tr.db.Where("jti = ?", jti).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		tr.log.Warn("Error happend during loading user token",
+			zap.String("reason", "database_error"),
+		)
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed redeems the token. It fails with ErrTokenAlreadyUsed when another
+// request redeemed it first.
+func (tr *UserTokenRepository) MarkUsed(token *models.UserToken) error {
+	now := time.Now()
+	result := tr.db.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return utils.ErrTokenAlreadyUsed
+	}
+	token.UsedAt = &now
+	return nil
+}
+
+// InvalidateTokens marks every unused token of the user for the purpose as
+// used, so only the most recently issued one stays valid.
+func (tr *UserTokenRepository) InvalidateTokens(userID uint, purpose string) error {
+	return tr.db.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
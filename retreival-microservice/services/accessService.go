@@ -0,0 +1,76 @@
+package services
+
+import (
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+)
+
+// AccessStore keeps the access entries of files, tags and folders.
+type AccessStore interface {
+	GrantAccess(ownerID uint, target models.AccessTarget, principalType string, principalID uint, permission string) (*models.ACLEntryInfo, error)
+	RevokeAccess(ownerID uint, target models.AccessTarget, entryID uint) error
+	ListAccess(ownerID uint, target models.AccessTarget) ([]models.ACLEntryInfo, error)
+}
+
+// AccessService shares files, tags and folders with users and groups. It resolves
+// who an models.AccessRequest names; the store keeps the entries.
+type AccessService struct {
+	store  AccessStore
+	groups *GroupService
+	log    *zap.Logger
+}
+
+func NewAccessService(store AccessStore, groups *GroupService) *AccessService {
+	return &AccessService{store: store, groups: groups, log: utils.GetLogger()}
+}
+
+// Grant shares the owner's file, tag or folder. Owners can only share with
+// groups they are a member of.
+func (as *AccessService) Grant(ownerID uint, target models.AccessTarget, request models.AccessRequest) (*models.ACLEntryInfo, error) {
+	if request.Permission != models.PermissionRead && request.Permission != models.PermissionWrite {
+		return nil, utils.ErrInvalidPermission
+	}
+
+	var principalType string
+	var principalID uint
+	switch {
+	case request.User != "" && request.GroupID == 0:
+		user, err := as.groups.FindUser(request.User)
+		if err != nil {
+			return nil, err
+		}
+		if user.ID == ownerID {
+			return nil, utils.ErrShareWithSelf
+		}
+		principalType, principalID = models.PrincipalUser, user.ID
+	case request.User == "" && request.GroupID != 0:
+		if err := as.groups.CheckMember(ownerID, request.GroupID); err != nil {
+			return nil, err
+		}
+		principalType, principalID = models.PrincipalGroup, request.GroupID
+	default:
+		return nil, utils.ErrInvalidPrincipal
+	}
+
+	entry, err := as.store.GrantAccess(ownerID, target, principalType, principalID, request.Permission)
+	if err != nil {
+		return nil, err
+	}
+	as.log.Info("Access granted",
+		zap.Uint("OwnerID", ownerID),
+		zap.String("PrincipalType", principalType),
+		zap.Uint("PrincipalID", principalID),
+		zap.String("Permission", request.Permission),
+	)
+	return entry, nil
+}
+
+func (as *AccessService) Revoke(ownerID uint, target models.AccessTarget, entryID uint) error {
+	return as.store.RevokeAccess(ownerID, target, entryID)
+}
+
+func (as *AccessService) List(ownerID uint, target models.AccessTarget) ([]models.ACLEntryInfo, error) {
+	return as.store.ListAccess(ownerID, target)
+}
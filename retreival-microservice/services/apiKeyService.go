@@ -0,0 +1,148 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+)
+
+const (
+	apiKeyPrefix = "mk_"
+	// lastUsedResolution limits how often last_used_at is written for a busy key.
+	lastUsedResolution = time.Minute
+)
+
+type APIKeyService struct {
+	repo *repositories.APIKeyRepository
+	log  *zap.Logger
+	now  func() time.Time
+}
+
+func NewAPIKeyService(repo *repositories.APIKeyRepository) *APIKeyService {
+	return &APIKeyService{repo: repo, log: utils.GetLogger(), now: time.Now}
+}
+
+// CreateKey stores a new key for the user and returns the plain key. It is
+// only available here; afterwards just its hash is known.
+func (as *APIKeyService) CreateKey(userID uint, request models.APIKeyRequest) (string, *models.APIKey, error) {
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		return "", nil, utils.ErrAPIKeyNameRequired
+	}
+
+	scopes, err := normalizeScopes(request.Scopes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(as.now()) {
+		return "", nil, utils.ErrAPIKeyExpiryInPast
+	}
+
+	secret, err := randomHex(20)
+	if err != nil {
+		return "", nil, err
+	}
+	rawKey := apiKeyPrefix + secret
+
+	key := &models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    rawKey[:len(apiKeyPrefix)+8],
+		KeyHash:   hashAPIKey(rawKey),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: request.ExpiresAt,
+	}
+	if err := as.repo.CreateKey(key); err != nil {
+		as.log.Error("Failed to create api key", zap.Uint("UserID", userID), zap.Error(err))
+		return "", nil, err
+	}
+
+	as.log.Info("API key created", zap.Uint("UserID", userID), zap.String("prefix", key.Prefix))
+	return rawKey, key, nil
+}
+
+func (as *APIKeyService) ListKeys(userID uint) ([]models.APIKey, error) {
+	return as.repo.ListKeys(userID)
+}
+
+func (as *APIKeyService) RevokeKey(userID, keyID uint) error {
+	revoked, err := as.repo.RevokeKey(userID, keyID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return utils.ErrAPIKeyNotFound
+	}
+	as.log.Info("API key revoked", zap.Uint("UserID", userID), zap.Uint("KeyID", keyID))
+	return nil
+}
+
+// Authenticate resolves a plain key to its record, rejecting unknown, revoked
+// and expired keys, and records when it was last used.
+func (as *APIKeyService) Authenticate(rawKey string) (*models.APIKey, error) {
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return nil, utils.ErrInvalidAPIKey
+	}
+
+	key, err := as.repo.GetKeyByHash(hashAPIKey(rawKey))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || key.RevokedAt != nil {
+		return nil, utils.ErrInvalidAPIKey
+	}
+
+	now := as.now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, utils.ErrAPIKeyExpired
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedResolution {
+		if err := as.repo.TouchLastUsed(key.ID, now); err != nil {
+			as.log.Warn("Failed to update api key last use", zap.Uint("KeyID", key.ID), zap.Error(err))
+		}
+		key.LastUsedAt = &now
+	}
+
+	return key, nil
+}
+
+func normalizeScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, utils.ErrInvalidAPIKeyScope
+	}
+
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, scope := range requested {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		valid := false
+		for _, known := range models.AllScopes {
+			if scope == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, utils.ErrInvalidAPIKeyScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func prepareAPIKeyService(t *testing.T) *services.APIKeyService {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.APIKey{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+	return services.NewAPIKeyService(repositories.NewAPIKeyRepository(db))
+}
+
+func TestAPIKeyService_CreateAndAuthenticate(t *testing.T) {
+	apiKeys := prepareAPIKeyService(t)
+
+	rawKey, key, err := apiKeys.CreateKey(1, models.APIKeyRequest{Name: "ci", Scopes: []string{"upload", "UPLOAD"}})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rawKey, key.Prefix))
+	assert.NotContains(t, key.KeyHash, rawKey)
+	assert.Equal(t, []string{models.ScopeUpload}, key.ScopeList())
+
+	authenticated, err := apiKeys.Authenticate(rawKey)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), authenticated.UserID)
+	assert.NotNil(t, authenticated.LastUsedAt)
+
+	keys, err := apiKeys.ListKeys(1)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	// Test case: revoked keys stop working
+	assert.Equal(t, utils.ErrAPIKeyNotFound, apiKeys.RevokeKey(2, key.ID))
+	assert.NoError(t, apiKeys.RevokeKey(1, key.ID))
+	_, err = apiKeys.Authenticate(rawKey)
+	assert.Equal(t, utils.ErrInvalidAPIKey, err)
+
+	_, err = apiKeys.Authenticate("mk_unknown")
+	assert.Equal(t, utils.ErrInvalidAPIKey, err)
+}
+
+func TestAPIKeyService_Validation(t *testing.T) {
+	apiKeys := prepareAPIKeyService(t)
+
+	_, _, err := apiKeys.CreateKey(1, models.APIKeyRequest{Scopes: []string{"read"}})
+	assert.Equal(t, utils.ErrAPIKeyNameRequired, err)
+
+	_, _, err = apiKeys.CreateKey(1, models.APIKeyRequest{Name: "ci", Scopes: []string{"admin"}})
+	assert.Equal(t, utils.ErrInvalidAPIKeyScope, err)
+
+	past := time.Now().Add(-time.Hour)
+	_, _, err = apiKeys.CreateKey(1, models.APIKeyRequest{Name: "ci", Scopes: []string{"read"}, ExpiresAt: &past})
+	assert.Equal(t, utils.ErrAPIKeyExpiryInPast, err)
+}
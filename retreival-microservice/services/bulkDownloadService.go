@@ -0,0 +1,129 @@
+package services
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"retreival/models"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+)
+
+// ArchiveSource lists and reads the files of a bulk download. FileService
+// gets them from the store.
+type ArchiveSource interface {
+	ListArchiveFiles(ownerID uint, query *models.FileQuery, maxFiles int) ([]models.FileInfo, error)
+	DownloadFile(ownerID, fileID uint, version int) (*models.FileContent, error)
+}
+
+// BulkDownloadLimits cap what one bulk download may hold. Zero means no
+// limit.
+type BulkDownloadLimits struct {
+	MaxFiles int
+	MaxBytes int64
+}
+
+func DefaultBulkDownloadLimits() BulkDownloadLimits {
+	return BulkDownloadLimits{MaxFiles: 1000, MaxBytes: 1 << 30}
+}
+
+// BulkDownloadService builds ZIP archives of the files matching a query.
+type BulkDownloadService struct {
+	source ArchiveSource
+	limits BulkDownloadLimits
+	log    *zap.Logger
+}
+
+func NewBulkDownloadService(source ArchiveSource, limits BulkDownloadLimits) *BulkDownloadService {
+	return &BulkDownloadService{
+		source: source,
+		limits: limits,
+		log:    utils.GetLogger(),
+	}
+}
+
+// Prepare lists the files of a bulk download and checks them against the
+// limits, so the request can still fail before the archive is sent.
+func (bs *BulkDownloadService) Prepare(ownerID uint, query *models.FileQuery) ([]models.FileInfo, error) {
+	files, err := bs.source.ListArchiveFiles(ownerID, query, bs.limits.MaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, utils.ErrFileNotFound
+	}
+	if bs.limits.MaxFiles > 0 && len(files) > bs.limits.MaxFiles {
+		bs.log.Info("Bulk download rejected - too many files", zap.Uint("ownerID", ownerID), zap.Int("maxFiles", bs.limits.MaxFiles))
+		return nil, utils.ErrDownloadTooLarge
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.FileSize
+	}
+	if bs.limits.MaxBytes > 0 && total > bs.limits.MaxBytes {
+		bs.log.Info("Bulk download rejected - too large", zap.Uint("ownerID", ownerID), zap.Int64("bytes", total))
+		return nil, utils.ErrDownloadTooLarge
+	}
+	return files, nil
+}
+
+// WriteArchive writes files to w as a ZIP archive. The files are fetched
+// from the store one at a time, so only one of them is held in memory.
+func (bs *BulkDownloadService) WriteArchive(w io.Writer, ownerID uint, files []models.FileInfo) error {
+	zw := zip.NewWriter(w)
+	names := map[string]bool{}
+
+	var total int64
+	for _, file := range files {
+		content, err := bs.source.DownloadFile(ownerID, file.ID, 0)
+		if err == utils.ErrFileNotFound {
+			// Deleted since the files were listed.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// A new version may have been uploaded since the files were listed.
+		total += int64(len(content.Content))
+		if bs.limits.MaxBytes > 0 && total > bs.limits.MaxBytes {
+			return utils.ErrDownloadTooLarge
+		}
+
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     archiveEntryName(content.FileName, names),
+			Method:   zip.Deflate,
+			Modified: file.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(content.Content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// archiveEntryName turns a file name into a ZIP entry name that is neither
+// a path nor used before in the archive. Repeated names are numbered like
+// "report (2).pdf".
+func archiveEntryName(fileName string, used map[string]bool) string {
+	name := path.Base(strings.ReplaceAll(fileName, "\\", "/"))
+	if name == "/" || name == "." || name == ".." {
+		name = "file"
+	}
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 2; used[name]; n++ {
+		name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	used[name] = true
+	return name
+}
@@ -0,0 +1,97 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+
+	"retreival/models"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeArchiveSource struct {
+	files    []models.FileInfo
+	contents map[uint]string
+}
+
+func (fs *fakeArchiveSource) ListArchiveFiles(ownerID uint, query *models.FileQuery, maxFiles int) ([]models.FileInfo, error) {
+	if maxFiles > 0 && len(fs.files) > maxFiles+1 {
+		return fs.files[:maxFiles+1], nil
+	}
+	return fs.files, nil
+}
+
+func (fs *fakeArchiveSource) DownloadFile(ownerID, fileID uint, version int) (*models.FileContent, error) {
+	content, ok := fs.contents[fileID]
+	if !ok {
+		return nil, utils.ErrFileNotFound
+	}
+	for _, file := range fs.files {
+		if file.ID == fileID {
+			return &models.FileContent{FileName: file.FileName, Content: []byte(content)}, nil
+		}
+	}
+	return nil, utils.ErrFileNotFound
+}
+
+func TestBulkDownloadService_WriteArchive(t *testing.T) {
+	source := &fakeArchiveSource{
+		files: []models.FileInfo{
+			{ID: 1, FileName: "report.pdf", FileSize: 5},
+			{ID: 2, FileName: "report.pdf", FileSize: 6},
+			{ID: 3, FileName: "../notes.txt", FileSize: 5},
+			{ID: 4, FileName: "gone.txt", FileSize: 1},
+		},
+		contents: map[uint]string{1: "first", 2: "second", 3: "notes"},
+	}
+	service := services.NewBulkDownloadService(source, services.DefaultBulkDownloadLimits())
+
+	files, err := service.Prepare(1, &models.FileQuery{Tags: []string{"work"}})
+	assert.NoError(t, err)
+
+	var archive bytes.Buffer
+	assert.NoError(t, service.WriteArchive(&archive, 1, files))
+
+	zr, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	assert.NoError(t, err)
+
+	// Test case: names are flattened and numbered, deleted files are left out
+	entries := map[string]string{}
+	for _, file := range zr.File {
+		rc, err := file.Open()
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		entries[file.Name] = string(content)
+	}
+	assert.Equal(t, map[string]string{"report.pdf": "first", "report (2).pdf": "second", "notes.txt": "notes"}, entries)
+}
+
+func TestBulkDownloadService_Limits(t *testing.T) {
+	source := &fakeArchiveSource{
+		files: []models.FileInfo{
+			{ID: 1, FileName: "a.txt", FileSize: 60},
+			{ID: 2, FileName: "b.txt", FileSize: 60},
+		},
+		contents: map[uint]string{1: "a", 2: "b"},
+	}
+
+	// Test case: too many files
+	service := services.NewBulkDownloadService(source, services.BulkDownloadLimits{MaxFiles: 1})
+	_, err := service.Prepare(1, &models.FileQuery{})
+	assert.Equal(t, utils.ErrDownloadTooLarge, err)
+
+	// Test case: too many bytes
+	service = services.NewBulkDownloadService(source, services.BulkDownloadLimits{MaxBytes: 100})
+	_, err = service.Prepare(1, &models.FileQuery{})
+	assert.Equal(t, utils.ErrDownloadTooLarge, err)
+
+	// Test case: nothing matches
+	service = services.NewBulkDownloadService(&fakeArchiveSource{}, services.DefaultBulkDownloadLimits())
+	_, err = service.Prepare(1, &models.FileQuery{})
+	assert.Equal(t, utils.ErrFileNotFound, err)
+}
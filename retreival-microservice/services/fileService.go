@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"retreival/models"
 	"retreival/utils"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
@@ -15,24 +18,79 @@ import (
 type FileService struct {
 	rabbitMQService RabbitMQService
 	fileLimit       int
+	fileTypes       *FileTypeService
+	groups          GroupResolver
 	log             *zap.Logger
 }
 
-func NewFileService(rabbitMQService RabbitMQService, fileLimit int) *FileService {
+// NewFileService creates the service. fileTypes may be nil to accept every
+// file type; types are detected either way. groups may be nil when files are
+// only shared with users.
+func NewFileService(rabbitMQService RabbitMQService, fileLimit int, fileTypes *FileTypeService, groups GroupResolver) *FileService {
 	log := utils.GetLogger()
-	return &FileService{rabbitMQService, fileLimit, log}
+	if fileTypes == nil {
+		fileTypes = NewFileTypeService(FileTypePolicy{})
+	}
+	return &FileService{rabbitMQService, fileLimit, fileTypes, groups, log}
+}
+
+// UploadPart is one file of an upload request. Err tells why it cannot be
+// uploaded; Archive files are expanded by the store.
+type UploadPart struct {
+	FileName string
+	FileData *models.FileData
+	Archive  bool
+	Err      error
 }
 
-func (fs *FileService) ExtractFileDataAndMetadata(c *fiber.Ctx) (*models.FileData, error) {
+// archiveTypes are the detected types the store can expand.
+var archiveTypes = []string{"application/zip", "application/x-tar", "application/x-gzip", "application/gzip"}
+
+// ExtractFileDataAndMetadata reads every "file" part of the request. Tags,
+// type, folder and custom metadata are shared by all of them. With
+// extract=true the files have to be archives, which the store expands.
+func (fs *FileService) ExtractFileDataAndMetadata(c *fiber.Ctx) ([]UploadPart, error) {
+	folderID, err := ParseFolderID(c.FormValue("folder_id"))
+	if err != nil {
+		return nil, err
+	}
 	fileType := c.FormValue("type")
-	fileTags := strings.Split(c.FormValue("tags"), ",")
+	fileTags := models.NormalizeTags(strings.Split(c.FormValue("tags"), ","))
+	for _, tag := range fileTags {
+		if len(tag) > models.MaxTagLength {
+			return nil, utils.ErrTagTooLong
+		}
+	}
+	extract, _ := strconv.ParseBool(c.FormValue("extract"))
 
-	file, err := c.FormFile("file")
+	metadata, err := fs.extractCustomMetadata(c)
 	if err != nil {
+		return nil, err
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
 		fs.log.Error("Failed to retrieve file", zap.Error(err))
 		return nil, utils.ErrNoFileUploaded
 	}
 
+	parts := make([]UploadPart, 0, len(form.File["file"]))
+	for _, file := range form.File["file"] {
+		part := UploadPart{FileName: file.Filename, Archive: extract}
+		part.FileData, part.Err = fs.readFilePart(file, fileType, fileTags, metadata, extract)
+		if part.FileData != nil {
+			part.FileData.FolderID = folderID
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+func (fs *FileService) readFilePart(file *multipart.FileHeader, fileType string, fileTags []string, metadata map[string]string, archive bool) (*models.FileData, error) {
+	if file.Size > int64(fs.fileLimit) {
+		return nil, utils.ErrFileSizeExceedsLimit
+	}
+
 	src, err := file.Open()
 	if err != nil {
 		fs.log.Error("Failed to open uploaded file", zap.Error(err))
@@ -45,23 +103,93 @@ func (fs *FileService) ExtractFileDataAndMetadata(c *fiber.Ctx) (*models.FileDat
 		return nil, fmt.Errorf("failed to read file content: %s", err.Error())
 	}
 
-	if file.Size > int64(fs.fileLimit) {
-		return nil, utils.ErrFileSizeExceedsLimit
+	// Clients such as curl send application/octet-stream for any file, so
+	// that part header only counts when it says more.
+	declaredType := fileType
+	if partType := file.Header.Get("Content-Type"); declaredType == "" && mediaType(partType) != unknownFileType {
+		declaredType = partType
+	}
+	detectedType := fs.fileTypes.Detect(file.Filename, fileBytes)
+	// The files in an archive are checked by the store instead.
+	if archive {
+		if !matchesAny(detectedType, archiveTypes) {
+			return nil, utils.ErrNotAnArchive
+		}
+	} else if err := fs.fileTypes.Check(declaredType, detectedType); err != nil {
+		return nil, err
 	}
 
 	fileData := &models.FileData{
-		FileName:  file.Filename,
-		FileType:  fileType,
-		FileSize:  file.Size,
-		FileTags:  fileTags,
-		FileBytes: fileBytes,
-		TagName:   fileTags,
-		Type:      fileType,
+		FileName:     file.Filename,
+		FileType:     detectedType,
+		DeclaredType: declaredType,
+		FileSize:     file.Size,
+		FileTags:     fileTags,
+		FileBytes:    fileBytes,
+		TagName:      fileTags,
+		Type:         fileType,
+		Metadata:     metadata,
 	}
 
 	return fileData, nil
 }
 
+// ParseFolderID reads the folder_id of an upload. An empty value is the
+// root, which is nil.
+func ParseFolderID(value string) (*uint, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || id == 0 {
+		return nil, utils.ErrInvalidFolderID
+	}
+	folderID := uint(id)
+	return &folderID, nil
+}
+
+// extractCustomMetadata reads custom metadata from a "metadata" part holding
+// a JSON object and from "meta.<key>" form fields, which win over the JSON.
+func (fs *FileService) extractCustomMetadata(c *fiber.Ctx) (map[string]string, error) {
+	metadata := map[string]string{}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return metadata, nil
+	}
+
+	var raw []byte
+	if values := form.Value["metadata"]; len(values) > 0 {
+		raw = []byte(values[0])
+	} else if files := form.File["metadata"]; len(files) > 0 {
+		part, err := files[0].Open()
+		if err != nil {
+			return nil, utils.ErrInvalidMetadata
+		}
+		defer part.Close()
+		if raw, err = io.ReadAll(part); err != nil {
+			return nil, utils.ErrInvalidMetadata
+		}
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &metadata); err != nil {
+			fs.log.Info("Invalid custom metadata", zap.Error(err))
+			return nil, utils.ErrInvalidMetadata
+		}
+	}
+
+	for name, values := range form.Value {
+		if key, ok := strings.CutPrefix(name, "meta."); ok && len(values) > 0 {
+			metadata[key] = values[0]
+		}
+	}
+
+	if err := models.ValidateMetadata(metadata); err != nil {
+		fs.log.Info("Invalid custom metadata", zap.Error(err))
+		return nil, utils.ErrInvalidMetadata
+	}
+	return metadata, nil
+}
+
 func (fs *FileService) ProcessFileUpload(fileData *models.FileData) error {
 	if fileData.FileSize > int64(fs.fileLimit) {
 		return utils.ErrFileSizeExceedsLimit
@@ -75,7 +203,38 @@ func (fs *FileService) ProcessFileUpload(fileData *models.FileData) error {
 	return nil
 }
 
+// archiveTimeout is how long the store may take to expand an archive.
+const archiveTimeout = 2 * time.Minute
+
+// ExtractArchive has the store expand an uploaded archive into files and
+// returns what happened to each of them.
+func (fs *FileService) ExtractArchive(fileData *models.FileData) ([]models.ArchiveEntryResult, error) {
+	policy := fs.fileTypes.Policy()
+	payload, err := json.Marshal(models.ArchivePayload{
+		File:         *fileData,
+		AllowedTypes: policy.Allowed,
+		DeniedTypes:  policy.Denied,
+		MaxFileSize:  int64(fs.fileLimit),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []models.ArchiveEntryResult{}
+	command := &models.FileCommand{Action: models.FileActionExtractArchive, OwnerID: fileData.OwnerID, Payload: payload}
+	if err := fs.sendCommandTimeout(command, &entries, archiveTimeout); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
 func (fs *FileService) PublishFileRequest(request *models.FileRequest, queueName string) error {
+	groupIDs, err := fs.groupIDs(request.OwnerID)
+	if err != nil {
+		return err
+	}
+	request.GroupIDs = groupIDs
+
 	requestJSON, err := json.Marshal(request)
 	if err != nil {
 		fs.log.Error("Failed to marshal file request to JSON", zap.Error(err))
@@ -113,3 +272,394 @@ func (fs *FileService) RetrieveFileNamesFromQueue(queueName string) ([]string, e
 
 	return nil, nil
 }
+
+// DeleteFile moves the file to the owner's trash.
+func (fs *FileService) DeleteFile(ownerID, fileID uint) (*models.FileInfo, error) {
+	var file models.FileInfo
+	err := fs.sendCommand(&models.FileCommand{Action: models.FileActionDelete, OwnerID: ownerID, FileID: fileID}, &file)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (fs *FileService) ListTrash(ownerID uint) ([]models.FileInfo, error) {
+	files := []models.FileInfo{}
+	err := fs.sendCommand(&models.FileCommand{Action: models.FileActionListTrash, OwnerID: ownerID}, &files)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// RestoreFile moves the file out of the trash again.
+func (fs *FileService) RestoreFile(ownerID, fileID uint) (*models.FileInfo, error) {
+	var file models.FileInfo
+	err := fs.sendCommand(&models.FileCommand{Action: models.FileActionRestore, OwnerID: ownerID, FileID: fileID}, &file)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// SearchFiles returns one page of the owner's files matching query.
+func (fs *FileService) SearchFiles(ownerID uint, query *models.FileQuery) (*models.FileSearchResult, error) {
+	payload, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.FileSearchResult
+	err = fs.sendCommand(&models.FileCommand{Action: models.FileActionSearch, OwnerID: ownerID, Payload: payload}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListArchiveFiles returns the owner's files matching query for a bulk
+// download, with at most one more than maxFiles.
+func (fs *FileService) ListArchiveFiles(ownerID uint, query *models.FileQuery, maxFiles int) ([]models.FileInfo, error) {
+	payload, err := json.Marshal(models.ArchiveListPayload{Query: *query, MaxFiles: maxFiles})
+	if err != nil {
+		return nil, err
+	}
+
+	files := []models.FileInfo{}
+	err = fs.sendCommand(&models.FileCommand{Action: models.FileActionListArchive, OwnerID: ownerID, Payload: payload}, &files)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ListVersions returns the versions of the file, newest first.
+func (fs *FileService) ListVersions(ownerID, fileID uint) ([]models.FileVersionInfo, error) {
+	versions := []models.FileVersionInfo{}
+	err := fs.sendCommand(&models.FileCommand{Action: models.FileActionListVersions, OwnerID: ownerID, FileID: fileID}, &versions)
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// DownloadFile returns the content of a version of the file. Version 0 is the
+// current version.
+func (fs *FileService) DownloadFile(ownerID, fileID uint, version int) (*models.FileContent, error) {
+	return fs.download(ownerID, fileID, models.DownloadPayload{Version: version})
+}
+
+// DownloadRange returns the bytes of byteRange of a version.
+func (fs *FileService) DownloadRange(ownerID, fileID uint, version int, byteRange *models.ByteRange) (*models.FileContent, error) {
+	return fs.download(ownerID, fileID, models.DownloadPayload{Version: version, Range: byteRange})
+}
+
+// StatFile returns the name, size, type and validators of a version without
+// its content.
+func (fs *FileService) StatFile(ownerID, fileID uint, version int) (*models.FileContent, error) {
+	return fs.download(ownerID, fileID, models.DownloadPayload{Version: version, InfoOnly: true})
+}
+
+func (fs *FileService) download(ownerID, fileID uint, request models.DownloadPayload) (*models.FileContent, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var content models.FileContent
+	command := &models.FileCommand{Action: models.FileActionDownload, OwnerID: ownerID, FileID: fileID, Payload: payload}
+	if err := fs.sendCommand(command, &content); err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+// GetThumbnail returns the thumbnail of a version of an image that is at
+// least size pixels large, or the largest there is. Size 0 is the default
+// size.
+func (fs *FileService) GetThumbnail(ownerID, fileID uint, version, size int) (*models.FileContent, error) {
+	payload, err := json.Marshal(models.ThumbnailPayload{Version: version, Size: size})
+	if err != nil {
+		return nil, err
+	}
+
+	var content models.FileContent
+	command := &models.FileCommand{Action: models.FileActionThumbnail, OwnerID: ownerID, FileID: fileID, Payload: payload}
+	if err := fs.sendCommand(command, &content); err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+func (fs *FileService) RestoreVersion(ownerID, fileID uint, version int) (*models.FileInfo, error) {
+	command, err := versionCommand(models.FileActionRestoreVersion, ownerID, fileID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	var file models.FileInfo
+	if err := fs.sendCommand(command, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func versionCommand(action string, ownerID, fileID uint, version int) (*models.FileCommand, error) {
+	payload, err := json.Marshal(models.VersionPayload{Version: version})
+	if err != nil {
+		return nil, err
+	}
+	return &models.FileCommand{Action: action, OwnerID: ownerID, FileID: fileID, Payload: payload}, nil
+}
+
+// ListTags returns the owner's tags with the number of files that have them.
+func (fs *FileService) ListTags(ownerID uint) ([]models.TagInfo, error) {
+	tags := []models.TagInfo{}
+	err := fs.sendCommand(&models.FileCommand{Action: models.FileActionListTags, OwnerID: ownerID}, &tags)
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// AddTags adds tags to the file; RemoveTags removes them.
+func (fs *FileService) AddTags(ownerID, fileID uint, tags []string) (*models.FileInfo, error) {
+	return fs.changeTags(models.FileActionAddTags, ownerID, fileID, tags)
+}
+
+func (fs *FileService) RemoveTags(ownerID, fileID uint, tags []string) (*models.FileInfo, error) {
+	return fs.changeTags(models.FileActionRemoveTags, ownerID, fileID, tags)
+}
+
+func (fs *FileService) changeTags(action string, ownerID, fileID uint, tags []string) (*models.FileInfo, error) {
+	payload, err := json.Marshal(models.TagsPayload{Tags: tags})
+	if err != nil {
+		return nil, err
+	}
+
+	var file models.FileInfo
+	if err := fs.sendCommand(&models.FileCommand{Action: action, OwnerID: ownerID, FileID: fileID, Payload: payload}, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (fs *FileService) RenameTag(ownerID uint, name, newName string) (*models.TagInfo, error) {
+	payload, err := json.Marshal(models.RenameTagPayload{Name: name, NewName: newName})
+	if err != nil {
+		return nil, err
+	}
+
+	var tag models.TagInfo
+	if err := fs.sendCommand(&models.FileCommand{Action: models.FileActionRenameTag, OwnerID: ownerID, Payload: payload}, &tag); err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// MergeTags moves the files of the source tags to target and deletes the
+// sources.
+func (fs *FileService) MergeTags(ownerID uint, sources []string, target string) (*models.TagInfo, error) {
+	payload, err := json.Marshal(models.MergeTagsPayload{Sources: sources, Target: target})
+	if err != nil {
+		return nil, err
+	}
+
+	var tag models.TagInfo
+	if err := fs.sendCommand(&models.FileCommand{Action: models.FileActionMergeTags, OwnerID: ownerID, Payload: payload}, &tag); err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// UpdateMetadata applies patch to the custom metadata of the file.
+func (fs *FileService) UpdateMetadata(ownerID, fileID uint, patch models.MetadataPatch) (*models.FileInfo, error) {
+	payload, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var file models.FileInfo
+	err = fs.sendCommand(&models.FileCommand{Action: models.FileActionUpdateMetadata, OwnerID: ownerID, FileID: fileID, Payload: payload}, &file)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// GrantAccess gives a user or a group permission on one of the owner's
+// files, tags or folders.
+func (fs *FileService) GrantAccess(ownerID uint, target models.AccessTarget, principalType string, principalID uint, permission string) (*models.ACLEntryInfo, error) {
+	request := models.ACLPayload{PrincipalType: principalType, PrincipalID: principalID, Permission: permission}
+	var entry models.ACLEntryInfo
+	if err := fs.sendAccessCommand(models.FileActionGrantAccess, ownerID, target, request, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (fs *FileService) RevokeAccess(ownerID uint, target models.AccessTarget, entryID uint) error {
+	return fs.sendAccessCommand(models.FileActionRevokeAccess, ownerID, target, models.ACLPayload{EntryID: entryID}, nil)
+}
+
+func (fs *FileService) ListAccess(ownerID uint, target models.AccessTarget) ([]models.ACLEntryInfo, error) {
+	entries := []models.ACLEntryInfo{}
+	if err := fs.sendAccessCommand(models.FileActionListAccess, ownerID, target, models.ACLPayload{}, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (fs *FileService) sendAccessCommand(action string, ownerID uint, target models.AccessTarget, request models.ACLPayload, out interface{}) error {
+	request.Tag, request.FolderID = target.Tag, target.FolderID
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return fs.sendCommand(&models.FileCommand{Action: action, OwnerID: ownerID, FileID: target.FileID, Payload: payload}, out)
+}
+
+// CreateFolder creates a folder in the owner's root, or in parentID.
+func (fs *FileService) CreateFolder(ownerID uint, name string, parentID *uint) (*models.FolderInfo, error) {
+	var folder models.FolderInfo
+	if err := fs.sendFolderCommand(models.FileActionCreateFolder, ownerID, models.FolderPayload{Name: name, ParentID: parentID}, &folder); err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+func (fs *FileService) RenameFolder(ownerID, folderID uint, name string) (*models.FolderInfo, error) {
+	var folder models.FolderInfo
+	if err := fs.sendFolderCommand(models.FileActionRenameFolder, ownerID, models.FolderPayload{FolderID: folderID, Name: name}, &folder); err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// MoveFolder moves a folder below parentID, or to the root if it is nil.
+func (fs *FileService) MoveFolder(ownerID, folderID uint, parentID *uint) (*models.FolderInfo, error) {
+	var folder models.FolderInfo
+	if err := fs.sendFolderCommand(models.FileActionMoveFolder, ownerID, models.FolderPayload{FolderID: folderID, ParentID: parentID}, &folder); err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// DeleteFolder deletes an empty folder, or with recursive a folder with
+// everything below it; its files go to the trash.
+func (fs *FileService) DeleteFolder(ownerID, folderID uint, recursive bool) error {
+	return fs.sendFolderCommand(models.FileActionDeleteFolder, ownerID, models.FolderPayload{FolderID: folderID, Recursive: recursive}, nil)
+}
+
+// ListFolder returns one page of a folder's contents. Folder 0 is the root.
+func (fs *FileService) ListFolder(ownerID, folderID uint, limit int, cursor string) (*models.FolderListing, error) {
+	var listing models.FolderListing
+	if err := fs.sendFolderCommand(models.FileActionListFolder, ownerID, models.FolderPayload{FolderID: folderID, Limit: limit, Cursor: cursor}, &listing); err != nil {
+		return nil, err
+	}
+	return &listing, nil
+}
+
+// ResolvePath returns the folder or file a path like /projects/report.pdf
+// names in the owner's tree.
+func (fs *FileService) ResolvePath(ownerID uint, path string) (*models.PathResult, error) {
+	payload, err := json.Marshal(models.PathPayload{Path: path})
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.PathResult
+	if err := fs.sendCommand(&models.FileCommand{Action: models.FileActionResolvePath, OwnerID: ownerID, Payload: payload}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// MoveFile moves a file into folderID, or to the root if it is nil.
+func (fs *FileService) MoveFile(ownerID, fileID uint, folderID *uint) (*models.FileInfo, error) {
+	payload, err := json.Marshal(models.MoveFilePayload{FolderID: folderID})
+	if err != nil {
+		return nil, err
+	}
+
+	var file models.FileInfo
+	if err := fs.sendCommand(&models.FileCommand{Action: models.FileActionMoveFile, OwnerID: ownerID, FileID: fileID, Payload: payload}, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (fs *FileService) sendFolderCommand(action string, ownerID uint, request models.FolderPayload, out interface{}) error {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return fs.sendCommand(&models.FileCommand{Action: action, OwnerID: ownerID, Payload: payload}, out)
+}
+
+// storeErrors are the store's folder and thumbnail errors, told apart from
+// file errors of the same status by their message.
+var storeErrors = map[string]error{
+	utils.ErrFolderNotFound.Error():    utils.ErrFolderNotFound,
+	utils.ErrFolderNameInUse.Error():   utils.ErrFolderNameInUse,
+	utils.ErrFolderNotEmpty.Error():    utils.ErrFolderNotEmpty,
+	utils.ErrThumbnailNotFound.Error(): utils.ErrThumbnailNotFound,
+	utils.ErrThumbnailPending.Error():  utils.ErrThumbnailPending,
+}
+
+// sendCommand runs command in the store and decodes the reply data into out.
+func (fs *FileService) sendCommand(command *models.FileCommand, out interface{}) error {
+	return fs.sendCommandTimeout(command, out, rpcTimeout)
+}
+
+func (fs *FileService) sendCommandTimeout(command *models.FileCommand, out interface{}, timeout time.Duration) error {
+	groupIDs, err := fs.groupIDs(command.OwnerID)
+	if err != nil {
+		return err
+	}
+	command.GroupIDs = groupIDs
+
+	var reply models.FileCommandReply
+	if err := fs.rabbitMQService.CallTimeout(FileCommandsQueue, command, &reply, timeout); err != nil {
+		return err
+	}
+
+	if err, ok := storeErrors[reply.Error]; ok && reply.Status != models.CommandStatusOK {
+		return err
+	}
+	switch reply.Status {
+	case models.CommandStatusOK:
+	case models.CommandStatusNotFound:
+		return utils.ErrFileNotFound
+	case models.CommandStatusInvalid:
+		return utils.ErrInvalidFileQuery
+	case models.CommandStatusConflict:
+		return utils.ErrFileConflict
+	case models.CommandStatusTooLarge:
+		return utils.ErrArchiveTooLarge
+	default:
+		fs.log.Error("File command failed in the store",
+			zap.String("action", command.Action),
+			zap.String("error", reply.Error),
+		)
+		return utils.ErrStoreFailed
+	}
+
+	if out == nil || len(reply.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(reply.Data, out)
+}
+
+// groupIDs returns the groups the store matches shared files against.
+func (fs *FileService) groupIDs(userID uint) ([]uint, error) {
+	if fs.groups == nil || userID == 0 {
+		return nil, nil
+	}
+	groupIDs, err := fs.groups.GroupIDs(userID)
+	if err != nil {
+		fs.log.Error("Failed to load groups of user", zap.Uint("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return groupIDs, nil
+}
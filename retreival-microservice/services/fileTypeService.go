@@ -0,0 +1,177 @@
+package services
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"retreival/utils"
+
+	"go.uber.org/zap"
+)
+
+const unknownFileType = "application/octet-stream"
+
+type FileTypePolicy struct {
+	Allowed        []string // media types or prefixes like image/*; empty allows every type
+	Denied         []string // checked before Allowed, against the detected and the declared type
+	RejectMismatch bool     // reject uploads whose declared type does not match the content
+}
+
+// FileTypeService detects the type of uploaded content from its magic bytes
+// and checks it against the deployment's FileTypePolicy.
+type FileTypeService struct {
+	policy FileTypePolicy
+	log    *zap.Logger
+}
+
+func NewFileTypeService(policy FileTypePolicy) *FileTypeService {
+	return &FileTypeService{
+		policy: policy,
+		log:    utils.GetLogger(),
+	}
+}
+
+func (ts *FileTypeService) Policy() FileTypePolicy {
+	return ts.policy
+}
+
+// signatures lists formats http.DetectContentType does not know.
+var signatures = []struct {
+	magic     []byte
+	mediaType string
+}{
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+}
+
+// textTypes refines plain text by file extension.
+var textTypes = map[string]string{
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".csv":      "text/csv",
+	".json":     "application/json",
+	".yaml":     "application/yaml",
+	".yml":      "application/yaml",
+}
+
+// zipTypes refines ZIP archives by file extension.
+var zipTypes = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".epub": "application/epub+zip",
+	".jar":  "application/java-archive",
+}
+
+// Detect returns the media type of content. Text and ZIP based formats,
+// which look alike, are told apart by the file extension.
+func (ts *FileTypeService) Detect(fileName string, content []byte) string {
+	for _, signature := range signatures {
+		if bytes.HasPrefix(content, signature.magic) {
+			return signature.mediaType
+		}
+	}
+
+	detected := mediaType(http.DetectContentType(content))
+	ext := strings.ToLower(filepath.Ext(fileName))
+	switch detected {
+	case "text/plain":
+		if refined, ok := textTypes[ext]; ok {
+			return refined
+		}
+	case "application/zip":
+		if refined, ok := zipTypes[ext]; ok {
+			return refined
+		}
+	}
+	return detected
+}
+
+// Check applies the policy to an upload with the declared and the detected
+// type. An empty declared type is not checked.
+func (ts *FileTypeService) Check(declared, detected string) error {
+	declared = mediaType(declared)
+
+	if matchesAny(detected, ts.policy.Denied) || (declared != "" && matchesAny(declared, ts.policy.Denied)) {
+		ts.log.Info("Upload rejected - file type denied", zap.String("detected", detected), zap.String("declared", declared))
+		return utils.ErrFileTypeNotAllowed
+	}
+	if len(ts.policy.Allowed) > 0 && !matchesAny(detected, ts.policy.Allowed) {
+		ts.log.Info("Upload rejected - file type not allowed", zap.String("detected", detected))
+		return utils.ErrFileTypeNotAllowed
+	}
+	if ts.policy.RejectMismatch && !typesMatch(declared, detected) {
+		ts.log.Info("Upload rejected - declared type does not match content", zap.String("detected", detected), zap.String("declared", declared))
+		return utils.ErrFileTypeMismatch
+	}
+	return nil
+}
+
+// typesMatch reports whether the declared type fits the detected one. Nothing
+// can be said about undeclared types and content of unknown type.
+func typesMatch(declared, detected string) bool {
+	if declared == "" || declared == unknownFileType || detected == unknownFileType || declared == detected {
+		return true
+	}
+	switch detected {
+	case "text/plain", "text/markdown", "text/csv", "application/json", "application/yaml":
+		return isTextType(declared)
+	case "text/xml", "application/xml":
+		return declared == "text/xml" || declared == "application/xml" || strings.HasSuffix(declared, "+xml")
+	case "application/zip":
+		for _, zipType := range zipTypes {
+			if declared == zipType {
+				return true
+			}
+		}
+		return declared == "application/x-zip-compressed"
+	case "image/jpeg":
+		return declared == "image/jpg"
+	}
+	return false
+}
+
+func isTextType(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/yaml", "application/x-yaml", "application/javascript", "application/csv":
+		return true
+	}
+	return false
+}
+
+// matchesAny reports whether mediaType is one of patterns. A pattern ending
+// in /* matches the whole top-level type.
+func matchesAny(mediaType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if pattern == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the lower-case media type without parameters, or an
+// empty string if value is not a media type.
+func mediaType(value string) string {
+	parsed, _, err := mime.ParseMediaType(value)
+	if err != nil || !strings.Contains(parsed, "/") {
+		return ""
+	}
+	return parsed
+}
@@ -0,0 +1,51 @@
+package services_test
+
+import (
+	"testing"
+
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestFileTypeService_Detect(t *testing.T) {
+	service := services.NewFileTypeService(services.FileTypePolicy{})
+
+	assert.Equal(t, "image/png", service.Detect("photo.txt", pngHeader))
+	assert.Equal(t, "application/pdf", service.Detect("doc", []byte("%PDF-1.7\n")))
+	assert.Equal(t, "text/plain", service.Detect("notes.txt", []byte("hello")))
+	assert.Equal(t, "text/markdown", service.Detect("README.md", []byte("# Title")))
+	assert.Equal(t, "application/json", service.Detect("data.json", []byte(`{"a": 1}`)))
+	assert.Equal(t, "application/x-executable", service.Detect("tool", []byte("\x7fELF\x02\x01")))
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		service.Detect("report.docx", []byte("PK\x03\x04\x14\x00")))
+
+	// Test case: the extension alone does not change binary content
+	assert.Equal(t, "application/zip", service.Detect("report.md", []byte("PK\x03\x04\x14\x00")))
+}
+
+func TestFileTypeService_Check(t *testing.T) {
+	service := services.NewFileTypeService(services.FileTypePolicy{
+		Allowed:        []string{"image/*", "text/plain", "application/json"},
+		Denied:         []string{"image/svg+xml"},
+		RejectMismatch: true,
+	})
+
+	assert.NoError(t, service.Check("", "image/png"))
+	assert.NoError(t, service.Check("image/png", "image/png"))
+	assert.NoError(t, service.Check("application/json", "text/plain"))
+	assert.NoError(t, service.Check("text/plain; charset=utf-8", "text/plain"))
+	assert.NoError(t, service.Check("application/octet-stream", "image/gif"))
+
+	assert.Equal(t, utils.ErrFileTypeNotAllowed, service.Check("", "application/pdf"))
+	assert.Equal(t, utils.ErrFileTypeNotAllowed, service.Check("", "image/svg+xml"))
+	assert.Equal(t, utils.ErrFileTypeNotAllowed, service.Check("image/svg+xml", "text/plain"))
+	assert.Equal(t, utils.ErrFileTypeMismatch, service.Check("image/png", "text/plain"))
+
+	// Test case: mismatches pass unless they are rejected
+	lenient := services.NewFileTypeService(services.FileTypePolicy{})
+	assert.NoError(t, lenient.Check("image/png", "application/pdf"))
+}
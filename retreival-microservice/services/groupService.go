@@ -0,0 +1,177 @@
+package services
+
+import (
+	"strings"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+)
+
+const maxGroupNameLength = 100
+
+// GroupResolver finds the groups of a user, so the store can match files
+// shared with them.
+type GroupResolver interface {
+	GroupIDs(userID uint) ([]uint, error)
+}
+
+// GroupService manages groups of users that files and tags are shared with.
+// Only members see a group; only its owner changes it, though members may
+// leave.
+type GroupService struct {
+	repo     *repositories.GroupRepository
+	userRepo *repositories.UserRepository
+	log      *zap.Logger
+}
+
+func NewGroupService(repo *repositories.GroupRepository, userRepo *repositories.UserRepository) *GroupService {
+	return &GroupService{repo: repo, userRepo: userRepo, log: utils.GetLogger()}
+}
+
+func (gs *GroupService) CreateGroup(ownerID uint, request models.GroupRequest) (*models.Group, error) {
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > maxGroupNameLength {
+		return nil, utils.ErrInvalidGroupName
+	}
+
+	group := &models.Group{OwnerID: ownerID, Name: name}
+	if err := gs.repo.CreateGroup(group); err != nil {
+		gs.log.Error("Failed to create group", zap.Uint("OwnerID", ownerID), zap.Error(err))
+		return nil, err
+	}
+	gs.log.Info("Group created", zap.Uint("OwnerID", ownerID), zap.Uint("GroupID", group.ID))
+	return group, nil
+}
+
+// ListGroups returns the groups the user is a member of.
+func (gs *GroupService) ListGroups(userID uint) ([]models.Group, error) {
+	return gs.repo.ListGroups(userID)
+}
+
+func (gs *GroupService) GroupIDs(userID uint) ([]uint, error) {
+	return gs.repo.GroupIDs(userID)
+}
+
+func (gs *GroupService) DeleteGroup(userID, groupID uint) error {
+	if _, err := gs.ownGroup(userID, groupID); err != nil {
+		return err
+	}
+	if err := gs.repo.DeleteGroup(groupID); err != nil {
+		return err
+	}
+	gs.log.Info("Group deleted", zap.Uint("UserID", userID), zap.Uint("GroupID", groupID))
+	return nil
+}
+
+// AddMember adds the user with the username or email identifier to one of
+// the owner's groups.
+func (gs *GroupService) AddMember(ownerID, groupID uint, identifier string) (*models.User, error) {
+	if _, err := gs.ownGroup(ownerID, groupID); err != nil {
+		return nil, err
+	}
+	user, err := gs.FindUser(identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	isMember, err := gs.repo.IsMember(groupID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if isMember {
+		return nil, utils.ErrAlreadyGroupMember
+	}
+	if err := gs.repo.AddMember(&models.GroupMember{GroupID: groupID, UserID: user.ID}); err != nil {
+		return nil, err
+	}
+	gs.log.Info("Group member added", zap.Uint("GroupID", groupID), zap.Uint("UserID", user.ID))
+	return user, nil
+}
+
+// RemoveMember removes a member from a group. The owner removes others;
+// members remove themselves. The owner stays a member until the group is
+// deleted.
+func (gs *GroupService) RemoveMember(userID, groupID, memberID uint) error {
+	group, err := gs.memberGroup(userID, groupID)
+	if err != nil {
+		return err
+	}
+	if memberID == group.OwnerID {
+		return utils.ErrGroupOwnerCannotLeave
+	}
+	if userID != group.OwnerID && userID != memberID {
+		return utils.ErrNotGroupOwner
+	}
+
+	removed, err := gs.repo.RemoveMember(groupID, memberID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return utils.ErrGroupMemberNotFound
+	}
+	gs.log.Info("Group member removed", zap.Uint("GroupID", groupID), zap.Uint("UserID", memberID))
+	return nil
+}
+
+func (gs *GroupService) ListMembers(userID, groupID uint) ([]models.GroupMemberResponse, error) {
+	if _, err := gs.memberGroup(userID, groupID); err != nil {
+		return nil, err
+	}
+	return gs.repo.ListMembers(groupID)
+}
+
+// CheckMember fails with ErrGroupNotFound unless the user is a member of the
+// group, so groups cannot be found by guessing their ids.
+func (gs *GroupService) CheckMember(userID, groupID uint) error {
+	_, err := gs.memberGroup(userID, groupID)
+	return err
+}
+
+// FindUser returns the user with the username or email identifier.
+func (gs *GroupService) FindUser(identifier string) (*models.User, error) {
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return nil, utils.ErrUserNotFound
+	}
+	user, err := gs.userRepo.GetUserByEmailOrUsername(identifier)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, utils.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (gs *GroupService) memberGroup(userID, groupID uint) (*models.Group, error) {
+	group, err := gs.repo.GetGroupByID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, utils.ErrGroupNotFound
+	}
+	isMember, err := gs.repo.IsMember(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, utils.ErrGroupNotFound
+	}
+	return group, nil
+}
+
+func (gs *GroupService) ownGroup(userID, groupID uint) (*models.Group, error) {
+	group, err := gs.memberGroup(userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if group.OwnerID != userID {
+		return nil, utils.ErrNotGroupOwner
+	}
+	return group, nil
+}
@@ -0,0 +1,130 @@
+package services_test
+
+import (
+	"testing"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type recordingAccessStore struct {
+	granted []models.ACLPayload
+}
+
+func (rs *recordingAccessStore) GrantAccess(ownerID uint, target models.AccessTarget, principalType string, principalID uint, permission string) (*models.ACLEntryInfo, error) {
+	rs.granted = append(rs.granted, models.ACLPayload{Tag: target.Tag, FolderID: target.FolderID, PrincipalType: principalType, PrincipalID: principalID, Permission: permission})
+	return &models.ACLEntryInfo{ID: uint(len(rs.granted)), FileID: target.FileID, Tag: target.Tag, FolderID: target.FolderID, PrincipalType: principalType, PrincipalID: principalID, Permission: permission}, nil
+}
+
+func (rs *recordingAccessStore) RevokeAccess(ownerID uint, target models.AccessTarget, entryID uint) error {
+	return nil
+}
+
+func (rs *recordingAccessStore) ListAccess(ownerID uint, target models.AccessTarget) ([]models.ACLEntryInfo, error) {
+	return nil, nil
+}
+
+func prepareGroupService(t *testing.T) (*services.GroupService, []*models.User) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Group{}, &models.GroupMember{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	userRepo := repositories.NewUserRepository(db)
+	var users []*models.User
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := userRepo.CreateUser(models.User{Username: name, Email: name + "@example.com", Password: "hash"})
+		assert.NoError(t, err)
+		users = append(users, user)
+	}
+	return services.NewGroupService(repositories.NewGroupRepository(db), userRepo), users
+}
+
+func TestGroupService_Members(t *testing.T) {
+	service, users := prepareGroupService(t)
+	alice, bob, carol := users[0], users[1], users[2]
+
+	group, err := service.CreateGroup(alice.ID, models.GroupRequest{Name: " Team "})
+	assert.NoError(t, err)
+	assert.Equal(t, "Team", group.Name)
+
+	// Test case: members are added by username or email, once
+	_, err = service.AddMember(alice.ID, group.ID, "bob")
+	assert.NoError(t, err)
+	_, err = service.AddMember(alice.ID, group.ID, "bob@example.com")
+	assert.Equal(t, utils.ErrAlreadyGroupMember, err)
+	_, err = service.AddMember(alice.ID, group.ID, "nobody")
+	assert.Equal(t, utils.ErrUserNotFound, err)
+
+	// Test case: only the owner adds members; outsiders do not see the group
+	_, err = service.AddMember(bob.ID, group.ID, "carol")
+	assert.Equal(t, utils.ErrNotGroupOwner, err)
+	_, err = service.ListMembers(carol.ID, group.ID)
+	assert.Equal(t, utils.ErrGroupNotFound, err)
+
+	members, err := service.ListMembers(bob.ID, group.ID)
+	assert.NoError(t, err)
+	assert.Len(t, members, 2)
+
+	ids, err := service.GroupIDs(bob.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{group.ID}, ids)
+
+	// Test case: members leave, the owner cannot
+	assert.Equal(t, utils.ErrGroupOwnerCannotLeave, service.RemoveMember(bob.ID, group.ID, alice.ID))
+	assert.NoError(t, service.RemoveMember(bob.ID, group.ID, bob.ID))
+	ids, err = service.GroupIDs(bob.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+
+	// Test case: deleting the group removes every membership
+	assert.NoError(t, service.DeleteGroup(alice.ID, group.ID))
+	groups, err := service.ListGroups(alice.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestAccessService_Grant(t *testing.T) {
+	groups, users := prepareGroupService(t)
+	alice, bob := users[0], users[1]
+	store := &recordingAccessStore{}
+	service := services.NewAccessService(store, groups)
+
+	team, err := groups.CreateGroup(alice.ID, models.GroupRequest{Name: "team"})
+	assert.NoError(t, err)
+	other, err := groups.CreateGroup(bob.ID, models.GroupRequest{Name: "other"})
+	assert.NoError(t, err)
+
+	// Test case: users are resolved to their ids
+	entry, err := service.Grant(alice.ID, models.AccessTarget{FileID: 7}, models.AccessRequest{User: "bob", Permission: models.PermissionWrite})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PrincipalUser, entry.PrincipalType)
+	assert.Equal(t, bob.ID, entry.PrincipalID)
+
+	// Test case: tags are shared with the owner's groups only
+	_, err = service.Grant(alice.ID, models.AccessTarget{Tag: "work"}, models.AccessRequest{GroupID: team.ID, Permission: models.PermissionRead})
+	assert.NoError(t, err)
+	_, err = service.Grant(alice.ID, models.AccessTarget{Tag: "work"}, models.AccessRequest{GroupID: other.ID, Permission: models.PermissionRead})
+	assert.Equal(t, utils.ErrGroupNotFound, err)
+
+	// Test case: invalid requests never reach the store
+	_, err = service.Grant(alice.ID, models.AccessTarget{FileID: 7}, models.AccessRequest{User: "alice", Permission: models.PermissionRead})
+	assert.Equal(t, utils.ErrShareWithSelf, err)
+	_, err = service.Grant(alice.ID, models.AccessTarget{FileID: 7}, models.AccessRequest{User: "bob", GroupID: team.ID, Permission: models.PermissionRead})
+	assert.Equal(t, utils.ErrInvalidPrincipal, err)
+	_, err = service.Grant(alice.ID, models.AccessTarget{FileID: 7}, models.AccessRequest{User: "bob", Permission: "admin"})
+	assert.Equal(t, utils.ErrInvalidPermission, err)
+	assert.Len(t, store.granted, 2)
+}
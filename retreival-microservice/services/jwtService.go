@@ -1,6 +1,8 @@
 package services
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"time"
 
 	"retreival/utils"
@@ -31,6 +33,18 @@ func (jwtService *JWTService) GenerateToken(userID uint) (string, error) {
 	return signedToken, nil
 }
 
+// GenerateSessionToken signs an access token for the session version of the
+// user. Tokens of an older version are rejected once it is bumped; tokens
+// from GenerateToken count as version 0.
+func (jwtService *JWTService) GenerateSessionToken(userID uint, sessionVersion int) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id":         userID,
+		"session_version": sessionVersion,
+		"exp":             time.Now().Add(time.Hour * 24 * 30).Unix(), // Token expires in 30 days
+	}
+	return jwtService.GenerateTokenWithClaims(claims)
+}
+
 func (jwtService *JWTService) GenerateTokenWithClaims(claims jwt.Claims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	signedToken, err := token.SignedString([]byte(jwtService.secretKey))
@@ -69,6 +83,15 @@ func (jwtService *JWTService) ValidateToken(tokenString string) (*jwt.Token, err
 		return nil, utils.ErrInvalidTokenClaims
 	}
 
+	// Purpose tokens (email verification, password reset, ...) are not access
+	// tokens and must never authenticate a request.
+	if _, hasPurpose := claims["purpose"]; hasPurpose {
+		jwtService.log.Warn("Purpose token used as access token",
+			zap.String("reason", "invalid_token"),
+		)
+		return nil, utils.ErrInvalidTokenClaims
+	}
+
 	expirationTime := time.Unix(int64(claims["exp"].(float64)), 0)
 	if time.Now().After(expirationTime) {
 		jwtService.log.Warn("Invalid token",
@@ -80,3 +103,99 @@ func (jwtService *JWTService) ValidateToken(tokenString string) (*jwt.Token, err
 
 	return token, nil
 }
+
+// GeneratePurposeToken signs a short lived token that is only valid for the
+// given purpose. It returns the token and its unique id (jti) so callers can
+// make it single use.
+func (jwtService *JWTService) GeneratePurposeToken(userID uint, purpose string, ttl time.Duration) (string, string, error) {
+	jti, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	claims := jwt.MapClaims{
+		"user_id": userID,
+		"purpose": purpose,
+		"jti":     jti,
+		"exp":     time.Now().Add(ttl).Unix(),
+	}
+	signedToken, err := jwtService.GenerateTokenWithClaims(claims)
+	if err != nil {
+		return "", "", err
+	}
+	return signedToken, jti, nil
+}
+
+// ValidatePurposeToken checks the signature, expiry and purpose of a token
+// created by GeneratePurposeToken and returns its user id and jti.
+func (jwtService *JWTService) ValidatePurposeToken(tokenString, purpose string) (uint, string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(jwtService.secretKey), nil
+	})
+	if err != nil {
+		if validationErr, ok := err.(*jwt.ValidationError); ok && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return 0, "", utils.ErrTokenExpired
+		}
+		return 0, "", utils.ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != purpose {
+		jwtService.log.Warn("Invalid purpose token",
+			zap.String("reason", "invalid_token"),
+			zap.String("purpose", purpose),
+		)
+		return 0, "", utils.ErrInvalidToken
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	jti, _ := claims["jti"].(string)
+	if !ok || jti == "" {
+		return 0, "", utils.ErrInvalidTokenClaims
+	}
+
+	return uint(userID), jti, nil
+}
+
+// UserIDFromToken extracts the user id claim of a validated access token.
+func UserIDFromToken(token *jwt.Token) (uint, bool) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, false
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+// SessionVersionFromToken extracts the session version claim of a validated
+// access token.
+func SessionVersionFromToken(token *jwt.Token) int {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0
+	}
+	version, _ := claims["session_version"].(float64)
+	return int(version)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func randomHex(n int) (string, error) {
+	b, err := randomBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -50,3 +50,28 @@ func TestJWTService_ValidateToken(t *testing.T) {
 	assert.Error(t, err)
 	assert.EqualError(t, err, utils.ErrTokenExpired.Error())
 }
+
+func TestJWTService_PurposeToken(t *testing.T) {
+	secretKey := "702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b"
+	jwtService := services.NewJWTService(secretKey)
+
+	token, jti, err := jwtService.GeneratePurposeToken(123, "verify_email", time.Hour)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, jti)
+
+	userID, gotJTI, err := jwtService.ValidatePurposeToken(token, "verify_email")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(123), userID)
+	assert.Equal(t, jti, gotJTI)
+
+	// A token for one purpose is rejected for any other purpose and as an
+	// access token.
+	_, _, err = jwtService.ValidatePurposeToken(token, "reset_password")
+	assert.Equal(t, utils.ErrInvalidToken, err)
+	_, err = jwtService.ValidateToken(token)
+	assert.Equal(t, utils.ErrInvalidTokenClaims, err)
+
+	expired, _, _ := jwtService.GeneratePurposeToken(123, "verify_email", -time.Minute)
+	_, _, err = jwtService.ValidatePurposeToken(expired, "verify_email")
+	assert.Equal(t, utils.ErrTokenExpired, err)
+}
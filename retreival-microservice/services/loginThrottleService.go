@@ -0,0 +1,183 @@
+package services
+
+import (
+	"strconv"
+	"time"
+
+	"retreival/repositories"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+)
+
+type LockoutPolicy struct {
+	AccountMaxAttempts int           // failures on one account before it is locked
+	IPMaxAttempts      int           // failures from one IP before it is locked
+	LockoutDuration    time.Duration // how long a lock lasts
+	DelayAfter         int           // failures allowed before progressive delays start
+	BaseDelay          time.Duration // first delay, doubled on every further failure
+	MaxDelay           time.Duration
+	Window             time.Duration // failures older than this are forgotten
+}
+
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		AccountMaxAttempts: 5,
+		IPMaxAttempts:      20,
+		LockoutDuration:    15 * time.Minute,
+		DelayAfter:         2,
+		BaseDelay:          time.Second,
+		MaxDelay:           30 * time.Second,
+		Window:             15 * time.Minute,
+	}
+}
+
+type LoginThrottleService struct {
+	store  repositories.LoginAttemptStore
+	policy LockoutPolicy
+	log    *zap.Logger
+	now    func() time.Time
+}
+
+func NewLoginThrottleService(store repositories.LoginAttemptStore, policy LockoutPolicy) *LoginThrottleService {
+	return &LoginThrottleService{
+		store:  store,
+		policy: policy,
+		log:    utils.GetLogger(),
+		now:    time.Now,
+	}
+}
+
+// Check returns how long the caller has to wait before another login attempt
+// for the user or IP is accepted. Zero means the attempt may proceed. The
+// account counter is kept per user, so logins by username and by email share
+// it; userID 0 is the one counter for identifiers that match no user.
+func (ts *LoginThrottleService) Check(userID uint, ip string) (time.Duration, error) {
+	return ts.check(ts.keys(accountKey(userID), ip))
+}
+
+// RecordFailure counts a failed login against both the account and the IP.
+func (ts *LoginThrottleService) RecordFailure(userID uint, ip string) error {
+	return ts.recordFailures(accountKey(userID), ip)
+}
+
+// Reset clears the account counter after a successful login. The IP counter is
+// left alone so one valid account cannot be used to reset it.
+func (ts *LoginThrottleService) Reset(userID uint) error {
+	return ts.store.Delete(accountKey(userID))
+}
+
+// Prune deletes the counters whose failures are older than the window and
+// whose lock is over; they would start over anyway.
+func (ts *LoginThrottleService) Prune() error {
+	now := ts.now()
+	if err := ts.store.DeleteExpired(now.Add(-ts.policy.Window), now); err != nil {
+		ts.log.Error("Failed to prune login attempts", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// CheckTwoFactor, RecordTwoFactorFailure and ResetTwoFactor do the same for
+// the second login step of a user. Its counter has a key that no login
+// identifier maps to, so failed password logins cannot lock it.
+func (ts *LoginThrottleService) CheckTwoFactor(userID uint, ip string) (time.Duration, error) {
+	return ts.check(ts.keys(twoFactorKey(userID), ip))
+}
+
+func (ts *LoginThrottleService) RecordTwoFactorFailure(userID uint, ip string) error {
+	return ts.recordFailures(twoFactorKey(userID), ip)
+}
+
+func (ts *LoginThrottleService) ResetTwoFactor(userID uint) error {
+	return ts.store.Delete(twoFactorKey(userID))
+}
+
+func (ts *LoginThrottleService) check(keys []string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range keys {
+		attempt, err := ts.store.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		if attempt == nil {
+			continue
+		}
+		if remaining := attempt.LockedUntil.Sub(ts.now()); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+func (ts *LoginThrottleService) recordFailures(key, ip string) error {
+	if err := ts.recordFailure(key, ts.policy.AccountMaxAttempts); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+	return ts.recordFailure(ipKey(ip), ts.policy.IPMaxAttempts)
+}
+
+// recordFailure counts the failure in the store first, so concurrent failures
+// are all counted, and then locks the key for what the new count calls for.
+func (ts *LoginThrottleService) recordFailure(key string, maxAttempts int) error {
+	now := ts.now()
+
+	attempt, err := ts.store.AddFailure(key, now, ts.policy.Window)
+	if err != nil {
+		return err
+	}
+
+	if maxAttempts > 0 && attempt.Failures >= maxAttempts {
+		ts.log.Warn("Login locked after repeated failures",
+			zap.String("key", key),
+			zap.Int("failures", attempt.Failures),
+		)
+		return ts.store.Lock(key, now.Add(ts.policy.LockoutDuration))
+	}
+	if delay := ts.delayFor(attempt.Failures); delay > 0 {
+		return ts.store.Lock(key, now.Add(delay))
+	}
+	return nil
+}
+
+func (ts *LoginThrottleService) delayFor(failures int) time.Duration {
+	extra := failures - ts.policy.DelayAfter
+	if extra <= 0 || ts.policy.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := ts.policy.BaseDelay
+	for i := 1; i < extra; i++ {
+		delay *= 2
+		if ts.policy.MaxDelay > 0 && delay >= ts.policy.MaxDelay {
+			return ts.policy.MaxDelay
+		}
+	}
+	return delay
+}
+
+func (ts *LoginThrottleService) keys(key, ip string) []string {
+	keys := []string{key}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	return keys
+}
+
+func accountKey(userID uint) string {
+	if userID == 0 {
+		return "account:unknown"
+	}
+	return "account:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func twoFactorKey(userID uint) string {
+	return "2fa:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,183 @@
+package services_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestLoginThrottleService_LocksAccount(t *testing.T) {
+	throttle := services.NewLoginThrottleService(repositories.NewInMemoryLoginAttemptStore(), services.LockoutPolicy{
+		AccountMaxAttempts: 3,
+		IPMaxAttempts:      10,
+		LockoutDuration:    time.Minute,
+		DelayAfter:         3,
+		Window:             time.Minute,
+	})
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, throttle.RecordFailure(1, "10.0.0.1"))
+	}
+	wait, err := throttle.Check(1, "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+
+	assert.NoError(t, throttle.RecordFailure(1, "10.0.0.1"))
+	wait, err = throttle.Check(1, "10.0.0.2")
+	assert.NoError(t, err)
+	assert.Greater(t, wait, 50*time.Second)
+
+	// Other accounts from the same IP are not affected yet.
+	wait, err = throttle.Check(2, "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+
+	assert.NoError(t, throttle.Reset(1))
+	wait, err = throttle.Check(1, "10.0.0.2")
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+}
+
+func TestLoginThrottleService_ProgressiveDelay(t *testing.T) {
+	throttle := services.NewLoginThrottleService(repositories.NewInMemoryLoginAttemptStore(), services.LockoutPolicy{
+		AccountMaxAttempts: 10,
+		IPMaxAttempts:      10,
+		LockoutDuration:    time.Hour,
+		DelayAfter:         1,
+		BaseDelay:          time.Second,
+		MaxDelay:           4 * time.Second,
+		Window:             time.Minute,
+	})
+
+	assert.NoError(t, throttle.RecordFailure(1, ""))
+	wait, _ := throttle.Check(1, "")
+	assert.Zero(t, wait)
+
+	assert.NoError(t, throttle.RecordFailure(1, ""))
+	wait, _ = throttle.Check(1, "")
+	assert.InDelta(t, time.Second, wait, float64(100*time.Millisecond))
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, throttle.RecordFailure(1, ""))
+	}
+	wait, _ = throttle.Check(1, "")
+	assert.InDelta(t, 4*time.Second, wait, float64(100*time.Millisecond))
+}
+
+func TestLoginThrottleService_LocksIP(t *testing.T) {
+	throttle := services.NewLoginThrottleService(repositories.NewInMemoryLoginAttemptStore(), services.LockoutPolicy{
+		AccountMaxAttempts: 10,
+		IPMaxAttempts:      3,
+		LockoutDuration:    time.Minute,
+		DelayAfter:         10,
+		Window:             time.Minute,
+	})
+
+	for _, userID := range []uint{1, 2, 3} {
+		assert.NoError(t, throttle.RecordFailure(userID, "10.0.0.1"))
+	}
+
+	wait, err := throttle.Check(4, "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Greater(t, wait, time.Duration(0))
+}
+
+func TestLoginThrottleService_ConcurrentFailures(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.LoginAttempt{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	const failures = 20
+	stores := map[string]repositories.LoginAttemptStore{
+		"memory":   repositories.NewInMemoryLoginAttemptStore(),
+		"database": repositories.NewLoginAttemptRepository(db),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			throttle := services.NewLoginThrottleService(store, services.LockoutPolicy{
+				AccountMaxAttempts: failures,
+				IPMaxAttempts:      100,
+				LockoutDuration:    time.Minute,
+				DelayAfter:         100,
+				Window:             time.Minute,
+			})
+
+			// Test case: every failure counts, so the last one locks the account
+			var wg sync.WaitGroup
+			for i := 0; i < failures; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					assert.NoError(t, throttle.RecordFailure(1, "10.0.0.1"))
+				}()
+			}
+			wg.Wait()
+
+			wait, err := throttle.Check(1, "")
+			assert.NoError(t, err)
+			assert.Greater(t, wait, 50*time.Second)
+		})
+	}
+}
+
+func TestLoginThrottleService_Prune(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.LoginAttempt{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	stores := map[string]repositories.LoginAttemptStore{
+		"memory":   repositories.NewInMemoryLoginAttemptStore(),
+		"database": repositories.NewLoginAttemptRepository(db),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			throttle := services.NewLoginThrottleService(store, services.LockoutPolicy{
+				AccountMaxAttempts: 2,
+				IPMaxAttempts:      10,
+				LockoutDuration:    time.Hour,
+				DelayAfter:         10,
+				Window:             time.Minute,
+			})
+
+			now := time.Now()
+			_, err := store.AddFailure("account:1", now.Add(-2*time.Minute), time.Minute)
+			assert.NoError(t, err)
+			_, err = store.AddFailure("account:2", now, time.Minute)
+			assert.NoError(t, err)
+			_, err = store.AddFailure("account:3", now.Add(-2*time.Minute), time.Minute)
+			assert.NoError(t, err)
+			assert.NoError(t, store.Lock("account:3", now.Add(time.Hour)))
+
+			// Test case: only old counters without a running lock are deleted
+			assert.NoError(t, throttle.Prune())
+			for key, kept := range map[string]bool{"account:1": false, "account:2": true, "account:3": true} {
+				attempt, err := store.Get(key)
+				assert.NoError(t, err)
+				assert.Equal(t, kept, attempt != nil, key)
+			}
+		})
+	}
+}
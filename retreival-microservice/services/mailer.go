@@ -0,0 +1,96 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"retreival/utils"
+
+	"go.uber.org/zap"
+)
+
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+	log  *zap.Logger
+}
+
+// NewSMTPMailer sends plain text mails through the given server. Auth is only
+// used when a username is set.
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		from: from,
+		auth: auth,
+		log:  utils.GetLogger(),
+	}
+}
+
+func (sm *SMTPMailer) Send(to, subject, body string) error {
+	msg := strings.Join([]string{
+		"From: " + sm.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(sm.addr, sm.auth, sm.from, []string{to}, []byte(msg)); err != nil {
+		sm.log.Error("Failed to send mail", zap.String("to", to), zap.Error(err))
+		return err
+	}
+	sm.log.Info("Mail sent", zap.String("to", to), zap.String("subject", subject))
+	return nil
+}
+
+// LogMailer does not deliver mails. It appends them to a file, or to the log
+// when no file is set, which is handy for local development and tests.
+type LogMailer struct {
+	path string
+	mu   sync.Mutex
+	log  *zap.Logger
+}
+
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{path: path, log: utils.GetLogger()}
+}
+
+func (lm *LogMailer) Send(to, subject, body string) error {
+	if lm.path == "" {
+		lm.log.Info("Mail",
+			zap.String("to", to),
+			zap.String("subject", subject),
+			zap.String("body", body),
+		)
+		return nil
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	f, err := os.OpenFile(lm.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "To: %s\nSubject: %s\n\n%s\n---\n", to, subject, body)
+	return err
+}
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"retreival/utils"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+)
+
+// ExternalIdentity is what an identity provider tells us about a user after a
+// successful login.
+type ExternalIdentity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	GivenName         string
+	FamilyName        string
+	Nonce             string
+}
+
+// IdentityProvider runs the authorization code flow against an external
+// provider.
+type IdentityProvider interface {
+	Name() string
+	// AuthCodeURL returns where to send the browser to log in.
+	AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+	// Exchange redeems the authorization code and returns the verified identity.
+	Exchange(ctx context.Context, code, codeVerifier string) (*ExternalIdentity, error)
+}
+
+type OIDCConfig struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider is an OpenID Connect IdentityProvider using discovery, PKCE
+// (S256) and RS256 signed ID tokens.
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+	log    *zap.Logger
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	if config.Name == "" {
+		config.Name = "oidc"
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &OIDCProvider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		log:    utils.GetLogger(),
+	}
+}
+
+func (op *OIDCProvider) Name() string {
+	return op.config.Name
+}
+
+func (op *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	discovery, err := op.discover(ctx)
+	if err != nil {
+		op.log.Error("Failed to load OIDC discovery document", zap.Error(err))
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", op.config.ClientID)
+	query.Set("redirect_uri", op.config.RedirectURL)
+	query.Set("scope", strings.Join(op.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+func (op *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier string) (*ExternalIdentity, error) {
+	discovery, err := op.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", op.config.RedirectURL)
+	form.Set("client_id", op.config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if op.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(op.config.ClientID), url.QueryEscape(op.config.ClientSecret))
+	}
+
+	resp, err := op.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		op.log.Warn("OIDC token exchange failed",
+			zap.Int("status", resp.StatusCode),
+			zap.String("body", string(body)),
+		)
+		return nil, utils.ErrOIDCExchangeFailed
+	}
+
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return nil, err
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, utils.ErrOIDCExchangeFailed
+	}
+
+	return op.verifyIDToken(ctx, discovery, tokenResponse.IDToken)
+}
+
+func (op *OIDCProvider) verifyIDToken(ctx context.Context, discovery *oidcDiscovery, idToken string) (*ExternalIdentity, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return op.publicKey(ctx, discovery, kid)
+	})
+	if err != nil || !token.Valid {
+		op.log.Warn("Invalid OIDC id token", zap.Error(err))
+		return nil, utils.ErrOIDCInvalidIDToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, utils.ErrOIDCInvalidIDToken
+	}
+	if iss, _ := claims["iss"].(string); iss != discovery.Issuer {
+		return nil, utils.ErrOIDCInvalidIDToken
+	}
+	if !audienceContains(claims["aud"], op.config.ClientID) {
+		return nil, utils.ErrOIDCInvalidIDToken
+	}
+	if _, hasExp := claims["exp"]; !hasExp {
+		return nil, utils.ErrOIDCInvalidIDToken
+	}
+
+	identity := &ExternalIdentity{Issuer: discovery.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+	identity.GivenName, _ = claims["given_name"].(string)
+	identity.FamilyName, _ = claims["family_name"].(string)
+	identity.Nonce, _ = claims["nonce"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+
+	if identity.Subject == "" {
+		return nil, utils.ErrOIDCInvalidIDToken
+	}
+	return identity, nil
+}
+
+func (op *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	if op.discovery != nil {
+		return op.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(op.config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	var discovery oidcDiscovery
+	if err := op.getJSON(ctx, wellKnown, &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(op.config.IssuerURL, "/") {
+		return nil, fmt.Errorf("oidc issuer mismatch: got %q", discovery.Issuer)
+	}
+
+	op.discovery = &discovery
+	return op.discovery, nil
+}
+
+// publicKey returns the signing key with the given id, refreshing the JWKS
+// once when the key is unknown (for example after a key rotation).
+func (op *OIDCProvider) publicKey(ctx context.Context, discovery *oidcDiscovery, kid string) (*rsa.PublicKey, error) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	if key := op.lookupKey(kid); key != nil {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := op.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	op.keys = keys
+
+	if key := op.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc signing key %q not found", kid)
+}
+
+func (op *OIDCProvider) lookupKey(kid string) *rsa.PublicKey {
+	if kid != "" {
+		return op.keys[kid]
+	}
+	// Providers with a single key may omit the kid.
+	if len(op.keys) == 1 {
+		for _, key := range op.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+func (op *OIDCProvider) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := op.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, entry := range aud {
+			if entry == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	oidcStateTTL        = 10 * time.Minute
+	maxUsernameAttempts = 20
+)
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// OIDCService logs users in through an external IdentityProvider and maps
+// the external account to a local models.User.
+type OIDCService struct {
+	provider     IdentityProvider
+	userRepo     *repositories.UserRepository
+	identityRepo *repositories.UserIdentityRepository
+	jwt          *JWTService
+	tokens       *repositories.UserTokenRepository
+	log          *zap.Logger
+}
+
+func NewOIDCService(provider IdentityProvider, userRepo *repositories.UserRepository, identityRepo *repositories.UserIdentityRepository, jwt *JWTService, tokens *repositories.UserTokenRepository) *OIDCService {
+	return &OIDCService{
+		provider:     provider,
+		userRepo:     userRepo,
+		identityRepo: identityRepo,
+		jwt:          jwt,
+		tokens:       tokens,
+		log:          utils.GetLogger(),
+	}
+}
+
+// Begin starts an authorization code + PKCE flow and returns the provider URL
+// the browser has to visit.
+func (oc *OIDCService) Begin(ctx context.Context) (string, error) {
+	state, err := randomURLToken(24)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomURLToken(24)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomURLToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	if err := oc.identityRepo.DeleteExpiredStates(); err != nil {
+		oc.log.Warn("Failed to delete expired OIDC states", zap.Error(err))
+	}
+	if err := oc.identityRepo.SaveState(&models.OIDCLoginState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}); err != nil {
+		return "", err
+	}
+
+	return oc.provider.AuthCodeURL(ctx, state, nonce, pkceChallenge(verifier))
+}
+
+// Callback finishes the flow started by Begin. Users with two-factor
+// authentication still have to pass the second step.
+func (oc *OIDCService) Callback(ctx context.Context, state, code string) (*LoginResult, error) {
+	loginState, err := oc.identityRepo.ConsumeState(state)
+	if err != nil {
+		return nil, err
+	}
+	if loginState == nil {
+		return nil, utils.ErrOIDCInvalidState
+	}
+
+	identity, err := oc.provider.Exchange(ctx, code, loginState.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	if identity.Nonce != loginState.Nonce {
+		oc.log.Warn("OIDC nonce mismatch", zap.String("provider", oc.provider.Name()))
+		return nil, utils.ErrOIDCInvalidIDToken
+	}
+
+	user, err := oc.resolveUser(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		return issueTwoFactorChallenge(oc.jwt, oc.tokens, user.ID)
+	}
+
+	token, err := oc.jwt.GenerateSessionToken(user.ID, user.SessionVersion)
+	if err != nil {
+		return nil, utils.ErrInGenerateToken
+	}
+	return &LoginResult{Token: token}, nil
+}
+
+// resolveUser finds the user linked to the external account. On first login
+// it links an existing user with the same verified email, or creates one.
+func (oc *OIDCService) resolveUser(identity *ExternalIdentity) (*models.User, error) {
+	provider := oc.provider.Name()
+
+	link, err := oc.identityRepo.GetIdentity(provider, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if link != nil {
+		user, err := oc.userRepo.GetUserByID(link.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, utils.ErrUserNotFound
+		}
+		return user, nil
+	}
+
+	var user *models.User
+	if identity.Email != "" {
+		existing, err := oc.userRepo.GetUserByEmailOrUsername(identity.Email)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil && existing.Email == identity.Email {
+			// Only a provider verified email may take over a local account.
+			if !identity.EmailVerified {
+				return nil, utils.ErrEmailExist
+			}
+			user = existing
+		}
+	}
+
+	if user == nil {
+		user, err = oc.createUser(identity)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := oc.identityRepo.CreateIdentity(&models.UserIdentity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}); err != nil {
+		return nil, err
+	}
+
+	oc.log.Info("External identity linked",
+		zap.Uint("UserID", user.ID),
+		zap.String("provider", provider),
+	)
+	return user, nil
+}
+
+func (oc *OIDCService) createUser(identity *ExternalIdentity) (*models.User, error) {
+	// SSO users log in through the provider; the random password only keeps
+	// the column populated and cannot be guessed.
+	randomPassword, err := randomURLToken(32)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.User{
+		Email:         identity.Email,
+		Password:      string(hashedPassword),
+		FirstName:     identity.GivenName,
+		LastName:      identity.FamilyName,
+		EmailVerified: identity.EmailVerified,
+	}
+	if identity.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	base := usernameFromIdentity(identity)
+	for attempt := 1; attempt <= maxUsernameAttempts; attempt++ {
+		user.Username = base
+		if attempt > 1 {
+			suffix := strconv.Itoa(attempt)
+			if len(base)+len(suffix) > 32 {
+				user.Username = base[:32-len(suffix)]
+			}
+			user.Username += suffix
+		}
+
+		created, err := oc.userRepo.CreateUser(user)
+		if err == utils.ErrUsernameExist {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return created, nil
+	}
+	return nil, utils.ErrUsernameExist
+}
+
+func usernameFromIdentity(identity *ExternalIdentity) string {
+	candidate := identity.PreferredUsername
+	if candidate == "" || strings.Contains(candidate, "@") {
+		candidate = identity.Email
+		if at := strings.Index(candidate, "@"); at >= 0 {
+			candidate = candidate[:at]
+		}
+	}
+
+	candidate = usernameInvalidChars.ReplaceAllString(candidate, "")
+	if len(candidate) > 32 {
+		candidate = candidate[:32]
+	}
+	if len(candidate) < 3 {
+		candidate = "user" + candidate
+	}
+	return candidate
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomURLToken(n int) (string, error) {
+	raw, err := randomBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
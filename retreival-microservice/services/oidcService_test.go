@@ -0,0 +1,207 @@
+package services_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// mockIssuer is a minimal OpenID Connect provider: discovery, JWKS and a token
+// endpoint that checks PKCE before issuing an RS256 ID token.
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("failed to generate key:", err)
+	}
+	issuer := &mockIssuer{key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		issuer.mu.Lock()
+		authorization, ok := issuer.codes[r.Form.Get("code")]
+		delete(issuer.codes, r.Form.Get("code"))
+		issuer.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != authorization.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, authorization.claims)
+		token.Header["kid"] = "test-key"
+		idToken, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "id_token": idToken})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+// authorize plays the browser and the provider login page: it accepts the
+// authorization URL and returns the state and code sent to the callback.
+func (mi *mockIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) (string, string) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal("invalid authorization url:", err)
+	}
+	query := parsed.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	claims["iss"] = mi.server.URL
+	claims["aud"] = query.Get("client_id")
+	claims["nonce"] = query.Get("nonce")
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	claims["iat"] = time.Now().Unix()
+
+	code := "code-" + query.Get("state")
+	mi.mu.Lock()
+	mi.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), claims: claims}
+	mi.mu.Unlock()
+
+	return query.Get("state"), code
+}
+
+func TestOIDCService_LoginCreatesAndLinksUser(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.UserIdentity{}, &models.OIDCLoginState{}, &models.UserToken{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	userRepo := repositories.NewUserRepository(db)
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	provider := services.NewOIDCProvider(services.OIDCConfig{
+		Name:        "sso",
+		IssuerURL:   issuer.server.URL,
+		ClientID:    "retrieval",
+		RedirectURL: "http://localhost:8080/api/v1/user/oidc/callback",
+	})
+	oidc := services.NewOIDCService(provider, userRepo, repositories.NewUserIdentityRepository(db), jwtService, repositories.NewUserTokenRepository(db))
+	ctx := context.Background()
+
+	// Test case: first login creates the user
+	authURL, err := oidc.Begin(ctx)
+	assert.NoError(t, err)
+	state, code := issuer.authorize(t, authURL, jwt.MapClaims{
+		"sub":                "external-1",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"preferred_username": "alice",
+		"given_name":         "Alice",
+	})
+
+	result, err := oidc.Callback(ctx, state, code)
+	assert.NoError(t, err)
+	token, err := jwtService.ValidateToken(result.Token)
+	assert.NoError(t, err)
+	userID, _ := services.UserIDFromToken(token)
+
+	user, _ := userRepo.GetUserByID(userID)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, "Alice", user.FirstName)
+	assert.True(t, user.EmailVerified)
+
+	// Test case: the state cannot be replayed
+	_, err = oidc.Callback(ctx, state, code)
+	assert.Equal(t, utils.ErrOIDCInvalidState, err)
+
+	// Test case: later logins map the subject to the same user
+	authURL, _ = oidc.Begin(ctx)
+	state, code = issuer.authorize(t, authURL, jwt.MapClaims{"sub": "external-1", "email": "alice@example.com"})
+	result, err = oidc.Callback(ctx, state, code)
+	assert.NoError(t, err)
+	token, _ = jwtService.ValidateToken(result.Token)
+	sameUserID, _ := services.UserIDFromToken(token)
+	assert.Equal(t, userID, sameUserID)
+
+	// Test case: an unverified email cannot take over an existing account
+	authURL, _ = oidc.Begin(ctx)
+	state, code = issuer.authorize(t, authURL, jwt.MapClaims{"sub": "external-2", "email": "alice@example.com", "email_verified": false})
+	_, err = oidc.Callback(ctx, state, code)
+	assert.Equal(t, utils.ErrEmailExist, err)
+
+	// Test case: identities without an email each get their own user
+	var emailless []uint
+	for _, subject := range []string{"external-4", "external-5"} {
+		authURL, _ = oidc.Begin(ctx)
+		state, code = issuer.authorize(t, authURL, jwt.MapClaims{"sub": subject})
+		result, err = oidc.Callback(ctx, state, code)
+		assert.NoError(t, err)
+		token, _ = jwtService.ValidateToken(result.Token)
+		id, _ := services.UserIDFromToken(token)
+		user, _ = userRepo.GetUserByID(id)
+		assert.Equal(t, "", user.Email)
+		assert.NoError(t, userRepo.UpdateUser(user))
+		emailless = append(emailless, id)
+	}
+	assert.NotEqual(t, emailless[0], emailless[1])
+
+	// Test case: a wrong PKCE verifier is rejected by the provider
+	authURL, _ = oidc.Begin(ctx)
+	state, _ = issuer.authorize(t, authURL, jwt.MapClaims{"sub": "external-3"})
+	_, err = oidc.Callback(ctx, state, "unknown-code")
+	assert.Equal(t, utils.ErrOIDCExchangeFailed, err)
+}
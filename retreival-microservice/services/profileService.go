@@ -0,0 +1,139 @@
+package services
+
+import (
+	"strings"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ProfileService lets authenticated users read and change their own account.
+type ProfileService struct {
+	userRepo     *repositories.UserRepository
+	verification *VerificationService
+	events       UserEventPublisher
+	jwt          *JWTService
+	log          *zap.Logger
+}
+
+func NewProfileService(userRepo *repositories.UserRepository, verification *VerificationService, events UserEventPublisher, jwt *JWTService) *ProfileService {
+	return &ProfileService{
+		userRepo:     userRepo,
+		verification: verification,
+		events:       events,
+		jwt:          jwt,
+		log:          utils.GetLogger(),
+	}
+}
+
+func (ps *ProfileService) GetProfile(userID uint) (*models.User, error) {
+	return ps.loadUser(userID)
+}
+
+func (ps *ProfileService) UpdateProfile(userID uint, request models.UpdateProfileRequest) (*models.User, error) {
+	user, err := ps.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.FirstName != nil {
+		user.FirstName = *request.FirstName
+	}
+	if request.LastName != nil {
+		user.LastName = *request.LastName
+	}
+	if err := ps.userRepo.UpdateUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ChangeEmail starts the re-verification of a new address. The password is
+// required so a stolen session cannot take over the account.
+func (ps *ProfileService) ChangeEmail(userID uint, request models.ChangeEmailRequest) error {
+	user, err := ps.loadUser(userID)
+	if err != nil {
+		return err
+	}
+	if !ps.userRepo.VerifyPassword(user, request.Password) {
+		return utils.ErrIncorrectPassword
+	}
+
+	newEmail := strings.TrimSpace(request.Email)
+	if strings.EqualFold(newEmail, user.Email) {
+		return utils.ErrEmailUnchanged
+	}
+	return ps.verification.RequestEmailChange(user, newEmail)
+}
+
+// ChangePassword sets a new password and signs the user out of every other
+// session: older session tokens stop working and the API keys are revoked.
+// It returns a new session token for the caller.
+func (ps *ProfileService) ChangePassword(userID uint, request models.ChangePasswordRequest) (string, error) {
+	user, err := ps.loadUser(userID)
+	if err != nil {
+		return "", err
+	}
+	if !ps.userRepo.VerifyPassword(user, request.CurrentPassword) {
+		return "", utils.ErrIncorrectPassword
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		ps.log.Error("Failed to hash password", zap.String("reason", "failed_to_hash_password"), zap.Error(err))
+		return "", err
+	}
+	if err := ps.userRepo.ChangePassword(user.ID, string(hashedPassword)); err != nil {
+		return "", err
+	}
+
+	user, err = ps.loadUser(userID)
+	if err != nil {
+		return "", err
+	}
+	token, err := ps.jwt.GenerateSessionToken(user.ID, user.SessionVersion)
+	if err != nil {
+		return "", utils.ErrInGenerateToken
+	}
+
+	ps.log.Info("Password changed", zap.Uint("UserID", user.ID))
+	return token, nil
+}
+
+// DeleteAccount removes the user and asks the store to delete the user's
+// files. The account is only removed once the event has been published.
+func (ps *ProfileService) DeleteAccount(userID uint, password string) error {
+	user, err := ps.loadUser(userID)
+	if err != nil {
+		return err
+	}
+	if !ps.userRepo.VerifyPassword(user, password) {
+		return utils.ErrIncorrectPassword
+	}
+
+	err = ps.userRepo.DeleteUser(user.ID, func() error {
+		return ps.events.PublishUserEvent(&models.UserEvent{Event: models.UserEventDeleted, UserID: user.ID})
+	})
+	if err != nil {
+		ps.log.Error("Failed to delete account", zap.Uint("UserID", user.ID), zap.Error(err))
+		return err
+	}
+
+	ps.log.Info("Account deleted", zap.Uint("UserID", user.ID))
+	return nil
+}
+
+func (ps *ProfileService) loadUser(userID uint) (*models.User, error) {
+	user, err := ps.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, utils.ErrUserNotFound
+	}
+	return user, nil
+}
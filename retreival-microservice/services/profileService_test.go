@@ -0,0 +1,168 @@
+package services_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"retreival/middleware"
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type recordingPublisher struct {
+	events []models.UserEvent
+	err    error
+}
+
+func (rp *recordingPublisher) PublishUserEvent(event *models.UserEvent) error {
+	if rp.err != nil {
+		return rp.err
+	}
+	rp.events = append(rp.events, *event)
+	return nil
+}
+
+func prepareProfileService(t *testing.T) (*services.ProfileService, *recordingMailer, *recordingPublisher, *repositories.UserRepository, *models.User) {
+	return prepareProfileServiceOn(t, openProfileDatabase(t))
+}
+
+func openProfileDatabase(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.UserToken{}, &models.RecoveryCode{}, &models.APIKey{}, &models.UserIdentity{}, &models.StorageUsage{}, &models.Group{}, &models.GroupMember{}, &models.ShareLink{}, &models.ShareLinkAccess{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+	return db
+}
+
+func prepareProfileServiceOn(t *testing.T, db *gorm.DB) (*services.ProfileService, *recordingMailer, *recordingPublisher, *repositories.UserRepository, *models.User) {
+	userRepo := repositories.NewUserRepository(db)
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	mailer := &recordingMailer{}
+	verification := services.NewVerificationService(userRepo, repositories.NewUserTokenRepository(db), jwtService, nil, mailer, "http://localhost:8080")
+	publisher := &recordingPublisher{}
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	user, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com", Password: string(hashedPassword), EmailVerified: true})
+	assert.NoError(t, err)
+
+	return services.NewProfileService(userRepo, verification, publisher, jwtService), mailer, publisher, userRepo, user
+}
+
+func TestProfileService_UpdateProfile(t *testing.T) {
+	service, _, _, _, user := prepareProfileService(t)
+
+	firstName := "Test"
+	updated, err := service.UpdateProfile(user.ID, models.UpdateProfileRequest{FirstName: &firstName})
+	assert.NoError(t, err)
+	assert.Equal(t, "Test", updated.FirstName)
+	assert.Equal(t, "", updated.LastName)
+}
+
+func TestProfileService_ChangeEmail(t *testing.T) {
+	service, mailer, _, userRepo, user := prepareProfileService(t)
+
+	// Test case: the password is required
+	err := service.ChangeEmail(user.ID, models.ChangeEmailRequest{Email: "new@example.com", Password: "wrong"})
+	assert.Equal(t, utils.ErrIncorrectPassword, err)
+
+	// Test case: the old address stays active until the new one is confirmed
+	err = service.ChangeEmail(user.ID, models.ChangeEmailRequest{Email: "new@example.com", Password: "password123"})
+	assert.NoError(t, err)
+	assert.Len(t, mailer.mails, 1)
+	assert.Equal(t, "new@example.com", mailer.mails[0].to)
+
+	pending, _ := userRepo.GetUserByID(user.ID)
+	assert.Equal(t, "test@example.com", pending.Email)
+	assert.Equal(t, "new@example.com", pending.PendingEmail)
+
+	profile, err := service.GetProfile(user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "new@example.com", profile.PendingEmail)
+
+	// Test case: the current address is rejected
+	err = service.ChangeEmail(user.ID, models.ChangeEmailRequest{Email: "test@example.com", Password: "password123"})
+	assert.Equal(t, utils.ErrEmailUnchanged, err)
+}
+
+func TestProfileService_ChangePassword(t *testing.T) {
+	service, _, _, userRepo, user := prepareProfileService(t)
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	oldToken, _ := jwtService.GenerateSessionToken(user.ID, user.SessionVersion)
+
+	_, err := service.ChangePassword(user.ID, models.ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "newpassword1"})
+	assert.Equal(t, utils.ErrIncorrectPassword, err)
+
+	newToken, err := service.ChangePassword(user.ID, models.ChangePasswordRequest{CurrentPassword: "password123", NewPassword: "newpassword1"})
+	assert.NoError(t, err)
+
+	changed, _ := userRepo.GetUserByID(user.ID)
+	assert.True(t, userRepo.VerifyPassword(changed, "newpassword1"))
+
+	// Test case: the old session is signed out, the returned one works
+	app := fiber.New()
+	app.Get("/me", middleware.JWTAuthMiddleware(jwtService, nil, userRepo, utils.GetLogger()), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusUnauthorized, request(oldToken))
+	assert.Equal(t, http.StatusOK, request(newToken))
+}
+
+func TestProfileService_ChangePasswordRevokesAPIKeys(t *testing.T) {
+	db := openProfileDatabase(t)
+	service, _, _, _, user := prepareProfileServiceOn(t, db)
+	apiKeys := services.NewAPIKeyService(repositories.NewAPIKeyRepository(db))
+
+	rawKey, _, err := apiKeys.CreateKey(user.ID, models.APIKeyRequest{Name: "sync", Scopes: []string{models.ScopeRead}})
+	assert.NoError(t, err)
+
+	_, err = service.ChangePassword(user.ID, models.ChangePasswordRequest{CurrentPassword: "password123", NewPassword: "newpassword1"})
+	assert.NoError(t, err)
+
+	_, err = apiKeys.Authenticate(rawKey)
+	assert.Equal(t, utils.ErrInvalidAPIKey, err)
+}
+
+func TestProfileService_DeleteAccount(t *testing.T) {
+	service, _, publisher, userRepo, user := prepareProfileService(t)
+
+	// Test case: nothing is deleted when the event cannot be published
+	publisher.err = errors.New("broker unavailable")
+	assert.Error(t, service.DeleteAccount(user.ID, "password123"))
+	existing, _ := userRepo.GetUserByID(user.ID)
+	assert.NotNil(t, existing)
+
+	publisher.err = nil
+	assert.Equal(t, utils.ErrIncorrectPassword, service.DeleteAccount(user.ID, "wrong"))
+
+	assert.NoError(t, service.DeleteAccount(user.ID, "password123"))
+	assert.Equal(t, []models.UserEvent{{Event: models.UserEventDeleted, UserID: user.ID}}, publisher.events)
+
+	deleted, _ := userRepo.GetUserByID(user.ID)
+	assert.Nil(t, deleted)
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"encoding/json"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+)
+
+// FileStatusQueue carries models.UploadStatus messages from the store.
+const FileStatusQueue = "file-status-queue"
+
+// QuotaService checks uploads against the user's storage quota. Quotas come
+// from the user's plan unless the user has an own quota; users without a
+// known plan get the default quota.
+type QuotaService struct {
+	repo         *repositories.QuotaRepository
+	userRepo     *repositories.UserRepository
+	defaultQuota int64
+	log          *zap.Logger
+}
+
+func NewQuotaService(repo *repositories.QuotaRepository, userRepo *repositories.UserRepository, defaultQuota int64) *QuotaService {
+	return &QuotaService{
+		repo:         repo,
+		userRepo:     userRepo,
+		defaultQuota: defaultQuota,
+		log:          utils.GetLogger(),
+	}
+}
+
+// EnsureDefaultPlan creates the default plan with the default quota unless it
+// already exists.
+func (qs *QuotaService) EnsureDefaultPlan() error {
+	return qs.repo.CreatePlanIfMissing(&models.Plan{Name: models.DefaultPlan, QuotaBytes: qs.defaultQuota})
+}
+
+// QuotaFor returns the user's plan name and quota in bytes.
+func (qs *QuotaService) QuotaFor(user *models.User) (string, int64, error) {
+	planName := user.Plan
+	if planName == "" {
+		planName = models.DefaultPlan
+	}
+	if user.QuotaBytes != nil {
+		return planName, *user.QuotaBytes, nil
+	}
+
+	plan, err := qs.repo.GetPlan(planName)
+	if err != nil {
+		return "", 0, err
+	}
+	if plan == nil {
+		return planName, qs.defaultQuota, nil
+	}
+	return planName, plan.QuotaBytes, nil
+}
+
+// Reserve books size bytes for an upload that is about to be published and
+// returns the quota the store has to confirm.
+func (qs *QuotaService) Reserve(userID uint, size int64) (int64, error) {
+	user, err := qs.userRepo.GetUserByID(userID)
+	if err != nil {
+		return 0, err
+	}
+	if user == nil {
+		return 0, utils.ErrUserNotFound
+	}
+
+	_, quota, err := qs.QuotaFor(user)
+	if err != nil {
+		return 0, err
+	}
+
+	reserved, err := qs.repo.ReservePending(userID, size, quota)
+	if err != nil {
+		return 0, err
+	}
+	if !reserved {
+		qs.log.Info("Upload rejected - quota exceeded",
+			zap.Uint("UserID", userID),
+			zap.Int64("size", size),
+			zap.Int64("quota", quota),
+		)
+		return 0, utils.ErrQuotaExceeded
+	}
+	return quota, nil
+}
+
+// Release gives back a reservation for an upload that was never published.
+func (qs *QuotaService) Release(userID uint, size int64) {
+	if err := qs.repo.ReleasePending(userID, size); err != nil {
+		qs.log.Error("Failed to release reserved storage", zap.Uint("UserID", userID), zap.Error(err))
+	}
+}
+
+// HandleUploadStatus applies a status message from the store.
+func (qs *QuotaService) HandleUploadStatus(body []byte) error {
+	var status models.UploadStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		qs.log.Error("Failed to unmarshal upload status", zap.Error(err))
+		return err
+	}
+	if status.OwnerID == 0 {
+		return nil
+	}
+
+	if status.ScanStatus == models.ScanInfected {
+		qs.log.Warn("Infected upload quarantined by the store",
+			zap.Uint("UserID", status.OwnerID),
+			zap.String("fileName", status.FileName),
+			zap.String("signature", status.Signature),
+		)
+	} else if status.Status == models.UploadStatusRejected {
+		qs.log.Warn("Upload rejected by the store",
+			zap.Uint("UserID", status.OwnerID),
+			zap.String("fileName", status.FileName),
+			zap.String("reason", status.Reason),
+		)
+	}
+	// Reservations for archives are released once the store expanded them.
+	reserved := status.FileSize
+	if status.Archive != "" {
+		reserved = 0
+	}
+	return qs.repo.ApplyStatus(status.OwnerID, reserved, status.UsedBytes, status.FileCount)
+}
+
+func (qs *QuotaService) GetUsage(userID uint) (*models.UsageResponse, error) {
+	user, err := qs.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, utils.ErrUserNotFound
+	}
+
+	planName, quota, err := qs.QuotaFor(user)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := qs.repo.GetUsage(userID)
+	if err != nil {
+		return nil, err
+	}
+	if usage == nil {
+		usage = &models.StorageUsage{UserID: userID}
+	}
+
+	response := &models.UsageResponse{
+		Plan:         planName,
+		QuotaBytes:   quota,
+		UsedBytes:    usage.UsedBytes,
+		PendingBytes: usage.PendingBytes,
+		FileCount:    usage.FileCount,
+	}
+	if quota > 0 {
+		available := quota - usage.UsedBytes - usage.PendingBytes
+		if available < 0 {
+			available = 0
+		}
+		response.AvailableBytes = &available
+	}
+	return response, nil
+}
@@ -0,0 +1,89 @@
+package services_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func prepareQuotaService(t *testing.T) (*services.QuotaService, *repositories.QuotaRepository, *repositories.UserRepository) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Plan{}, &models.StorageUsage{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	quotaRepo := repositories.NewQuotaRepository(db)
+	userRepo := repositories.NewUserRepository(db)
+	service := services.NewQuotaService(quotaRepo, userRepo, 1000)
+	assert.NoError(t, service.EnsureDefaultPlan())
+
+	return service, quotaRepo, userRepo
+}
+
+func TestQuotaService_Reserve(t *testing.T) {
+	service, quotaRepo, userRepo := prepareQuotaService(t)
+
+	user, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com"})
+	assert.NoError(t, err)
+
+	// Test case: reservations count against the quota until confirmed
+	quota, err := service.Reserve(user.ID, 600)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), quota)
+
+	_, err = service.Reserve(user.ID, 500)
+	assert.Equal(t, utils.ErrQuotaExceeded, err)
+
+	// Test case: a stored upload moves from pending to used
+	status, _ := json.Marshal(models.UploadStatus{OwnerID: user.ID, FileSize: 600, Status: models.UploadStatusStored, UsedBytes: 600, FileCount: 1})
+	assert.NoError(t, service.HandleUploadStatus(status))
+
+	usage, err := service.GetUsage(user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), usage.UsedBytes)
+	assert.Equal(t, int64(0), usage.PendingBytes)
+	assert.Equal(t, int64(400), *usage.AvailableBytes)
+	assert.Equal(t, models.DefaultPlan, usage.Plan)
+
+	// Test case: released reservations free the space again
+	_, err = service.Reserve(user.ID, 400)
+	assert.NoError(t, err)
+	service.Release(user.ID, 400)
+	stored, _ := quotaRepo.GetUsage(user.ID)
+	assert.Equal(t, int64(0), stored.PendingBytes)
+}
+
+func TestQuotaService_QuotaFor(t *testing.T) {
+	service, quotaRepo, _ := prepareQuotaService(t)
+
+	assert.NoError(t, quotaRepo.CreatePlanIfMissing(&models.Plan{Name: "pro", QuotaBytes: 5000}))
+
+	_, quota, err := service.QuotaFor(&models.User{Plan: "pro"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5000), quota)
+
+	// Test case: a user quota overrides the plan
+	override := int64(0)
+	_, quota, err = service.QuotaFor(&models.User{Plan: "pro", QuotaBytes: &override})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), quota)
+
+	// Test case: unknown plans fall back to the default quota
+	_, quota, err = service.QuotaFor(&models.User{Plan: "missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), quota)
+}
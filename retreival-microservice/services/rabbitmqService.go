@@ -4,20 +4,56 @@ import (
 	"encoding/json"
 	"retreival/models"
 	"retreival/utils"
+	"sync"
+	"time"
 
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 )
 
+const (
+	// UserEventsQueue carries models.UserEvent messages to the store.
+	UserEventsQueue = "user-events-queue"
+	// FileCommandsQueue carries models.FileCommand requests to the store.
+	FileCommandsQueue = "file-commands-queue"
+	// FileChunksQueue carries models.UploadChunk messages of resumable
+	// uploads to the store.
+	FileChunksQueue = "file-chunks-queue"
+
+	// directReplyTo is RabbitMQ's pseudo queue for replies to this channel.
+	directReplyTo = "amq.rabbitmq.reply-to"
+	rpcTimeout    = 10 * time.Second
+)
+
+// UserEventPublisher announces account changes to other services.
+type UserEventPublisher interface {
+	PublishUserEvent(event *models.UserEvent) error
+}
+
+// UploadChunkPublisher forwards parts of resumable uploads to the store.
+type UploadChunkPublisher interface {
+	PublishUploadChunk(chunk *models.UploadChunk) error
+}
+
 type RabbitMQService struct {
 	conn *amqp.Connection
 	ch   *amqp.Channel
 	log  *zap.Logger
+	rpc  *rpcState
+}
+
+// rpcState is shared by copies of the service so replies reach the caller
+// that is waiting for them.
+type rpcState struct {
+	once    sync.Once
+	err     error
+	mu      sync.Mutex
+	pending map[string]chan []byte
 }
 
 func NewRabbitMQService(conn *amqp.Connection, ch *amqp.Channel) *RabbitMQService {
 	log := utils.GetLogger()
-	return &RabbitMQService{conn, ch, log}
+	return &RabbitMQService{conn, ch, log, &rpcState{pending: make(map[string]chan []byte)}}
 }
 
 func (rmq *RabbitMQService) PublishFileData(fileData *models.FileData, queueName string) error {
@@ -36,6 +72,34 @@ func (rmq *RabbitMQService) PublishFileData(fileData *models.FileData, queueName
 	return nil
 }
 
+func (rmq *RabbitMQService) PublishUserEvent(event *models.UserEvent) error {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		rmq.log.Error("Failed to marshal user event to JSON", zap.Error(err))
+		return err
+	}
+
+	if err := rmq.publishToQueue(eventJSON, UserEventsQueue); err != nil {
+		rmq.log.Error("Failed to publish user event", zap.Error(err), zap.String("event", event.Event))
+		return err
+	}
+	return nil
+}
+
+func (rmq *RabbitMQService) PublishUploadChunk(chunk *models.UploadChunk) error {
+	chunkJSON, err := json.Marshal(chunk)
+	if err != nil {
+		rmq.log.Error("Failed to marshal upload chunk to JSON", zap.Error(err))
+		return err
+	}
+
+	if err := rmq.publishToQueue(chunkJSON, FileChunksQueue); err != nil {
+		rmq.log.Error("Failed to publish upload chunk", zap.Error(err), zap.String("uploadID", chunk.UploadID))
+		return err
+	}
+	return nil
+}
+
 func (rmq *RabbitMQService) publishToQueue(message []byte, queueName string) error {
 	q, err := rmq.ch.QueueDeclare(
 		queueName, // Name of the queue
@@ -68,6 +132,12 @@ func (rmq *RabbitMQService) publishToQueue(message []byte, queueName string) err
 }
 
 func (rmq *RabbitMQService) ConsumeQueue(queueName string) (<-chan amqp.Delivery, error) {
+	// The queue may not exist yet when this service starts before the store.
+	if _, err := rmq.ch.QueueDeclare(queueName, true, false, false, false, nil); err != nil {
+		rmq.log.Error("Failed to declare queue", zap.Error(err), zap.String("QueueName", queueName))
+		return nil, err
+	}
+
 	msgs, err := rmq.ch.Consume(
 		queueName, // queue
 		"",        // consumer
@@ -83,3 +153,77 @@ func (rmq *RabbitMQService) ConsumeQueue(queueName string) (<-chan amqp.Delivery
 	}
 	return msgs, nil
 }
+
+// Call publishes request to queueName and waits for the JSON reply, which is
+// decoded into response.
+func (rmq *RabbitMQService) Call(queueName string, request, response interface{}) error {
+	return rmq.CallTimeout(queueName, request, response, rpcTimeout)
+}
+
+// CallTimeout is Call for requests that may take longer than usual.
+func (rmq *RabbitMQService) CallTimeout(queueName string, request, response interface{}, timeout time.Duration) error {
+	if err := rmq.startReplyConsumer(); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	correlationID, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+
+	replies := make(chan []byte, 1)
+	rmq.rpc.mu.Lock()
+	rmq.rpc.pending[correlationID] = replies
+	rmq.rpc.mu.Unlock()
+	defer func() {
+		rmq.rpc.mu.Lock()
+		delete(rmq.rpc.pending, correlationID)
+		rmq.rpc.mu.Unlock()
+	}()
+
+	err = rmq.ch.Publish("", queueName, false, false, amqp.Publishing{
+		ContentType:   "application/json",
+		CorrelationId: correlationID,
+		ReplyTo:       directReplyTo,
+		Body:          body,
+	})
+	if err != nil {
+		rmq.log.Error("Failed to publish request", zap.Error(err), zap.String("QueueName", queueName))
+		return err
+	}
+
+	select {
+	case reply := <-replies:
+		return json.Unmarshal(reply, response)
+	case <-time.After(timeout):
+		rmq.log.Error("Request timed out", zap.String("QueueName", queueName))
+		return utils.ErrStoreUnavailable
+	}
+}
+
+func (rmq *RabbitMQService) startReplyConsumer() error {
+	rmq.rpc.once.Do(func() {
+		msgs, err := rmq.ch.Consume(directReplyTo, "", true, false, false, false, nil)
+		if err != nil {
+			rmq.log.Error("Failed to consume replies", zap.Error(err))
+			rmq.rpc.err = err
+			return
+		}
+
+		go func() {
+			for msg := range msgs {
+				rmq.rpc.mu.Lock()
+				replies, ok := rmq.rpc.pending[msg.CorrelationId]
+				rmq.rpc.mu.Unlock()
+				if ok {
+					replies <- msg.Body
+				}
+			}
+		}()
+	})
+	return rmq.rpc.err
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	DefaultShareLinkTTL = 7 * 24 * time.Hour
+	MaxShareLinkTTL     = 30 * 24 * time.Hour
+
+	// ShareDownloadGrantTTL is how long the ranges of a counted download
+	// can be requested without counting again.
+	ShareDownloadGrantTTL = time.Hour
+
+	// A link is locked for sharePasswordWindow after sharePasswordAttempts
+	// wrong passwords, so its password cannot be guessed quickly.
+	sharePasswordAttempts = 10
+	sharePasswordWindow   = 15 * time.Minute
+)
+
+// ShareClient describes who opens a share link. Resume requests ask for a
+// range that does not start at the beginning of the file; Grant is the
+// download grant the client got with its counted download.
+type ShareClient struct {
+	IP        string
+	UserAgent string
+	Resume    bool
+	Grant     string
+}
+
+// ShareService mints signed links to single files and checks them when they
+// are opened. The token is a purpose JWT; its jti finds the ShareLink that
+// holds the password, the download limit and the revocation.
+type ShareService struct {
+	repo    *repositories.ShareLinkRepository
+	jwt     *JWTService
+	baseURL string
+	log     *zap.Logger
+	now     func() time.Time
+}
+
+func NewShareService(repo *repositories.ShareLinkRepository, jwt *JWTService, baseURL string) *ShareService {
+	return &ShareService{repo: repo, jwt: jwt, baseURL: baseURL, log: utils.GetLogger(), now: time.Now}
+}
+
+// CreateLink shares one of the user's files and returns the link's URL. The
+// caller checks that the file exists.
+func (ss *ShareService) CreateLink(userID, fileID uint, request models.ShareLinkRequest) (string, *models.ShareLink, error) {
+	now := ss.now()
+	expiresAt := now.Add(DefaultShareLinkTTL)
+	if request.ExpiresAt != nil {
+		expiresAt = *request.ExpiresAt
+	}
+	if !expiresAt.After(now) || expiresAt.Sub(now) > MaxShareLinkTTL {
+		return "", nil, utils.ErrInvalidShareExpiry
+	}
+	if request.MaxDownloads < 0 {
+		return "", nil, utils.ErrInvalidShareLimit
+	}
+
+	token, jti, err := ss.jwt.GeneratePurposeToken(userID, models.TokenPurposeShareLink, expiresAt.Sub(now))
+	if err != nil {
+		return "", nil, err
+	}
+
+	link := &models.ShareLink{
+		UserID:       userID,
+		FileID:       fileID,
+		JTI:          jti,
+		MaxDownloads: request.MaxDownloads,
+		ExpiresAt:    expiresAt,
+	}
+	if request.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", nil, err
+		}
+		link.PasswordHash = string(hash)
+	}
+	if err := ss.repo.CreateLink(link); err != nil {
+		ss.log.Error("Failed to create share link", zap.Uint("UserID", userID), zap.Uint("FileID", fileID), zap.Error(err))
+		return "", nil, err
+	}
+
+	ss.log.Info("Share link created", zap.Uint("UserID", userID), zap.Uint("FileID", fileID), zap.Uint("LinkID", link.ID))
+	return ss.baseURL + "/s/" + token, link, nil
+}
+
+func (ss *ShareService) ListLinks(userID, fileID uint) ([]models.ShareLink, error) {
+	return ss.repo.ListLinks(userID, fileID)
+}
+
+func (ss *ShareService) RevokeLink(userID, fileID, linkID uint) error {
+	revoked, err := ss.repo.RevokeLink(userID, fileID, linkID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return utils.ErrShareLinkNotFound
+	}
+	ss.log.Info("Share link revoked", zap.Uint("UserID", userID), zap.Uint("LinkID", linkID))
+	return nil
+}
+
+// ListAccesses returns the recorded uses of one of the user's links.
+func (ss *ShareService) ListAccesses(userID, fileID, linkID uint) ([]models.ShareLinkAccess, error) {
+	links, err := ss.repo.ListLinks(userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		if link.ID == linkID {
+			return ss.repo.ListAccesses(linkID)
+		}
+	}
+	return nil, utils.ErrShareLinkNotFound
+}
+
+// Open checks a link's token, password and download limit and counts the
+// download. A counted download returns a grant; resume requests that bring a
+// grant of the link are not counted, so a player seeking through a video or
+// a resumed download is one download, also once the limit is reached. Other
+// requests count, whatever range they ask for.
+func (ss *ShareService) Open(token, password string, client ShareClient) (*models.ShareLink, string, error) {
+	_, jti, err := ss.jwt.ValidatePurposeToken(token, models.TokenPurposeShareLink)
+	if err != nil {
+		return nil, "", err
+	}
+	link, err := ss.repo.GetLinkByJTI(jti)
+	if err != nil {
+		return nil, "", err
+	}
+	if link == nil {
+		return nil, "", utils.ErrShareLinkNotFound
+	}
+	if link.RevokedAt != nil {
+		return nil, "", utils.ErrShareLinkRevoked
+	}
+	now := ss.now()
+	if now.After(link.ExpiresAt) {
+		return nil, "", utils.ErrTokenExpired
+	}
+
+	if link.PasswordHash != "" {
+		failed, err := ss.repo.CountAccesses(link.ID, models.ShareAccessWrongPassword, now.Add(-sharePasswordWindow))
+		if err != nil {
+			return nil, "", err
+		}
+		if failed >= sharePasswordAttempts {
+			return nil, "", utils.ErrShareLinkLocked
+		}
+		if password == "" {
+			return nil, "", utils.ErrSharePasswordRequired
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			ss.record(link, models.ShareAccessWrongPassword, client, "")
+			return nil, "", utils.ErrSharePasswordInvalid
+		}
+	}
+
+	// Ranges of unlimited links are not counted, the limit cannot be reached.
+	if client.Resume && link.MaxDownloads == 0 {
+		return link, client.Grant, nil
+	}
+	if client.Resume && client.Grant != "" {
+		granted, err := ss.hasGrant(link, client.Grant)
+		if err != nil {
+			return nil, "", err
+		}
+		if granted {
+			return link, client.Grant, nil
+		}
+	}
+	claimed, err := ss.repo.ClaimDownload(link.ID, now)
+	if err != nil {
+		return nil, "", err
+	}
+	if !claimed {
+		ss.record(link, models.ShareAccessLimitReached, client, "")
+		return nil, "", utils.ErrShareLinkExhausted
+	}
+
+	grant, grantID, err := ss.jwt.GeneratePurposeToken(link.UserID, models.TokenPurposeShareDownload, ShareDownloadGrantTTL)
+	if err != nil {
+		return nil, "", err
+	}
+	ss.record(link, models.ShareAccessDownloaded, client, grantID)
+	return link, grant, nil
+}
+
+// hasGrant reports whether grant is an unexpired grant of a counted download
+// of the link.
+func (ss *ShareService) hasGrant(link *models.ShareLink, grant string) (bool, error) {
+	_, grantID, err := ss.jwt.ValidatePurposeToken(grant, models.TokenPurposeShareDownload)
+	if err != nil {
+		return false, nil
+	}
+	return ss.repo.HasGrant(link.ID, grantID)
+}
+
+func (ss *ShareService) record(link *models.ShareLink, result string, client ShareClient, grantID string) {
+	access := &models.ShareLinkAccess{
+		ShareLinkID: link.ID,
+		Result:      result,
+		IP:          client.IP,
+		UserAgent:   client.UserAgent,
+		GrantID:     grantID,
+		CreatedAt:   ss.now(),
+	}
+	if err := ss.repo.RecordAccess(access); err != nil {
+		ss.log.Warn("Failed to record share link access", zap.Uint("LinkID", link.ID), zap.Error(err))
+	}
+}
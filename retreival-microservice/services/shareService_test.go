@@ -0,0 +1,148 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func prepareShareService(t *testing.T) (*services.ShareService, *repositories.ShareLinkRepository) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.ShareLink{}, &models.ShareLinkAccess{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	repo := repositories.NewShareLinkRepository(db)
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	return services.NewShareService(repo, jwtService, "http://localhost:8080"), repo
+}
+
+func TestShareService_Open(t *testing.T) {
+	service, repo := prepareShareService(t)
+
+	url, link, err := service.CreateLink(1, 7, models.ShareLinkRequest{Password: "secret", MaxDownloads: 2})
+	assert.NoError(t, err)
+	token, ok := strings.CutPrefix(url, "http://localhost:8080/s/")
+	assert.True(t, ok)
+
+	// Test case: the password is required and checked
+	_, _, err = service.Open(token, "", services.ShareClient{})
+	assert.Equal(t, utils.ErrSharePasswordRequired, err)
+	_, _, err = service.Open(token, "wrong", services.ShareClient{IP: "10.0.0.1"})
+	assert.Equal(t, utils.ErrSharePasswordInvalid, err)
+
+	// Test case: downloads are counted up to the limit, also resumed ones
+	// from another IP
+	opened, _, err := service.Open(token, "secret", services.ShareClient{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), opened.FileID)
+	_, _, err = service.Open(token, "secret", services.ShareClient{IP: "10.0.0.2", Resume: true})
+	assert.NoError(t, err)
+	_, _, err = service.Open(token, "secret", services.ShareClient{})
+	assert.Equal(t, utils.ErrShareLinkExhausted, err)
+
+	accesses, err := repo.ListAccesses(link.ID)
+	assert.NoError(t, err)
+	assert.Len(t, accesses, 4)
+
+	// Test case: revoked links cannot be opened
+	assert.NoError(t, service.RevokeLink(1, 7, link.ID))
+	_, _, err = service.Open(token, "secret", services.ShareClient{})
+	assert.Equal(t, utils.ErrShareLinkRevoked, err)
+	assert.Equal(t, utils.ErrShareLinkNotFound, service.RevokeLink(1, 7, link.ID))
+
+	// Test case: invalid tokens are rejected
+	_, _, err = service.Open("not-a-token", "", services.ShareClient{})
+	assert.Equal(t, utils.ErrInvalidToken, err)
+}
+
+func TestShareService_CreateLinkLimits(t *testing.T) {
+	service, _ := prepareShareService(t)
+
+	past := time.Now().Add(-time.Hour)
+	_, _, err := service.CreateLink(1, 7, models.ShareLinkRequest{ExpiresAt: &past})
+	assert.Equal(t, utils.ErrInvalidShareExpiry, err)
+
+	tooFar := time.Now().Add(services.MaxShareLinkTTL + time.Hour)
+	_, _, err = service.CreateLink(1, 7, models.ShareLinkRequest{ExpiresAt: &tooFar})
+	assert.Equal(t, utils.ErrInvalidShareExpiry, err)
+
+	_, _, err = service.CreateLink(1, 7, models.ShareLinkRequest{MaxDownloads: -1})
+	assert.Equal(t, utils.ErrInvalidShareLimit, err)
+
+	// Test case: links without a limit do not count resume requests
+	url, link, err := service.CreateLink(1, 7, models.ShareLinkRequest{})
+	assert.NoError(t, err)
+	token := url[strings.LastIndex(url, "/")+1:]
+	_, _, err = service.Open(token, "", services.ShareClient{Resume: true})
+	assert.NoError(t, err)
+	links, err := service.ListLinks(1, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, link.ID, links[0].ID)
+	assert.Equal(t, 0, links[0].Downloads)
+}
+
+func TestShareService_OpenResumesLimitedLink(t *testing.T) {
+	service, _ := prepareShareService(t)
+
+	url, link, err := service.CreateLink(1, 7, models.ShareLinkRequest{MaxDownloads: 1})
+	assert.NoError(t, err)
+	token := url[strings.LastIndex(url, "/")+1:]
+	player := services.ShareClient{IP: "10.0.0.1", UserAgent: "player"}
+
+	// Test case: the first request counts and the ranges that bring its grant
+	// continue the same download
+	_, grant, err := service.Open(token, "", player)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, grant)
+	for i := 0; i < 5; i++ {
+		_, resumed, err := service.Open(token, "", services.ShareClient{IP: player.IP, UserAgent: player.UserAgent, Resume: true, Grant: grant})
+		assert.NoError(t, err)
+		assert.Equal(t, grant, resumed)
+	}
+	links, err := service.ListLinks(1, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, link.ID, links[0].ID)
+	assert.Equal(t, 1, links[0].Downloads)
+
+	// Test case: starting over is another download
+	_, _, err = service.Open(token, "", player)
+	assert.Equal(t, utils.ErrShareLinkExhausted, err)
+
+	// Test case: ranges without the grant are counted, also from the IP that
+	// downloaded
+	for i := 0; i < 3; i++ {
+		_, _, err = service.Open(token, "", services.ShareClient{IP: player.IP, UserAgent: player.UserAgent, Resume: true})
+		assert.Equal(t, utils.ErrShareLinkExhausted, err)
+	}
+
+	// Test case: grants of other links and other tokens are not accepted
+	otherURL, _, err := service.CreateLink(1, 7, models.ShareLinkRequest{MaxDownloads: 5})
+	assert.NoError(t, err)
+	_, otherGrant, err := service.Open(otherURL[strings.LastIndex(otherURL, "/")+1:], "", player)
+	assert.NoError(t, err)
+	for _, forged := range []string{otherGrant, token, "not-a-token"} {
+		_, _, err = service.Open(token, "", services.ShareClient{IP: player.IP, Resume: true, Grant: forged})
+		assert.Equal(t, utils.ErrShareLinkExhausted, err)
+	}
+
+	// Test case: ranges stop once the link is revoked
+	assert.NoError(t, service.RevokeLink(1, 7, link.ID))
+	_, _, err = service.Open(token, "", services.ShareClient{IP: player.IP, Resume: true, Grant: grant})
+	assert.Equal(t, utils.ErrShareLinkRevoked, err)
+}
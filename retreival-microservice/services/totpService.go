@@ -0,0 +1,378 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+)
+
+const (
+	totpPeriod         = 30
+	totpDigits         = 6
+	totpSkew           = 1 // accepted steps before and after the current one
+	recoveryCodeCount  = 10
+	twoFactorChallenge = 5 * time.Minute
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPService manages RFC 6238 authenticator enrollment and the second login
+// step for users that enabled it.
+type TOTPService struct {
+	userRepo     *repositories.UserRepository
+	recoveryRepo *repositories.RecoveryCodeRepository
+	jwt          *JWTService
+	tokens       *repositories.UserTokenRepository
+	throttle     *LoginThrottleService
+	issuer       string
+	log          *zap.Logger
+	now          func() time.Time
+}
+
+// NewTOTPService creates the service. throttle may be nil to disable limiting
+// of failed codes.
+func NewTOTPService(userRepo *repositories.UserRepository, recoveryRepo *repositories.RecoveryCodeRepository, jwt *JWTService, tokens *repositories.UserTokenRepository, throttle *LoginThrottleService, issuer string) *TOTPService {
+	return &TOTPService{
+		userRepo:     userRepo,
+		recoveryRepo: recoveryRepo,
+		jwt:          jwt,
+		tokens:       tokens,
+		throttle:     throttle,
+		issuer:       issuer,
+		log:          utils.GetLogger(),
+		now:          time.Now,
+	}
+}
+
+// Setup creates a new pending secret for the user and returns it together with
+// an otpauth:// URI that authenticator apps accept as a QR code. The secret
+// only becomes active after Enable confirms a code.
+func (ts *TOTPService) Setup(userID uint) (string, string, error) {
+	user, err := ts.loadUser(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if user.TOTPEnabled {
+		return "", "", utils.ErrTwoFactorAlreadyEnabled
+	}
+
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret := base32NoPadding.EncodeToString(raw)
+
+	user.TOTPPendingSecret = secret
+	if err := ts.userRepo.UpdateUser(user); err != nil {
+		return "", "", err
+	}
+
+	return secret, ts.provisioningURI(user, secret), nil
+}
+
+// Enable activates the pending secret when code matches it and returns fresh
+// recovery codes. The codes are shown once and only stored hashed.
+func (ts *TOTPService) Enable(userID uint, code string) ([]string, error) {
+	user, err := ts.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, utils.ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, utils.ErrTwoFactorNotSetUp
+	}
+
+	step, ok := ts.matchCode(user.TOTPPendingSecret, code, 0)
+	if !ok {
+		return nil, utils.ErrInvalidTwoFactorCode
+	}
+
+	user.TOTPSecret = user.TOTPPendingSecret
+	user.TOTPPendingSecret = ""
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	if err := ts.userRepo.UpdateUser(user); err != nil {
+		return nil, err
+	}
+
+	codes, err := ts.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ts.log.Info("Two-factor authentication enabled", zap.Uint("UserID", user.ID))
+	return codes, nil
+}
+
+// Disable turns 2FA off. Both the password and a current code (or a recovery
+// code) are required.
+func (ts *TOTPService) Disable(userID uint, password, code string) error {
+	user, err := ts.loadUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return utils.ErrTwoFactorNotEnabled
+	}
+	if !ts.userRepo.VerifyPassword(user, password) {
+		return utils.ErrIncorrectPassword
+	}
+	if ok, err := ts.verify(user, code); err != nil {
+		return err
+	} else if !ok {
+		return utils.ErrInvalidTwoFactorCode
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPPendingSecret = ""
+	user.TOTPLastStep = 0
+	if err := ts.userRepo.UpdateUser(user); err != nil {
+		return err
+	}
+
+	ts.log.Info("Two-factor authentication disabled", zap.Uint("UserID", user.ID))
+	return ts.recoveryRepo.DeleteCodes(user.ID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a current
+// authenticator code.
+func (ts *TOTPService) RegenerateRecoveryCodes(userID uint, code string) ([]string, error) {
+	user, err := ts.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, utils.ErrTwoFactorNotEnabled
+	}
+
+	step, ok := ts.matchCode(user.TOTPSecret, code, user.TOTPLastStep)
+	if !ok {
+		return nil, utils.ErrInvalidTwoFactorCode
+	}
+	if used, err := ts.userRepo.UseTOTPStep(user.ID, step); err != nil {
+		return nil, err
+	} else if !used {
+		return nil, utils.ErrInvalidTwoFactorCode
+	}
+
+	return ts.newRecoveryCodes(user.ID)
+}
+
+// issueTwoFactorChallenge starts the second login step of a user. The
+// challenge is recorded so CompleteLogin accepts it only once.
+func issueTwoFactorChallenge(jwt *JWTService, tokens *repositories.UserTokenRepository, userID uint) (*LoginResult, error) {
+	challenge, jti, err := jwt.GeneratePurposeToken(userID, models.TokenPurposeTwoFactor, twoFactorChallenge)
+	if err != nil {
+		return nil, utils.ErrInGenerateToken
+	}
+	record := &models.UserToken{
+		UserID:    userID,
+		Purpose:   models.TokenPurposeTwoFactor,
+		JTI:       jti,
+		ExpiresAt: time.Now().Add(twoFactorChallenge),
+	}
+	if err := tokens.CreateToken(record); err != nil {
+		return nil, err
+	}
+	return &LoginResult{TwoFactorRequired: true, ChallengeToken: challenge}, nil
+}
+
+// CompleteLogin exchanges a challenge token and an authenticator or recovery
+// code for a regular access token. A challenge is redeemed once the code
+// matched, so a mistyped code can be corrected but the challenge is not
+// accepted again.
+func (ts *TOTPService) CompleteLogin(challenge, code, ip string) (string, error) {
+	record, err := findUserToken(ts.jwt, ts.tokens, challenge, models.TokenPurposeTwoFactor)
+	if err != nil {
+		return "", err
+	}
+	userID := record.UserID
+
+	if ts.throttle != nil {
+		wait, err := ts.throttle.CheckTwoFactor(userID, ip)
+		if err != nil {
+			return "", err
+		}
+		if wait > 0 {
+			return "", &utils.LockoutError{RetryAfter: wait}
+		}
+	}
+
+	user, err := ts.loadUser(userID)
+	if err != nil {
+		return "", err
+	}
+	if !user.TOTPEnabled {
+		return "", utils.ErrInvalidToken
+	}
+
+	ok, err := ts.verify(user, code)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		if ts.throttle != nil {
+			if err := ts.throttle.RecordTwoFactorFailure(userID, ip); err != nil {
+				ts.log.Error("Failed to record failed 2FA code", zap.Error(err))
+			}
+		}
+		return "", utils.ErrInvalidTwoFactorCode
+	}
+
+	if err := ts.tokens.MarkUsed(record); err != nil {
+		return "", err
+	}
+	if ts.throttle != nil {
+		if err := ts.throttle.ResetTwoFactor(userID); err != nil {
+			ts.log.Warn("Failed to reset 2FA throttle", zap.Error(err))
+		}
+	}
+
+	token, err := ts.jwt.GenerateSessionToken(user.ID, user.SessionVersion)
+	if err != nil {
+		return "", utils.ErrInGenerateToken
+	}
+	return token, nil
+}
+
+// verify accepts either a TOTP code newer than the last one used or an unused
+// recovery code.
+func (ts *TOTPService) verify(user *models.User, code string) (bool, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+
+	// Two requests with the same code can both match it; only one of them
+	// uses the step.
+	if step, ok := ts.matchCode(user.TOTPSecret, code, user.TOTPLastStep); ok {
+		return ts.userRepo.UseTOTPStep(user.ID, step)
+	}
+
+	if len(code) == totpDigits {
+		return false, nil
+	}
+	used, err := ts.recoveryRepo.UseCode(user.ID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	if used {
+		ts.log.Info("Recovery code used", zap.Uint("UserID", user.ID))
+	}
+	return used, nil
+}
+
+// matchCode checks code against the steps around now and returns the matching
+// step. Steps at or before lastStep are rejected so a code cannot be replayed.
+func (ts *TOTPService) matchCode(secret, code string, lastStep int64) (int64, bool) {
+	if len(code) != totpDigits || secret == "" {
+		return 0, false
+	}
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := ts.now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func (ts *TOTPService) newRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(base32NoPadding.EncodeToString(raw))
+		codes[i] = encoded[:4] + "-" + encoded[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := ts.recoveryRepo.ReplaceCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (ts *TOTPService) provisioningURI(user *models.User, secret string) string {
+	account := user.Email
+	if account == "" {
+		account = user.Username
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", ts.issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(ts.issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func (ts *TOTPService) loadUser(userID uint) (*models.User, error) {
+	user, err := ts.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, utils.ErrUserNotFound
+	}
+	return user, nil
+}
+
+// totpCode computes the RFC 6238 code (HMAC-SHA1, dynamic truncation) for a
+// time step.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// TOTPCode returns the current code for a base32 secret. It is used by tests
+// and tooling that need to act as an authenticator.
+func TOTPCode(secret string, at time.Time) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, at.Unix()/totpPeriod), nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,142 @@
+package services_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	// Base32 of the RFC 6238 SHA1 seed "12345678901234567890".
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	code, err := services.TOTPCode(secret, time.Unix(59, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "287082", code)
+
+	code, err = services.TOTPCode(secret, time.Unix(1111111109, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "081804", code)
+}
+
+func TestTOTPService_TwoStepLogin(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.RecoveryCode{}, &models.UserToken{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	userRepo := repositories.NewUserRepository(db)
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	throttle := services.NewLoginThrottleService(repositories.NewInMemoryLoginAttemptStore(), services.DefaultLockoutPolicy())
+	totpService := services.NewTOTPService(userRepo, repositories.NewRecoveryCodeRepository(db), jwtService, repositories.NewUserTokenRepository(db), throttle, "mani-task")
+	userService := services.NewUserService(*userRepo, utils.GetLogger(), *jwtService, throttle, repositories.NewUserTokenRepository(db))
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("testpassword"), bcrypt.DefaultCost)
+	user, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com", Password: string(hashedPassword)})
+	assert.NoError(t, err)
+
+	// Test case: enroll an authenticator
+	secret, uri, err := totpService.Setup(user.ID)
+	assert.NoError(t, err)
+	assert.Contains(t, uri, "otpauth://totp/mani-task:test@example.com?")
+	assert.Contains(t, uri, "secret="+secret)
+
+	_, err = totpService.Enable(user.ID, "000000")
+	assert.Equal(t, utils.ErrInvalidTwoFactorCode, err)
+
+	code, _ := services.TOTPCode(secret, time.Now())
+	recoveryCodes, err := totpService.Enable(user.ID, code)
+	assert.NoError(t, err)
+	assert.Len(t, recoveryCodes, 10)
+
+	// Test case: password login now only yields a challenge
+	result, err := userService.Login("testuser", "testpassword", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, result.TwoFactorRequired)
+	assert.Empty(t, result.Token)
+
+	// The challenge is not an access token.
+	_, err = jwtService.ValidateToken(result.ChallengeToken)
+	assert.Error(t, err)
+
+	// Test case: a code that was already used cannot be replayed
+	_, err = totpService.CompleteLogin(result.ChallengeToken, code, "127.0.0.1")
+	assert.Equal(t, utils.ErrInvalidTwoFactorCode, err)
+
+	// Test case: recovery codes work once
+	token, err := totpService.CompleteLogin(result.ChallengeToken, recoveryCodes[0], "127.0.0.1")
+	assert.NoError(t, err)
+	_, err = jwtService.ValidateToken(token)
+	assert.NoError(t, err)
+
+	// Test case: a challenge is only accepted once
+	_, err = totpService.CompleteLogin(result.ChallengeToken, recoveryCodes[1], "127.0.0.1")
+	assert.Equal(t, utils.ErrTokenAlreadyUsed, err)
+
+	result, err = userService.Login("testuser", "testpassword", "127.0.0.1")
+	assert.NoError(t, err)
+	_, err = totpService.CompleteLogin(result.ChallengeToken, recoveryCodes[0], "127.0.0.1")
+	assert.Equal(t, utils.ErrInvalidTwoFactorCode, err)
+	_, err = totpService.CompleteLogin(result.ChallengeToken, recoveryCodes[1], "127.0.0.1")
+	assert.NoError(t, err)
+}
+
+func TestTOTPService_FailedLoginsDoNotLockSecondStep(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.RecoveryCode{}, &models.UserToken{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	userRepo := repositories.NewUserRepository(db)
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	throttle := services.NewLoginThrottleService(repositories.NewInMemoryLoginAttemptStore(), services.DefaultLockoutPolicy())
+	totpService := services.NewTOTPService(userRepo, repositories.NewRecoveryCodeRepository(db), jwtService, repositories.NewUserTokenRepository(db), throttle, "mani-task")
+	userService := services.NewUserService(*userRepo, utils.GetLogger(), *jwtService, throttle, repositories.NewUserTokenRepository(db))
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("testpassword"), bcrypt.DefaultCost)
+	user, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com", Password: string(hashedPassword)})
+	assert.NoError(t, err)
+	secret, _, err := totpService.Setup(user.ID)
+	assert.NoError(t, err)
+	code, _ := services.TOTPCode(secret, time.Now())
+	recoveryCodes, err := totpService.Enable(user.ID, code)
+	assert.NoError(t, err)
+
+	result, err := userService.Login("testuser", "testpassword", "127.0.0.1")
+	assert.NoError(t, err)
+
+	// Test case: failed logins with an identifier that looks like the second
+	// step's counter do not lock the user's second step
+	identifier := fmt.Sprintf("2fa:%d", user.ID)
+	for i := 0; i < services.DefaultLockoutPolicy().AccountMaxAttempts+1; i++ {
+		_, err = userService.Login(identifier, "wrong", "")
+		assert.Error(t, err)
+	}
+	_, err = userService.Login(identifier, "wrong", "")
+	assert.IsType(t, &utils.LockoutError{}, err)
+
+	token, err := totpService.CompleteLogin(result.ChallengeToken, recoveryCodes[0], "127.0.0.1")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+}
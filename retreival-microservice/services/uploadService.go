@@ -0,0 +1,323 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultUploadChunkSize is the most bytes sent to the store per message.
+	DefaultUploadChunkSize = 4 << 20
+	// DefaultUploadExpiry is how long an unfinished upload is kept.
+	DefaultUploadExpiry = 24 * time.Hour
+)
+
+// UploadService runs resumable uploads following the tus 1.0 core protocol.
+// Bytes are forwarded to the store as they arrive; the store saves the file
+// once all of them were received.
+type UploadService struct {
+	repo      *repositories.UploadSessionRepository
+	publisher UploadChunkPublisher
+	fileTypes *FileTypeService
+	quota     *QuotaService
+	fileLimit int64
+	chunkSize int
+	expiry    time.Duration
+	log       *zap.Logger
+	now       func() time.Time
+}
+
+// NewUploadService creates the service. quota may be nil to accept uploads
+// without checking storage quotas and fileTypes nil to accept every type.
+func NewUploadService(repo *repositories.UploadSessionRepository, publisher UploadChunkPublisher, fileTypes *FileTypeService, quota *QuotaService, fileLimit int64, expiry time.Duration) *UploadService {
+	if fileTypes == nil {
+		fileTypes = NewFileTypeService(FileTypePolicy{})
+	}
+	if expiry <= 0 {
+		expiry = DefaultUploadExpiry
+	}
+	return &UploadService{
+		repo:      repo,
+		publisher: publisher,
+		fileTypes: fileTypes,
+		quota:     quota,
+		fileLimit: fileLimit,
+		chunkSize: DefaultUploadChunkSize,
+		expiry:    expiry,
+		log:       utils.GetLogger(),
+		now:       time.Now,
+	}
+}
+
+// MaxSize is the largest upload accepted, 0 if there is no limit.
+func (us *UploadService) MaxSize() int64 {
+	return us.fileLimit
+}
+
+// ParseUploadMetadata decodes a tus Upload-Metadata header: comma separated
+// pairs of a key and a base64 encoded value.
+func ParseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || key == "" {
+			return nil, utils.ErrInvalidUploadMetadata
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// Create starts an upload of length bytes. The metadata needs a "filename"
+// and may have a "filetype", comma separated "tags", a "folder_id" and
+// "meta.<key>" custom metadata.
+func (us *UploadService) Create(userID uint, length int64, metadata map[string]string) (*models.UploadSession, error) {
+	fileName := metadata["filename"]
+	if fileName == "" || length < 0 {
+		return nil, utils.ErrInvalidUploadMetadata
+	}
+	if us.fileLimit > 0 && length > us.fileLimit {
+		return nil, utils.ErrFileSizeExceedsLimit
+	}
+
+	tags := models.NormalizeTags(strings.Split(metadata["tags"], ","))
+	for _, tag := range tags {
+		if len(tag) > models.MaxTagLength {
+			return nil, utils.ErrTagTooLong
+		}
+	}
+	custom := map[string]string{}
+	for name, value := range metadata {
+		if key, ok := strings.CutPrefix(name, "meta."); ok {
+			custom[key] = value
+		}
+	}
+	if err := models.ValidateMetadata(custom); err != nil {
+		return nil, utils.ErrInvalidMetadata
+	}
+	customJSON, _ := json.Marshal(custom)
+	folderID, err := ParseFolderID(metadata["folder_id"])
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	session := &models.UploadSession{
+		ID:           id,
+		UserID:       userID,
+		FileName:     fileName,
+		DeclaredType: metadata["filetype"],
+		Tags:         strings.Join(tags, ","),
+		Metadata:     string(customJSON),
+		FolderID:     folderID,
+		Length:       length,
+		ExpiresAt:    us.now().Add(us.expiry),
+	}
+
+	if us.quota != nil {
+		session.QuotaBytes, err = us.quota.Reserve(userID, length)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := us.repo.CreateSession(session); err != nil {
+		us.releaseQuota(session)
+		return nil, err
+	}
+
+	if length == 0 {
+		if err := us.finish(session); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// Get returns one of the user's unexpired uploads.
+func (us *UploadService) Get(userID uint, id string) (*models.UploadSession, error) {
+	session, err := us.repo.GetSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.UserID != userID || !session.ExpiresAt.After(us.now()) {
+		return nil, utils.ErrUploadNotFound
+	}
+	return session, nil
+}
+
+// Append forwards the bytes read from body, which continue the upload at
+// offset. Bytes forwarded before an error count; the client resumes after
+// them. The upload is finished when all its bytes arrived.
+func (us *UploadService) Append(userID uint, id string, offset int64, body io.Reader) (*models.UploadSession, error) {
+	session, err := us.Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != session.Offset {
+		return session, utils.ErrUploadOffsetMismatch
+	}
+
+	buf := make([]byte, us.chunkSize)
+	for session.Offset < session.Length {
+		n, readErr := io.ReadFull(body, buf)
+		if n > 0 {
+			if err := us.forward(session, buf[:n]); err != nil {
+				return session, err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			us.log.Info("Upload interrupted", zap.String("uploadID", id), zap.Int64("offset", session.Offset), zap.Error(readErr))
+			return session, nil
+		}
+	}
+	// Anything after the declared length is more than was announced.
+	if session.Offset == session.Length {
+		if n, _ := body.Read(buf[:1]); n > 0 {
+			return session, utils.ErrUploadTooLarge
+		}
+		return session, us.finish(session)
+	}
+	return session, nil
+}
+
+// forward sends one chunk to the store and moves the offset past it. The
+// type is detected and checked on the first chunk.
+func (us *UploadService) forward(session *models.UploadSession, chunk []byte) error {
+	if session.Offset+int64(len(chunk)) > session.Length {
+		return utils.ErrUploadTooLarge
+	}
+
+	if session.Offset == 0 {
+		fileType := us.fileTypes.Detect(session.FileName, chunk)
+		if err := us.fileTypes.Check(session.DeclaredType, fileType); err != nil {
+			_ = us.Terminate(session.UserID, session.ID)
+			return err
+		}
+		if err := us.repo.SetFileType(session.ID, fileType); err != nil {
+			return err
+		}
+		session.FileType = fileType
+	}
+
+	next := session.Offset + int64(len(chunk))
+	advanced, err := us.repo.AdvanceOffset(session.ID, session.Offset, next)
+	if err != nil {
+		return err
+	}
+	if !advanced {
+		return utils.ErrUploadOffsetMismatch
+	}
+
+	err = us.publisher.PublishUploadChunk(&models.UploadChunk{UploadID: session.ID, Offset: session.Offset, Bytes: chunk})
+	if err != nil {
+		_, _ = us.repo.AdvanceOffset(session.ID, next, session.Offset)
+		return err
+	}
+	session.Offset = next
+	return nil
+}
+
+// finish tells the store to save the upload and forgets the session. The
+// quota reservation is settled by the store's upload status.
+func (us *UploadService) finish(session *models.UploadSession) error {
+	var tags []string
+	if session.Tags != "" {
+		tags = strings.Split(session.Tags, ",")
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(session.Metadata), &metadata); err != nil {
+		metadata = nil
+	}
+	fileType := session.FileType
+	if fileType == "" {
+		fileType = us.fileTypes.Detect(session.FileName, nil)
+	}
+
+	err := us.publisher.PublishUploadChunk(&models.UploadChunk{
+		UploadID: session.ID,
+		Offset:   session.Length,
+		Final:    true,
+		File: &models.FileData{
+			FileName:     session.FileName,
+			FileType:     fileType,
+			DeclaredType: session.DeclaredType,
+			FileSize:     session.Length,
+			FileTags:     tags,
+			TagName:      tags,
+			Type:         session.DeclaredType,
+			OwnerID:      session.UserID,
+			QuotaBytes:   session.QuotaBytes,
+			FolderID:     session.FolderID,
+			Metadata:     metadata,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	us.log.Info("Upload finished", zap.String("uploadID", session.ID), zap.Int64("length", session.Length))
+	return us.repo.DeleteSession(session.ID)
+}
+
+// Terminate drops one of the user's uploads and what the store received.
+func (us *UploadService) Terminate(userID uint, id string) error {
+	session, err := us.Get(userID, id)
+	if err != nil {
+		return err
+	}
+	return us.drop(session)
+}
+
+// ExpireSessions drops the uploads that were not finished in time.
+func (us *UploadService) ExpireSessions() error {
+	sessions, err := us.repo.ListExpired(us.now())
+	if err != nil {
+		us.log.Error("Failed to list expired uploads", zap.Error(err))
+		return err
+	}
+
+	var errs []error
+	for i := range sessions {
+		errs = append(errs, us.drop(&sessions[i]))
+	}
+	return errors.Join(errs...)
+}
+
+func (us *UploadService) drop(session *models.UploadSession) error {
+	if session.Offset > 0 {
+		if err := us.publisher.PublishUploadChunk(&models.UploadChunk{UploadID: session.ID, Abort: true}); err != nil {
+			return err
+		}
+	}
+	if err := us.repo.DeleteSession(session.ID); err != nil {
+		return err
+	}
+	us.releaseQuota(session)
+	return nil
+}
+
+func (us *UploadService) releaseQuota(session *models.UploadSession) {
+	if us.quota != nil {
+		us.quota.Release(session.UserID, session.Length)
+	}
+}
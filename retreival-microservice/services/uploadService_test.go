@@ -0,0 +1,151 @@
+package services_test
+
+import (
+	"bytes"
+	"testing"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type recordingChunkPublisher struct {
+	chunks []models.UploadChunk
+}
+
+func (rp *recordingChunkPublisher) PublishUploadChunk(chunk *models.UploadChunk) error {
+	rp.chunks = append(rp.chunks, *chunk)
+	return nil
+}
+
+func prepareUploadService(t *testing.T, policy services.FileTypePolicy) (*services.UploadService, *recordingChunkPublisher, *repositories.UploadSessionRepository) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.UploadSession{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	repo := repositories.NewUploadSessionRepository(db)
+	publisher := &recordingChunkPublisher{}
+	service := services.NewUploadService(repo, publisher, services.NewFileTypeService(policy), nil, 1000, 0)
+	return service, publisher, repo
+}
+
+func TestUploadService_Append(t *testing.T) {
+	service, publisher, repo := prepareUploadService(t, services.FileTypePolicy{})
+
+	session, err := service.Create(1, 11, map[string]string{"filename": "notes.txt", "tags": " Work,notes ", "meta.project": "alpha"})
+	assert.NoError(t, err)
+
+	// Test case: bytes are forwarded as they arrive
+	session, err = service.Append(1, session.ID, 0, bytes.NewReader([]byte("hello ")))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), session.Offset)
+	assert.Len(t, publisher.chunks, 1)
+	assert.Equal(t, "text/plain", session.FileType)
+
+	// Test case: a wrong offset is a conflict
+	_, err = service.Append(1, session.ID, 3, bytes.NewReader([]byte("world")))
+	assert.Equal(t, utils.ErrUploadOffsetMismatch, err)
+
+	// Test case: other users cannot see the upload
+	_, err = service.Get(2, session.ID)
+	assert.Equal(t, utils.ErrUploadNotFound, err)
+
+	// Test case: the last bytes finish the upload
+	session, err = service.Append(1, session.ID, 6, bytes.NewReader([]byte("world")))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), session.Offset)
+	assert.Len(t, publisher.chunks, 3)
+
+	final := publisher.chunks[2]
+	assert.True(t, final.Final)
+	assert.Equal(t, "notes.txt", final.File.FileName)
+	assert.Equal(t, int64(11), final.File.FileSize)
+	assert.Equal(t, []string{"work", "notes"}, final.File.FileTags)
+	assert.Equal(t, map[string]string{"project": "alpha"}, final.File.Metadata)
+
+	stored, err := repo.GetSession(session.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+}
+
+func TestUploadService_Folder(t *testing.T) {
+	service, publisher, _ := prepareUploadService(t, services.FileTypePolicy{})
+
+	// Test case: the folder goes to the store with the file
+	session, err := service.Create(1, 2, map[string]string{"filename": "a.txt", "folder_id": "7"})
+	assert.NoError(t, err)
+	_, err = service.Append(1, session.ID, 0, bytes.NewReader([]byte("hi")))
+	assert.NoError(t, err)
+	final := publisher.chunks[len(publisher.chunks)-1]
+	assert.True(t, final.Final)
+	if assert.NotNil(t, final.File.FolderID) {
+		assert.Equal(t, uint(7), *final.File.FolderID)
+	}
+
+	// Test case: without one the file is saved in the root
+	session, err = service.Create(1, 2, map[string]string{"filename": "b.txt"})
+	assert.NoError(t, err)
+	_, err = service.Append(1, session.ID, 0, bytes.NewReader([]byte("hi")))
+	assert.NoError(t, err)
+	assert.Nil(t, publisher.chunks[len(publisher.chunks)-1].File.FolderID)
+
+	for _, folderID := range []string{"0", "-1", "docs"} {
+		_, err = service.Create(1, 2, map[string]string{"filename": "c.txt", "folder_id": folderID})
+		assert.Equal(t, utils.ErrInvalidFolderID, err, folderID)
+	}
+}
+
+func TestUploadService_Limits(t *testing.T) {
+	service, publisher, _ := prepareUploadService(t, services.FileTypePolicy{Denied: []string{"application/pdf"}})
+
+	// Test case: uploads over the file limit are refused up front
+	_, err := service.Create(1, 1001, map[string]string{"filename": "big.bin"})
+	assert.Equal(t, utils.ErrFileSizeExceedsLimit, err)
+
+	_, err = service.Create(1, 10, map[string]string{})
+	assert.Equal(t, utils.ErrInvalidUploadMetadata, err)
+
+	// Test case: more bytes than announced are refused
+	session, err := service.Create(1, 3, map[string]string{"filename": "a.txt"})
+	assert.NoError(t, err)
+	_, err = service.Append(1, session.ID, 0, bytes.NewReader([]byte("abcd")))
+	assert.Equal(t, utils.ErrUploadTooLarge, err)
+
+	// Test case: the type policy is checked on the first bytes
+	session, err = service.Create(1, 20, map[string]string{"filename": "doc.pdf"})
+	assert.NoError(t, err)
+	_, err = service.Append(1, session.ID, 0, bytes.NewReader([]byte("%PDF-1.4 not really")))
+	assert.Equal(t, utils.ErrFileTypeNotAllowed, err)
+	_, err = service.Get(1, session.ID)
+	assert.Equal(t, utils.ErrUploadNotFound, err)
+	assert.Empty(t, publisher.chunks)
+
+	// Test case: terminating tells the store to drop the received bytes
+	session, err = service.Create(1, 10, map[string]string{"filename": "b.txt"})
+	assert.NoError(t, err)
+	_, err = service.Append(1, session.ID, 0, bytes.NewReader([]byte("abc")))
+	assert.NoError(t, err)
+	assert.NoError(t, service.Terminate(1, session.ID))
+	assert.True(t, publisher.chunks[len(publisher.chunks)-1].Abort)
+}
+
+func TestParseUploadMetadata(t *testing.T) {
+	metadata, err := services.ParseUploadMetadata("filename cmVwb3J0LnBkZg==,is_confidential, tags aW52b2ljZQ==")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"filename": "report.pdf", "is_confidential": "", "tags": "invoice"}, metadata)
+
+	_, err = services.ParseUploadMetadata("filename not-base64!")
+	assert.Equal(t, utils.ErrInvalidUploadMetadata, err)
+}
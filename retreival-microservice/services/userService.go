@@ -1,6 +1,8 @@
 package services
 
 import (
+	"sync"
+
 	"retreival/models"
 	"retreival/repositories"
 	"retreival/utils"
@@ -13,16 +15,36 @@ type UserService struct {
 	userRepo repositories.UserRepository
 	log      *zap.Logger
 	jwt      JWTService
+	throttle *LoginThrottleService
+	tokens   *repositories.UserTokenRepository
 }
 
-func NewUserService(repo repositories.UserRepository, log *zap.Logger, jwt JWTService) *UserService {
+// NewUserService creates the user service. throttle may be nil to disable
+// login throttling. tokens records the 2FA challenges.
+func NewUserService(repo repositories.UserRepository, log *zap.Logger, jwt JWTService, throttle *LoginThrottleService, tokens *repositories.UserTokenRepository) *UserService {
 	return &UserService{
 		userRepo: repo,
 		log:      log,
 		jwt:      jwt,
+		throttle: throttle,
+		tokens:   tokens,
 	}
 }
 
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// compareDummyHash spends the same bcrypt work as a real password check so
+// unknown identifiers cannot be told apart by response time.
+func compareDummyHash(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
 func (us *UserService) RegisterUser(user models.User) (*models.User, string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -40,7 +62,7 @@ func (us *UserService) RegisterUser(user models.User) (*models.User, string, err
 		zap.Uint("UserID", newUser.ID),
 		zap.String("Username", newUser.Username),
 	)
-	token, err := us.jwt.GenerateToken(user.ID)
+	token, err := us.jwt.GenerateSessionToken(newUser.ID, newUser.SessionVersion)
 	if err != nil {
 		return nil, "", utils.ErrInGenerateToken
 	}
@@ -48,20 +70,75 @@ func (us *UserService) RegisterUser(user models.User) (*models.User, string, err
 	return newUser, token, nil
 }
 
-func (us *UserService) Login(identifier, password string) (string, error) {
+// LoginResult carries either the access token or, for users with 2FA enabled,
+// the challenge token that has to be exchanged at /user/login/2fa.
+type LoginResult struct {
+	Token             string
+	TwoFactorRequired bool
+	ChallengeToken    string
+}
+
+// Login checks the credentials. Unknown users and wrong passwords both fail
+// with ErrInvalidCredentials; repeated failures for the account or ip lead
+// to a *utils.LockoutError.
+func (us *UserService) Login(identifier, password, ip string) (*LoginResult, error) {
 	user, err := us.userRepo.GetUserByEmailOrUsername(identifier)
-	if err != nil || user == nil {
-		return "", utils.ErrUserNotFound
+	if err != nil {
+		return nil, err
+	}
+
+	// Unknown identifiers share the throttle's counter for user 0.
+	var userID uint
+	if user != nil {
+		userID = user.ID
+	}
+	if us.throttle != nil {
+		wait, err := us.throttle.Check(userID, ip)
+		if err != nil {
+			us.log.Error("Failed to check login throttle", zap.Error(err))
+			return nil, err
+		}
+		if wait > 0 {
+			return nil, &utils.LockoutError{RetryAfter: wait}
+		}
+	}
+
+	if user == nil {
+		compareDummyHash(password)
+		return nil, us.loginFailed(userID, ip)
 	}
 
 	if !us.userRepo.VerifyPassword(user, password) {
-		return "", utils.ErrIncorrectPassword
+		return nil, us.loginFailed(userID, ip)
+	}
+
+	if us.throttle != nil {
+		if err := us.throttle.Reset(userID); err != nil {
+			us.log.Warn("Failed to reset login throttle", zap.Error(err))
+		}
+	}
+
+	if user.TOTPEnabled {
+		return issueTwoFactorChallenge(&us.jwt, us.tokens, user.ID)
 	}
 
-	token, err := us.jwt.GenerateToken(user.ID)
+	token, err := us.jwt.GenerateSessionToken(user.ID, user.SessionVersion)
 	if err != nil {
-		return "", utils.ErrInGenerateToken
+		return nil, utils.ErrInGenerateToken
 	}
 
-	return token, nil
+	return &LoginResult{Token: token}, nil
+}
+
+func (us *UserService) loginFailed(userID uint, ip string) error {
+	if us.throttle != nil {
+		if err := us.throttle.RecordFailure(userID, ip); err != nil {
+			us.log.Error("Failed to record failed login", zap.Error(err))
+		}
+	}
+	us.log.Info("Login failed",
+		zap.String("reason", "invalid_credentials"),
+		zap.String("ip", ip),
+	)
+	return utils.ErrInvalidCredentials
 }
@@ -1,7 +1,9 @@
 package services_test
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
@@ -27,7 +29,7 @@ func prepareUserService() (*services.UserService, *gorm.DB) {
 
 	logger := utils.GetLogger()
 
-	userService := services.NewUserService(*userRepo, logger, *jwtService)
+	userService := services.NewUserService(*userRepo, logger, *jwtService, nil, repositories.NewUserTokenRepository(db))
 
 	return userService, db
 }
@@ -60,7 +62,68 @@ func TestUserService_Login(t *testing.T) {
 	}
 	_ = db.Create(&testUser)
 
-	token, err := userService.Login("testuser", password)
+	result, err := userService.Login("testuser", password, "127.0.0.1")
 	assert.NoError(t, err)
-	assert.NotEmpty(t, token)
+	assert.NotEmpty(t, result.Token)
+	assert.False(t, result.TwoFactorRequired)
+}
+
+func TestUserService_LoginInvalidCredentials(t *testing.T) {
+	userService, db := prepareUserService()
+	defer db.Migrator().DropTable(&models.User{})
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("testpassword"), bcrypt.DefaultCost)
+	_ = db.Create(&models.User{Username: "testuser", Password: string(hashedPassword)})
+
+	_, err := userService.Login("testuser", "wrongpassword", "127.0.0.1")
+	assert.Equal(t, utils.ErrInvalidCredentials, err)
+
+	_, err = userService.Login("nobody", "testpassword", "127.0.0.1")
+	assert.Equal(t, utils.ErrInvalidCredentials, err)
+}
+
+func TestUserService_LoginThrottlePerAccount(t *testing.T) {
+	db, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	_ = db.AutoMigrate(&models.User{})
+	userRepo := repositories.NewUserRepository(db)
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	store := repositories.NewInMemoryLoginAttemptStore()
+	throttle := services.NewLoginThrottleService(store, services.LockoutPolicy{
+		AccountMaxAttempts: 4,
+		IPMaxAttempts:      100,
+		LockoutDuration:    time.Minute,
+		DelayAfter:         10,
+		Window:             time.Minute,
+	})
+	userService := services.NewUserService(*userRepo, utils.GetLogger(), *jwtService, throttle, repositories.NewUserTokenRepository(db))
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("testpassword"), bcrypt.DefaultCost)
+	_ = db.Create(&models.User{Username: "testuser", Email: "test@example.com", Password: string(hashedPassword)})
+
+	// Test case: failures by username and by email count against one budget
+	for _, identifier := range []string{"testuser", "test@example.com", "testuser", "test@example.com"} {
+		_, err := userService.Login(identifier, "wrongpassword", "")
+		assert.Equal(t, utils.ErrInvalidCredentials, err)
+	}
+	_, err := userService.Login("test@example.com", "testpassword", "")
+	assert.IsType(t, &utils.LockoutError{}, err)
+
+	// Test case: made up identifiers all count on one counter, which locks
+	// like an account's
+	for i := 0; i < 4; i++ {
+		_, err = userService.Login(fmt.Sprintf("nobody-%d", i), "testpassword", "")
+		assert.Equal(t, utils.ErrInvalidCredentials, err)
+	}
+	_, err = userService.Login("nobody-9", "testpassword", "")
+	assert.IsType(t, &utils.LockoutError{}, err)
+	for _, key := range []string{"account:nobody-0", "account:nobody-9"} {
+		attempt, err := store.Get(key)
+		assert.NoError(t, err)
+		assert.Nil(t, attempt)
+	}
+	attempt, err := store.Get("account:unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, attempt.Failures)
 }
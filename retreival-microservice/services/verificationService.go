@@ -0,0 +1,282 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/utils"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+	changeEmailTTL   = 24 * time.Hour
+)
+
+// VerificationService issues and redeems the one-time tokens used for email
+// verification and password reset.
+type VerificationService struct {
+	userRepo  *repositories.UserRepository
+	tokenRepo *repositories.UserTokenRepository
+	jwt       *JWTService
+	throttle  *LoginThrottleService
+	mailer    Mailer
+	baseURL   string
+	log       *zap.Logger
+}
+
+// NewVerificationService creates the service. throttle may be nil; otherwise a
+// password reset lifts the login lock of the account.
+func NewVerificationService(userRepo *repositories.UserRepository, tokenRepo *repositories.UserTokenRepository, jwt *JWTService, throttle *LoginThrottleService, mailer Mailer, baseURL string) *VerificationService {
+	return &VerificationService{
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+		jwt:       jwt,
+		throttle:  throttle,
+		mailer:    mailer,
+		baseURL:   baseURL,
+		log:       utils.GetLogger(),
+	}
+}
+
+func (vs *VerificationService) SendVerificationEmail(user *models.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+
+	token, err := vs.issueToken(user.ID, models.TokenPurposeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nplease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours.\n",
+		user.Username, vs.link("/api/v1/user/verify", token))
+	return vs.mailer.Send(user.Email, "Confirm your email address", body)
+}
+
+func (vs *VerificationService) VerifyEmail(token string) (*models.User, error) {
+	record, err := vs.redeemToken(token, models.TokenPurposeVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := vs.userRepo.GetUserByID(record.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, utils.ErrInvalidToken
+	}
+
+	now := time.Now()
+	user.EmailVerified = true
+	user.EmailVerifiedAt = &now
+	if err := vs.userRepo.UpdateUser(user); err != nil {
+		vs.log.Error("Failed to mark email as verified", zap.Uint("UserID", user.ID), zap.Error(err))
+		return nil, err
+	}
+
+	vs.log.Info("Email verified", zap.Uint("UserID", user.ID))
+	return user, nil
+}
+
+// RequestPasswordReset mails a reset link when the email belongs to a user.
+// Unknown emails are ignored so the caller cannot probe for accounts.
+func (vs *VerificationService) RequestPasswordReset(email string) error {
+	user, err := vs.userRepo.GetUserByEmailOrUsername(email)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Email != email {
+		return nil
+	}
+
+	token, err := vs.issueToken(user.ID, models.TokenPurposeResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nsomeone asked to reset the password of your account. Use the token below to choose a new one:\n\n%s\n\nThe token expires in 1 hour. If you did not ask for this, you can ignore this mail.\n",
+		user.Username, token)
+	return vs.mailer.Send(user.Email, "Reset your password", body)
+}
+
+func (vs *VerificationService) ResetPassword(token, newPassword string) error {
+	if newPassword == "" {
+		return utils.ErrPasswordRequired
+	}
+
+	record, err := findUserToken(vs.jwt, vs.tokenRepo, token, models.TokenPurposeResetPassword)
+	if err != nil {
+		return err
+	}
+
+	user, err := vs.userRepo.GetUserByID(record.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return utils.ErrInvalidToken
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		vs.log.Error("Failed to hash password", zap.String("reason", "failed_to_hash_password"), zap.Error(err))
+		return err
+	}
+	if err := vs.userRepo.ResetPassword(user.ID, record.ID, string(hashedPassword)); err != nil {
+		return err
+	}
+
+	// The account may be locked by the failed logins that led to the reset.
+	if vs.throttle != nil {
+		if err := vs.throttle.Reset(user.ID); err != nil {
+			vs.log.Warn("Failed to reset login throttle", zap.Error(err))
+		}
+	}
+
+	vs.log.Info("Password reset", zap.Uint("UserID", user.ID))
+	return nil
+}
+
+// RequestEmailChange stores newEmail as pending and mails a confirmation link
+// to it. The current address stays active until the link is used.
+func (vs *VerificationService) RequestEmailChange(user *models.User, newEmail string) error {
+	if err := vs.checkEmailAvailable(user.ID, newEmail); err != nil {
+		return err
+	}
+
+	user.PendingEmail = newEmail
+	if err := vs.userRepo.UpdateUser(user); err != nil {
+		return err
+	}
+
+	token, err := vs.issueToken(user.ID, models.TokenPurposeChangeEmail, changeEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nplease confirm your new email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours.\n",
+		user.Username, vs.link("/api/v1/user/me/email/confirm", token))
+	return vs.mailer.Send(newEmail, "Confirm your new email address", body)
+}
+
+// ConfirmEmailChange makes the pending address the verified account email and
+// tells the previous address about the change.
+func (vs *VerificationService) ConfirmEmailChange(token string) (*models.User, error) {
+	record, err := vs.redeemToken(token, models.TokenPurposeChangeEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := vs.userRepo.GetUserByID(record.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.PendingEmail == "" {
+		return nil, utils.ErrInvalidToken
+	}
+	if err := vs.checkEmailAvailable(user.ID, user.PendingEmail); err != nil {
+		return nil, err
+	}
+
+	previous := user.Email
+	now := time.Now()
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+	user.EmailVerified = true
+	user.EmailVerifiedAt = &now
+	if err := vs.userRepo.UpdateUser(user); err != nil {
+		vs.log.Error("Failed to change email", zap.Uint("UserID", user.ID), zap.Error(err))
+		return nil, err
+	}
+	vs.log.Info("Email changed", zap.Uint("UserID", user.ID))
+
+	if previous != "" {
+		body := fmt.Sprintf("Hi %s,\n\nthe email address of your account was changed to %s. If you did not do this, please contact support.\n",
+			user.Username, user.Email)
+		if err := vs.mailer.Send(previous, "Your email address was changed", body); err != nil {
+			vs.log.Warn("Failed to notify previous email address", zap.Uint("UserID", user.ID), zap.Error(err))
+		}
+	}
+	return user, nil
+}
+
+func (vs *VerificationService) checkEmailAvailable(userID uint, email string) error {
+	existing, err := vs.userRepo.GetUserByEmailOrUsername(email)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ID != userID && existing.Email == email {
+		return utils.ErrEmailExist
+	}
+	return nil
+}
+
+func (vs *VerificationService) issueToken(userID uint, purpose string, ttl time.Duration) (string, error) {
+	if err := vs.tokenRepo.InvalidateTokens(userID, purpose); err != nil {
+		return "", err
+	}
+
+	token, jti, err := vs.jwt.GeneratePurposeToken(userID, purpose, ttl)
+	if err != nil {
+		return "", utils.ErrInGenerateToken
+	}
+
+	record := &models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		JTI:       jti,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := vs.tokenRepo.CreateToken(record); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (vs *VerificationService) redeemToken(token, purpose string) (*models.UserToken, error) {
+	record, err := findUserToken(vs.jwt, vs.tokenRepo, token, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := vs.tokenRepo.MarkUsed(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// findUserToken checks a one-time token and returns its record while it is
+// neither used nor expired. Callers redeem it with MarkUsed.
+func findUserToken(jwt *JWTService, tokens *repositories.UserTokenRepository, token, purpose string) (*models.UserToken, error) {
+	userID, jti, err := jwt.ValidatePurposeToken(token, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := tokens.GetTokenByJTI(jti)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.UserID != userID || record.Purpose != purpose {
+		return nil, utils.ErrInvalidToken
+	}
+	if record.UsedAt != nil {
+		return nil, utils.ErrTokenAlreadyUsed
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, utils.ErrTokenExpired
+	}
+	return record, nil
+}
+
+func (vs *VerificationService) link(path, token string) string {
+	return vs.baseURL + path + "?token=" + url.QueryEscape(token)
+}
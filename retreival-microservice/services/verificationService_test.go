@@ -0,0 +1,171 @@
+package services_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"retreival/models"
+	"retreival/repositories"
+	"retreival/services"
+	"retreival/utils"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type sentMail struct {
+	to, subject, body string
+}
+
+type recordingMailer struct {
+	mails []sentMail
+}
+
+func (rm *recordingMailer) Send(to, subject, body string) error {
+	rm.mails = append(rm.mails, sentMail{to, subject, body})
+	return nil
+}
+
+var tokenPattern = regexp.MustCompile(`[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)
+
+func prepareVerificationService(t *testing.T) (*services.VerificationService, *recordingMailer, *repositories.UserRepository) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.UserToken{}, &models.APIKey{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	userRepo := repositories.NewUserRepository(db)
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	mailer := &recordingMailer{}
+	service := services.NewVerificationService(userRepo, repositories.NewUserTokenRepository(db), jwtService, nil, mailer, "http://localhost:8080")
+
+	return service, mailer, userRepo
+}
+
+func TestVerificationService_VerifyEmail(t *testing.T) {
+	service, mailer, userRepo := prepareVerificationService(t)
+
+	user, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, service.SendVerificationEmail(user))
+	assert.Len(t, mailer.mails, 1)
+	assert.Equal(t, "test@example.com", mailer.mails[0].to)
+	assert.Contains(t, mailer.mails[0].body, "http://localhost:8080/api/v1/user/verify?token=")
+
+	token := tokenPattern.FindString(mailer.mails[0].body)
+	verified, err := service.VerifyEmail(token)
+	assert.NoError(t, err)
+	assert.True(t, verified.EmailVerified)
+
+	// Tokens are single use.
+	_, err = service.VerifyEmail(token)
+	assert.Equal(t, utils.ErrTokenAlreadyUsed, err)
+
+	_, err = service.VerifyEmail("invalid")
+	assert.Equal(t, utils.ErrInvalidToken, err)
+}
+
+func TestVerificationService_ResetPassword(t *testing.T) {
+	service, mailer, userRepo := prepareVerificationService(t)
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("oldpassword"), bcrypt.DefaultCost)
+	_, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com", Password: string(hashedPassword)})
+	assert.NoError(t, err)
+
+	// Unknown emails are silently ignored.
+	assert.NoError(t, service.RequestPasswordReset("nobody@example.com"))
+	assert.Empty(t, mailer.mails)
+
+	assert.NoError(t, service.RequestPasswordReset("test@example.com"))
+	assert.NoError(t, service.RequestPasswordReset("test@example.com"))
+	assert.Len(t, mailer.mails, 2)
+
+	// Requesting a new reset invalidates the previous token.
+	staleToken := tokenPattern.FindString(mailer.mails[0].body)
+	assert.Equal(t, utils.ErrTokenAlreadyUsed, service.ResetPassword(staleToken, "newpassword"))
+
+	token := tokenPattern.FindString(mailer.mails[1].body)
+	assert.NoError(t, service.ResetPassword(token, "newpassword"))
+
+	user, _ := userRepo.GetUserByEmailOrUsername("test@example.com")
+	assert.True(t, userRepo.VerifyPassword(user, "newpassword"))
+	assert.Equal(t, utils.ErrTokenAlreadyUsed, service.ResetPassword(token, "another"))
+}
+
+func TestVerificationService_ResetPasswordSignsOut(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("failed to connect database:", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.UserToken{}, &models.APIKey{}); err != nil {
+		t.Fatal("failed to migrate tables:", err)
+	}
+
+	userRepo := repositories.NewUserRepository(db)
+	jwtService := services.NewJWTService("702defe113014c81cd620451962243ab9585ec20db4e1e0856cce19a5977321b")
+	throttle := services.NewLoginThrottleService(repositories.NewInMemoryLoginAttemptStore(), services.LockoutPolicy{
+		AccountMaxAttempts: 1,
+		IPMaxAttempts:      100,
+		LockoutDuration:    time.Hour,
+		Window:             time.Hour,
+	})
+	mailer := &recordingMailer{}
+	service := services.NewVerificationService(userRepo, repositories.NewUserTokenRepository(db), jwtService, throttle, mailer, "http://localhost:8080")
+	apiKeys := services.NewAPIKeyService(repositories.NewAPIKeyRepository(db))
+
+	user, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com"})
+	assert.NoError(t, err)
+	rawKey, _, err := apiKeys.CreateKey(user.ID, models.APIKeyRequest{Name: "sync", Scopes: []string{models.ScopeRead}})
+	assert.NoError(t, err)
+	assert.NoError(t, throttle.RecordFailure(user.ID, "10.0.0.1"))
+
+	assert.NoError(t, service.RequestPasswordReset("test@example.com"))
+	assert.NoError(t, service.ResetPassword(tokenPattern.FindString(mailer.mails[0].body), "newpassword"))
+
+	// Test case: sessions of the old version and the API keys stop working
+	user, _ = userRepo.GetUserByID(user.ID)
+	assert.Equal(t, 1, user.SessionVersion)
+	_, err = apiKeys.Authenticate(rawKey)
+	assert.Equal(t, utils.ErrInvalidAPIKey, err)
+
+	// Test case: the account is no longer locked
+	wait, err := throttle.Check(user.ID, "")
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+}
+
+func TestVerificationService_ConfirmEmailChange(t *testing.T) {
+	service, mailer, userRepo := prepareVerificationService(t)
+
+	user, err := userRepo.CreateUser(models.User{Username: "testuser", Email: "test@example.com"})
+	assert.NoError(t, err)
+	_, err = userRepo.CreateUser(models.User{Username: "other", Email: "taken@example.com"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, utils.ErrEmailExist, service.RequestEmailChange(user, "taken@example.com"))
+
+	assert.NoError(t, service.RequestEmailChange(user, "new@example.com"))
+	assert.Contains(t, mailer.mails[0].body, "http://localhost:8080/api/v1/user/me/email/confirm?token=")
+
+	changed, err := service.ConfirmEmailChange(tokenPattern.FindString(mailer.mails[0].body))
+	assert.NoError(t, err)
+	assert.Equal(t, "new@example.com", changed.Email)
+	assert.Equal(t, "", changed.PendingEmail)
+	assert.True(t, changed.EmailVerified)
+
+	// The previous address is told about the change.
+	assert.Len(t, mailer.mails, 2)
+	assert.Equal(t, "test@example.com", mailer.mails[1].to)
+}
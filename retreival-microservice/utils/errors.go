@@ -1,6 +1,9 @@
 package utils
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
 	ErrUserNotFound         = errors.New("user not found")
@@ -12,4 +15,96 @@ var (
 	ErrUsernameExist        = errors.New("username already exists")
 	ErrFileSizeExceedsLimit = errors.New("file size limit exceeded")
 	ErrNoFileUploaded       = errors.New("no file uploaded")
+
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrAccountLocked      = errors.New("too many failed login attempts")
+
+	ErrInvalidToken     = errors.New("invalid token")
+	ErrTokenAlreadyUsed = errors.New("token already used")
+	ErrEmailNotVerified = errors.New("email not verified")
+	ErrPasswordRequired = errors.New("password is required")
+
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication not enabled")
+	ErrTwoFactorNotSetUp       = errors.New("two-factor authentication not set up")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+
+	ErrInvalidAPIKey      = errors.New("invalid api key")
+	ErrAPIKeyExpired      = errors.New("api key expired")
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrAPIKeyNameRequired = errors.New("api key name is required")
+	ErrAPIKeyExpiryInPast = errors.New("api key expiry must be in the future")
+	ErrInvalidAPIKeyScope = errors.New("invalid api key scope")
+
+	ErrOIDCInvalidState   = errors.New("invalid or expired login state")
+	ErrOIDCExchangeFailed = errors.New("authorization code exchange failed")
+	ErrOIDCInvalidIDToken = errors.New("invalid id token")
+
+	ErrEmailUnchanged = errors.New("email is unchanged")
+
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+	ErrFileNotFound     = errors.New("file not found")
+	ErrInvalidFileQuery = errors.New("invalid file request")
+	ErrFileConflict     = errors.New("file request conflicts with the current state")
+	ErrStoreUnavailable = errors.New("store did not answer")
+	ErrStoreFailed      = errors.New("store failed to handle the request")
+	ErrTagTooLong       = errors.New("tag is too long")
+	ErrInvalidMetadata  = errors.New("invalid custom metadata")
+
+	ErrFileTypeNotAllowed = errors.New("file type is not allowed")
+	ErrFileTypeMismatch   = errors.New("declared file type does not match the content")
+
+	ErrUploadNotFound        = errors.New("upload not found")
+	ErrUploadOffsetMismatch  = errors.New("upload offset does not match")
+	ErrUploadTooLarge        = errors.New("upload is larger than its length")
+	ErrInvalidUploadMetadata = errors.New("invalid upload metadata")
+
+	ErrNotAnArchive     = errors.New("file is not a zip or tar archive")
+	ErrArchiveTooLarge  = errors.New("archive exceeds the extraction limits")
+	ErrDownloadTooLarge = errors.New("download exceeds the archive limits")
+
+	ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+	ErrShareLinkNotFound     = errors.New("share link not found")
+	ErrShareLinkRevoked      = errors.New("share link was revoked")
+	ErrShareLinkExhausted    = errors.New("share link reached its download limit")
+	ErrSharePasswordRequired = errors.New("share link needs a password")
+	ErrSharePasswordInvalid  = errors.New("wrong share link password")
+	ErrShareLinkLocked       = errors.New("too many wrong passwords, try again later")
+	ErrInvalidShareExpiry    = errors.New("expiry must be in the future and within 30 days")
+	ErrInvalidShareLimit     = errors.New("download limit must not be negative")
+
+	ErrGroupNotFound         = errors.New("group not found")
+	ErrInvalidGroupName      = errors.New("group name must be 1 to 100 characters")
+	ErrNotGroupOwner         = errors.New("only the group owner can do this")
+	ErrAlreadyGroupMember    = errors.New("user is already a member of the group")
+	ErrGroupMemberNotFound   = errors.New("user is not a member of the group")
+	ErrGroupOwnerCannotLeave = errors.New("the group owner cannot leave the group")
+	ErrInvalidPermission     = errors.New("permission must be read or write")
+	ErrInvalidPrincipal      = errors.New("either 'user' or 'group_id' is required")
+	ErrShareWithSelf         = errors.New("files cannot be shared with their owner")
+
+	ErrFolderNotFound    = errors.New("folder not found")
+	ErrFolderNameInUse   = errors.New("a folder with this name already exists")
+	ErrFolderNotEmpty    = errors.New("folder is not empty")
+	ErrInvalidFolderName = errors.New("folder names must be 1 to 255 characters without slashes")
+	ErrInvalidFolderID   = errors.New("folder_id must be the id of a folder")
+
+	ErrThumbnailNotFound = errors.New("thumbnail not found")
+	ErrThumbnailPending  = errors.New("thumbnail is not ready yet")
 )
+
+// LockoutError is returned while logins are throttled. It matches
+// ErrAccountLocked with errors.Is.
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return ErrAccountLocked.Error()
+}
+
+func (e *LockoutError) Is(target error) bool {
+	return target == ErrAccountLocked
+}
@@ -0,0 +1,98 @@
+package validation
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// bcrypt ignores everything after 72 bytes.
+const maxPasswordBytes = 72
+
+type PasswordPolicy struct {
+	MinLength        int
+	RequireMixedCase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	// Denylist holds lower-cased passwords known from breaches.
+	Denylist map[string]struct{}
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: 8}
+}
+
+// LoadDenylist reads one password per line. Empty lines and lines starting
+// with # are skipped.
+func LoadDenylist(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	denylist := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		denylist[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return denylist, nil
+}
+
+// Check validates password against the policy. username and email are used
+// to reject passwords that simply repeat them.
+func (p PasswordPolicy) Check(field, password, username, email string) Errors {
+	var errs Errors
+
+	if password == "" {
+		errs.add(field, "required", "password is required")
+		return errs
+	}
+	if len([]rune(password)) < p.MinLength {
+		errs.add(field, "too_short", "password must be at least "+itoa(p.MinLength)+" characters")
+	}
+	if len(password) > maxPasswordBytes {
+		errs.add(field, "too_long", "password must be at most "+itoa(maxPasswordBytes)+" bytes")
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+	if p.RequireMixedCase && !(hasUpper && hasLower) {
+		errs.add(field, "missing_mixed_case", "password must contain upper and lower case letters")
+	}
+	if p.RequireDigit && !hasDigit {
+		errs.add(field, "missing_digit", "password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		errs.add(field, "missing_symbol", "password must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	if (username != "" && lowered == strings.ToLower(username)) || (email != "" && lowered == strings.ToLower(email)) {
+		errs.add(field, "matches_identity", "password must not match the username or email")
+	}
+	if _, denied := p.Denylist[lowered]; denied {
+		errs.add(field, "breached", "password is too common or appeared in a data breach")
+	}
+
+	return errs
+}
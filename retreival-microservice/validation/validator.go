@@ -0,0 +1,118 @@
+package validation
+
+import (
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"retreival/models"
+)
+
+const (
+	usernameMinLength = 3
+	usernameMaxLength = 32
+	emailMaxLength    = 254
+	nameMaxLength     = 100
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+type Validator struct {
+	passwords PasswordPolicy
+}
+
+func NewValidator(passwords PasswordPolicy) *Validator {
+	return &Validator{passwords: passwords}
+}
+
+// ValidateRegistration trims the request in place and reports every invalid
+// field.
+func (v *Validator) ValidateRegistration(req *models.UserRegistrationRequest) error {
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+	req.FirstName = strings.TrimSpace(req.FirstName)
+	req.LastName = strings.TrimSpace(req.LastName)
+
+	var errs Errors
+	errs = append(errs, checkUsername("username", req.Username)...)
+	errs = append(errs, checkEmail("email", req.Email)...)
+	errs = append(errs, v.passwords.Check("password", req.Password, req.Username, req.Email)...)
+	errs = append(errs, checkName("first_name", req.FirstName)...)
+	errs = append(errs, checkName("last_name", req.LastName)...)
+	return errs.Err()
+}
+
+// ValidateProfile trims the provided names in place and checks their length.
+func (v *Validator) ValidateProfile(req *models.UpdateProfileRequest) error {
+	var errs Errors
+	if req.FirstName != nil {
+		*req.FirstName = strings.TrimSpace(*req.FirstName)
+		errs = append(errs, checkName("first_name", *req.FirstName)...)
+	}
+	if req.LastName != nil {
+		*req.LastName = strings.TrimSpace(*req.LastName)
+		errs = append(errs, checkName("last_name", *req.LastName)...)
+	}
+	return errs.Err()
+}
+
+func (v *Validator) ValidateLogin(identifier, password string) error {
+	var errs Errors
+	if strings.TrimSpace(identifier) == "" {
+		errs.add("identifier", "required", "identifier is required")
+	}
+	if password == "" {
+		errs.add("password", "required", "password is required")
+	}
+	return errs.Err()
+}
+
+// ValidatePassword checks a new password against the policy.
+func (v *Validator) ValidatePassword(field, password, username, email string) error {
+	return v.passwords.Check(field, password, username, email).Err()
+}
+
+func (v *Validator) ValidateEmail(field, email string) error {
+	return checkEmail(field, strings.TrimSpace(email)).Err()
+}
+
+func checkUsername(field, username string) Errors {
+	var errs Errors
+	switch {
+	case username == "":
+		errs.add(field, "required", "username is required")
+	case len(username) < usernameMinLength || len(username) > usernameMaxLength:
+		errs.add(field, "invalid_length", "username must be between "+itoa(usernameMinLength)+" and "+itoa(usernameMaxLength)+" characters")
+	case !usernamePattern.MatchString(username):
+		errs.add(field, "invalid_characters", "username may only contain letters, digits, '.', '_' and '-'")
+	}
+	return errs
+}
+
+func checkEmail(field, email string) Errors {
+	var errs Errors
+	if email == "" {
+		errs.add(field, "required", "email is required")
+		return errs
+	}
+
+	addr, err := mail.ParseAddress(email)
+	at := strings.LastIndex(email, "@")
+	if err != nil || addr.Address != email || len(email) > emailMaxLength || at < 1 || !strings.Contains(email[at+1:], ".") {
+		errs.add(field, "invalid_format", "email is not a valid address")
+	}
+	return errs
+}
+
+func checkName(field, name string) Errors {
+	var errs Errors
+	if len([]rune(name)) > nameMaxLength {
+		errs.add(field, "too_long", field+" must be at most "+itoa(nameMaxLength)+" characters")
+	}
+	return errs
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
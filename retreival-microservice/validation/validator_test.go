@@ -0,0 +1,81 @@
+package validation_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"retreival/models"
+	"retreival/validation"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fieldCodes(err error) map[string]string {
+	codes := map[string]string{}
+	if fieldErrs, ok := err.(validation.Errors); ok {
+		for _, fieldErr := range fieldErrs {
+			codes[fieldErr.Field] = fieldErr.Code
+		}
+	}
+	return codes
+}
+
+func TestValidator_ValidateRegistration(t *testing.T) {
+	validator := validation.NewValidator(validation.DefaultPasswordPolicy())
+
+	// Test case: valid request is trimmed and accepted
+	req := models.UserRegistrationRequest{
+		Username: " testuser ",
+		Email:    "test@example.com",
+		Password: "correct horse",
+	}
+	assert.NoError(t, validator.ValidateRegistration(&req))
+	assert.Equal(t, "testuser", req.Username)
+
+	// Test case: every invalid field is reported
+	req = models.UserRegistrationRequest{
+		Username: "a b",
+		Email:    "not-an-email",
+		Password: "x",
+	}
+	err := validator.ValidateRegistration(&req)
+	assert.Error(t, err)
+	assert.Equal(t, map[string]string{
+		"username": "invalid_characters",
+		"email":    "invalid_format",
+		"password": "too_short",
+	}, fieldCodes(err))
+
+	// Test case: empty fields are required
+	req = models.UserRegistrationRequest{}
+	assert.Equal(t, map[string]string{
+		"username": "required",
+		"email":    "required",
+		"password": "required",
+	}, fieldCodes(validator.ValidateRegistration(&req)))
+}
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	denylistPath := filepath.Join(t.TempDir(), "denylist.txt")
+	assert.NoError(t, os.WriteFile(denylistPath, []byte("# comment\nPassword123\n\n"), 0o600))
+
+	denylist, err := validation.LoadDenylist(denylistPath)
+	assert.NoError(t, err)
+
+	policy := validation.PasswordPolicy{
+		MinLength:        8,
+		RequireMixedCase: true,
+		RequireDigit:     true,
+		Denylist:         denylist,
+	}
+
+	assert.Empty(t, policy.Check("password", "Tr0ub4dor", "testuser", "test@example.com"))
+	assert.Equal(t, "breached", policy.Check("password", "PASSword123", "", "")[0].Code)
+	assert.Equal(t, "matches_identity", policy.Check("password", "Testuser1", "testuser1", "")[0].Code)
+
+	errs := policy.Check("password", "lowercase", "", "")
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "missing_mixed_case", errs[0].Code)
+	assert.Equal(t, "missing_digit", errs[1].Code)
+}
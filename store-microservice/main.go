@@ -4,11 +4,11 @@ import (
 	"encoding/json"
 	"log"
 	"os"
-	"path/filepath"
 	"store/models"
 	"store/services"
 	"store/utils"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/streadway/amqp"
@@ -24,6 +24,22 @@ type Config struct {
 	FileLimit   string
 	RabbitmqUrl string
 	FilePath    string
+
+	ReconcileMinutes     string
+	TrashRetentionHours  string
+	PurgeIntervalMinutes string
+	VersionKeepLast      string
+
+	ClamAVAddress        string
+	ClamAVTimeoutSeconds string
+	QuarantinePath       string
+
+	UploadStagingPath  string
+	UploadStagingHours string
+
+	ArchiveMaxEntries string
+	ArchiveMaxBytes   string
+	ArchiveMaxRatio   string
 }
 
 func LoadConfig() Config {
@@ -39,6 +55,22 @@ func LoadConfig() Config {
 		FileLimit:   os.Getenv("FILE_LIMIT"),
 		RabbitmqUrl: os.Getenv("RABBITMQ_URL"),
 		FilePath:    os.Getenv("FILE_PATH"),
+
+		ReconcileMinutes:     os.Getenv("USAGE_RECONCILE_MINUTES"),
+		TrashRetentionHours:  os.Getenv("TRASH_RETENTION_HOURS"),
+		PurgeIntervalMinutes: os.Getenv("PURGE_INTERVAL_MINUTES"),
+		VersionKeepLast:      os.Getenv("VERSION_KEEP_LAST"),
+
+		ClamAVAddress:        os.Getenv("CLAMAV_ADDRESS"),
+		ClamAVTimeoutSeconds: os.Getenv("CLAMAV_TIMEOUT_SECONDS"),
+		QuarantinePath:       os.Getenv("QUARANTINE_PATH"),
+
+		UploadStagingPath:  os.Getenv("UPLOAD_STAGING_PATH"),
+		UploadStagingHours: os.Getenv("UPLOAD_STAGING_HOURS"),
+
+		ArchiveMaxEntries: os.Getenv("ARCHIVE_MAX_ENTRIES"),
+		ArchiveMaxBytes:   os.Getenv("ARCHIVE_MAX_BYTES"),
+		ArchiveMaxRatio:   os.Getenv("ARCHIVE_MAX_RATIO"),
 	}
 }
 
@@ -62,15 +94,45 @@ func createQueueIfNotExist(queueName string, conn *amqp.Connection) error {
 	return nil
 }
 
+// newScanner returns a clamd scanner if CLAMAV_ADDRESS is set and a scanner
+// that accepts everything otherwise.
+func newScanner(config Config, logger *zap.Logger) services.Scanner {
+	if config.ClamAVAddress == "" {
+		logger.Warn("CLAMAV_ADDRESS is not set, uploads are not scanned")
+		return services.NoopScanner{}
+	}
+	timeoutSeconds, err := strconv.Atoi(config.ClamAVTimeoutSeconds)
+	if err != nil || timeoutSeconds <= 0 {
+		timeoutSeconds = 60
+	}
+	return services.NewClamAVScanner(config.ClamAVAddress, time.Duration(timeoutSeconds)*time.Second)
+}
+
+// archiveLimits reads the archive extraction limits, keeping the defaults for
+// unset or invalid values.
+func archiveLimits(config Config) services.ArchiveLimits {
+	limits := services.DefaultArchiveLimits()
+	if n, err := strconv.Atoi(config.ArchiveMaxEntries); err == nil && n > 0 {
+		limits.MaxEntries = n
+	}
+	if n, err := strconv.ParseInt(config.ArchiveMaxBytes, 10, 64); err == nil && n > 0 {
+		limits.MaxBytes = n
+	}
+	if n, err := strconv.ParseInt(config.ArchiveMaxRatio, 10, 64); err == nil && n > 0 {
+		limits.MaxRatio = n
+	}
+	return limits
+}
+
 func main() {
 	config := LoadConfig()
 	logger := utils.GetLogger()
 
-	db, err := gorm.Open(postgres.Open(config.DatabaseURL), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(config.DatabaseURL), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
-	err = db.AutoMigrate(&models.File{}, &models.FileTag{})
+	err = db.AutoMigrate(&models.File{}, &models.FileVersion{}, &models.FileTag{}, &models.UserUsage{}, &models.QuarantinedFile{}, &models.ACLEntry{}, &models.Folder{})
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
@@ -86,6 +148,26 @@ func main() {
 	if err != nil {
 		logger.Fatal("Failed to create or check file-data-queue", zap.Error(err))
 	}
+	err = createQueueIfNotExist(services.FileStatusQueue, conn)
+	if err != nil {
+		logger.Fatal("Failed to create or check file-status-queue", zap.Error(err))
+	}
+	err = createQueueIfNotExist("user-events-queue", conn)
+	if err != nil {
+		logger.Fatal("Failed to create or check user-events-queue", zap.Error(err))
+	}
+	err = createQueueIfNotExist(services.FileCommandsQueue, conn)
+	if err != nil {
+		logger.Fatal("Failed to create or check file-commands-queue", zap.Error(err))
+	}
+	err = createQueueIfNotExist(services.FileChunksQueue, conn)
+	if err != nil {
+		logger.Fatal("Failed to create or check file-chunks-queue", zap.Error(err))
+	}
+	err = createQueueIfNotExist(services.FileThumbnailsQueue, conn)
+	if err != nil {
+		logger.Fatal("Failed to create or check file-thumbnails-queue", zap.Error(err))
+	}
 
 	ch, err := conn.Channel()
 	if err != nil {
@@ -97,9 +179,88 @@ func main() {
 
 	fileService := services.NewFileSystemService(logger)
 	metaDataService := services.NewMetadataService(db)
-	volumeLimitService := services.NewVolumeLimitService(logger)
+	intFileLimit, _ := strconv.Atoi(config.FileLimit)
+	volumeLimitService := services.NewVolumeLimitService(logger, config.FilePath, int64(intFileLimit))
 
 	storageService := services.NewStorageService(*rabbitService, db)
+	usageService := services.NewUsageService(db, *rabbitService)
+
+	keepLast, err := strconv.Atoi(config.VersionKeepLast)
+	if err != nil {
+		keepLast = 10
+	}
+	versionService := services.NewVersionService(db, fileService, volumeLimitService, usageService, config.FilePath, []byte(config.SecretKey), keepLast)
+	if err := versionService.MigrateLegacyFiles(); err != nil {
+		log.Fatal("Failed to migrate files to versions:", err)
+	}
+	if err := metaDataService.MigrateFileNames(); err != nil {
+		log.Fatal("Failed to make file names unique:", err)
+	}
+	fullTextService := services.NewFullTextService(db)
+	thumbnailService := services.NewThumbnailService(db, *rabbitService, fileService, volumeLimitService, config.FilePath, []byte(config.SecretKey))
+	quarantinePath := config.QuarantinePath
+	if quarantinePath == "" {
+		quarantinePath = "quarantine"
+	}
+	ingestService := services.NewIngestService(*rabbitService, metaDataService, fileService, volumeLimitService, usageService, versionService, fullTextService, thumbnailService, newScanner(config, logger), config.FilePath, quarantinePath, []byte(config.SecretKey))
+	stagingPath := config.UploadStagingPath
+	if stagingPath == "" {
+		stagingPath = "uploads"
+	}
+	chunkService := services.NewChunkService(ingestService, stagingPath, []byte(config.SecretKey))
+	archiveService := services.NewArchiveService(ingestService, archiveLimits(config))
+
+	retentionHours, err := strconv.Atoi(config.TrashRetentionHours)
+	if err != nil || retentionHours < 0 {
+		retentionHours = 720
+	}
+	trashService := services.NewTrashService(db, versionService, usageService, time.Duration(retentionHours)*time.Hour)
+	tagService := services.NewTagService(db)
+	if err := tagService.MigrateTags(); err != nil {
+		log.Fatal("Failed to migrate tags:", err)
+	}
+	accessService := services.NewAccessService(db)
+	folderService := services.NewFolderService(db)
+	userEventService := services.NewUserEventService(db, trashService, tagService, usageService, accessService, folderService)
+
+	commandService := services.NewCommandService(*rabbitService)
+	trashService.RegisterCommands(commandService)
+	versionService.RegisterCommands(commandService)
+	storageService.RegisterCommands(commandService)
+	tagService.RegisterCommands(commandService)
+	metaDataService.RegisterCommands(commandService)
+	archiveService.RegisterCommands(commandService)
+	accessService.RegisterCommands(commandService)
+	folderService.RegisterCommands(commandService)
+	thumbnailService.RegisterCommands(commandService)
+
+	reconcileMinutes, err := strconv.Atoi(config.ReconcileMinutes)
+	if err != nil || reconcileMinutes <= 0 {
+		reconcileMinutes = 10
+	}
+	go func() {
+		for range time.Tick(time.Duration(reconcileMinutes) * time.Minute) {
+			if err := volumeLimitService.Reconcile(); err != nil {
+				logger.Error("Failed to reconcile volume usage", zap.Error(err))
+			}
+			_ = usageService.Reconcile()
+		}
+	}()
+
+	purgeMinutes, err := strconv.Atoi(config.PurgeIntervalMinutes)
+	if err != nil || purgeMinutes <= 0 {
+		purgeMinutes = 60
+	}
+	stagingHours, err := strconv.Atoi(config.UploadStagingHours)
+	if err != nil || stagingHours <= 0 {
+		stagingHours = 48
+	}
+	go func() {
+		for range time.Tick(time.Duration(purgeMinutes) * time.Minute) {
+			_ = trashService.Purge()
+			_ = chunkService.RemoveStale(time.Duration(stagingHours) * time.Hour)
+		}
+	}()
 
 	fileRequestMsgs, err := rabbitService.ConsumeQueue("file-request-queue")
 	if err != nil {
@@ -136,6 +297,70 @@ func main() {
 		}
 	}()
 
+	commandMsgs, err := rabbitService.ConsumeQueue(services.FileCommandsQueue)
+	if err != nil {
+		logger.Warn("Failed to consume from file-commands-queue", zap.Error(err))
+	}
+
+	logger.Info("Listening to 'file-commands-queue'...")
+
+	go func() {
+		for msg := range commandMsgs {
+			go commandService.HandleCommand(msg)
+		}
+	}()
+
+	userEventMsgs, err := rabbitService.ConsumeQueue("user-events-queue")
+	if err != nil {
+		logger.Warn("Failed to consume from user-events-queue", zap.Error(err))
+	}
+
+	logger.Info("Listening to 'user-events-queue'...")
+
+	go func() {
+		for msg := range userEventMsgs {
+			if err := userEventService.HandleUserEvent(msg.Body); err != nil {
+				logger.Error("Failed to handle user event", zap.Error(err))
+			}
+		}
+	}()
+
+	chunkMsgs, err := rabbitService.ConsumeQueue(services.FileChunksQueue)
+	if err != nil {
+		logger.Warn("Failed to consume from file-chunks-queue", zap.Error(err))
+	}
+
+	logger.Info("Listening to 'file-chunks-queue'...")
+
+	// Chunks are handled in order so the final chunk comes after the others.
+	go func() {
+		for msg := range chunkMsgs {
+			if err := chunkService.HandleChunk(msg.Body); err != nil {
+				logger.Error("Failed to handle upload chunk", zap.Error(err))
+			}
+		}
+	}()
+
+	thumbnailMsgs, err := rabbitService.ConsumeQueue(services.FileThumbnailsQueue)
+	if err != nil {
+		logger.Warn("Failed to consume from file-thumbnails-queue", zap.Error(err))
+	}
+
+	logger.Info("Listening to 'file-thumbnails-queue'...")
+
+	// Thumbnails are rendered one at a time, so large images do not take up
+	// memory together.
+	go func() {
+		for msg := range thumbnailMsgs {
+			if err := thumbnailService.HandleJob(msg.Body); err != nil {
+				logger.Error("Failed to handle thumbnail job", zap.Error(err))
+			}
+		}
+	}()
+	if err := thumbnailService.QueueMissing(); err != nil {
+		logger.Error("Failed to queue missing thumbnails", zap.Error(err))
+	}
+
 	msgs, err := rabbitService.ConsumeQueue("file-data-queue")
 	if err != nil {
 		logger.Warn("Failed to consume from queue", zap.Error(err))
@@ -150,39 +375,8 @@ func main() {
 			break
 		}
 
-		var fileData models.FileData
-		err := json.Unmarshal(msg.Body, &fileData)
-		if err != nil {
-			logger.Error("Failed to unmarshal file data from message", zap.Error(err))
-			continue
-		}
-
-		logger.Info("Received file data", zap.String("fileName", fileData.FileName))
-
-		err = metaDataService.SaveFileData(&fileData)
-		if err != nil {
-			logger.Error("Failed to save metadata in the database", zap.Error(err))
-			continue
-		}
-		logger.Info("Metadata saved successfully", zap.String("fileName", fileData.FileName))
-
-		intFileLimit, _ := strconv.Atoi(config.FileLimit)
-		isWithinLimit, err := volumeLimitService.IsWithinLimit(config.FilePath, fileData.FileSize, intFileLimit)
-		if err != nil {
-			logger.Error("Error checking volume limit", zap.Error(err))
-			continue
-		}
-
-		if isWithinLimit {
-			filePath := filepath.Join(config.FilePath, fileData.FileName)
-			err = fileService.EncryptAndSaveFile(fileData.FileBytes, filePath, []byte(config.SecretKey))
-			if err != nil {
-				logger.Error("Failed to save encrypted file", zap.Error(err))
-				continue
-			}
-			logger.Info("File saved successfully", zap.String("filePath", filePath))
-		} else {
-			logger.Warn("Volume limit exceeded, file not saved", zap.String("fileName", fileData.FileName))
+		if err := ingestService.HandleFileData(msg.Body); err != nil {
+			logger.Error("Failed to handle file data", zap.Error(err))
 		}
 	}
 }
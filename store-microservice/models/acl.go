@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	FileActionGrantAccess  = "grant_access"
+	FileActionRevokeAccess = "revoke_access"
+	FileActionListAccess   = "list_access"
+)
+
+const (
+	PrincipalUser  = "user"
+	PrincipalGroup = "group"
+)
+
+const (
+	PermissionRead = "read"
+	// PermissionWrite includes read.
+	PermissionWrite = "write"
+)
+
+// ACLEntry grants a user or a group access to one file, to every file of
+// the owner with a tag, or to a folder and everything below it.
+type ACLEntry struct {
+	gorm.Model
+	OwnerID       uint  `gorm:"index"`
+	FileID        *uint `gorm:"index"`
+	TagID         *uint `gorm:"index"`
+	FolderID      *uint `gorm:"index"`
+	PrincipalType string
+	PrincipalID   uint `gorm:"index"`
+	Permission    string
+}
+
+// Principal is who a command runs for: a user and the groups they are in.
+type Principal struct {
+	UserID   uint
+	GroupIDs []uint
+}
+
+// ACLPayload is the payload of the access actions. They work on the
+// command's file, or on the owner's Tag or FolderID if one is set. Grant
+// needs the principal and the permission, revoke the EntryID.
+type ACLPayload struct {
+	Tag           string `json:"tag,omitempty"`
+	FolderID      uint   `json:"folder_id,omitempty"`
+	EntryID       uint   `json:"entry_id,omitempty"`
+	PrincipalType string `json:"principal_type,omitempty"`
+	PrincipalID   uint   `json:"principal_id,omitempty"`
+	Permission    string `json:"permission,omitempty"`
+}
+
+type ACLEntryInfo struct {
+	ID            uint      `json:"id"`
+	FileID        uint      `json:"file_id,omitempty"`
+	Tag           string    `json:"tag,omitempty"`
+	FolderID      uint      `json:"folder_id,omitempty"`
+	PrincipalType string    `json:"principal_type"`
+	PrincipalID   uint      `json:"principal_id"`
+	Permission    string    `json:"permission"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+const FileActionExtractArchive = "extract_archive"
+
+// ArchivePayload asks the store to expand an uploaded .zip, .tar or .tar.gz
+// into one file per entry. Entries get the archive's tags and metadata and
+// their types are checked like those of direct uploads.
+type ArchivePayload struct {
+	File         FileData `json:"file"`
+	AllowedTypes []string `json:"allowed_types,omitempty"`
+	DeniedTypes  []string `json:"denied_types,omitempty"`
+	MaxFileSize  int64    `json:"max_file_size,omitempty"`
+}
+
+// ArchiveEntrySkipped is the status of entries that are not files or whose
+// path is unsafe; other entries are stored or rejected like uploads.
+const ArchiveEntrySkipped = "skipped"
+
+const (
+	SkipUnsafePath   = "unsafe_path"
+	SkipNotRegular   = "not_a_regular_file"
+	RejectTypeDenied = "type_not_allowed"
+	RejectTooLarge   = "file_too_large"
+)
+
+// ArchiveEntryResult is what happened to one entry of an archive.
+type ArchiveEntryResult struct {
+	FileName   string `json:"file_name"`
+	FileType   string `json:"file_type,omitempty"`
+	FileSize   int64  `json:"file_size"`
+	Status     string `json:"status"`
+	Reason     string `json:"reason,omitempty"`
+	ScanStatus string `json:"scan_status,omitempty"`
+}
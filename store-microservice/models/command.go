@@ -0,0 +1,146 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	FileActionDelete    = "delete"
+	FileActionListTrash = "list_trash"
+	FileActionRestore   = "restore"
+
+	FileActionListVersions   = "list_versions"
+	FileActionDownload       = "download"
+	FileActionRestoreVersion = "restore_version"
+)
+
+const (
+	CommandStatusOK       = "ok"
+	CommandStatusNotFound = "not_found"
+	CommandStatusInvalid  = "invalid"
+	CommandStatusConflict = "conflict"
+	CommandStatusTooLarge = "too_large"
+	CommandStatusError    = "error"
+)
+
+// FileCommand runs an action for the user OwnerID, who is in GroupIDs.
+// Files shared with the user or the groups are found besides their own.
+type FileCommand struct {
+	Action   string          `json:"action"`
+	OwnerID  uint            `json:"owner_id"`
+	GroupIDs []uint          `json:"group_ids,omitempty"`
+	FileID   uint            `json:"file_id,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+}
+
+func (c *FileCommand) Principal() Principal {
+	return Principal{UserID: c.OwnerID, GroupIDs: c.GroupIDs}
+}
+
+type FileCommandReply struct {
+	Status string          `json:"status"`
+	Error  string          `json:"error,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+type FileInfo struct {
+	ID           uint              `json:"id"`
+	OwnerID      uint              `json:"owner_id"`
+	FolderID     *uint             `json:"folder_id"`
+	FileName     string            `json:"file_name"`
+	FileType     string            `json:"file_type"`
+	DeclaredType string            `json:"declared_type,omitempty"`
+	FileSize     int64             `json:"file_size"`
+	Version      int               `json:"version"`
+	Tags         []string          `json:"tags"`
+	Metadata     map[string]string `json:"metadata"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+	DeletedAt    *time.Time        `json:"deleted_at,omitempty"`
+}
+
+func ConvertFileToFileInfo(file File) FileInfo {
+	info := FileInfo{
+		ID:           file.ID,
+		OwnerID:      file.OwnerID,
+		FolderID:     file.FolderID,
+		FileName:     file.FileName,
+		FileType:     file.FileType,
+		DeclaredType: file.DeclaredType,
+		FileSize:     file.FileSize,
+		Version:      file.CurrentVersion,
+		Tags:         make([]string, 0, len(file.FileTags)),
+		Metadata:     file.Metadata,
+		CreatedAt:    file.CreatedAt,
+		UpdatedAt:    file.UpdatedAt,
+	}
+	if info.Metadata == nil {
+		info.Metadata = map[string]string{}
+	}
+	for _, tag := range file.FileTags {
+		info.Tags = append(info.Tags, tag.Name)
+	}
+	if file.DeletedAt.Valid {
+		deletedAt := file.DeletedAt.Time
+		info.DeletedAt = &deletedAt
+	}
+	return info
+}
+
+// VersionPayload selects a version for the download and restore_version
+// actions. Version 0 is the current version.
+type VersionPayload struct {
+	Version int `json:"version"`
+}
+
+// DownloadPayload selects the version and the bytes for the download action.
+// Without a Range the whole content is sent; with InfoOnly none of it.
+type DownloadPayload struct {
+	Version  int        `json:"version"`
+	Range    *ByteRange `json:"range,omitempty"`
+	InfoOnly bool       `json:"info_only,omitempty"`
+}
+
+// ByteRange is Length bytes of content from Offset. It may end past the
+// content, which then is cut short.
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+type FileVersionInfo struct {
+	Version      int       `json:"version"`
+	FileType     string    `json:"file_type"`
+	DeclaredType string    `json:"declared_type,omitempty"`
+	FileSize     int64     `json:"file_size"`
+	Current      bool      `json:"current"`
+	ScanStatus   string    `json:"scan_status,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func ConvertFileVersionToInfo(version FileVersion, current int) FileVersionInfo {
+	return FileVersionInfo{
+		Version:      version.Version,
+		FileType:     version.FileType,
+		DeclaredType: version.DeclaredType,
+		FileSize:     version.FileSize,
+		Current:      version.Version == current,
+		ScanStatus:   version.ScanStatus,
+		CreatedAt:    version.CreatedAt,
+	}
+}
+
+// FileContent is a downloaded version. Content holds the requested bytes,
+// which start at Offset of the FileSize bytes of the version.
+type FileContent struct {
+	OwnerID    uint      `json:"owner_id"`
+	FileName   string    `json:"file_name"`
+	FileType   string    `json:"file_type"`
+	Version    int       `json:"version"`
+	FileSize   int64     `json:"file_size"`
+	Offset     int64     `json:"offset,omitempty"`
+	ETag       string    `json:"etag"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Content    []byte    `json:"content"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const FileActionUpdateMetadata = "update_metadata"
+
+const (
+	MaxMetadataKeys        = 32
+	MaxMetadataValueLength = 256
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// CustomMetadata holds the custom key/value metadata of a file. It is saved
+// as a JSON object.
+type CustomMetadata map[string]string
+
+func (m CustomMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+func (m *CustomMetadata) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*m = CustomMetadata{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into CustomMetadata", value)
+	}
+	return json.Unmarshal(data, m)
+}
+
+// MetadataPatch changes custom metadata. Keys set to null are removed.
+type MetadataPatch map[string]*string
+
+// ValidateMetadata checks the keys and values of custom metadata.
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > MaxMetadataKeys {
+		return fmt.Errorf("at most %d metadata keys are allowed", MaxMetadataKeys)
+	}
+	for key, value := range metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid metadata key %q", key)
+		}
+		if len(value) > MaxMetadataValueLength {
+			return fmt.Errorf("metadata value of %q is too long", key)
+		}
+	}
+	return nil
+}
+
+// ApplyPatch returns metadata with the patch applied.
+func (m CustomMetadata) ApplyPatch(patch MetadataPatch) CustomMetadata {
+	merged := CustomMetadata{}
+	for key, value := range m {
+		merged[key] = value
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = *value
+		}
+	}
+	return merged
+}
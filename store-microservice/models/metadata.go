@@ -1,14 +1,20 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// FileTag is a tag of one owner. Names are normalized with NormalizeTag and
+// unique per owner.
 type FileTag struct {
 	gorm.Model
-	Name string
+	OwnerID uint `gorm:"index"`
+	Name    string
 }
 
 type File struct {
@@ -17,21 +23,82 @@ type File struct {
 	FileType  string
 	FileSize  int64
 	FileTags  []FileTag `gorm:"many2many:file_file_tag;"`
+	OwnerID   uint      `gorm:"index"`
+	FolderID  *uint     `gorm:"index"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
+
+	// CurrentVersion is the version downloads return by default. FileType
+	// and FileSize are those of the current version.
+	CurrentVersion int
+	Versions       []FileVersion
+
+	Metadata CustomMetadata `gorm:"type:jsonb;not null;default:'{}';index:,type:gin"`
+	// DeclaredType is the type the client sent; FileType was detected from
+	// the content by the retrieval service.
+	DeclaredType string
+}
+
+// FileVersion is one upload of a file. The content is saved under StorageKey
+// in the storage folder.
+type FileVersion struct {
+	gorm.Model
+	FileID       uint `gorm:"uniqueIndex:idx_file_version"`
+	Version      int  `gorm:"uniqueIndex:idx_file_version"`
+	FileType     string
+	DeclaredType string
+	FileSize     int64
+	StorageKey   string `gorm:"index"`
+	// ScanStatus is the scanner's verdict, models.ScanClean or
+	// models.ScanSkipped; infected uploads are never saved as versions.
+	ScanStatus string
+	// ThumbnailStatus is models.ThumbnailPending until the thumbnails of an
+	// image are rendered; ThumbnailType is their MIME type.
+	ThumbnailStatus string
+	ThumbnailType   string
+	// ContentVector indexes the text extracted from the content. It is
+	// written with to_tsvector and only used in queries.
+	ContentVector string `gorm:"type:tsvector;index:,type:gin;->:false;<-:false"`
 }
 
+// ETag identifies the content of the version. Versions are never changed
+// once saved, so it is a strong validator.
+func (v FileVersion) ETag() string {
+	sum := sha256.Sum256([]byte(strconv.FormatUint(uint64(v.ID), 10) + ":" + v.StorageKey))
+	return hex.EncodeToString(sum[:16])
+}
+
+// FileData is an upload sent to the store on file-data-queue. FileBytes
+// carries the content, base64 encoded in the JSON message.
 type FileData struct {
-	FileName  string   `json:"file_name"`
-	FileType  string   `json:"file_type"`
-	FileSize  int64    `json:"file_size"`
-	FileTags  []string `json:"file_tags"`
-	FileBytes []byte   `json:"-"`
-	TagName   []string `json:"tag_name"`
-	Type      string   `json:"type"`
+	FileName   string   `json:"file_name"`
+	FileType   string   `json:"file_type"`
+	FileSize   int64    `json:"file_size"`
+	FileTags   []string `json:"file_tags"`
+	FileBytes  []byte   `json:"file_bytes"`
+	TagName    []string `json:"tag_name"`
+	Type       string   `json:"type"`
+	OwnerID    uint     `json:"owner_id"`
+	QuotaBytes int64    `json:"quota_bytes"`
+	// FolderID is the owner's folder the file is saved in, nil for the root.
+	FolderID *uint `json:"folder_id,omitempty"`
+
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	DeclaredType string            `json:"declared_type,omitempty"`
+	// Archive names the archive the file was extracted from.
+	Archive string `json:"-"`
 }
 
 type FileRequest struct {
-	Name string   `json:"name"`
-	Tags []string `json:"tags"`
+	Name     string   `json:"name"`
+	Tags     []string `json:"tags"`
+	OwnerID  uint     `json:"owner_id"`
+	GroupIDs []uint   `json:"group_ids,omitempty"`
+}
+
+const UserEventDeleted = "user.deleted"
+
+type UserEvent struct {
+	Event  string `json:"event"`
+	UserID uint   `json:"user_id"`
 }
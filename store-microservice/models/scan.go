@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Scan verdicts saved on file versions and reported in UploadStatus.
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	// ScanSkipped is the verdict when no scanner is configured.
+	ScanSkipped = "not_scanned"
+)
+
+// QuarantinedFile is an upload a scanner found infected. Its content is saved
+// encrypted under StorageKey in the quarantine folder and never served.
+type QuarantinedFile struct {
+	ID           uint `gorm:"primarykey"`
+	OwnerID      uint `gorm:"index"`
+	FileName     string
+	FileType     string
+	DeclaredType string
+	FileSize     int64
+	StorageKey   string
+	Signature    string
+	Metadata     CustomMetadata `gorm:"type:jsonb;not null;default:'{}'"`
+	CreatedAt    time.Time
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+const (
+	FileActionSearch      = "search"
+	FileActionListArchive = "list_archive"
+)
+
+const (
+	TagMatchAll = "all"
+	TagMatchAny = "any"
+)
+
+const (
+	SortByName      = "name"
+	SortBySize      = "size"
+	SortByCreatedAt = "created_at"
+	// SortByRelevance ranks full-text matches and needs a Query.
+	SortByRelevance = "relevance"
+)
+
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// FileQuery is the payload of the search action. Every filter is optional.
+type FileQuery struct {
+	Query         string     `json:"q,omitempty"`
+	Name          string     `json:"name,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+	TagMatch      string     `json:"tag_match,omitempty"`
+	FileType      string     `json:"file_type,omitempty"`
+	CreatedAfter  *time.Time `json:"created_after,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+	MinSize       *int64     `json:"min_size,omitempty"`
+	MaxSize       *int64     `json:"max_size,omitempty"`
+	// SharedWithMe only matches files of other owners shared with the caller.
+	SharedWithMe bool `json:"shared_with_me,omitempty"`
+	// Metadata matches files whose custom metadata has all these values.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Sort     string            `json:"sort,omitempty"`
+	Order    string            `json:"order,omitempty"`
+	Limit    int               `json:"limit,omitempty"`
+	Cursor   string            `json:"cursor,omitempty"`
+}
+
+// FileSearchResult is one page of search results. NextCursor is empty on the
+// last page.
+type FileSearchResult struct {
+	Files      []FileInfo `json:"files"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// ArchiveListPayload selects the files of a bulk download. The reply holds at
+// most one file more than MaxFiles, so the caller can tell there are too many.
+type ArchiveListPayload struct {
+	Query    FileQuery `json:"query"`
+	MaxFiles int       `json:"max_files,omitempty"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "strings"
+
+const (
+	FileActionListTags   = "list_tags"
+	FileActionAddTags    = "add_tags"
+	FileActionRemoveTags = "remove_tags"
+	FileActionRenameTag  = "rename_tag"
+	FileActionMergeTags  = "merge_tags"
+)
+
+// MaxTagLength is the longest tag name in bytes.
+const MaxTagLength = 64
+
+// TagsPayload lists the tags for the add_tags and remove_tags actions.
+type TagsPayload struct {
+	Tags []string `json:"tags"`
+}
+
+type RenameTagPayload struct {
+	Name    string `json:"name"`
+	NewName string `json:"new_name"`
+}
+
+// MergeTagsPayload moves the files of every source tag to the target tag and
+// deletes the sources.
+type MergeTagsPayload struct {
+	Sources []string `json:"sources"`
+	Target  string   `json:"target"`
+}
+
+type TagInfo struct {
+	Name      string `json:"name"`
+	FileCount int64  `json:"file_count"`
+}
+
+// NormalizeTag trims and case-folds a tag name and collapses inner white
+// space.
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// NormalizeTags normalizes the names and drops empty and repeated ones.
+func NormalizeTags(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	tags := make([]string, 0, len(names))
+	for _, name := range names {
+		tag := NormalizeTag(name)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
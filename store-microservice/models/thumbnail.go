@@ -0,0 +1,25 @@
+package models
+
+const FileActionThumbnail = "thumbnail"
+
+// Thumbnail states saved on file versions. Versions of other types have
+// none.
+const (
+	ThumbnailPending = "pending"
+	ThumbnailReady   = "ready"
+	ThumbnailFailed  = "failed"
+)
+
+// ThumbnailJob asks the thumbnail worker to render the thumbnails of a
+// version.
+type ThumbnailJob struct {
+	FileVersionID uint `json:"file_version_id"`
+}
+
+// ThumbnailPayload selects the version and the size in pixels for the
+// thumbnail action. Version 0 is the current version and Size 0 the default
+// size.
+type ThumbnailPayload struct {
+	Version int `json:"version"`
+	Size    int `json:"size"`
+}
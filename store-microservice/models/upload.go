@@ -0,0 +1,13 @@
+package models
+
+// UploadChunk is part of a resumable upload sent by the retrieval service.
+// The file is saved when the Final chunk arrives, which has no bytes but the
+// file's metadata; Abort drops what was received.
+type UploadChunk struct {
+	UploadID string    `json:"upload_id"`
+	Offset   int64     `json:"offset"`
+	Bytes    []byte    `json:"bytes,omitempty"`
+	Final    bool      `json:"final,omitempty"`
+	Abort    bool      `json:"abort,omitempty"`
+	File     *FileData `json:"file,omitempty"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "gorm.io/gorm"
+
+// UserUsage is the storage used by one owner, kept up to date on every save
+// and delete.
+type UserUsage struct {
+	gorm.Model
+	OwnerID   uint `gorm:"uniqueIndex"`
+	UsedBytes int64
+	FileCount int64
+}
+
+const (
+	UploadStatusStored   = "stored"
+	UploadStatusRejected = "rejected"
+	// UploadStatusPurged only reports the new usage after files were purged.
+	UploadStatusPurged = "purged"
+
+	RejectQuotaExceeded = "quota_exceeded"
+	RejectVolumeLimit   = "volume_limit"
+	RejectStorageError  = "storage_error"
+	// RejectInfected uploads are quarantined, RejectScanFailed ones dropped.
+	RejectInfected   = "infected"
+	RejectScanFailed = "scan_failed"
+	// RejectFolderNotFound uploads name a folder the owner does not have.
+	RejectFolderNotFound = "folder_not_found"
+)
+
+// UploadStatus tells the retrieval service what happened to an upload.
+type UploadStatus struct {
+	OwnerID   uint   `json:"owner_id"`
+	FileName  string `json:"file_name"`
+	FileSize  int64  `json:"file_size"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+	UsedBytes int64  `json:"used_bytes"`
+	FileCount int64  `json:"file_count"`
+
+	// ScanStatus is the scanner's verdict; Signature names what an infected
+	// file contained.
+	ScanStatus string `json:"scan_status,omitempty"`
+	Signature  string `json:"signature,omitempty"`
+	// Archive names the archive a file was extracted from. The retrieval
+	// service reserved quota for the archive, not for its files.
+	Archive string `json:"archive,omitempty"`
+}
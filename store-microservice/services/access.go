@@ -0,0 +1,268 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// AccessService lets owners share files, tags and folders with other users
+// and with groups. Groups are kept by the retrieval service, which sends the groups of
+// the caller with every command.
+type AccessService struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewAccessService(db *gorm.DB) *AccessService {
+	log := utils.GetLogger()
+	return &AccessService{db, log}
+}
+
+func (as *AccessService) RegisterCommands(commands *CommandService) {
+	commands.Register(models.FileActionGrantAccess, as.GrantAccess)
+	commands.Register(models.FileActionRevokeAccess, as.RevokeAccess)
+	commands.Register(models.FileActionListAccess, as.ListAccess)
+}
+
+// GrantAccess gives a user or a group access to the owner's file, tag or
+// folder.
+// Granting the same principal again changes its permission.
+func (as *AccessService) GrantAccess(command *models.FileCommand) (interface{}, error) {
+	var payload models.ACLPayload
+	if err := json.Unmarshal(command.Payload, &payload); err != nil {
+		return nil, utils.ErrInvalidCommand
+	}
+	switch {
+	case payload.PrincipalType != models.PrincipalUser && payload.PrincipalType != models.PrincipalGroup,
+		payload.Permission != models.PermissionRead && payload.Permission != models.PermissionWrite,
+		payload.PrincipalID == 0,
+		payload.PrincipalType == models.PrincipalUser && payload.PrincipalID == command.OwnerID:
+		return nil, utils.ErrInvalidCommand
+	}
+
+	target, err := as.findTarget(command, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := models.ACLEntry{
+		OwnerID:       command.OwnerID,
+		FileID:        target.FileID,
+		TagID:         target.TagID,
+		FolderID:      target.FolderID,
+		PrincipalType: payload.PrincipalType,
+		PrincipalID:   payload.PrincipalID,
+	}
+	err = as.target(as.db, target).
+		Where("principal_type = ? AND principal_id = ?", entry.PrincipalType, entry.PrincipalID).
+		Attrs(models.ACLEntry{Permission: payload.Permission}).
+		FirstOrCreate(&entry).Error
+	if err == nil && entry.Permission != payload.Permission {
+		err = as.db.Model(&entry).Update("permission", payload.Permission).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	as.log.Info("Access granted",
+		zap.Uint("ownerID", command.OwnerID),
+		zap.String("principalType", entry.PrincipalType),
+		zap.Uint("principalID", entry.PrincipalID),
+		zap.String("permission", entry.Permission),
+	)
+	return convertACLEntryToInfo(entry, payload.Tag), nil
+}
+
+// RevokeAccess deletes one access entry of the owner's file, tag or folder.
+func (as *AccessService) RevokeAccess(command *models.FileCommand) (interface{}, error) {
+	var payload models.ACLPayload
+	if err := json.Unmarshal(command.Payload, &payload); err != nil || payload.EntryID == 0 {
+		return nil, utils.ErrInvalidCommand
+	}
+	target, err := as.findTarget(command, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	result := as.target(as.db.Unscoped(), target).Where("id = ?", payload.EntryID).Delete(&models.ACLEntry{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, utils.ErrAccessEntryNotFound
+	}
+
+	as.log.Info("Access revoked", zap.Uint("ownerID", command.OwnerID), zap.Uint("entryID", payload.EntryID))
+	return nil, nil
+}
+
+// ListAccess returns the access entries of the owner's file, tag or folder.
+// Access a file has through its tags and folders is listed on those.
+func (as *AccessService) ListAccess(command *models.FileCommand) (interface{}, error) {
+	var payload models.ACLPayload
+	if len(command.Payload) > 0 {
+		if err := json.Unmarshal(command.Payload, &payload); err != nil {
+			return nil, utils.ErrInvalidCommand
+		}
+	}
+	target, err := as.findTarget(command, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []models.ACLEntry
+	if err := as.target(as.db, target).Order("id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	infos := make([]models.ACLEntryInfo, len(entries))
+	for i, entry := range entries {
+		infos[i] = convertACLEntryToInfo(entry, payload.Tag)
+	}
+	return infos, nil
+}
+
+// DeleteUserAccess deletes the entries the user granted and the entries
+// granted to the user.
+func (as *AccessService) DeleteUserAccess(userID uint) error {
+	return as.db.Unscoped().
+		Where("owner_id = ? OR (principal_type = ? AND principal_id = ?)", userID, models.PrincipalUser, userID).
+		Delete(&models.ACLEntry{}).Error
+}
+
+// findTarget returns the owner's tag or folder if the payload names one, or
+// else the command's file, as the ids of an entry.
+func (as *AccessService) findTarget(command *models.FileCommand, payload *models.ACLPayload) (*models.ACLEntry, error) {
+	if payload.FolderID != 0 {
+		var folder models.Folder
+		err := as.db.Where("id = ? AND owner_id = ?", payload.FolderID, command.OwnerID).First(&folder).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrFolderNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &models.ACLEntry{FolderID: &folder.ID}, nil
+	}
+
+	if payload.Tag != "" {
+		var fileTag models.FileTag
+		err := as.db.Where("owner_id = ? AND name = ?", command.OwnerID, models.NormalizeTag(payload.Tag)).First(&fileTag).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrTagNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &models.ACLEntry{TagID: &fileTag.ID}, nil
+	}
+
+	var file models.File
+	err := as.db.Where("id = ? AND owner_id = ?", command.FileID, command.OwnerID).First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.ACLEntry{FileID: &file.ID}, nil
+}
+
+func (as *AccessService) target(tx *gorm.DB, target *models.ACLEntry) *gorm.DB {
+	if target.FolderID != nil {
+		return tx.Where("folder_id = ?", *target.FolderID)
+	}
+	if target.TagID != nil {
+		return tx.Where("tag_id = ?", *target.TagID)
+	}
+	return tx.Where("file_id = ?", *target.FileID)
+}
+
+func convertACLEntryToInfo(entry models.ACLEntry, tag string) models.ACLEntryInfo {
+	info := models.ACLEntryInfo{
+		ID:            entry.ID,
+		PrincipalType: entry.PrincipalType,
+		PrincipalID:   entry.PrincipalID,
+		Permission:    entry.Permission,
+		CreatedAt:     entry.CreatedAt,
+	}
+	switch {
+	case entry.FileID != nil:
+		info.FileID = *entry.FileID
+	case entry.FolderID != nil:
+		info.FolderID = *entry.FolderID
+	default:
+		info.Tag = models.NormalizeTag(tag)
+	}
+	return info
+}
+
+// withAccess limits a query on the files table to the files the principal
+// owns or was granted permission on, directly, through one of the file's
+// tags or through its folder or a folder above. Write access includes read.
+func withAccess(principal models.Principal, permission string) func(*gorm.DB) *gorm.DB {
+	grants, vars := aclGrants(principal, permission)
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(`(files.owner_id = ? OR EXISTS (
+			SELECT 1 FROM acl_entries WHERE `+grants+`
+				AND (acl_entries.file_id = files.id
+					OR acl_entries.tag_id IN (SELECT file_tag_id FROM file_file_tag WHERE file_file_tag.file_id = files.id)
+					OR (acl_entries.folder_id IS NOT NULL AND EXISTS (SELECT 1 FROM folders WHERE folders.id = files.folder_id
+						AND folders.path LIKE CONCAT('%/', acl_entries.folder_id, '/%'))))))`,
+			append([]interface{}{principal.UserID}, vars...)...,
+		)
+	}
+}
+
+// withFolderAccess limits a query on the folders table to the folders the
+// principal owns or was granted permission on, on the folder or above it.
+func withFolderAccess(principal models.Principal, permission string) func(*gorm.DB) *gorm.DB {
+	grants, vars := aclGrants(principal, permission)
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(`(folders.owner_id = ? OR EXISTS (
+			SELECT 1 FROM acl_entries WHERE `+grants+`
+				AND acl_entries.folder_id IS NOT NULL
+				AND folders.path LIKE CONCAT('%/', acl_entries.folder_id, '/%')))`,
+			append([]interface{}{principal.UserID}, vars...)...,
+		)
+	}
+}
+
+// aclGrants is the condition on acl_entries that matches the entries giving
+// the principal permission.
+func aclGrants(principal models.Principal, permission string) (string, []interface{}) {
+	permissions := []string{models.PermissionWrite}
+	if permission == models.PermissionRead {
+		permissions = append(permissions, models.PermissionRead)
+	}
+	groupIDs := principal.GroupIDs
+	if len(groupIDs) == 0 {
+		// IN () is not valid SQL and group ids start at 1.
+		groupIDs = []uint{0}
+	}
+
+	return `acl_entries.deleted_at IS NULL
+				AND acl_entries.permission IN ?
+				AND ((acl_entries.principal_type = ? AND acl_entries.principal_id = ?)
+					OR (acl_entries.principal_type = ? AND acl_entries.principal_id IN ?))`,
+		[]interface{}{permissions, models.PrincipalUser, principal.UserID, models.PrincipalGroup, groupIDs}
+}
+
+// findAccessibleFile returns the command's file if the caller has permission
+// on it.
+func findAccessibleFile(tx *gorm.DB, command *models.FileCommand, permission string) (*models.File, error) {
+	var file models.File
+	err := tx.Scopes(withAccess(command.Principal(), permission)).Where("files.id = ?", command.FileID).First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
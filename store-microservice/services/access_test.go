@@ -0,0 +1,124 @@
+package services_test
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"store/models"
+	"store/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// accessibleFiles returns which of the files the principal has permission on.
+func accessibleFiles(t *testing.T, db *gorm.DB, principal models.Principal, permission string, files ...*models.File) []string {
+	ids := make([]uint, len(files))
+	for i, file := range files {
+		ids[i] = file.ID
+	}
+	var names []string
+	err := db.Model(&models.File{}).Scopes(services.WithAccess(principal, permission)).
+		Where("files.id IN ?", ids).Order("files.file_name").Pluck("files.file_name", &names).Error
+	require.NoError(t, err)
+	return names
+}
+
+// accessibleFolders returns which of the folders the principal has
+// permission on.
+func accessibleFolders(t *testing.T, db *gorm.DB, principal models.Principal, permission string, ids ...uint) []string {
+	var names []string
+	err := db.Model(&models.Folder{}).Scopes(services.WithFolderAccess(principal, permission)).
+		Where("folders.id IN ?", ids).Order("folders.name").Pluck("folders.name", &names).Error
+	require.NoError(t, err)
+	return names
+}
+
+func moveToFolder(t *testing.T, db *gorm.DB, file *models.File, folderID uint) {
+	require.NoError(t, db.Model(file).Update("folder_id", folderID).Error)
+}
+
+func TestAccess_Grants(t *testing.T) {
+	db := openTestDB(t)
+	access := services.NewAccessService(db)
+	tags := services.NewTagService(db)
+	folders := services.NewFolderService(db)
+	ownerID, userID, strangerID, groupID := testOwnerID(), testOwnerID(), testOwnerID(), testOwnerID()
+
+	direct := createFile(t, db, ownerID, "direct.txt")
+	tagged := createFile(t, db, ownerID, "tagged.txt")
+	require.NoError(t, addTags(t, tags, ownerID, tagged.ID, "team"))
+	parent := createFolder(t, folders, ownerID, "parent")
+	childInfo, err := folders.CreateFolder(folderCommand(t, models.FileActionCreateFolder, ownerID, models.FolderPayload{ParentID: &parent.ID, Name: "child"}))
+	require.NoError(t, err)
+	child := childInfo.(*models.FolderInfo)
+	inParent := createFile(t, db, ownerID, "parent.txt")
+	moveToFolder(t, db, inParent, parent.ID)
+	inChild := createFile(t, db, ownerID, "child.txt")
+	moveToFolder(t, db, inChild, child.ID)
+	private := createFile(t, db, ownerID, "private.txt")
+	files := []*models.File{direct, tagged, inParent, inChild, private}
+
+	directEntry := grantAccess(t, access, ownerID, direct.ID, models.ACLPayload{PrincipalType: models.PrincipalUser, PrincipalID: userID, Permission: models.PermissionRead})
+	tagEntry := grantAccess(t, access, ownerID, 0, models.ACLPayload{Tag: "team", PrincipalType: models.PrincipalUser, PrincipalID: userID, Permission: models.PermissionWrite})
+	grantAccess(t, access, ownerID, 0, models.ACLPayload{FolderID: parent.ID, PrincipalType: models.PrincipalGroup, PrincipalID: groupID, Permission: models.PermissionRead})
+
+	user := models.Principal{UserID: userID}
+	member := models.Principal{UserID: userID, GroupIDs: []uint{groupID}}
+	stranger := models.Principal{UserID: strangerID, GroupIDs: []uint{groupID + 1}}
+
+	// Test case: the owner has every permission on their files
+	assert.Len(t, accessibleFiles(t, db, models.Principal{UserID: ownerID}, models.PermissionWrite, files...), len(files))
+
+	// Test case: direct and tag grants; write includes read, read is not write
+	assert.Equal(t, []string{"direct.txt", "tagged.txt"}, accessibleFiles(t, db, user, models.PermissionRead, files...))
+	assert.Equal(t, []string{"tagged.txt"}, accessibleFiles(t, db, user, models.PermissionWrite, files...))
+
+	// Test case: a group grant on a folder covers its files and subfolders
+	assert.Equal(t, []string{"child.txt", "direct.txt", "parent.txt", "tagged.txt"}, accessibleFiles(t, db, member, models.PermissionRead, files...))
+	assert.Equal(t, []string{"tagged.txt"}, accessibleFiles(t, db, member, models.PermissionWrite, files...))
+	assert.Equal(t, []string{"child", "parent"}, accessibleFolders(t, db, member, models.PermissionRead, parent.ID, child.ID))
+	assert.Empty(t, accessibleFolders(t, db, member, models.PermissionWrite, parent.ID, child.ID))
+	assert.Empty(t, accessibleFolders(t, db, user, models.PermissionRead, parent.ID, child.ID))
+
+	// Test case: other users and groups get nothing
+	assert.Empty(t, accessibleFiles(t, db, stranger, models.PermissionRead, files...))
+	assert.Empty(t, accessibleFolders(t, db, stranger, models.PermissionRead, parent.ID, child.ID))
+
+	// Test case: a grant on a subfolder does not reach the folder above
+	grantAccess(t, access, ownerID, 0, models.ACLPayload{FolderID: child.ID, PrincipalType: models.PrincipalUser, PrincipalID: strangerID, Permission: models.PermissionWrite})
+	assert.Equal(t, []string{"child.txt"}, accessibleFiles(t, db, stranger, models.PermissionWrite, files...))
+	assert.Equal(t, []string{"child"}, accessibleFolders(t, db, stranger, models.PermissionWrite, parent.ID, child.ID))
+
+	// Test case: revoked and deleted entries give no access
+	_, err = access.RevokeAccess(fileCommand(t, models.FileActionRevokeAccess, ownerID, direct.ID, models.ACLPayload{EntryID: directEntry.ID}))
+	require.NoError(t, err)
+	require.NoError(t, db.Delete(&models.ACLEntry{}, tagEntry.ID).Error)
+	assert.Empty(t, accessibleFiles(t, db, user, models.PermissionRead, files...))
+	assert.Equal(t, []string{"child.txt", "parent.txt"}, accessibleFiles(t, db, member, models.PermissionRead, files...))
+}
+
+func TestAccess_FolderIDPrefix(t *testing.T) {
+	db := openTestDB(t)
+	access := services.NewAccessService(db)
+	ownerID, userID := testOwnerID(), testOwnerID()
+
+	// The ids are picked far above the sequence, so that one is a prefix of
+	// the other: a grant on folder n must not match the path of folder n1.
+	id := uint(1_000_000_000_000 + rand.Int63n(1_000_000_000))
+	short := models.Folder{Model: gorm.Model{ID: id}, OwnerID: ownerID, Name: "short", Path: "/" + strconv.FormatUint(uint64(id), 10) + "/"}
+	long := models.Folder{Model: gorm.Model{ID: id*10 + 1}, OwnerID: ownerID, Name: "long", Path: "/" + strconv.FormatUint(uint64(id*10+1), 10) + "/"}
+	require.NoError(t, db.Create(&short).Error)
+	require.NoError(t, db.Create(&long).Error)
+	inShort := createFile(t, db, ownerID, "short.txt")
+	moveToFolder(t, db, inShort, short.ID)
+	inLong := createFile(t, db, ownerID, "long.txt")
+	moveToFolder(t, db, inLong, long.ID)
+
+	grantAccess(t, access, ownerID, 0, models.ACLPayload{FolderID: short.ID, PrincipalType: models.PrincipalUser, PrincipalID: userID, Permission: models.PermissionRead})
+	user := models.Principal{UserID: userID}
+	assert.Equal(t, []string{"short"}, accessibleFolders(t, db, user, models.PermissionRead, short.ID, long.ID))
+	assert.Equal(t, []string{"short.txt"}, accessibleFiles(t, db, user, models.PermissionRead, inShort, inLong))
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+)
+
+// ArchiveLimits guard extraction against zip bombs. MaxBytes caps the
+// extracted size, which also may not exceed MaxRatio times the archive size.
+type ArchiveLimits struct {
+	MaxEntries int
+	MaxBytes   int64
+	MaxRatio   int64
+}
+
+func DefaultArchiveLimits() ArchiveLimits {
+	return ArchiveLimits{MaxEntries: 1000, MaxBytes: 1 << 30, MaxRatio: 100}
+}
+
+// ArchiveService expands uploaded archives into one file per entry.
+type ArchiveService struct {
+	ingest *IngestService
+	limits ArchiveLimits
+	log    *zap.Logger
+}
+
+func NewArchiveService(ingest *IngestService, limits ArchiveLimits) *ArchiveService {
+	log := utils.GetLogger()
+	return &ArchiveService{ingest, limits, log}
+}
+
+func (as *ArchiveService) RegisterCommands(commands *CommandService) {
+	commands.Register(models.FileActionExtractArchive, as.ExtractArchive)
+}
+
+// archiveEntry is a file in an archive; read returns its content.
+type archiveEntry struct {
+	name    string
+	dir     bool
+	regular bool
+	read    func(limit int64) ([]byte, error)
+}
+
+// ExtractArchive checks the whole archive against the limits before any of
+// its files is saved, then ingests the entries one by one.
+func (as *ArchiveService) ExtractArchive(command *models.FileCommand) (interface{}, error) {
+	var payload models.ArchivePayload
+	if err := json.Unmarshal(command.Payload, &payload); err != nil {
+		return nil, utils.ErrInvalidCommand
+	}
+	archive := &payload.File
+	archive.OwnerID = command.OwnerID
+
+	budget := as.limits.MaxBytes
+	if ratioBudget := as.limits.MaxRatio * int64(len(archive.FileBytes)); as.limits.MaxRatio > 0 && ratioBudget < budget {
+		budget = ratioBudget
+	}
+
+	// The first pass only reads, so a bomb is found before anything is saved.
+	var entries, total int64
+	err := as.walk(archive.FileBytes, func(entry archiveEntry) error {
+		entries++
+		if as.limits.MaxEntries > 0 && entries > int64(as.limits.MaxEntries) {
+			return utils.ErrArchiveTooLarge
+		}
+		if !entry.regular {
+			return nil
+		}
+		content, err := entry.read(budget - total)
+		total += int64(len(content))
+		return err
+	})
+	if err != nil {
+		as.log.Warn("Archive rejected", zap.String("fileName", archive.FileName), zap.Uint("ownerID", archive.OwnerID), zap.Error(err))
+		return nil, err
+	}
+
+	results := []models.ArchiveEntryResult{}
+	err = as.walk(archive.FileBytes, func(entry archiveEntry) error {
+		name, safe := safeEntryName(entry.name)
+		result := models.ArchiveEntryResult{FileName: name, Status: models.ArchiveEntrySkipped}
+		switch {
+		case entry.dir:
+			return nil
+		case !entry.regular:
+			result.FileName, result.Reason = entry.name, models.SkipNotRegular
+		case !safe:
+			result.FileName, result.Reason = entry.name, models.SkipUnsafePath
+		default:
+			content, err := entry.read(budget)
+			if err != nil {
+				return err
+			}
+			result = as.ingestEntry(&payload, name, content)
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	as.log.Info("Archive extracted", zap.String("fileName", archive.FileName), zap.Int("entries", len(results)))
+	return results, nil
+}
+
+func (as *ArchiveService) ingestEntry(payload *models.ArchivePayload, name string, content []byte) models.ArchiveEntryResult {
+	fileType := DetectFileType(name, content)
+	result := models.ArchiveEntryResult{FileName: name, FileType: fileType, FileSize: int64(len(content)), Status: models.UploadStatusRejected}
+
+	switch {
+	case payload.MaxFileSize > 0 && result.FileSize > payload.MaxFileSize:
+		result.Reason = models.RejectTooLarge
+		return result
+	case matchesAny(fileType, payload.DeniedTypes), len(payload.AllowedTypes) > 0 && !matchesAny(fileType, payload.AllowedTypes):
+		result.Reason = models.RejectTypeDenied
+		return result
+	}
+
+	archive := payload.File
+	status, err := as.ingest.Ingest(&models.FileData{
+		FileName:   name,
+		FileType:   fileType,
+		FileSize:   result.FileSize,
+		FileTags:   archive.FileTags,
+		FileBytes:  content,
+		TagName:    archive.TagName,
+		OwnerID:    archive.OwnerID,
+		QuotaBytes: archive.QuotaBytes,
+		FolderID:   archive.FolderID,
+		Metadata:   archive.Metadata,
+		Archive:    archive.FileName,
+	})
+	if err != nil {
+		as.log.Error("Failed to publish upload status", zap.String("fileName", name), zap.Error(err))
+	}
+	result.Status, result.Reason, result.ScanStatus = status.Status, status.Reason, status.ScanStatus
+	return result
+}
+
+// walk calls fn for each entry of a .zip, .tar or .tar.gz archive.
+func (as *ArchiveService) walk(data []byte, fn func(entry archiveEntry) error) error {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")), bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return walkZip(data, fn)
+	case bytes.HasPrefix(data, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return utils.ErrInvalidArchive
+		}
+		defer gz.Close()
+		return walkTar(gz, fn)
+	case len(data) > 262 && string(data[257:262]) == "ustar":
+		return walkTar(bytes.NewReader(data), fn)
+	}
+	return utils.ErrInvalidArchive
+}
+
+func walkZip(data []byte, fn func(entry archiveEntry) error) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return utils.ErrInvalidArchive
+	}
+	for _, file := range zr.File {
+		file := file
+		err := fn(archiveEntry{
+			name:    file.Name,
+			dir:     file.Mode().IsDir(),
+			regular: file.Mode().IsRegular(),
+			read: func(limit int64) ([]byte, error) {
+				// The sizes in the headers are not trusted.
+				if file.UncompressedSize64 > uint64(limit) {
+					return nil, utils.ErrArchiveTooLarge
+				}
+				rc, err := file.Open()
+				if err != nil {
+					return nil, utils.ErrInvalidArchive
+				}
+				defer rc.Close()
+				return readLimited(rc, limit)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(r io.Reader, fn func(entry archiveEntry) error) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return utils.ErrInvalidArchive
+		}
+		err = fn(archiveEntry{
+			name:    header.Name,
+			dir:     header.Typeflag == tar.TypeDir,
+			regular: header.Typeflag == tar.TypeReg,
+			read: func(limit int64) ([]byte, error) {
+				return readLimited(tr, limit)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLimited reads r and fails once more than limit bytes come out.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit < 0 {
+		limit = 0
+	}
+	content, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, utils.ErrInvalidArchive
+	}
+	if int64(len(content)) > limit {
+		return nil, utils.ErrArchiveTooLarge
+	}
+	return content, nil
+}
+
+// safeEntryName cleans an entry path. Absolute paths and paths with ".."
+// are not safe, so they cannot escape a folder the files are extracted to.
+func safeEntryName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return name, false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return name, false
+		}
+	}
+	return path.Clean(name), true
+}
@@ -0,0 +1,199 @@
+package services_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"store/models"
+	"store/services"
+	"store/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type archiveFile struct {
+	name    string
+	content []byte
+}
+
+func zipArchive(t *testing.T, files ...archiveFile) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		require.NoError(t, err)
+		_, err = w.Write(file.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, files ...archiveFile) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(file.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// lyingZipArchive stores content under a header that claims it is size
+// bytes long.
+func lyingZipArchive(t *testing.T, content []byte, size uint64) []byte {
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+	require.NoError(t, err)
+	_, err = fw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "small.txt",
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(content),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: size,
+	})
+	require.NoError(t, err)
+	_, err = w.Write(compressed.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func extractCommand(t *testing.T, data []byte) *models.FileCommand {
+	body, err := json.Marshal(models.ArchivePayload{File: models.FileData{FileName: "upload.zip", FileBytes: data}})
+	require.NoError(t, err)
+	return &models.FileCommand{Action: models.FileActionExtractArchive, OwnerID: 1, Payload: body}
+}
+
+func TestSafeEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		safe bool
+	}{
+		{"report.pdf", "report.pdf", true},
+		{"docs/./report.pdf", "docs/report.pdf", true},
+		{"docs\\report.pdf", "docs/report.pdf", true},
+		{"docs/..report.pdf", "docs/..report.pdf", true},
+		{"", "", false},
+		{"../report.pdf", "../report.pdf", false},
+		{"docs/../../report.pdf", "docs/../../report.pdf", false},
+		{"docs/..", "docs/..", false},
+		{"..\\report.pdf", "../report.pdf", false},
+		{"/etc/passwd", "/etc/passwd", false},
+		{"\\etc\\passwd", "/etc/passwd", false},
+		{"C:/Windows/win.ini", "C:/Windows/win.ini", false},
+		{"c:win.ini", "c:win.ini", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, safe := services.SafeEntryName(tt.name)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.safe, safe)
+		})
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		limit   int64
+		err     error
+	}{
+		{"below the limit", "abc", 4, nil},
+		{"at the limit", "abcd", 4, nil},
+		{"over the limit", "abcde", 4, utils.ErrArchiveTooLarge},
+		{"empty with no budget", "", 0, nil},
+		{"content with no budget", "a", 0, utils.ErrArchiveTooLarge},
+		{"negative budget", "", -5, nil},
+		{"content with a negative budget", "a", -5, utils.ErrArchiveTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := services.ReadLimited(strings.NewReader(tt.content), tt.limit)
+			assert.Equal(t, tt.err, err)
+			if tt.err == nil {
+				assert.Equal(t, tt.content, string(content))
+			}
+		})
+	}
+}
+
+func TestArchiveService_ExtractArchiveLimits(t *testing.T) {
+	zeros := make([]byte, 1<<20)
+	small := []byte("hello")
+
+	tests := []struct {
+		name   string
+		data   []byte
+		limits services.ArchiveLimits
+		err    error
+	}{
+		{"zip bomb over the ratio", zipArchive(t, archiveFile{"zeros", zeros}), services.ArchiveLimits{MaxBytes: 1 << 30, MaxRatio: 100}, utils.ErrArchiveTooLarge},
+		{"tar.gz bomb over the ratio", tarGzArchive(t, archiveFile{"zeros", zeros}), services.ArchiveLimits{MaxBytes: 1 << 30, MaxRatio: 100}, utils.ErrArchiveTooLarge},
+		{"entries share the budget", zipArchive(t, archiveFile{"a", zeros[:600]}, archiveFile{"b", zeros[:600]}), services.ArchiveLimits{MaxBytes: 1000}, utils.ErrArchiveTooLarge},
+		// The zip reader stops at the size in the header, so content beyond it
+		// makes the archive invalid.
+		{"zip header understating the size", lyingZipArchive(t, zeros, 10), services.ArchiveLimits{MaxBytes: 1 << 30, MaxRatio: 100}, utils.ErrInvalidArchive},
+		{"over MaxBytes", zipArchive(t, archiveFile{"zeros", zeros}), services.ArchiveLimits{MaxBytes: 1 << 10}, utils.ErrArchiveTooLarge},
+		{"too many entries", zipArchive(t, archiveFile{"a", small}, archiveFile{"b", small}, archiveFile{"c", small}), services.ArchiveLimits{MaxEntries: 2, MaxBytes: 1 << 30}, utils.ErrArchiveTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Rejected archives never reach the ingest service.
+			archives := services.NewArchiveService(nil, tt.limits)
+			_, err := archives.ExtractArchive(extractCommand(t, tt.data))
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestArchiveService_ExtractArchiveSkipsUnsafePaths(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"zip", zipArchive(t, archiveFile{"../evil.sh", []byte("x")}, archiveFile{"/etc/passwd", []byte("x")}, archiveFile{"C:/evil.bat", []byte("x")})},
+		{"tar.gz", tarGzArchive(t, archiveFile{"../evil.sh", []byte("x")}, archiveFile{"/etc/passwd", []byte("x")}, archiveFile{"C:/evil.bat", []byte("x")})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Unsafe entries are skipped before they reach the ingest service.
+			archives := services.NewArchiveService(nil, services.DefaultArchiveLimits())
+			results, err := archives.ExtractArchive(extractCommand(t, tt.data))
+			require.NoError(t, err)
+			entries := results.([]models.ArchiveEntryResult)
+			require.Len(t, entries, 3)
+			for i, name := range []string{"../evil.sh", "/etc/passwd", "C:/evil.bat"} {
+				assert.Equal(t, name, entries[i].FileName)
+				assert.Equal(t, models.ArchiveEntrySkipped, entries[i].Status)
+				assert.Equal(t, models.SkipUnsafePath, entries[i].Reason)
+			}
+		})
+	}
+}
+
+func TestArchiveService_ExtractArchiveRejectsOtherFormats(t *testing.T) {
+	archives := services.NewArchiveService(nil, services.DefaultArchiveLimits())
+	_, err := archives.ExtractArchive(extractCommand(t, []byte("not an archive")))
+	assert.Equal(t, utils.ErrInvalidArchive, err)
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+)
+
+const FileChunksQueue = "file-chunks-queue"
+
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{1,64}$`)
+
+// ChunkService stages the chunks of resumable uploads and ingests a file
+// once all its chunks arrived. Staged chunks are encrypted with AES-CTR
+// under a random IV kept at the start of the staging file, so each chunk
+// can be written at its offset on its own.
+type ChunkService struct {
+	ingest      *IngestService
+	stagingPath string
+	secretKey   []byte
+	log         *zap.Logger
+}
+
+func NewChunkService(ingest *IngestService, stagingPath string, secretKey []byte) *ChunkService {
+	log := utils.GetLogger()
+	return &ChunkService{ingest, stagingPath, secretKey, log}
+}
+
+func (cs *ChunkService) HandleChunk(body []byte) error {
+	var chunk models.UploadChunk
+	if err := json.Unmarshal(body, &chunk); err != nil {
+		cs.log.Error("Failed to unmarshal upload chunk from message", zap.Error(err))
+		return err
+	}
+	if !uploadIDPattern.MatchString(chunk.UploadID) || chunk.Offset < 0 {
+		cs.log.Warn("Dropping invalid upload chunk", zap.String("uploadID", chunk.UploadID))
+		return utils.ErrInvalidCommand
+	}
+	stagingFile := filepath.Join(".", cs.stagingPath, chunk.UploadID)
+
+	switch {
+	case chunk.Abort:
+		cs.log.Info("Upload aborted", zap.String("uploadID", chunk.UploadID))
+		return removeStaged(stagingFile)
+	case chunk.Final:
+		if chunk.File == nil {
+			return utils.ErrInvalidCommand
+		}
+		content, err := cs.readStaged(stagingFile, chunk.Offset)
+		_ = removeStaged(stagingFile)
+		if err != nil {
+			cs.log.Error("Failed to read staged upload", zap.String("uploadID", chunk.UploadID), zap.Error(err))
+			_, err = cs.ingest.reject(chunk.File, models.RejectStorageError)
+			return err
+		}
+		chunk.File.FileBytes = content
+		chunk.File.FileSize = int64(len(content))
+		_, err = cs.ingest.Ingest(chunk.File)
+		return err
+	default:
+		return cs.writeStaged(stagingFile, chunk.Offset, chunk.Bytes)
+	}
+}
+
+func (cs *ChunkService) writeStaged(stagingFile string, offset int64, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(stagingFile), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(stagingFile, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		cs.log.Error("Failed to open staged upload", zap.String("filePath", stagingFile), zap.Error(err))
+		return err
+	}
+	defer file.Close()
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(file, iv); err != nil {
+		if _, err := rand.Read(iv); err != nil {
+			return err
+		}
+		if _, err := file.WriteAt(iv, 0); err != nil {
+			return err
+		}
+	}
+
+	key, _ := hex.DecodeString(string(cs.secretKey))
+	stream, err := utils.NewCTRAt(key, iv, offset)
+	if err != nil {
+		return err
+	}
+	encrypted := make([]byte, len(data))
+	stream.XORKeyStream(encrypted, data)
+	_, err = file.WriteAt(encrypted, aes.BlockSize+offset)
+	return err
+}
+
+// readStaged decrypts a staged upload, which has to be length bytes long.
+func (cs *ChunkService) readStaged(stagingFile string, length int64) ([]byte, error) {
+	data, err := os.ReadFile(stagingFile)
+	if os.IsNotExist(err) && length == 0 {
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != aes.BlockSize+length {
+		return nil, errors.New("staged upload is incomplete")
+	}
+
+	key, _ := hex.DecodeString(string(cs.secretKey))
+	stream, err := utils.NewCTRAt(key, data[:aes.BlockSize], 0)
+	if err != nil {
+		return nil, err
+	}
+	content := data[aes.BlockSize:]
+	stream.XORKeyStream(content, content)
+	return content, nil
+}
+
+// RemoveStale deletes staged uploads that were not written to for maxAge,
+// in case the retrieval service never finished or aborted them.
+func (cs *ChunkService) RemoveStale(maxAge time.Duration) error {
+	entries, err := os.ReadDir(filepath.Join(".", cs.stagingPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := removeStaged(filepath.Join(".", cs.stagingPath, entry.Name())); err != nil {
+			cs.log.Error("Failed to remove stale upload", zap.String("name", entry.Name()), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func removeStaged(stagingFile string) error {
+	if err := os.Remove(stagingFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
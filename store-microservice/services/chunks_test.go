@@ -0,0 +1,136 @@
+package services_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"store/models"
+	"store/services"
+	"store/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newChunkService stages uploads in a temporary working directory, as the
+// staging path is relative to it.
+func newChunkService(t *testing.T) *services.ChunkService {
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { _ = os.Chdir(dir) })
+
+	key := hex.EncodeToString(bytes.Repeat([]byte{0x42}, 32))
+	return services.NewChunkService(nil, "staging", []byte(key))
+}
+
+func sendChunk(t *testing.T, chunks *services.ChunkService, chunk models.UploadChunk) error {
+	body, err := json.Marshal(chunk)
+	require.NoError(t, err)
+	return chunks.HandleChunk(body)
+}
+
+func TestChunkService_Assembly(t *testing.T) {
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+
+	// Each case writes the [start, end) ranges of content in order.
+	tests := []struct {
+		name   string
+		ranges [][2]int
+	}{
+		{"one chunk", [][2]int{{0, 1000}}},
+		{"block aligned", [][2]int{{0, 256}, {256, 512}, {512, 1000}}},
+		{"unaligned", [][2]int{{0, 7}, {7, 24}, {24, 33}, {33, 1000}}},
+		{"out of order", [][2]int{{500, 1000}, {0, 17}, {17, 500}}},
+		{"retried chunk", [][2]int{{0, 300}, {300, 600}, {300, 600}, {600, 1000}}},
+		{"overlapping chunks", [][2]int{{0, 400}, {350, 700}, {650, 1000}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := newChunkService(t)
+			for _, r := range tt.ranges {
+				require.NoError(t, sendChunk(t, chunks, models.UploadChunk{UploadID: "abc", Offset: int64(r[0]), Bytes: content[r[0]:r[1]]}))
+			}
+
+			staged, err := os.ReadFile(filepath.Join("staging", "abc"))
+			require.NoError(t, err)
+			assert.Len(t, staged, 16+len(content))
+			assert.False(t, bytes.Contains(staged, content[:64]), "staged chunks are encrypted")
+
+			assembled, err := services.ReadStaged(chunks, filepath.Join("staging", "abc"), int64(len(content)))
+			require.NoError(t, err)
+			assert.Equal(t, content, assembled)
+		})
+	}
+}
+
+func TestChunkService_ReadStagedLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		written int
+		length  int64
+		wantErr bool
+	}{
+		{"complete", 100, 100, false},
+		{"missing the end", 100, 101, true},
+		{"longer than announced", 100, 99, true},
+		{"nothing staged for an empty file", 0, 0, false},
+		{"nothing staged", 0, 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := newChunkService(t)
+			if tt.written > 0 {
+				require.NoError(t, sendChunk(t, chunks, models.UploadChunk{UploadID: "abc", Bytes: bytes.Repeat([]byte("x"), tt.written)}))
+			}
+
+			content, err := services.ReadStaged(chunks, filepath.Join("staging", "abc"), tt.length)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, content, int(tt.length))
+		})
+	}
+}
+
+func TestChunkService_HandleChunkRejectsInvalidChunks(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk models.UploadChunk
+	}{
+		{"negative offset", models.UploadChunk{UploadID: "abc", Offset: -1, Bytes: []byte("x")}},
+		{"empty upload id", models.UploadChunk{Bytes: []byte("x")}},
+		{"path in upload id", models.UploadChunk{UploadID: "../abc", Bytes: []byte("x")}},
+		{"upper case upload id", models.UploadChunk{UploadID: "ABC", Bytes: []byte("x")}},
+		{"long upload id", models.UploadChunk{UploadID: strings.Repeat("a", 65), Bytes: []byte("x")}},
+		{"final chunk without file", models.UploadChunk{UploadID: "abc", Final: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := newChunkService(t)
+			assert.Equal(t, utils.ErrInvalidCommand, sendChunk(t, chunks, tt.chunk))
+		})
+	}
+}
+
+func TestChunkService_Abort(t *testing.T) {
+	chunks := newChunkService(t)
+	require.NoError(t, sendChunk(t, chunks, models.UploadChunk{UploadID: "abc", Bytes: []byte("hello")}))
+
+	require.NoError(t, sendChunk(t, chunks, models.UploadChunk{UploadID: "abc", Abort: true}))
+	_, err := os.Stat(filepath.Join("staging", "abc"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Test case: aborting twice is fine
+	assert.NoError(t, sendChunk(t, chunks, models.UploadChunk{UploadID: "abc", Abort: true}))
+}
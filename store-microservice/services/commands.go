@@ -0,0 +1,105 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+
+	"store/models"
+	"store/utils"
+
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+)
+
+const FileCommandsQueue = "file-commands-queue"
+
+// CommandHandler runs one file action. The result is sent back as the reply
+// data.
+type CommandHandler func(command *models.FileCommand) (interface{}, error)
+
+// CommandService answers models.FileCommand requests from the retrieval
+// service by dispatching them to the handler registered for the action.
+type CommandService struct {
+	rabbitMQService RabbitMQService
+	handlers        map[string]CommandHandler
+	log             *zap.Logger
+}
+
+func NewCommandService(rabbitMQService RabbitMQService) *CommandService {
+	log := utils.GetLogger()
+	return &CommandService{rabbitMQService, make(map[string]CommandHandler), log}
+}
+
+func (cs *CommandService) Register(action string, handler CommandHandler) {
+	cs.handlers[action] = handler
+}
+
+func (cs *CommandService) HandleCommand(msg amqp.Delivery) error {
+	reply := cs.run(msg.Body)
+
+	replyJSON, err := json.Marshal(reply)
+	if err != nil {
+		cs.log.Error("Failed to marshal command reply", zap.Error(err))
+		return err
+	}
+	return cs.rabbitMQService.Reply(msg, replyJSON)
+}
+
+func (cs *CommandService) run(body []byte) *models.FileCommandReply {
+	var command models.FileCommand
+	if err := json.Unmarshal(body, &command); err != nil {
+		cs.log.Error("Failed to unmarshal file command", zap.Error(err))
+		return commandReply(nil, utils.ErrInvalidCommand)
+	}
+
+	handler, ok := cs.handlers[command.Action]
+	if !ok {
+		cs.log.Warn("Unknown file action", zap.String("action", command.Action))
+		return commandReply(nil, utils.ErrUnknownAction)
+	}
+
+	result, err := handler(&command)
+	if err != nil && commandStatus(err) == models.CommandStatusError {
+		cs.log.Error("File command failed",
+			zap.String("action", command.Action),
+			zap.Uint("ownerID", command.OwnerID),
+			zap.Error(err),
+		)
+	}
+	return commandReply(result, err)
+}
+
+func commandReply(result interface{}, err error) *models.FileCommandReply {
+	if err != nil {
+		return &models.FileCommandReply{Status: commandStatus(err), Error: err.Error()}
+	}
+
+	reply := &models.FileCommandReply{Status: models.CommandStatusOK}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return &models.FileCommandReply{Status: models.CommandStatusError, Error: err.Error()}
+		}
+		reply.Data = data
+	}
+	return reply
+}
+
+func commandStatus(err error) string {
+	switch {
+	case errors.Is(err, utils.ErrFileNotFound), errors.Is(err, utils.ErrVersionNotFound), errors.Is(err, utils.ErrTagNotFound),
+		errors.Is(err, utils.ErrAccessEntryNotFound), errors.Is(err, utils.ErrFolderNotFound), errors.Is(err, utils.ErrThumbnailNotFound):
+		return models.CommandStatusNotFound
+	case errors.Is(err, utils.ErrInvalidCommand), errors.Is(err, utils.ErrUnknownAction), errors.Is(err, utils.ErrInvalidArchive),
+		errors.Is(err, utils.ErrInvalidRange):
+		return models.CommandStatusInvalid
+	case errors.Is(err, utils.ErrFileNotInTrash), errors.Is(err, utils.ErrFileNameInUse),
+		errors.Is(err, utils.ErrTagNameInUse), errors.Is(err, utils.ErrFolderNameInUse), errors.Is(err, utils.ErrFolderNotEmpty),
+		errors.Is(err, utils.ErrThumbnailPending):
+		return models.CommandStatusConflict
+	case errors.Is(err, utils.ErrArchiveTooLarge):
+		return models.CommandStatusTooLarge
+	default:
+		return models.CommandStatusError
+	}
+}
@@ -0,0 +1,39 @@
+package services_test
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"store/models"
+	"store/services"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB connects to the PostgreSQL database in STORE_TEST_DATABASE_URL
+// and migrates it. The store relies on row locks and partial indexes, so
+// tests that need a database are skipped without one.
+func openTestDB(t *testing.T) *gorm.DB {
+	url := os.Getenv("STORE_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("STORE_TEST_DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(url), &gorm.Config{TranslateError: true, Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	err = db.AutoMigrate(&models.File{}, &models.FileVersion{}, &models.FileTag{}, &models.UserUsage{}, &models.QuarantinedFile{}, &models.ACLEntry{}, &models.Folder{})
+	require.NoError(t, err)
+	require.NoError(t, services.NewMetadataService(db).MigrateFileNames())
+	require.NoError(t, services.NewTagService(db).MigrateTags())
+	return db
+}
+
+// testOwnerID returns a random owner, so tests sharing a database do not see
+// each other's files.
+func testOwnerID() uint {
+	return uint(rand.Int31()) + 1
+}
@@ -0,0 +1,24 @@
+package services
+
+// Unexported helpers for the tests in package services_test.
+var (
+	SafeEntryName = safeEntryName
+	ReadLimited   = readLimited
+
+	ParseClamdReply = parseClamdReply
+
+	ReadStaged = (*ChunkService).readStaged
+
+	WithAccess       = withAccess
+	WithFolderAccess = withFolderAccess
+
+	ExtractPDFText  = extractPDFText
+	ExtractJSONText = extractJSONText
+	MaxIndexedText  = maxIndexedText
+
+	RenderThumbnails   = (*ThumbnailService).render
+	ScaleToFit         = scaleToFit
+	ThumbnailSize      = thumbnailSize
+	ThumbnailPath      = thumbnailPath
+	MaxThumbnailPixels = maxThumbnailPixels
+)
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+func extractPlainText(content []byte) (string, error) {
+	return string(content), nil
+}
+
+// extractJSONText returns the keys and string values of a JSON document.
+func extractJSONText(content []byte) (string, error) {
+	var document interface{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return "", err
+	}
+
+	var words []string
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, item := range v {
+				words = append(words, key)
+				walk(item)
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case string:
+			words = append(words, v)
+		}
+	}
+	walk(document)
+	return strings.Join(words, " "), nil
+}
+
+var pdfStream = regexp.MustCompile(`(?s)stream\r?\n(.*?)\r?\nendstream`)
+
+// maxPDFStream limits how far one content stream is inflated and
+// maxPDFInflated how far all streams of a PDF are, so a small upload cannot
+// inflate to gigabytes.
+const (
+	maxPDFStream   = 8 << 20
+	maxPDFInflated = 64 << 20
+)
+
+// extractPDFText returns the text shown by the content streams of a PDF. It
+// handles uncompressed and Flate compressed streams with literal strings,
+// which covers most text PDFs; anything else is skipped. It stops once it
+// has more text than is indexed.
+func extractPDFText(content []byte) (string, error) {
+	var text strings.Builder
+	budget := int64(maxPDFInflated)
+	for _, match := range pdfStream.FindAllSubmatch(content, -1) {
+		if text.Len() >= maxIndexedText || budget <= 0 {
+			break
+		}
+		stream := match[1]
+		if reader, err := zlib.NewReader(bytes.NewReader(stream)); err == nil {
+			inflated, err := io.ReadAll(io.LimitReader(reader, min(maxPDFStream, budget)))
+			reader.Close()
+			budget -= int64(len(inflated))
+			if err == nil {
+				stream = inflated
+			}
+		}
+		pdfShownText(stream, &text)
+	}
+	return text.String(), nil
+}
+
+// pdfShownText appends the literal strings between BT and ET operators. The
+// pieces of a TJ array are joined without spaces, as they usually split a
+// word for kerning.
+func pdfShownText(stream []byte, text *strings.Builder) {
+	inText, inArray := false, false
+	for i := 0; i < len(stream); i++ {
+		switch {
+		case !inText && pdfOperator(stream, i, "BT"):
+			inText = true
+			i++
+		case inText && pdfOperator(stream, i, "ET"):
+			inText = false
+			text.WriteByte('\n')
+			i++
+		case inText && stream[i] == '[':
+			inArray = true
+		case inText && stream[i] == ']':
+			inArray = false
+			text.WriteByte(' ')
+		case inText && stream[i] == '(':
+			literal, end := pdfLiteral(stream, i+1)
+			text.WriteString(literal)
+			if !inArray {
+				text.WriteByte(' ')
+			}
+			i = end
+		}
+	}
+}
+
+// pdfOperator reports whether the operator op starts at i and stands alone.
+func pdfOperator(stream []byte, i int, op string) bool {
+	if !bytes.HasPrefix(stream[i:], []byte(op)) {
+		return false
+	}
+	if i > 0 && !isPDFSpace(stream[i-1]) {
+		return false
+	}
+	end := i + len(op)
+	return end == len(stream) || isPDFSpace(stream[end])
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+// pdfLiteral decodes a literal string starting after its opening parenthesis
+// and returns it with the index of the closing parenthesis.
+func pdfLiteral(stream []byte, start int) (string, int) {
+	var literal strings.Builder
+	depth := 1
+	for i := start; i < len(stream); i++ {
+		c := stream[i]
+		switch {
+		case c == '\\' && i+1 < len(stream):
+			i++
+			switch e := stream[i]; e {
+			case 'n':
+				literal.WriteByte('\n')
+			case 'r':
+				literal.WriteByte('\r')
+			case 't':
+				literal.WriteByte('\t')
+			case '\r', '\n':
+				// Line continuation.
+			default:
+				if e >= '0' && e <= '7' {
+					value := 0
+					for j := 0; j < 3 && i < len(stream) && stream[i] >= '0' && stream[i] <= '7'; j++ {
+						value = value*8 + int(stream[i]-'0')
+						i++
+					}
+					i--
+					literal.WriteByte(byte(value))
+				} else {
+					literal.WriteByte(e)
+				}
+			}
+		case c == '(':
+			depth++
+			literal.WriteByte(c)
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return literal.String(), i
+			}
+			literal.WriteByte(c)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	return literal.String(), len(stream)
+}
@@ -0,0 +1,147 @@
+package services_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"store/models"
+	"store/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pdf wraps content streams into a minimal PDF body.
+func pdf(streams ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	for i, stream := range streams {
+		fmt.Fprintf(&buf, "%d 0 obj\n<< /Length %d >>\nstream\n", i+1, len(stream))
+		buf.Write(stream)
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+	buf.WriteString("%%EOF\n")
+	return buf.Bytes()
+}
+
+func deflate(t *testing.T, r io.Reader) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := io.Copy(zw, r)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// zeros reads n zero bytes without holding them in memory.
+type zeros struct{ n int64 }
+
+func (z *zeros) Read(p []byte) (int, error) {
+	if z.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > z.n {
+		p = p[:z.n]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	z.n -= int64(len(p))
+	return len(p), nil
+}
+
+func TestExtractPDFText(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"literal string", pdf([]byte("BT /F1 12 Tf 72 712 Td (Hello World) Tj ET")), "Hello World \n"},
+		{"kerned array", pdf([]byte("BT [(Hel) -20 (lo) 10 (World)] TJ ET")), "HelloWorld \n"},
+		{"escapes", pdf([]byte(`BT (a\(b\) \101\102 c\\d) Tj ET`)), "a(b) AB c\\d \n"},
+		{"nested parentheses", pdf([]byte("BT (f(x) = y) Tj ET")), "f(x) = y \n"},
+		{"text outside BT and ET is not shown", pdf([]byte("(hidden) BT (shown) Tj ET (hidden)")), "shown \n"},
+		{"several streams", pdf([]byte("BT (one) Tj ET"), []byte("BT (two) Tj ET")), "one \ntwo \n"},
+		{"flate compressed", pdf(deflate(t, strings.NewReader("BT (packed) Tj ET"))), "packed \n"},
+		{"no streams", []byte("%PDF-1.4\n%%EOF\n"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := services.ExtractPDFText(tt.content)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, text)
+		})
+	}
+}
+
+func TestExtractPDFText_InflateBomb(t *testing.T) {
+	// 64 MiB of zeros deflate to about 64 KiB.
+	bomb := deflate(t, &zeros{n: 64 << 20})
+	content := pdf(bomb, bomb, []byte("BT (after) Tj ET"))
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	_, err := services.ExtractPDFText(content)
+	runtime.ReadMemStats(&after)
+	require.NoError(t, err)
+
+	// Each stream is inflated to at most maxPDFStream.
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(64<<20))
+}
+
+func TestExtractPDFText_StopsAtIndexedText(t *testing.T) {
+	stream := []byte("BT (" + strings.Repeat("word ", 20000) + ") Tj ET")
+	streams := make([][]byte, 20)
+	for i := range streams {
+		streams[i] = stream
+	}
+
+	text, err := services.ExtractPDFText(pdf(streams...))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, len(text), services.MaxIndexedText)
+	assert.Less(t, len(text), services.MaxIndexedText+len(stream))
+}
+
+func TestExtractJSONText(t *testing.T) {
+	text, err := services.ExtractJSONText([]byte(`{"title": "Report", "tags": ["q1", "sales"], "pages": 3}`))
+	require.NoError(t, err)
+	words := strings.Fields(text)
+	assert.ElementsMatch(t, []string{"title", "Report", "tags", "q1", "sales", "pages"}, words)
+
+	_, err = services.ExtractJSONText([]byte(`{"title":`))
+	assert.Error(t, err)
+}
+
+func TestFullTextService_Extract(t *testing.T) {
+	fullText := services.NewFullTextService(nil)
+
+	tests := []struct {
+		name     string
+		fileData models.FileData
+		want     string
+	}{
+		{"by type", models.FileData{FileName: "notes", FileType: "text/plain; charset=utf-8", FileBytes: []byte(" some notes ")}, "some notes"},
+		{"by extension", models.FileData{FileName: "notes.MD", FileType: "application/octet-stream", FileBytes: []byte("# Title")}, "# Title"},
+		{"unknown type", models.FileData{FileName: "photo.jpg", FileType: "image/jpeg", FileBytes: []byte("text")}, ""},
+		{"failed extraction", models.FileData{FileName: "broken.json", FileType: "application/json", FileBytes: []byte("{")}, ""},
+		{"NUL and invalid UTF-8", models.FileData{FileName: "a.txt", FileType: "text/plain", FileBytes: []byte("a\x00b\xffc")}, "a b c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, fullText.Extract(&tt.fileData))
+		})
+	}
+
+	// Test case: the text is cut to maxIndexedText without splitting a rune
+	long := strings.Repeat("é", services.MaxIndexedText)
+	text := fullText.Extract(&models.FileData{FileName: "long.txt", FileType: "text/plain", FileBytes: []byte(long)})
+	assert.LessOrEqual(t, len(text), services.MaxIndexedText)
+	assert.Greater(t, len(text), services.MaxIndexedText-2)
+	assert.True(t, strings.HasPrefix(long, text))
+}
@@ -2,6 +2,8 @@ package services
 
 import (
 	"encoding/hex"
+	"os"
+	"path/filepath"
 
 	"store/utils"
 
@@ -28,8 +30,11 @@ func (fs *FileSystemService) EncryptAndSaveFile(fileBytes []byte, filePath strin
 	return nil
 }
 
-func (fs *FileSystemService) DecryptFile(filePath string, key []byte) ([]byte, error) {
-	plaintext, err := utils.DecryptFile(filePath, key)
+// DecryptFile decrypts the file saved for filePath. size is its recorded
+// plaintext size, or -1 when it is not known.
+func (fs *FileSystemService) DecryptFile(filePath string, key []byte, size int64) ([]byte, error) {
+	key, _ = hex.DecodeString(string(key))
+	plaintext, err := utils.DecryptFile(filepath.Join(".", filePath)+".encrypted", key, size)
 	if err != nil {
 		fs.log.Error("Failed to decrypt file", zap.String("filePath", filePath), zap.Error(err))
 		return nil, err
@@ -38,3 +43,34 @@ func (fs *FileSystemService) DecryptFile(filePath string, key []byte) ([]byte, e
 	fs.log.Info("File decrypted successfully", zap.String("filePath", filePath))
 	return plaintext, nil
 }
+
+// DecryptFileRange decrypts length bytes of the file saved for filePath,
+// starting at offset.
+func (fs *FileSystemService) DecryptFileRange(filePath string, key []byte, offset, length, size int64) ([]byte, error) {
+	key, _ = hex.DecodeString(string(key))
+	plaintext, err := utils.DecryptFileRange(filepath.Join(".", filePath)+".encrypted", key, offset, length, size)
+	if err != nil {
+		fs.log.Error("Failed to decrypt file range", zap.String("filePath", filePath), zap.Int64("offset", offset), zap.Error(err))
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// RemoveFile deletes the encrypted file saved for filePath and returns its
+// size. A missing file is not an error.
+func (fs *FileSystemService) RemoveFile(filePath string) (int64, error) {
+	encryptedFilePath := filepath.Join(".", filePath) + ".encrypted"
+	info, err := os.Stat(encryptedFilePath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.Remove(encryptedFilePath); err != nil {
+		fs.log.Error("Failed to remove file", zap.String("filePath", encryptedFilePath), zap.Error(err))
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// The retrieval service detects the types of uploads; files the store
+// extracts from archives are detected here the same way.
+
+// signatures lists formats http.DetectContentType does not know.
+var signatures = []struct {
+	magic     []byte
+	mediaType string
+}{
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+}
+
+// textTypes refines plain text by file extension.
+var textTypes = map[string]string{
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".csv":      "text/csv",
+	".json":     "application/json",
+	".yaml":     "application/yaml",
+	".yml":      "application/yaml",
+}
+
+// zipTypes refines ZIP archives by file extension.
+var zipTypes = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".epub": "application/epub+zip",
+	".jar":  "application/java-archive",
+}
+
+// DetectFileType returns the media type of content. Text and ZIP based
+// formats, which look alike, are told apart by the file extension.
+func DetectFileType(fileName string, content []byte) string {
+	for _, signature := range signatures {
+		if bytes.HasPrefix(content, signature.magic) {
+			return signature.mediaType
+		}
+	}
+
+	detected := mediaType(http.DetectContentType(content))
+	ext := strings.ToLower(filepath.Ext(fileName))
+	switch detected {
+	case "text/plain":
+		if refined, ok := textTypes[ext]; ok {
+			return refined
+		}
+	case "application/zip":
+		if refined, ok := zipTypes[ext]; ok {
+			return refined
+		}
+	}
+	return detected
+}
+
+// matchesAny reports whether mediaType is one of patterns. A pattern ending
+// in /* matches the whole top-level type.
+func matchesAny(mediaType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if pattern == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the lower-case media type without parameters, or an
+// empty string if value is not a media type.
+func mediaType(value string) string {
+	parsed, _, err := mime.ParseMediaType(value)
+	if err != nil || !strings.Contains(parsed, "/") {
+		return ""
+	}
+	return parsed
+}
@@ -0,0 +1,583 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	folderCursorFolder = "folder"
+	folderCursorFile   = "file"
+)
+
+// FolderService keeps the folder trees of the owners. File names are unique
+// per folder, so files of the same name in two folders are two files with
+// their own versions. Access granted on a folder reaches everything below it.
+type FolderService struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewFolderService(db *gorm.DB) *FolderService {
+	log := utils.GetLogger()
+	return &FolderService{db, log}
+}
+
+func (fs *FolderService) RegisterCommands(commands *CommandService) {
+	commands.Register(models.FileActionCreateFolder, fs.CreateFolder)
+	commands.Register(models.FileActionRenameFolder, fs.RenameFolder)
+	commands.Register(models.FileActionMoveFolder, fs.MoveFolder)
+	commands.Register(models.FileActionDeleteFolder, fs.DeleteFolder)
+	commands.Register(models.FileActionListFolder, fs.ListFolder)
+	commands.Register(models.FileActionMoveFile, fs.MoveFile)
+	commands.Register(models.FileActionResolvePath, fs.ResolvePath)
+}
+
+// CreateFolder creates a folder in the caller's root, or in a folder the
+// caller can write to. The new folder belongs to the owner of the tree.
+func (fs *FolderService) CreateFolder(command *models.FileCommand) (interface{}, error) {
+	payload, err := folderPayload(command)
+	if err != nil {
+		return nil, err
+	}
+	if !models.ValidFolderName(payload.Name) {
+		return nil, utils.ErrInvalidCommand
+	}
+
+	folder := models.Folder{OwnerID: command.OwnerID, ParentID: payload.ParentID, Name: payload.Name}
+	err = fs.db.Transaction(func(tx *gorm.DB) error {
+		parentPath := "/"
+		if payload.ParentID != nil {
+			// The lock keeps the parent from moving before the path of the
+			// new folder is saved.
+			parent, err := findAccessibleFolder(tx.Clauses(clause.Locking{Strength: "UPDATE"}), command, *payload.ParentID, models.PermissionWrite)
+			if err != nil {
+				return err
+			}
+			folder.OwnerID, parentPath = parent.OwnerID, parent.Path
+		}
+		if err := checkFolderName(tx, folder.OwnerID, folder.ParentID, folder.Name, 0); err != nil {
+			return err
+		}
+		if err := tx.Create(&folder).Error; err != nil {
+			return err
+		}
+		folder.Path = parentPath + strconv.FormatUint(uint64(folder.ID), 10) + "/"
+		return tx.Model(&folder).Update("path", folder.Path).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fs.log.Info("Folder created", zap.Uint("folderID", folder.ID), zap.Uint("ownerID", folder.OwnerID))
+	return fs.folderInfo(folder)
+}
+
+func (fs *FolderService) RenameFolder(command *models.FileCommand) (interface{}, error) {
+	payload, err := folderPayload(command)
+	if err != nil {
+		return nil, err
+	}
+	if !models.ValidFolderName(payload.Name) {
+		return nil, utils.ErrInvalidCommand
+	}
+
+	folder, err := findAccessibleFolder(fs.db, command, payload.FolderID, models.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+	if folder.Name != payload.Name {
+		if err := checkFolderName(fs.db, folder.OwnerID, folder.ParentID, payload.Name, folder.ID); err != nil {
+			return nil, err
+		}
+		if err := fs.db.Model(folder).Update("name", payload.Name).Error; err != nil {
+			return nil, err
+		}
+	}
+	return fs.folderInfo(*folder)
+}
+
+// MoveFolder moves a folder with everything below it to another folder of
+// the same owner, or to the owner's root.
+func (fs *FolderService) MoveFolder(command *models.FileCommand) (interface{}, error) {
+	payload, err := folderPayload(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var folder *models.Folder
+	err = fs.db.Transaction(func(tx *gorm.DB) error {
+		folder, err = findAccessibleFolder(tx, command, payload.FolderID, models.PermissionWrite)
+		if err != nil {
+			return err
+		}
+		// Two moves of the same tree could each pass the check below and
+		// together make a cycle, so they wait for each other. The folders
+		// are read again once locked.
+		if err := lockOwnerFolders(tx, folder.OwnerID); err != nil {
+			return err
+		}
+		folder, err = findAccessibleFolder(tx, command, payload.FolderID, models.PermissionWrite)
+		if err != nil {
+			return err
+		}
+
+		parentPath := "/"
+		if payload.ParentID != nil {
+			parent, err := findAccessibleFolder(tx, command, *payload.ParentID, models.PermissionWrite)
+			if err != nil {
+				return err
+			}
+			// A folder cannot move into itself or below itself.
+			if parent.OwnerID != folder.OwnerID || strings.HasPrefix(parent.Path, folder.Path) {
+				return utils.ErrInvalidCommand
+			}
+			parentPath = parent.Path
+		} else if folder.OwnerID != command.OwnerID {
+			// Others' folders only move within the owner's tree.
+			return utils.ErrInvalidCommand
+		}
+		if err := checkFolderName(tx, folder.OwnerID, payload.ParentID, folder.Name, folder.ID); err != nil {
+			return err
+		}
+
+		oldPath := folder.Path
+		newPath := parentPath + strconv.FormatUint(uint64(folder.ID), 10) + "/"
+		err := tx.Exec("UPDATE folders SET path = ? || SUBSTRING(path FROM ?) WHERE owner_id = ? AND path LIKE ?",
+			newPath, len(oldPath)+1, folder.OwnerID, oldPath+"%").Error
+		if err != nil {
+			return err
+		}
+		folder.Path, folder.ParentID = newPath, payload.ParentID
+		return tx.Model(folder).Update("parent_id", payload.ParentID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fs.log.Info("Folder moved", zap.Uint("folderID", folder.ID), zap.Uint("ownerID", folder.OwnerID))
+	return fs.folderInfo(*folder)
+}
+
+// DeleteFolder deletes one of the caller's folders. Folders that are not
+// empty are only deleted with Recursive; their files go to the trash and
+// come back in the root when restored.
+func (fs *FolderService) DeleteFolder(command *models.FileCommand) (interface{}, error) {
+	payload, err := folderPayload(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var folder models.Folder
+	var ids []uint
+	var files int64
+	err = fs.db.Transaction(func(tx *gorm.DB) error {
+		// A folder moved into the tree while it is deleted would be left
+		// without a parent.
+		if err := lockOwnerFolders(tx, command.OwnerID); err != nil {
+			return err
+		}
+		err := tx.Where("id = ? AND owner_id = ?", payload.FolderID, command.OwnerID).First(&folder).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.ErrFolderNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Folder{}).Where("owner_id = ? AND path LIKE ?", folder.OwnerID, folder.Path+"%").Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.File{}).Where("folder_id IN ?", ids).Count(&files).Error; err != nil {
+			return err
+		}
+		if !payload.Recursive && (files > 0 || len(ids) > 1) {
+			return utils.ErrFolderNotEmpty
+		}
+		if err := tx.Where("folder_id IN ?", ids).Delete(&models.File{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("folder_id IN ?", ids).Delete(&models.ACLEntry{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Folder{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fs.log.Info("Folder deleted",
+		zap.Uint("folderID", folder.ID),
+		zap.Uint("ownerID", folder.OwnerID),
+		zap.Int("folders", len(ids)),
+		zap.Int64("filesTrashed", files),
+	)
+	return nil, nil
+}
+
+// ListFolder returns one page of a folder's subfolders and files. Without a
+// FolderID it lists the caller's root.
+func (fs *FolderService) ListFolder(command *models.FileCommand) (interface{}, error) {
+	payload, err := folderPayload(command)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Limit < 0 {
+		return nil, utils.ErrInvalidCommand
+	}
+	limit := min(payload.Limit, maxSearchLimit)
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	cursor, err := decodeFolderCursor(payload.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &models.FolderListing{Folders: []models.FolderInfo{}, Files: []models.FileInfo{}}
+	folders := fs.db.Where("owner_id = ? AND parent_id IS NULL", command.OwnerID)
+	files := fs.db.Preload("FileTags").Where("owner_id = ? AND folder_id IS NULL", command.OwnerID)
+	if payload.FolderID != 0 {
+		folder, err := findAccessibleFolder(fs.db, command, payload.FolderID, models.PermissionRead)
+		if err != nil {
+			return nil, err
+		}
+		info, err := fs.folderInfo(*folder)
+		if err != nil {
+			return nil, err
+		}
+		listing.Folder = info
+		folders = fs.db.Where("parent_id = ?", folder.ID)
+		files = fs.db.Preload("FileTags").Where("folder_id = ?", folder.ID)
+	}
+
+	var last *folderCursor
+	if cursor == nil || cursor.Kind == folderCursorFolder {
+		if cursor != nil {
+			folders = folders.Where("(name > ?) OR (name = ? AND id > ?)", cursor.Name, cursor.Name, cursor.ID)
+		}
+		var page []models.Folder
+		if err := folders.Order("name").Order("id").Limit(limit + 1).Find(&page).Error; err != nil {
+			return nil, err
+		}
+		if len(page) > limit {
+			page = page[:limit]
+			last = &folderCursor{Kind: folderCursorFolder, Name: page[limit-1].Name, ID: page[limit-1].ID}
+		}
+		if listing.Folders, err = fs.folderInfos(page); err != nil {
+			return nil, err
+		}
+		cursor = nil
+	}
+
+	if last == nil {
+		if remaining := limit - len(listing.Folders); remaining > 0 {
+			if cursor != nil && cursor.ID != 0 {
+				files = files.Where("(file_name > ?) OR (file_name = ? AND id > ?)", cursor.Name, cursor.Name, cursor.ID)
+			}
+			var page []models.File
+			if err := files.Order("file_name").Order("id").Limit(remaining + 1).Find(&page).Error; err != nil {
+				return nil, err
+			}
+			if len(page) > remaining {
+				page = page[:remaining]
+				last = &folderCursor{Kind: folderCursorFile, Name: page[remaining-1].FileName, ID: page[remaining-1].ID}
+			}
+			for _, file := range page {
+				listing.Files = append(listing.Files, models.ConvertFileToFileInfo(file))
+			}
+		} else {
+			// The page is full of folders; the files start the next page.
+			var ids []uint
+			if err := files.Model(&models.File{}).Limit(1).Pluck("id", &ids).Error; err != nil {
+				return nil, err
+			}
+			if len(ids) > 0 {
+				last = &folderCursor{Kind: folderCursorFile}
+			}
+		}
+	}
+
+	if last != nil {
+		listing.NextCursor = last.encode()
+	}
+	return listing, nil
+}
+
+// MoveFile moves a file the caller can write to into a folder of the file's
+// owner, or to the owner's root, unless a file of the name is there already.
+func (fs *FolderService) MoveFile(command *models.FileCommand) (interface{}, error) {
+	var payload models.MoveFilePayload
+	if err := json.Unmarshal(command.Payload, &payload); err != nil {
+		return nil, utils.ErrInvalidCommand
+	}
+
+	file, err := findAccessibleFile(fs.db.Preload("FileTags"), command, models.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+	if payload.FolderID != nil {
+		folder, err := findAccessibleFolder(fs.db, command, *payload.FolderID, models.PermissionWrite)
+		if err != nil {
+			return nil, err
+		}
+		if folder.OwnerID != file.OwnerID {
+			return nil, utils.ErrInvalidCommand
+		}
+	}
+
+	if err := checkFileName(fs.db, file.OwnerID, payload.FolderID, file.FileName, file.ID); err != nil {
+		return nil, err
+	}
+	if err := fs.db.Model(file).Update("folder_id", payload.FolderID).Error; err != nil {
+		// A file of the name was saved in the folder since the check.
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, utils.ErrFileNameInUse
+		}
+		return nil, err
+	}
+	file.FolderID = payload.FolderID
+	return models.ConvertFileToFileInfo(*file), nil
+}
+
+// ResolvePath finds the folder or file a path like /projects/alpha/report.pdf
+// names in the caller's tree. A trailing slash only matches folders.
+func (fs *FolderService) ResolvePath(command *models.FileCommand) (interface{}, error) {
+	var payload models.PathPayload
+	if err := json.Unmarshal(command.Payload, &payload); err != nil || !strings.HasPrefix(payload.Path, "/") {
+		return nil, utils.ErrInvalidCommand
+	}
+	folderOnly := strings.HasSuffix(payload.Path, "/")
+	segments := strings.FieldsFunc(payload.Path, func(r rune) bool { return r == '/' })
+	if len(segments) == 0 {
+		return nil, utils.ErrInvalidCommand
+	}
+
+	var parent *models.Folder
+	for i, name := range segments {
+		query := fs.db.Where("owner_id = ? AND name = ?", command.OwnerID, name)
+		if parent == nil {
+			query = query.Where("parent_id IS NULL")
+		} else {
+			query = query.Where("parent_id = ?", parent.ID)
+		}
+		var folder models.Folder
+		err := query.First(&folder).Error
+		if err == nil {
+			parent = &folder
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if i < len(segments)-1 || folderOnly {
+			return nil, utils.ErrFolderNotFound
+		}
+		return fs.resolveFile(command.OwnerID, parent, name)
+	}
+
+	info, err := fs.folderInfo(*parent)
+	if err != nil {
+		return nil, err
+	}
+	return models.PathResult{Folder: info}, nil
+}
+
+func (fs *FolderService) resolveFile(ownerID uint, folder *models.Folder, name string) (interface{}, error) {
+	var folderID *uint
+	if folder != nil {
+		folderID = &folder.ID
+	}
+	var file models.File
+	err := fs.db.Preload("FileTags").Scopes(inFolder(folderID)).Where("owner_id = ? AND file_name = ?", ownerID, name).First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := models.ConvertFileToFileInfo(file)
+	return models.PathResult{File: &info}, nil
+}
+
+// DeleteOwnerFolders deletes every folder of the owner. The files must be
+// gone.
+func (fs *FolderService) DeleteOwnerFolders(ownerID uint) error {
+	var ids []uint
+	if err := fs.db.Model(&models.Folder{}).Where("owner_id = ?", ownerID).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return fs.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("folder_id IN ?", ids).Delete(&models.ACLEntry{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Folder{}).Error
+	})
+}
+
+func (fs *FolderService) folderInfo(folder models.Folder) (*models.FolderInfo, error) {
+	infos, err := fs.folderInfos([]models.Folder{folder})
+	if err != nil {
+		return nil, err
+	}
+	return &infos[0], nil
+}
+
+// folderInfos describes folders with their paths of names, loading every
+// ancestor at once.
+func (fs *FolderService) folderInfos(folders []models.Folder) ([]models.FolderInfo, error) {
+	infos := make([]models.FolderInfo, len(folders))
+	if len(folders) == 0 {
+		return infos, nil
+	}
+
+	var ids []uint
+	for _, folder := range folders {
+		ids = append(ids, folder.AncestorIDs()...)
+	}
+	var ancestors []models.Folder
+	if err := fs.db.Select("id", "name").Where("id IN ?", ids).Find(&ancestors).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[uint]string, len(ancestors))
+	for _, ancestor := range ancestors {
+		names[ancestor.ID] = ancestor.Name
+	}
+
+	for i, folder := range folders {
+		var path strings.Builder
+		for _, id := range folder.AncestorIDs() {
+			path.WriteString("/" + names[id])
+		}
+		infos[i] = models.FolderInfo{
+			ID:        folder.ID,
+			OwnerID:   folder.OwnerID,
+			ParentID:  folder.ParentID,
+			Name:      folder.Name,
+			Path:      path.String(),
+			CreatedAt: folder.CreatedAt,
+			UpdatedAt: folder.UpdatedAt,
+		}
+	}
+	return infos, nil
+}
+
+// findAccessibleFolder returns the folder if the caller has permission on it.
+func findAccessibleFolder(tx *gorm.DB, command *models.FileCommand, folderID uint, permission string) (*models.Folder, error) {
+	var folder models.Folder
+	err := tx.Scopes(withFolderAccess(command.Principal(), permission)).Where("folders.id = ?", folderID).First(&folder).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// lockOwnerFolders locks the folders of the owner in id order until the
+// transaction ends, so changes to the shape of a tree happen one at a time.
+func lockOwnerFolders(tx *gorm.DB, ownerID uint) error {
+	var ids []uint
+	return tx.Model(&models.Folder{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("owner_id = ?", ownerID).Order("id").Pluck("id", &ids).Error
+}
+
+// checkFolderName fails if another folder than except in parent already has
+// the name.
+func checkFolderName(tx *gorm.DB, ownerID uint, parentID *uint, name string, except uint) error {
+	query := tx.Model(&models.Folder{}).Where("owner_id = ? AND name = ? AND id <> ?", ownerID, name, except)
+	if parentID == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *parentID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return utils.ErrFolderNameInUse
+	}
+	return nil
+}
+
+// checkFileName fails if another live file than except in the folder, or in
+// the root for nil, already has the name.
+func checkFileName(tx *gorm.DB, ownerID uint, folderID *uint, name string, except uint) error {
+	var count int64
+	err := tx.Model(&models.File{}).Scopes(inFolder(folderID)).
+		Where("owner_id = ? AND file_name = ? AND id <> ?", ownerID, name, except).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return utils.ErrFileNameInUse
+	}
+	return nil
+}
+
+// inFolder limits a query on the files table to the files directly in the
+// folder, or in the root for nil.
+func inFolder(folderID *uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if folderID == nil {
+			return db.Where("folder_id IS NULL")
+		}
+		return db.Where("folder_id = ?", *folderID)
+	}
+}
+
+func folderPayload(command *models.FileCommand) (*models.FolderPayload, error) {
+	var payload models.FolderPayload
+	if len(command.Payload) > 0 {
+		if err := json.Unmarshal(command.Payload, &payload); err != nil {
+			return nil, utils.ErrInvalidCommand
+		}
+	}
+	return &payload, nil
+}
+
+// folderCursor points after the last folder or file of a listing page.
+type folderCursor struct {
+	Kind string `json:"k"`
+	Name string `json:"n,omitempty"`
+	ID   uint   `json:"id,omitempty"`
+}
+
+func (c *folderCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeFolderCursor(encoded string) (*folderCursor, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, utils.ErrInvalidCommand
+	}
+	var cursor folderCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, utils.ErrInvalidCommand
+	}
+	if cursor.Kind != folderCursorFolder && cursor.Kind != folderCursorFile {
+		return nil, utils.ErrInvalidCommand
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,129 @@
+package services_test
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"store/models"
+	"store/services"
+	"store/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func folderCommand(t *testing.T, action string, ownerID uint, payload models.FolderPayload) *models.FileCommand {
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+	return &models.FileCommand{Action: action, OwnerID: ownerID, Payload: body}
+}
+
+func createFolder(t *testing.T, folders *services.FolderService, ownerID uint, name string) *models.FolderInfo {
+	info, err := folders.CreateFolder(folderCommand(t, models.FileActionCreateFolder, ownerID, models.FolderPayload{Name: name}))
+	require.NoError(t, err)
+	return info.(*models.FolderInfo)
+}
+
+func TestFolderService_MoveFolderIntoOwnChild(t *testing.T) {
+	db := openTestDB(t)
+	folders := services.NewFolderService(db)
+	ownerID := testOwnerID()
+
+	a := createFolder(t, folders, ownerID, "a")
+	b := createFolder(t, folders, ownerID, "b")
+
+	_, err := folders.MoveFolder(folderCommand(t, models.FileActionMoveFolder, ownerID, models.FolderPayload{FolderID: b.ID, ParentID: &a.ID}))
+	require.NoError(t, err)
+	_, err = folders.MoveFolder(folderCommand(t, models.FileActionMoveFolder, ownerID, models.FolderPayload{FolderID: a.ID, ParentID: &b.ID}))
+	assert.ErrorIs(t, err, utils.ErrInvalidCommand)
+}
+
+func TestFolderService_ConcurrentMovesMakeNoCycle(t *testing.T) {
+	db := openTestDB(t)
+	folders := services.NewFolderService(db)
+
+	for round := 0; round < 10; round++ {
+		ownerID := testOwnerID()
+		a := createFolder(t, folders, ownerID, "a")
+		b := createFolder(t, folders, ownerID, "b")
+
+		// Each move is valid on its own; together they would put a and b
+		// below each other.
+		moves := []models.FolderPayload{
+			{FolderID: a.ID, ParentID: &b.ID},
+			{FolderID: b.ID, ParentID: &a.ID},
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, len(moves))
+		for _, move := range moves {
+			wg.Add(1)
+			go func(move models.FolderPayload) {
+				defer wg.Done()
+				_, err := folders.MoveFolder(folderCommand(t, models.FileActionMoveFolder, ownerID, move))
+				errs <- err
+			}(move)
+		}
+		wg.Wait()
+		close(errs)
+
+		var failed int
+		for err := range errs {
+			if err != nil {
+				assert.ErrorIs(t, err, utils.ErrInvalidCommand)
+				failed++
+			}
+		}
+		assert.Equal(t, 1, failed)
+
+		var stored []models.Folder
+		require.NoError(t, db.Where("owner_id = ?", ownerID).Find(&stored).Error)
+		roots := 0
+		for _, folder := range stored {
+			if folder.ParentID == nil {
+				roots++
+			}
+			// A path names every folder once.
+			seen := make(map[uint]bool)
+			for _, id := range folder.AncestorIDs() {
+				assert.False(t, seen[id], "folder %d has path %s", folder.ID, folder.Path)
+				seen[id] = true
+			}
+		}
+		assert.Equal(t, 1, roots)
+	}
+}
+
+func TestFolderService_FileNamesPerFolder(t *testing.T) {
+	db := openTestDB(t)
+	folders := services.NewFolderService(db)
+	ownerID := testOwnerID()
+
+	docs := createFolder(t, folders, ownerID, "docs")
+	root := models.File{OwnerID: ownerID, FileName: "notes.txt", CurrentVersion: 1}
+	require.NoError(t, db.Create(&root).Error)
+	inDocs := models.File{OwnerID: ownerID, FolderID: &docs.ID, FileName: "notes.txt", CurrentVersion: 1}
+	require.NoError(t, db.Create(&inDocs).Error)
+
+	// Test case: each path names its own file
+	resolve := func(path string) uint {
+		result, err := folders.ResolvePath(fileCommand(t, models.FileActionResolvePath, ownerID, 0, models.PathPayload{Path: path}))
+		require.NoError(t, err)
+		return result.(models.PathResult).File.ID
+	}
+	assert.Equal(t, root.ID, resolve("/notes.txt"))
+	assert.Equal(t, inDocs.ID, resolve("/docs/notes.txt"))
+
+	// Test case: a file does not move next to one of the same name
+	move := func(fileID uint, folderID *uint) error {
+		_, err := folders.MoveFile(fileCommand(t, models.FileActionMoveFile, ownerID, fileID, models.MoveFilePayload{FolderID: folderID}))
+		return err
+	}
+	assert.ErrorIs(t, move(root.ID, &docs.ID), utils.ErrFileNameInUse)
+	assert.ErrorIs(t, move(inDocs.ID, nil), utils.ErrFileNameInUse)
+
+	other := models.File{OwnerID: ownerID, FileName: "other.txt", CurrentVersion: 1}
+	require.NoError(t, db.Create(&other).Error)
+	require.NoError(t, move(other.ID, &docs.ID))
+	assert.Equal(t, other.ID, resolve("/docs/other.txt"))
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// textSearchConfig is the PostgreSQL text search configuration used for
+// indexing and querying.
+const textSearchConfig = "english"
+
+// maxIndexedText limits how much extracted text is indexed per version.
+const maxIndexedText = 512 * 1024
+
+// TextExtractor returns the searchable text of a document.
+type TextExtractor interface {
+	Extract(content []byte) (string, error)
+}
+
+// TextExtractorFunc adapts a function to TextExtractor.
+type TextExtractorFunc func(content []byte) (string, error)
+
+func (f TextExtractorFunc) Extract(content []byte) (string, error) {
+	return f(content)
+}
+
+// FullTextService extracts text from uploads with the extractor registered
+// for their MIME type and indexes it as a tsvector on the file version.
+type FullTextService struct {
+	db         *gorm.DB
+	extractors map[string]TextExtractor
+	log        *zap.Logger
+}
+
+// NewFullTextService creates the service with extractors for plain text,
+// Markdown, CSV, JSON and PDF.
+func NewFullTextService(db *gorm.DB) *FullTextService {
+	log := utils.GetLogger()
+	fs := &FullTextService{db, make(map[string]TextExtractor), log}
+
+	for _, mimeType := range []string{"text/plain", "text/markdown", "text/csv"} {
+		fs.Register(mimeType, TextExtractorFunc(extractPlainText))
+	}
+	fs.Register("application/json", TextExtractorFunc(extractJSONText))
+	fs.Register("application/pdf", TextExtractorFunc(extractPDFText))
+	return fs
+}
+
+// Register sets the extractor for a MIME type, replacing any earlier one.
+func (fs *FullTextService) Register(mimeType string, extractor TextExtractor) {
+	fs.extractors[mimeType] = extractor
+}
+
+// Extract returns the text of the upload, or an empty string if there is no
+// extractor for its type or extraction fails.
+func (fs *FullTextService) Extract(fileData *models.FileData) string {
+	extractor := fs.extractorFor(fileData.FileType, fileData.FileName)
+	if extractor == nil {
+		return ""
+	}
+
+	text, err := extractor.Extract(fileData.FileBytes)
+	if err != nil {
+		fs.log.Warn("Failed to extract text", zap.String("fileName", fileData.FileName), zap.Error(err))
+		return ""
+	}
+	return cleanIndexText(text)
+}
+
+// Index stores text as the search vector of the file's current version.
+func (fs *FullTextService) Index(file *models.File, text string) error {
+	if text == "" {
+		return nil
+	}
+
+	err := fs.db.Exec("UPDATE file_versions SET content_vector = to_tsvector(?::regconfig, ?) WHERE file_id = ? AND version = ?",
+		textSearchConfig, text, file.ID, file.CurrentVersion).Error
+	if err != nil {
+		fs.log.Error("Failed to index file text", zap.Uint("fileID", file.ID), zap.Error(err))
+	}
+	return err
+}
+
+func (fs *FullTextService) extractorFor(fileType, fileName string) TextExtractor {
+	if mediaType, _, err := mime.ParseMediaType(fileType); err == nil {
+		if extractor, ok := fs.extractors[mediaType]; ok {
+			return extractor
+		}
+	}
+	if mediaType, ok := extensionTypes[strings.ToLower(filepath.Ext(fileName))]; ok {
+		return fs.extractors[mediaType]
+	}
+	return nil
+}
+
+// extensionTypes is used when the upload has no known MIME type.
+var extensionTypes = map[string]string{
+	".txt":      "text/plain",
+	".log":      "text/plain",
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".csv":      "text/csv",
+	".json":     "application/json",
+	".pdf":      "application/pdf",
+}
+
+// cleanIndexText makes text acceptable for to_tsvector and cuts it to
+// maxIndexedText bytes.
+func cleanIndexText(text string) string {
+	text = strings.ToValidUTF8(text, " ")
+	text = strings.ReplaceAll(text, "\x00", " ")
+	if len(text) > maxIndexedText {
+		text = text[:maxIndexedText]
+		for !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+)
+
+const FileStatusQueue = "file-status-queue"
+
+// IngestService saves uploaded files received from the retrieval service and
+// reports the outcome of every upload on FileStatusQueue.
+type IngestService struct {
+	rabbitMQService RabbitMQService
+	metadata        *MetadataService
+	fileSystem      *FileSystemService
+	volumeLimit     *VolumeLimitService
+	usage           *UsageService
+	versions        *VersionService
+	fullText        *FullTextService
+	thumbnails      *ThumbnailService
+	scanner         Scanner
+	filePath        string
+	quarantinePath  string
+	secretKey       []byte
+	log             *zap.Logger
+}
+
+// NewIngestService scans every upload with scanner before saving it; a nil
+// scanner is a NoopScanner. Infected uploads are saved in quarantinePath.
+func NewIngestService(rabbitMQService RabbitMQService, metadata *MetadataService, fileSystem *FileSystemService, volumeLimit *VolumeLimitService, usage *UsageService, versions *VersionService, fullText *FullTextService, thumbnails *ThumbnailService, scanner Scanner, filePath, quarantinePath string, secretKey []byte) *IngestService {
+	log := utils.GetLogger()
+	if scanner == nil {
+		scanner = NoopScanner{}
+	}
+	return &IngestService{rabbitMQService, metadata, fileSystem, volumeLimit, usage, versions, fullText, thumbnails, scanner, filePath, quarantinePath, secretKey, log}
+}
+
+func (is *IngestService) HandleFileData(body []byte) error {
+	var fileData models.FileData
+	if err := json.Unmarshal(body, &fileData); err != nil {
+		is.log.Error("Failed to unmarshal file data from message", zap.Error(err))
+		return err
+	}
+
+	_, err := is.Ingest(&fileData)
+	return err
+}
+
+// Ingest scans, encrypts and saves an upload. The outcome is published on
+// FileStatusQueue and returned.
+func (is *IngestService) Ingest(fileData *models.FileData) (*models.UploadStatus, error) {
+	is.log.Info("Received file data", zap.String("fileName", fileData.FileName), zap.Uint("ownerID", fileData.OwnerID))
+
+	if err := models.ValidateMetadata(fileData.Metadata); err != nil {
+		is.log.Warn("Dropping invalid custom metadata", zap.String("fileName", fileData.FileName), zap.Error(err))
+		fileData.Metadata = nil
+	}
+
+	if fileData.FolderID != nil {
+		folder, err := is.metadata.FindFolder(fileData.OwnerID, *fileData.FolderID)
+		if err != nil {
+			return is.reject(fileData, models.RejectStorageError)
+		}
+		if folder == nil {
+			is.log.Warn("Folder not found, file not saved", zap.String("fileName", fileData.FileName), zap.Uint("folderID", *fileData.FolderID))
+			return is.reject(fileData, models.RejectFolderNotFound)
+		}
+	}
+
+	// Infected uploads are quarantined before any space is reserved for them.
+	scan, err := is.scanner.Scan(fileData.FileBytes)
+	if err != nil {
+		is.log.Error("Failed to scan file, file not saved", zap.String("fileName", fileData.FileName), zap.Error(err))
+		return is.reject(fileData, models.RejectScanFailed)
+	}
+	if scan.Status == models.ScanInfected {
+		return is.quarantine(fileData, scan)
+	}
+
+	isWithinLimit, err := is.volumeLimit.Reserve(fileData.FileSize)
+	if err != nil {
+		is.log.Error("Error checking volume limit", zap.Error(err))
+		return is.reject(fileData, models.RejectStorageError)
+	}
+	if !isWithinLimit {
+		is.log.Warn("Volume limit exceeded, file not saved", zap.String("fileName", fileData.FileName))
+		return is.reject(fileData, models.RejectVolumeLimit)
+	}
+
+	existing, err := is.metadata.FindLiveFile(fileData.OwnerID, fileData.FolderID, fileData.FileName)
+	if err != nil {
+		is.volumeLimit.Release(fileData.FileSize)
+		return is.reject(fileData, models.RejectStorageError)
+	}
+	// A new version adds to the used bytes but not to the number of files.
+	var count int64
+	if existing == nil {
+		count = 1
+	}
+
+	// Files without an owner predate quotas and are not accounted.
+	if fileData.OwnerID != 0 {
+		charged, err := is.usage.Charge(fileData.OwnerID, fileData.FileSize, count, fileData.QuotaBytes)
+		if err != nil {
+			is.volumeLimit.Release(fileData.FileSize)
+			return is.reject(fileData, models.RejectStorageError)
+		}
+		if !charged {
+			is.volumeLimit.Release(fileData.FileSize)
+			is.log.Warn("Quota exceeded, file not saved",
+				zap.String("fileName", fileData.FileName),
+				zap.Uint("ownerID", fileData.OwnerID),
+				zap.Int64("quota", fileData.QuotaBytes),
+			)
+			return is.reject(fileData, models.RejectQuotaExceeded)
+		}
+	}
+
+	// The text has to be extracted before the content is encrypted.
+	text := is.fullText.Extract(fileData)
+
+	storageKey, err := newStorageKey()
+	if err != nil {
+		is.volumeLimit.Release(fileData.FileSize)
+		is.refund(fileData, count)
+		return is.reject(fileData, models.RejectStorageError)
+	}
+	filePath := filepath.Join(is.filePath, storageKey)
+	if err := is.fileSystem.EncryptAndSaveFile(fileData.FileBytes, filePath, is.secretKey); err != nil {
+		is.volumeLimit.Release(fileData.FileSize)
+		is.refund(fileData, count)
+		return is.reject(fileData, models.RejectStorageError)
+	}
+	is.log.Info("File saved successfully", zap.String("filePath", filePath))
+
+	written := fileData.FileSize
+	if info, err := os.Stat(filepath.Join(".", filePath) + ".encrypted"); err == nil {
+		written = info.Size()
+	}
+	is.volumeLimit.Commit(fileData.FileSize, written)
+
+	file, err := is.metadata.SaveFileData(fileData, storageKey, scan.Status)
+	if err != nil {
+		is.log.Error("Failed to save metadata in the database", zap.Error(err))
+		is.refund(fileData, count)
+		if size, err := is.fileSystem.RemoveFile(filePath); err == nil {
+			is.volumeLimit.Remove(size)
+		}
+		if errors.Is(err, utils.ErrFolderNotFound) {
+			return is.reject(fileData, models.RejectFolderNotFound)
+		}
+		return is.reject(fileData, models.RejectStorageError)
+	}
+	is.log.Info("Metadata saved successfully", zap.String("fileName", fileData.FileName), zap.Int("version", file.CurrentVersion))
+
+	_ = is.fullText.Index(file, text)
+	_ = is.versions.Prune(file)
+	// Thumbnails are rendered from their own queue, so images are stored as
+	// fast as other files.
+	_ = is.thumbnails.Queue(file)
+
+	return is.publishStatus(fileData, models.UploadStatusStored, "", scan)
+}
+
+// quarantine saves an infected upload encrypted in the quarantine folder,
+// where it is kept for inspection but never served, and rejects the upload.
+func (is *IngestService) quarantine(fileData *models.FileData, scan *ScanResult) (*models.UploadStatus, error) {
+	is.log.Warn("Infected file quarantined",
+		zap.String("fileName", fileData.FileName),
+		zap.Uint("ownerID", fileData.OwnerID),
+		zap.String("signature", scan.Signature),
+	)
+
+	storageKey, err := newStorageKey()
+	if err == nil {
+		err = os.MkdirAll(filepath.Join(".", is.quarantinePath), 0o700)
+	}
+	filePath := filepath.Join(is.quarantinePath, storageKey)
+	if err == nil {
+		err = is.fileSystem.EncryptAndSaveFile(fileData.FileBytes, filePath, is.secretKey)
+	}
+	if err != nil {
+		is.log.Error("Failed to save quarantined file", zap.String("fileName", fileData.FileName), zap.Error(err))
+	} else if err := is.metadata.SaveQuarantinedFile(fileData, storageKey, scan.Signature); err != nil {
+		is.log.Error("Failed to save quarantined file metadata", zap.String("fileName", fileData.FileName), zap.Error(err))
+		_, _ = is.fileSystem.RemoveFile(filePath)
+	}
+
+	return is.publishStatus(fileData, models.UploadStatusRejected, models.RejectInfected, scan)
+}
+
+func (is *IngestService) refund(fileData *models.FileData, count int64) {
+	if fileData.OwnerID != 0 {
+		_ = is.usage.Refund(fileData.OwnerID, fileData.FileSize, count)
+	}
+}
+
+func (is *IngestService) reject(fileData *models.FileData, reason string) (*models.UploadStatus, error) {
+	return is.publishStatus(fileData, models.UploadStatusRejected, reason, nil)
+}
+
+// publishStatus reports the upload together with the scanner's verdict, if
+// scan is not nil.
+func (is *IngestService) publishStatus(fileData *models.FileData, status, reason string, scan *ScanResult) (*models.UploadStatus, error) {
+	uploadStatus := &models.UploadStatus{
+		OwnerID:  fileData.OwnerID,
+		FileName: fileData.FileName,
+		FileSize: fileData.FileSize,
+		Status:   status,
+		Reason:   reason,
+		Archive:  fileData.Archive,
+	}
+	if scan != nil {
+		uploadStatus.ScanStatus = scan.Status
+		uploadStatus.Signature = scan.Signature
+	}
+	return uploadStatus, is.usage.PublishStatus(uploadStatus)
+}
@@ -1,39 +1,293 @@
 package services
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"store/models"
+	"store/utils"
 
+	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MetadataService struct {
-	db *gorm.DB
+	db  *gorm.DB
+	log *zap.Logger
 }
 
 func NewMetadataService(db *gorm.DB) *MetadataService {
-	return &MetadataService{db}
+	log := utils.GetLogger()
+	return &MetadataService{db, log}
 }
 
-func (ms *MetadataService) SaveFileData(fileData *models.FileData) error {
-	file := models.File{
-		FileName:  fileData.FileName,
-		FileType:  fileData.FileType,
-		FileSize:  fileData.FileSize,
-		FileTags:  []models.FileTag{},
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+func (ms *MetadataService) RegisterCommands(commands *CommandService) {
+	commands.Register(models.FileActionUpdateMetadata, ms.UpdateMetadata)
+}
+
+// FindLiveFile returns the owner's file with the name in the folder, or in
+// the root for nil, that is not in the trash, or nil if there is none.
+func (ms *MetadataService) FindLiveFile(ownerID uint, folderID *uint, fileName string) (*models.File, error) {
+	var file models.File
+	err := ms.db.Scopes(inFolder(folderID)).Where("owner_id = ? AND file_name = ?", ownerID, fileName).First(&file).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
 	}
+	return &file, nil
+}
 
-	for _, tagName := range fileData.FileTags {
-		tag := models.FileTag{Name: tagName}
-		file.FileTags = append(file.FileTags, tag)
+// FindFolder returns the owner's folder, or nil if there is none.
+func (ms *MetadataService) FindFolder(ownerID, folderID uint) (*models.Folder, error) {
+	var folder models.Folder
+	if err := ms.db.Where("id = ? AND owner_id = ?", folderID, ownerID).First(&folder).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
 	}
+	return &folder, nil
+}
 
-	if err := ms.db.Create(&file).Error; err != nil {
+// MigrateFileNames makes the names of files outside the trash unique per
+// owner and folder. Concurrent first uploads of a name could create a second
+// file before; such files are renamed like "report (2).pdf" before the
+// unique index is created. The index on the owner and name alone, from when
+// names were unique across folders, is dropped.
+func (ms *MetadataService) MigrateFileNames() error {
+	var duplicates []models.File
+	err := ms.db.
+		Where(`(owner_id, COALESCE(folder_id, 0), file_name) IN (SELECT owner_id, COALESCE(folder_id, 0), file_name FROM files
+			WHERE deleted_at IS NULL GROUP BY owner_id, COALESCE(folder_id, 0), file_name HAVING COUNT(*) > 1)`).
+		Order("id").
+		Find(&duplicates).Error
+	if err != nil {
+		ms.log.Error("Failed to find files with the same name", zap.Error(err))
 		return err
 	}
 
-	return nil
+	kept := make(map[string]bool)
+	for _, file := range duplicates {
+		var folderID uint
+		if file.FolderID != nil {
+			folderID = *file.FolderID
+		}
+		key := fmt.Sprintf("%d/%d/%s", file.OwnerID, folderID, file.FileName)
+		if !kept[key] {
+			kept[key] = true
+			continue
+		}
+		name, err := ms.freeFileName(file.OwnerID, file.FolderID, file.FileName)
+		if err != nil {
+			return err
+		}
+		if err := ms.db.Model(&file).UpdateColumn("file_name", name).Error; err != nil {
+			ms.log.Error("Failed to rename file", zap.Uint("fileID", file.ID), zap.Error(err))
+			return err
+		}
+		ms.log.Info("Renamed file with the same name", zap.Uint("fileID", file.ID), zap.String("fileName", name))
+	}
+
+	// Folder ids start at 1, so 0 stands for the root.
+	err = ms.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_files_owner_folder_name ON files (owner_id, COALESCE(folder_id, 0), file_name) WHERE deleted_at IS NULL").Error
+	if err != nil {
+		return err
+	}
+	return ms.db.Exec("DROP INDEX IF EXISTS idx_files_owner_name").Error
+}
+
+// freeFileName returns the first of "name (2).ext", "name (3).ext" and so on
+// the owner has no file of in the folder.
+func (ms *MetadataService) freeFileName(ownerID uint, folderID *uint, fileName string) (string, error) {
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	for n := 2; ; n++ {
+		name := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		existing, err := ms.FindLiveFile(ownerID, folderID, name)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return name, nil
+		}
+	}
+}
+
+// SaveQuarantinedFile records an infected upload whose content was saved
+// under storageKey in the quarantine folder.
+func (ms *MetadataService) SaveQuarantinedFile(fileData *models.FileData, storageKey, signature string) error {
+	quarantined := models.QuarantinedFile{
+		OwnerID:      fileData.OwnerID,
+		FileName:     fileData.FileName,
+		FileType:     fileData.FileType,
+		DeclaredType: fileData.DeclaredType,
+		FileSize:     fileData.FileSize,
+		StorageKey:   storageKey,
+		Signature:    signature,
+		Metadata:     models.CustomMetadata(fileData.Metadata),
+	}
+	return ms.db.Create(&quarantined).Error
+}
+
+// SaveFileData records an upload whose content was saved under storageKey.
+// If the owner already has a file with the name in the folder, the upload
+// becomes its next version; otherwise a new file is created. Names are unique
+// per owner and folder, so of two concurrent first uploads of a name one
+// creates the file and the other adds a version to it. It fails with
+// utils.ErrFolderNotFound if the owner has no such folder.
+func (ms *MetadataService) SaveFileData(fileData *models.FileData, storageKey, scanStatus string) (*models.File, error) {
+	var file models.File
+	err := ms.db.Transaction(func(tx *gorm.DB) error {
+		if fileData.FolderID != nil {
+			// The lock keeps the folder from being deleted before the file
+			// is saved in it.
+			err := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+				Where("id = ? AND owner_id = ?", *fileData.FolderID, fileData.OwnerID).
+				First(&models.Folder{}).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return utils.ErrFolderNotFound
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		tags, err := FindOrCreateTags(tx, fileData.OwnerID, fileData.FileTags)
+		if err != nil {
+			return err
+		}
+
+		findFile := func() error {
+			return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(inFolder(fileData.FolderID)).
+				Where("owner_id = ? AND file_name = ?", fileData.OwnerID, fileData.FileName).
+				First(&file).Error
+		}
+		err = findFile()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			file = models.File{
+				FileName:       fileData.FileName,
+				FileType:       fileData.FileType,
+				DeclaredType:   fileData.DeclaredType,
+				FileSize:       fileData.FileSize,
+				OwnerID:        fileData.OwnerID,
+				FolderID:       fileData.FolderID,
+				CreatedAt:      time.Now(),
+				UpdatedAt:      time.Now(),
+				CurrentVersion: 1,
+				Metadata:       models.CustomMetadata(fileData.Metadata),
+			}
+			// The lock above covers no row, so another upload may have
+			// created the file since. The unique index then makes the
+			// insert wait for it and do nothing.
+			created := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit(clause.Associations).Create(&file)
+			if created.Error != nil {
+				return created.Error
+			}
+			if created.RowsAffected == 1 {
+				version := models.FileVersion{
+					FileID:       file.ID,
+					Version:      1,
+					FileType:     fileData.FileType,
+					DeclaredType: fileData.DeclaredType,
+					FileSize:     fileData.FileSize,
+					StorageKey:   storageKey,
+					ScanStatus:   scanStatus,
+				}
+				if err := tx.Create(&version).Error; err != nil {
+					return err
+				}
+				file.Versions = []models.FileVersion{version}
+				if len(tags) == 0 {
+					return nil
+				}
+				return tx.Model(&file).Association("FileTags").Append(tags)
+			}
+			file = models.File{}
+			err = findFile()
+		}
+		if err != nil {
+			return err
+		}
+
+		var latest int
+		if err := tx.Model(&models.FileVersion{}).Where("file_id = ?", file.ID).Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+			return err
+		}
+		version := models.FileVersion{
+			FileID:       file.ID,
+			Version:      latest + 1,
+			FileType:     fileData.FileType,
+			DeclaredType: fileData.DeclaredType,
+			FileSize:     fileData.FileSize,
+			StorageKey:   storageKey,
+			ScanStatus:   scanStatus,
+		}
+		if err := tx.Create(&version).Error; err != nil {
+			return err
+		}
+
+		file.CurrentVersion = version.Version
+		file.FileType = version.FileType
+		file.DeclaredType = version.DeclaredType
+		file.FileSize = version.FileSize
+		file.UpdatedAt = time.Now()
+		// Like tags, metadata of a new version is merged into the file's.
+		patch := models.MetadataPatch{}
+		for key := range fileData.Metadata {
+			value := fileData.Metadata[key]
+			patch[key] = &value
+		}
+		if merged := file.Metadata.ApplyPatch(patch); models.ValidateMetadata(merged) == nil {
+			file.Metadata = merged
+		}
+		if err := tx.Model(&file).Select("current_version", "file_type", "declared_type", "file_size", "updated_at", "metadata").Updates(&file).Error; err != nil {
+			return err
+		}
+		// Tags of a new version are added to those the file already has.
+		return tx.Model(&file).Association("FileTags").Append(tags)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// UpdateMetadata applies the models.MetadataPatch in the command payload to
+// the file's custom metadata.
+func (ms *MetadataService) UpdateMetadata(command *models.FileCommand) (interface{}, error) {
+	var patch models.MetadataPatch
+	if err := json.Unmarshal(command.Payload, &patch); err != nil || len(patch) == 0 {
+		return nil, utils.ErrInvalidCommand
+	}
+
+	var file models.File
+	err := ms.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := findAccessibleFile(tx.Clauses(clause.Locking{Strength: "UPDATE"}), command, models.PermissionWrite)
+		if err != nil {
+			return err
+		}
+		file = *locked
+
+		metadata := file.Metadata.ApplyPatch(patch)
+		if err := models.ValidateMetadata(metadata); err != nil {
+			return utils.ErrInvalidCommand
+		}
+		file.Metadata = metadata
+		return tx.Model(&file).Update("metadata", metadata).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ms.db.Preload("FileTags").First(&file, file.ID).Error; err != nil {
+		return nil, err
+	}
+	return models.ConvertFileToFileInfo(file), nil
 }
@@ -0,0 +1,112 @@
+package services_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"store/models"
+	"store/services"
+	"store/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataService_SaveFileDataConcurrentFirstUploads(t *testing.T) {
+	db := openTestDB(t)
+	metadata := services.NewMetadataService(db)
+	ownerID := testOwnerID()
+
+	const uploads = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fileData := &models.FileData{FileName: "report.pdf", FileType: "application/pdf", FileSize: 10, OwnerID: ownerID}
+			_, err := metadata.SaveFileData(fileData, fmt.Sprintf("key-%d-%d", ownerID, i), models.ScanSkipped)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// Test case: the uploads are versions of one file
+	var files []models.File
+	require.NoError(t, db.Where("owner_id = ?", ownerID).Find(&files).Error)
+	require.Len(t, files, 1)
+	assert.Equal(t, uploads, files[0].CurrentVersion)
+
+	var versions []int
+	require.NoError(t, db.Model(&models.FileVersion{}).Where("file_id = ?", files[0].ID).Order("version").Pluck("version", &versions).Error)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8}, versions)
+}
+
+func TestMetadataService_SaveFileDataNamesAreUnique(t *testing.T) {
+	db := openTestDB(t)
+	ownerID := testOwnerID()
+
+	// Test case: the database rejects a second live file of the name
+	first := models.File{OwnerID: ownerID, FileName: "notes.txt", CurrentVersion: 1}
+	require.NoError(t, db.Create(&first).Error)
+	second := models.File{OwnerID: ownerID, FileName: "notes.txt", CurrentVersion: 1}
+	assert.Error(t, db.Create(&second).Error)
+
+	// Test case: files in the trash do not count
+	require.NoError(t, db.Delete(&first).Error)
+	third := models.File{OwnerID: ownerID, FileName: "notes.txt", CurrentVersion: 1}
+	assert.NoError(t, db.Create(&third).Error)
+
+	// Test case: names are unique per folder
+	folder := createFolder(t, services.NewFolderService(db), ownerID, "docs")
+	inFolder := models.File{OwnerID: ownerID, FolderID: &folder.ID, FileName: "notes.txt", CurrentVersion: 1}
+	require.NoError(t, db.Create(&inFolder).Error)
+	again := models.File{OwnerID: ownerID, FolderID: &folder.ID, FileName: "notes.txt", CurrentVersion: 1}
+	assert.Error(t, db.Create(&again).Error)
+}
+
+func TestMetadataService_SaveFileDataInFolder(t *testing.T) {
+	db := openTestDB(t)
+	metadata := services.NewMetadataService(db)
+	folders := services.NewFolderService(db)
+	ownerID := testOwnerID()
+	folder := createFolder(t, folders, ownerID, "docs")
+
+	save := func(folderID *uint, key string) (*models.File, error) {
+		fileData := &models.FileData{FileName: "report.pdf", FileType: "application/pdf", FileSize: 10, OwnerID: ownerID, FolderID: folderID}
+		return metadata.SaveFileData(fileData, key, models.ScanSkipped)
+	}
+
+	root, err := save(nil, fmt.Sprintf("key-%d-1", ownerID))
+	require.NoError(t, err)
+
+	// Test case: the name in another folder is another file
+	inFolder, err := save(&folder.ID, fmt.Sprintf("key-%d-2", ownerID))
+	require.NoError(t, err)
+	assert.NotEqual(t, root.ID, inFolder.ID)
+	assert.Equal(t, &folder.ID, inFolder.FolderID)
+	assert.Equal(t, 1, inFolder.CurrentVersion)
+
+	// Test case: the name in the same folder is a new version
+	again, err := save(&folder.ID, fmt.Sprintf("key-%d-3", ownerID))
+	require.NoError(t, err)
+	assert.Equal(t, inFolder.ID, again.ID)
+	assert.Equal(t, 2, again.CurrentVersion)
+
+	found, err := metadata.FindLiveFile(ownerID, &folder.ID, "report.pdf")
+	require.NoError(t, err)
+	assert.Equal(t, inFolder.ID, found.ID)
+	found, err = metadata.FindLiveFile(ownerID, nil, "report.pdf")
+	require.NoError(t, err)
+	assert.Equal(t, root.ID, found.ID)
+
+	// Test case: folders of other owners are not found
+	other := createFolder(t, folders, testOwnerID(), "docs")
+	_, err = save(&other.ID, fmt.Sprintf("key-%d-4", ownerID))
+	assert.ErrorIs(t, err, utils.ErrFolderNotFound)
+}
@@ -88,3 +88,27 @@ func (rmq *RabbitMQService) PublishToQueue(message []byte, queueName string) err
 	rmq.log.Info("Message published to queue successfully", zap.String("QueueName", queueName))
 	return nil
 }
+
+// Reply answers a request that was published with a reply-to queue.
+func (rmq *RabbitMQService) Reply(request amqp.Delivery, message []byte) error {
+	if request.ReplyTo == "" {
+		return nil
+	}
+
+	err := rmq.ch.Publish(
+		"",              // Exchange
+		request.ReplyTo, // Routing key (reply queue)
+		false,           // Mandatory
+		false,           // Immediate
+		amqp.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: request.CorrelationId,
+			Body:          message,
+		},
+	)
+	if err != nil {
+		rmq.log.Error("Failed to publish reply", zap.Error(err), zap.String("ReplyTo", request.ReplyTo))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+)
+
+// ScanResult is the verdict of a Scanner. Signature names what was found in
+// infected content.
+type ScanResult struct {
+	Status    string
+	Signature string
+}
+
+// Scanner inspects uploaded content before it is encrypted and saved.
+type Scanner interface {
+	Scan(content []byte) (*ScanResult, error)
+}
+
+// NoopScanner accepts everything without looking at it.
+type NoopScanner struct{}
+
+func (NoopScanner) Scan(content []byte) (*ScanResult, error) {
+	return &ScanResult{Status: models.ScanSkipped}, nil
+}
+
+// clamdChunkSize stays well below clamd's default StreamMaxLength.
+const clamdChunkSize = 64 * 1024
+
+// ClamAVScanner scans content with a clamd daemon using the INSTREAM
+// command. The address is host:port, tcp://host:port or unix:///path.
+type ClamAVScanner struct {
+	network string
+	address string
+	timeout time.Duration
+	log     *zap.Logger
+}
+
+func NewClamAVScanner(address string, timeout time.Duration) *ClamAVScanner {
+	log := utils.GetLogger()
+	network := "tcp"
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		network, address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		address = strings.TrimPrefix(address, "tcp://")
+	}
+	return &ClamAVScanner{network, address, timeout, log}
+}
+
+func (cs *ClamAVScanner) Scan(content []byte) (*ScanResult, error) {
+	conn, err := net.DialTimeout(cs.network, cs.address, cs.timeout)
+	if err != nil {
+		cs.log.Error("Failed to connect to clamd", zap.String("address", cs.address), zap.Error(err))
+		return nil, err
+	}
+	defer conn.Close()
+	if cs.timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(cs.timeout))
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	for start := 0; start < len(content); start += clamdChunkSize {
+		end := start + clamdChunkSize
+		if end > len(content) {
+			end = len(content)
+		}
+		binary.BigEndian.PutUint32(size[:], uint32(end-start))
+		if _, err := conn.Write(size[:]); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(content[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	// A zero length chunk ends the stream.
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return nil, err
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		cs.log.Error("Failed to read the clamd reply", zap.Error(err))
+		return nil, err
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply reads replies such as "stream: OK" and
+// "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply []byte) (*ScanResult, error) {
+	line := strings.TrimSpace(string(bytes.TrimRight(reply, "\x00")))
+	line = strings.TrimPrefix(line, "stream:")
+	line = strings.TrimSpace(line)
+
+	switch {
+	case line == "OK":
+		return &ScanResult{Status: models.ScanClean}, nil
+	case strings.HasSuffix(line, " FOUND"):
+		return &ScanResult{Status: models.ScanInfected, Signature: strings.TrimSuffix(line, " FOUND")}, nil
+	default:
+		return nil, fmt.Errorf("clamd: %s", line)
+	}
+}
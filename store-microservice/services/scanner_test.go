@@ -0,0 +1,158 @@
+package services_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"store/models"
+	"store/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clamdSession struct {
+	command string
+	chunks  []int
+	content []byte
+}
+
+// fakeClamd accepts one INSTREAM session on listener, sends the chunk sizes
+// and the streamed content to received and answers with reply.
+func fakeClamd(listener net.Listener, reply string) <-chan clamdSession {
+	received := make(chan clamdSession, 1)
+	go func() {
+		defer close(received)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var session clamdSession
+		r := bufio.NewReader(conn)
+		session.command, _ = r.ReadString(0)
+		for {
+			var size [4]byte
+			if _, err := io.ReadFull(r, size[:]); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size[:])
+			session.chunks = append(session.chunks, int(n))
+			if n == 0 {
+				break
+			}
+			chunk := make([]byte, n)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			session.content = append(session.content, chunk...)
+		}
+		_, _ = conn.Write([]byte(reply))
+		received <- session
+	}()
+	return received
+}
+
+func TestClamAVScanner_Scan(t *testing.T) {
+	const chunk = 64 * 1024
+	tests := []struct {
+		name      string
+		size      int
+		reply     string
+		chunks    []int
+		status    string
+		signature string
+		wantErr   bool
+	}{
+		{"empty content", 0, "stream: OK\x00", []int{0}, models.ScanClean, "", false},
+		{"one chunk", 100, "stream: OK\x00", []int{100, 0}, models.ScanClean, "", false},
+		{"exactly one chunk", chunk, "stream: OK\x00", []int{chunk, 0}, models.ScanClean, "", false},
+		{"one byte over a chunk", chunk + 1, "stream: OK\x00", []int{chunk, 1, 0}, models.ScanClean, "", false},
+		{"several chunks", 3*chunk + 5, "stream: OK\x00", []int{chunk, chunk, chunk, 5, 0}, models.ScanClean, "", false},
+		{"infected", 68, "stream: Eicar-Signature FOUND\x00", []int{68, 0}, models.ScanInfected, "Eicar-Signature", false},
+		{"size limit", 100, "INSTREAM size limit exceeded. ERROR\x00", []int{100, 0}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer listener.Close()
+			received := fakeClamd(listener, tt.reply)
+
+			content := bytes.Repeat([]byte("x"), tt.size)
+			scanner := services.NewClamAVScanner("tcp://"+listener.Addr().String(), 5*time.Second)
+			result, err := scanner.Scan(content)
+
+			session := <-received
+			assert.Equal(t, "zINSTREAM\x00", session.command)
+			assert.Equal(t, tt.chunks, session.chunks)
+			assert.Equal(t, len(content), len(session.content))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, result.Status)
+			assert.Equal(t, tt.signature, result.Signature)
+		})
+	}
+}
+
+func TestClamAVScanner_ScanUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clamd.sock")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer listener.Close()
+	received := fakeClamd(listener, "stream: OK\x00")
+
+	result, err := services.NewClamAVScanner("unix://"+path, 5*time.Second).Scan([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, models.ScanClean, result.Status)
+	assert.Equal(t, []byte("hello"), (<-received).content)
+}
+
+func TestClamAVScanner_ScanUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	_, err = services.NewClamAVScanner(address, time.Second).Scan([]byte("hello"))
+	assert.Error(t, err)
+}
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply     string
+		status    string
+		signature string
+		wantErr   bool
+	}{
+		{"stream: OK\x00", models.ScanClean, "", false},
+		{"stream: OK\n", models.ScanClean, "", false},
+		{"OK", models.ScanClean, "", false},
+		{"stream: Win.Test.EICAR_HDB-1 FOUND\x00", models.ScanInfected, "Win.Test.EICAR_HDB-1", false},
+		{"stream: Eicar-Signature FOUND\n", models.ScanInfected, "Eicar-Signature", false},
+		{"INSTREAM size limit exceeded. ERROR\x00", "", "", true},
+		{"", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reply, func(t *testing.T) {
+			result, err := services.ParseClamdReply([]byte(tt.reply))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, result.Status)
+			assert.Equal(t, tt.signature, result.Signature)
+		})
+	}
+}
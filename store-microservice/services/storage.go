@@ -1,14 +1,30 @@
 package services
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"store/models"
 	"store/utils"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+var sortColumns = map[string]string{
+	models.SortByName:      "files.file_name",
+	models.SortBySize:      "files.file_size",
+	models.SortByCreatedAt: "files.created_at",
+}
+
 type StorageService struct {
 	rabbitMQService RabbitMQService
 	log             *zap.Logger
@@ -20,6 +36,11 @@ func NewStorageService(rabbitMQService RabbitMQService, db *gorm.DB) *StorageSer
 	return &StorageService{rabbitMQService, log, db}
 }
 
+func (ss *StorageService) RegisterCommands(commands *CommandService) {
+	commands.Register(models.FileActionSearch, ss.Search)
+	commands.Register(models.FileActionListArchive, ss.ListArchive)
+}
+
 func (ss *StorageService) HandleFileRequests(body []byte) ([]string, error) {
 	var request models.FileRequest
 
@@ -39,7 +60,8 @@ func (ss *StorageService) HandleFileRequests(body []byte) ([]string, error) {
 func (ss *StorageService) FindFileNames(request models.FileRequest) ([]string, error) {
 	var files []*models.File
 
-	query := ss.BuildFileQuery(&request)
+	principal := models.Principal{UserID: request.OwnerID, GroupIDs: request.GroupIDs}
+	query := ss.BuildFileQuery(&models.FileQuery{Name: request.Name, Tags: request.Tags}, principal)
 
 	if err := query.Find(&files).Error; err != nil {
 		ss.log.Error("Failed to find files based on request", zap.Error(err))
@@ -54,20 +76,286 @@ func (ss *StorageService) FindFileNames(request models.FileRequest) ([]string, e
 	return fileNames, nil
 }
 
-func (ss *StorageService) BuildFileQuery(request *models.FileRequest) *gorm.DB {
-	query := ss.db.Model(&models.File{})
+// Search returns one page of the caller's files matching the models.FileQuery
+// in the command payload.
+func (ss *StorageService) Search(command *models.FileCommand) (interface{}, error) {
+	var request models.FileQuery
+	if len(command.Payload) > 0 {
+		if err := json.Unmarshal(command.Payload, &request); err != nil {
+			return nil, utils.ErrInvalidCommand
+		}
+	}
+	if err := normalizeFileQuery(&request); err != nil {
+		return nil, err
+	}
+
+	query := ss.BuildFileQuery(&request, command.Principal()).
+		Preload("FileTags")
+
+	var cursor *searchCursor
+	if request.Cursor != "" {
+		var err error
+		if cursor, err = decodeSearchCursor(request.Cursor, &request); err != nil {
+			return nil, err
+		}
+	}
+
+	if request.Sort == models.SortByRelevance {
+		// Ranks are not unique enough for a keyset, so relevance pages by
+		// offset.
+		query = query.
+			Order(clause.Expr{SQL: "ts_rank(cv.content_vector, websearch_to_tsquery(?::regconfig, ?)) DESC", Vars: []interface{}{textSearchConfig, request.Query}}).
+			Order("files.id ASC")
+		if cursor != nil {
+			query = query.Offset(cursor.value.(int))
+		}
+	} else {
+		column := sortColumns[request.Sort]
+		if cursor != nil {
+			op := ">"
+			if request.Order == models.SortDesc {
+				op = "<"
+			}
+			query = query.Where("("+column+" "+op+" ?) OR ("+column+" = ? AND files.id "+op+" ?)", cursor.value, cursor.value, cursor.ID)
+		}
+		query = query.Order(column + " " + request.Order).Order("files.id " + request.Order)
+	}
+
+	var files []models.File
+	err := query.Limit(request.Limit + 1).Find(&files).Error
+	if err != nil {
+		ss.log.Error("Failed to search files", zap.Uint("ownerID", command.OwnerID), zap.Error(err))
+		return nil, err
+	}
+
+	result := &models.FileSearchResult{Files: make([]models.FileInfo, 0, len(files))}
+	if len(files) > request.Limit {
+		files = files[:request.Limit]
+		result.NextCursor = encodeSearchCursor(&request, cursor, files[len(files)-1])
+	}
+	for _, file := range files {
+		result.Files = append(result.Files, models.ConvertFileToFileInfo(file))
+	}
+	return result, nil
+}
+
+// ListArchive returns the caller's files matching a bulk download, sorted by
+// name. Paging does not apply.
+func (ss *StorageService) ListArchive(command *models.FileCommand) (interface{}, error) {
+	var request models.ArchiveListPayload
+	if err := json.Unmarshal(command.Payload, &request); err != nil || request.MaxFiles < 0 {
+		return nil, utils.ErrInvalidCommand
+	}
+	if err := normalizeFileQuery(&request.Query); err != nil {
+		return nil, err
+	}
+
+	query := ss.BuildFileQuery(&request.Query, command.Principal()).
+		Order("files.file_name ASC").
+		Order("files.id ASC")
+	if request.MaxFiles > 0 {
+		query = query.Limit(request.MaxFiles + 1)
+	}
+
+	var files []models.File
+	if err := query.Find(&files).Error; err != nil {
+		ss.log.Error("Failed to list files for archive", zap.Uint("ownerID", command.OwnerID), zap.Error(err))
+		return nil, err
+	}
+
+	infos := make([]models.FileInfo, len(files))
+	for i, file := range files {
+		infos[i] = models.ConvertFileToFileInfo(file)
+	}
+	return infos, nil
+}
+
+// BuildFileQuery applies the filters of request to a query on the files
+// the principal owns or can read. Sorting and pagination are left to the
+// caller.
+func (ss *StorageService) BuildFileQuery(request *models.FileQuery, principal models.Principal) *gorm.DB {
+	query := ss.db.Model(&models.File{}).Scopes(withAccess(principal, models.PermissionRead))
+	if request.SharedWithMe {
+		query = query.Where("files.owner_id <> ?", principal.UserID)
+	}
+
+	if request.Query != "" {
+		query = query.
+			Joins("JOIN file_versions AS cv ON cv.file_id = files.id AND cv.version = files.current_version AND cv.deleted_at IS NULL").
+			Where("cv.content_vector @@ websearch_to_tsquery(?::regconfig, ?)", textSearchConfig, request.Query)
+	}
 
 	if request.Name != "" {
-		query = query.Where("file_name LIKE ?", "%"+request.Name+"%")
+		query = query.Where("files.file_name LIKE ?", "%"+request.Name+"%")
 	}
 
-	if len(request.Tags) > 0 {
-		query = query.Joins("JOIN file_file_tag ON files.id = file_file_tag.file_id").
+	tags := models.NormalizeTags(request.Tags)
+	if len(tags) > 0 {
+		tagged := ss.db.Table("file_file_tag").
+			Select("file_file_tag.file_id").
 			Joins("JOIN file_tags ON file_file_tag.file_tag_id = file_tags.id").
-			Where("file_tags.name IN (?)", request.Tags).
-			Group("files.id").
-			Having("COUNT(DISTINCT file_tags.id) = ?", len(request.Tags))
+			Where("file_tags.name IN ?", tags)
+		if request.TagMatch != models.TagMatchAny {
+			tagged = tagged.Group("file_file_tag.file_id").
+				Having("COUNT(DISTINCT file_tags.name) = ?", len(tags))
+		}
+		query = query.Where("files.id IN (?)", tagged)
+	}
+
+	if request.FileType != "" {
+		if prefix, ok := strings.CutSuffix(request.FileType, "/*"); ok {
+			query = query.Where("files.file_type LIKE ?", prefix+"/%")
+		} else {
+			query = query.Where("files.file_type = ?", request.FileType)
+		}
+	}
+
+	if request.CreatedAfter != nil {
+		query = query.Where("files.created_at >= ?", *request.CreatedAfter)
+	}
+	if request.CreatedBefore != nil {
+		query = query.Where("files.created_at < ?", *request.CreatedBefore)
+	}
+	if len(request.Metadata) > 0 {
+		filter, _ := json.Marshal(request.Metadata)
+		query = query.Where("files.metadata @> ?::jsonb", string(filter))
+	}
+	if request.MinSize != nil {
+		query = query.Where("files.file_size >= ?", *request.MinSize)
+	}
+	if request.MaxSize != nil {
+		query = query.Where("files.file_size <= ?", *request.MaxSize)
 	}
 
 	return query
 }
+
+// normalizeFileQuery fills in the defaults and rejects invalid queries.
+func normalizeFileQuery(request *models.FileQuery) error {
+	request.Query = strings.TrimSpace(request.Query)
+	if request.Sort == "" {
+		request.Sort = models.SortByCreatedAt
+		if request.Query != "" {
+			request.Sort = models.SortByRelevance
+		}
+	}
+	if request.Sort == models.SortByRelevance {
+		if request.Query == "" {
+			return utils.ErrInvalidCommand
+		}
+		request.Order = models.SortDesc
+	} else if _, ok := sortColumns[request.Sort]; !ok {
+		return utils.ErrInvalidCommand
+	}
+
+	switch request.Order {
+	case "":
+		request.Order = models.SortDesc
+		if request.Sort == models.SortByName {
+			request.Order = models.SortAsc
+		}
+	case models.SortAsc, models.SortDesc:
+	default:
+		return utils.ErrInvalidCommand
+	}
+
+	switch request.TagMatch {
+	case "":
+		request.TagMatch = models.TagMatchAll
+	case models.TagMatchAll, models.TagMatchAny:
+	default:
+		return utils.ErrInvalidCommand
+	}
+
+	if request.Limit < 0 {
+		return utils.ErrInvalidCommand
+	}
+	if request.Limit == 0 {
+		request.Limit = defaultSearchLimit
+	}
+	if request.Limit > maxSearchLimit {
+		request.Limit = maxSearchLimit
+	}
+
+	if err := models.ValidateMetadata(request.Metadata); err != nil {
+		return utils.ErrInvalidCommand
+	}
+	if request.MinSize != nil && request.MaxSize != nil && *request.MinSize > *request.MaxSize {
+		return utils.ErrInvalidCommand
+	}
+	if request.CreatedAfter != nil && request.CreatedBefore != nil && !request.CreatedAfter.Before(*request.CreatedBefore) {
+		return utils.ErrInvalidCommand
+	}
+	return nil
+}
+
+// searchCursor points after the last file of a page. It is only valid for
+// the sort it was created with. Relevance cursors hold the offset of the next
+// page instead of a file.
+type searchCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+
+	value interface{}
+}
+
+func encodeSearchCursor(request *models.FileQuery, previous *searchCursor, last models.File) string {
+	cursor := searchCursor{Sort: request.Sort, Order: request.Order, ID: last.ID}
+	switch request.Sort {
+	case models.SortByRelevance:
+		offset := request.Limit
+		if previous != nil {
+			offset += previous.value.(int)
+		}
+		cursor.Value = strconv.Itoa(offset)
+	case models.SortByName:
+		cursor.Value = last.FileName
+	case models.SortBySize:
+		cursor.Value = strconv.FormatInt(last.FileSize, 10)
+	case models.SortByCreatedAt:
+		cursor.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchCursor(encoded string, request *models.FileQuery) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, utils.ErrInvalidCommand
+	}
+	var cursor searchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, utils.ErrInvalidCommand
+	}
+	if cursor.Sort != request.Sort || cursor.Order != request.Order {
+		return nil, utils.ErrInvalidCommand
+	}
+
+	switch cursor.Sort {
+	case models.SortByRelevance:
+		offset, err := strconv.Atoi(cursor.Value)
+		if err != nil || offset < 0 {
+			return nil, utils.ErrInvalidCommand
+		}
+		cursor.value = offset
+	case models.SortByName:
+		cursor.value = cursor.Value
+	case models.SortBySize:
+		size, err := strconv.ParseInt(cursor.Value, 10, 64)
+		if err != nil {
+			return nil, utils.ErrInvalidCommand
+		}
+		cursor.value = size
+	case models.SortByCreatedAt:
+		createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, utils.ErrInvalidCommand
+		}
+		cursor.value = createdAt
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,408 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const tagIndexName = "idx_file_tags_owner_name"
+
+// TagService manages the tags of an owner and the tags of single files.
+type TagService struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+func NewTagService(db *gorm.DB) *TagService {
+	log := utils.GetLogger()
+	return &TagService{db, log}
+}
+
+func (ts *TagService) RegisterCommands(commands *CommandService) {
+	commands.Register(models.FileActionListTags, ts.ListTags)
+	commands.Register(models.FileActionAddTags, ts.AddTags)
+	commands.Register(models.FileActionRemoveTags, ts.RemoveTags)
+	commands.Register(models.FileActionRenameTag, ts.RenameTag)
+	commands.Register(models.FileActionMergeTags, ts.MergeTags)
+}
+
+// MigrateTags moves tags saved before tags were normalized to one tag per
+// owner and name and then adds the unique index. It does nothing once the
+// index exists.
+func (ts *TagService) MigrateTags() error {
+	if ts.db.Migrator().HasIndex(&models.FileTag{}, tagIndexName) {
+		return nil
+	}
+
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		// Every old tag row belongs to the file it was uploaded with.
+		err := tx.Exec(`UPDATE file_tags SET owner_id = files.owner_id
+			FROM file_file_tag JOIN files ON files.id = file_file_tag.file_id
+			WHERE file_file_tag.file_tag_id = file_tags.id`).Error
+		if err != nil {
+			return err
+		}
+
+		var tags []models.FileTag
+		if err := tx.Unscoped().Order("id").Find(&tags).Error; err != nil {
+			return err
+		}
+
+		kept := make(map[uint]map[string]uint)
+		for _, tag := range tags {
+			name := models.NormalizeTag(tag.Name)
+			if len(name) > models.MaxTagLength {
+				name = name[:models.MaxTagLength]
+			}
+			if kept[tag.OwnerID] == nil {
+				kept[tag.OwnerID] = make(map[string]uint)
+			}
+			target, exists := kept[tag.OwnerID][name]
+
+			switch {
+			case name == "" || tag.DeletedAt.Valid:
+				err = ts.deleteTags(tx, []uint{tag.ID})
+			case exists:
+				err = ts.moveFiles(tx, tag.ID, target)
+				if err == nil {
+					err = ts.deleteTags(tx, []uint{tag.ID})
+				}
+			default:
+				kept[tag.OwnerID][name] = tag.ID
+				if name != tag.Name {
+					err = tx.Model(&models.FileTag{}).Where("id = ?", tag.ID).Update("name", name).Error
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Exec("CREATE UNIQUE INDEX " + tagIndexName + " ON file_tags (owner_id, name)").Error
+	})
+	if err != nil {
+		ts.log.Error("Failed to migrate tags", zap.Error(err))
+		return err
+	}
+
+	ts.log.Info("Migrated tags to unique names per owner")
+	return nil
+}
+
+// FindOrCreateTags returns the owner's tags with the normalized names,
+// creating the missing ones. Names longer than models.MaxTagLength are
+// skipped.
+func FindOrCreateTags(tx *gorm.DB, ownerID uint, names []string) ([]models.FileTag, error) {
+	valid := []string{}
+	for _, name := range models.NormalizeTags(names) {
+		if len(name) <= models.MaxTagLength {
+			valid = append(valid, name)
+		}
+	}
+	if len(valid) == 0 {
+		return []models.FileTag{}, nil
+	}
+
+	missing := make([]models.FileTag, len(valid))
+	for i, name := range valid {
+		missing[i] = models.FileTag{OwnerID: ownerID, Name: name}
+	}
+	err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "owner_id"}, {Name: "name"}}, DoNothing: true}).
+		Create(&missing).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []models.FileTag
+	if err := tx.Where("owner_id = ? AND name IN ?", ownerID, valid).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// ListTags returns the owner's tags with the number of files outside the
+// trash that have them.
+func (ts *TagService) ListTags(command *models.FileCommand) (interface{}, error) {
+	tags := []models.TagInfo{}
+	err := ts.db.Table("file_tags").
+		Select("file_tags.name, COUNT(files.id) AS file_count").
+		Joins("JOIN file_file_tag ON file_file_tag.file_tag_id = file_tags.id").
+		Joins("JOIN files ON files.id = file_file_tag.file_id AND files.deleted_at IS NULL").
+		Where("file_tags.owner_id = ? AND file_tags.deleted_at IS NULL", command.OwnerID).
+		Group("file_tags.name").
+		Order("file_tags.name").
+		Scan(&tags).Error
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (ts *TagService) AddTags(command *models.FileCommand) (interface{}, error) {
+	names, err := tagsPayload(command)
+	if err != nil {
+		return nil, err
+	}
+	file, err := findAccessibleFile(ts.db, command, models.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ts.db.Transaction(func(tx *gorm.DB) error {
+		// Tags belong to the file's owner, who may not be the caller.
+		tags, err := FindOrCreateTags(tx, file.OwnerID, names)
+		if err != nil {
+			return err
+		}
+		if file.OwnerID != command.OwnerID {
+			if err := ts.checkSharedTags(tx, command.Principal(), tags); err != nil {
+				return err
+			}
+		}
+		return tx.Model(file).Association("FileTags").Append(tags)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ts.fileInfo(file.ID)
+}
+
+// checkSharedTags rejects tags that carry access entries unless the
+// principal has write permission on the tag itself, so that adding a tag to a
+// file shared with the principal does not share the file with anyone else.
+func (ts *TagService) checkSharedTags(tx *gorm.DB, principal models.Principal, tags []models.FileTag) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(tags))
+	for i, tag := range tags {
+		ids[i] = tag.ID
+	}
+
+	grants, vars := aclGrants(principal, models.PermissionWrite)
+	var count int64
+	err := tx.Model(&models.FileTag{}).
+		Where("file_tags.id IN ?", ids).
+		Where("EXISTS (SELECT 1 FROM acl_entries WHERE acl_entries.tag_id = file_tags.id AND acl_entries.deleted_at IS NULL)").
+		Where("NOT EXISTS (SELECT 1 FROM acl_entries WHERE "+grants+" AND acl_entries.tag_id = file_tags.id)", vars...).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		// The owner's shared tags stay hidden, like the files the principal
+		// cannot see.
+		return utils.ErrTagNotFound
+	}
+	return nil
+}
+
+func (ts *TagService) RemoveTags(command *models.FileCommand) (interface{}, error) {
+	names, err := tagsPayload(command)
+	if err != nil {
+		return nil, err
+	}
+	file, err := findAccessibleFile(ts.db, command, models.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []models.FileTag
+	if err := ts.db.Where("owner_id = ? AND name IN ?", file.OwnerID, names).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	if len(tags) > 0 {
+		if err := ts.db.Model(file).Association("FileTags").Delete(tags); err != nil {
+			return nil, err
+		}
+	}
+	return ts.fileInfo(file.ID)
+}
+
+// RenameTag renames one of the owner's tags. Renaming to an existing tag is a
+// conflict; MergeTags joins tags.
+func (ts *TagService) RenameTag(command *models.FileCommand) (interface{}, error) {
+	var payload models.RenameTagPayload
+	if err := json.Unmarshal(command.Payload, &payload); err != nil {
+		return nil, utils.ErrInvalidCommand
+	}
+	name, newName := models.NormalizeTag(payload.Name), models.NormalizeTag(payload.NewName)
+	if name == "" || newName == "" || len(newName) > models.MaxTagLength {
+		return nil, utils.ErrInvalidCommand
+	}
+
+	tag, err := ts.findTag(ts.db, command.OwnerID, name)
+	if err != nil {
+		return nil, err
+	}
+	if name == newName {
+		return models.TagInfo{Name: name, FileCount: ts.countFiles(tag.ID)}, nil
+	}
+
+	existing, err := ts.findTag(ts.db, command.OwnerID, newName)
+	if err != nil && !errors.Is(err, utils.ErrTagNotFound) {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, utils.ErrTagNameInUse
+	}
+
+	if err := ts.db.Model(tag).Update("name", newName).Error; err != nil {
+		return nil, err
+	}
+	ts.log.Info("Tag renamed", zap.Uint("ownerID", command.OwnerID), zap.String("from", name), zap.String("to", newName))
+	return models.TagInfo{Name: newName, FileCount: ts.countFiles(tag.ID)}, nil
+}
+
+// MergeTags moves the files and access entries of the source tags to the
+// target tag, which is created if needed, and deletes the source tags.
+func (ts *TagService) MergeTags(command *models.FileCommand) (interface{}, error) {
+	var payload models.MergeTagsPayload
+	if err := json.Unmarshal(command.Payload, &payload); err != nil {
+		return nil, utils.ErrInvalidCommand
+	}
+	target := models.NormalizeTag(payload.Target)
+	if target == "" || len(target) > models.MaxTagLength {
+		return nil, utils.ErrInvalidCommand
+	}
+	sources := []string{}
+	for _, source := range models.NormalizeTags(payload.Sources) {
+		if source != target {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, utils.ErrInvalidCommand
+	}
+
+	var targetTag models.FileTag
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		var sourceTags []models.FileTag
+		if err := tx.Where("owner_id = ? AND name IN ?", command.OwnerID, sources).Find(&sourceTags).Error; err != nil {
+			return err
+		}
+		if len(sourceTags) == 0 {
+			return utils.ErrTagNotFound
+		}
+
+		tags, err := FindOrCreateTags(tx, command.OwnerID, []string{target})
+		if err != nil {
+			return err
+		}
+		targetTag = tags[0]
+
+		ids := make([]uint, len(sourceTags))
+		for i, source := range sourceTags {
+			if err := ts.moveFiles(tx, source.ID, targetTag.ID); err != nil {
+				return err
+			}
+			if err := ts.moveAccess(tx, source.ID, targetTag.ID); err != nil {
+				return err
+			}
+			ids[i] = source.ID
+		}
+		return ts.deleteTags(tx, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ts.log.Info("Tags merged", zap.Uint("ownerID", command.OwnerID), zap.Strings("sources", sources), zap.String("target", target))
+	return models.TagInfo{Name: targetTag.Name, FileCount: ts.countFiles(targetTag.ID)}, nil
+}
+
+// DeleteOwnerTags deletes every tag of the owner. The files must be gone.
+func (ts *TagService) DeleteOwnerTags(ownerID uint) error {
+	var ids []uint
+	if err := ts.db.Model(&models.FileTag{}).Where("owner_id = ?", ownerID).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return ts.deleteTags(ts.db, ids)
+}
+
+// moveFiles gives the files tagged with from the tag to instead.
+func (ts *TagService) moveFiles(tx *gorm.DB, from, to uint) error {
+	err := tx.Exec(`INSERT INTO file_file_tag (file_id, file_tag_id)
+		SELECT file_id, ? FROM file_file_tag WHERE file_tag_id = ?
+		ON CONFLICT DO NOTHING`, to, from).Error
+	if err != nil {
+		return err
+	}
+	return tx.Exec("DELETE FROM file_file_tag WHERE file_tag_id = ?", from).Error
+}
+
+// moveAccess gives the access entries of the tag from to the tag to, unless
+// to already has an entry for the same principal.
+func (ts *TagService) moveAccess(tx *gorm.DB, from, to uint) error {
+	return tx.Exec(`UPDATE acl_entries SET tag_id = ? WHERE tag_id = ? AND NOT EXISTS (
+		SELECT 1 FROM acl_entries AS existing
+		WHERE existing.tag_id = ? AND existing.principal_type = acl_entries.principal_type
+			AND existing.principal_id = acl_entries.principal_id)`, to, from, to).Error
+}
+
+func (ts *TagService) deleteTags(tx *gorm.DB, ids []uint) error {
+	if err := tx.Exec("DELETE FROM file_file_tag WHERE file_tag_id IN ?", ids).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("tag_id IN ?", ids).Delete(&models.ACLEntry{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id IN ?", ids).Delete(&models.FileTag{}).Error
+}
+
+func (ts *TagService) findTag(tx *gorm.DB, ownerID uint, name string) (*models.FileTag, error) {
+	var tag models.FileTag
+	if err := tx.Where("owner_id = ? AND name = ?", ownerID, name).First(&tag).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrTagNotFound
+		}
+		return nil, err
+	}
+	return &tag, nil
+}
+
+func (ts *TagService) fileInfo(fileID uint) (interface{}, error) {
+	var file models.File
+	if err := ts.db.Preload("FileTags").First(&file, fileID).Error; err != nil {
+		return nil, err
+	}
+	return models.ConvertFileToFileInfo(file), nil
+}
+
+func (ts *TagService) countFiles(tagID uint) int64 {
+	var count int64
+	ts.db.Table("file_file_tag").
+		Joins("JOIN files ON files.id = file_file_tag.file_id AND files.deleted_at IS NULL").
+		Where("file_file_tag.file_tag_id = ?", tagID).
+		Count(&count)
+	return count
+}
+
+// tagsPayload returns the normalized tags of an add_tags or remove_tags
+// command.
+func tagsPayload(command *models.FileCommand) ([]string, error) {
+	var payload models.TagsPayload
+	if err := json.Unmarshal(command.Payload, &payload); err != nil {
+		return nil, utils.ErrInvalidCommand
+	}
+	names := models.NormalizeTags(payload.Tags)
+	if len(names) == 0 {
+		return nil, utils.ErrInvalidCommand
+	}
+	for _, name := range names {
+		if len(name) > models.MaxTagLength {
+			return nil, utils.ErrInvalidCommand
+		}
+	}
+	return names, nil
+}
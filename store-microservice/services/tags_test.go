@@ -0,0 +1,224 @@
+package services_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"store/models"
+	"store/services"
+	"store/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func fileCommand(t *testing.T, action string, ownerID, fileID uint, payload interface{}) *models.FileCommand {
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+	return &models.FileCommand{Action: action, OwnerID: ownerID, FileID: fileID, Payload: body}
+}
+
+func createFile(t *testing.T, db *gorm.DB, ownerID uint, name string) *models.File {
+	file := models.File{OwnerID: ownerID, FileName: name, CurrentVersion: 1}
+	require.NoError(t, db.Create(&file).Error)
+	return &file
+}
+
+func grantAccess(t *testing.T, access *services.AccessService, ownerID, fileID uint, payload models.ACLPayload) models.ACLEntryInfo {
+	info, err := access.GrantAccess(fileCommand(t, models.FileActionGrantAccess, ownerID, fileID, payload))
+	require.NoError(t, err)
+	return info.(models.ACLEntryInfo)
+}
+
+func addTags(t *testing.T, tags *services.TagService, ownerID, fileID uint, names ...string) error {
+	_, err := tags.AddTags(fileCommand(t, models.FileActionAddTags, ownerID, fileID, models.TagsPayload{Tags: names}))
+	return err
+}
+
+func tagNames(t *testing.T, db *gorm.DB, fileID uint) []string {
+	var names []string
+	err := db.Table("file_tags").
+		Joins("JOIN file_file_tag ON file_file_tag.file_tag_id = file_tags.id").
+		Where("file_file_tag.file_id = ?", fileID).
+		Order("file_tags.name").
+		Pluck("file_tags.name", &names).Error
+	require.NoError(t, err)
+	return names
+}
+
+func TestTagService_AddSharedTagsNeedsWriteOnTag(t *testing.T) {
+	db := openTestDB(t)
+	tags := services.NewTagService(db)
+	access := services.NewAccessService(db)
+	ownerID, collaboratorID, strangerID := testOwnerID(), testOwnerID(), testOwnerID()
+
+	tagged := createFile(t, db, ownerID, "tagged.txt")
+	require.NoError(t, addTags(t, tags, ownerID, tagged.ID, "team", "plain"))
+	grantAccess(t, access, ownerID, 0, models.ACLPayload{Tag: "team", PrincipalType: models.PrincipalUser, PrincipalID: strangerID, Permission: models.PermissionRead})
+
+	shared := createFile(t, db, ownerID, "shared.txt")
+	grantAccess(t, access, ownerID, shared.ID, models.ACLPayload{PrincipalType: models.PrincipalUser, PrincipalID: collaboratorID, Permission: models.PermissionWrite})
+
+	// Test case: a collaborator cannot share the file further with a shared tag
+	err := addTags(t, tags, collaboratorID, shared.ID, "plain", "team")
+	assert.ErrorIs(t, err, utils.ErrTagNotFound)
+	assert.Empty(t, tagNames(t, db, shared.ID))
+
+	// Test case: tags without access entries can be added
+	require.NoError(t, addTags(t, tags, collaboratorID, shared.ID, "plain", "new"))
+	assert.Equal(t, []string{"new", "plain"}, tagNames(t, db, shared.ID))
+
+	// Test case: read on the tag is not enough, write is
+	grantAccess(t, access, ownerID, 0, models.ACLPayload{Tag: "team", PrincipalType: models.PrincipalUser, PrincipalID: collaboratorID, Permission: models.PermissionRead})
+	assert.ErrorIs(t, addTags(t, tags, collaboratorID, shared.ID, "team"), utils.ErrTagNotFound)
+	grantAccess(t, access, ownerID, 0, models.ACLPayload{Tag: "team", PrincipalType: models.PrincipalUser, PrincipalID: collaboratorID, Permission: models.PermissionWrite})
+	require.NoError(t, addTags(t, tags, collaboratorID, shared.ID, "team"))
+	assert.Equal(t, []string{"new", "plain", "team"}, tagNames(t, db, shared.ID))
+
+	// Test case: the owner adds shared tags freely
+	own := createFile(t, db, ownerID, "own.txt")
+	require.NoError(t, addTags(t, tags, ownerID, own.ID, "team"))
+	assert.Equal(t, []string{"team"}, tagNames(t, db, own.ID))
+}
+
+func TestTagService_MigrateTags(t *testing.T) {
+	db := openTestDB(t)
+	tags := services.NewTagService(db)
+	ownerID := testOwnerID()
+
+	// Tags saved before the migration have no owner and repeat names.
+	require.NoError(t, db.Migrator().DropIndex(&models.FileTag{}, "idx_file_tags_owner_name"))
+	t.Cleanup(func() { _ = tags.MigrateTags() })
+
+	a := createFile(t, db, ownerID, "a.txt")
+	b := createFile(t, db, ownerID, "b.txt")
+	long := strings.Repeat("x", models.MaxTagLength+10)
+	oldTags := []struct {
+		name   string
+		fileID uint
+	}{
+		{"Invoice", a.ID},
+		{" invoice", b.ID},
+		{"INVOICE", a.ID},
+		{"  ", a.ID},
+		{"draft", b.ID},
+		{long, a.ID},
+	}
+	ids := make([]uint, len(oldTags))
+	for i, old := range oldTags {
+		tag := models.FileTag{Name: old.name}
+		require.NoError(t, db.Create(&tag).Error)
+		require.NoError(t, db.Exec("INSERT INTO file_file_tag (file_id, file_tag_id) VALUES (?, ?)", old.fileID, tag.ID).Error)
+		ids[i] = tag.ID
+	}
+	require.NoError(t, db.Delete(&models.FileTag{}, ids[4]).Error)
+
+	require.NoError(t, tags.MigrateTags())
+	assert.True(t, db.Migrator().HasIndex(&models.FileTag{}, "idx_file_tags_owner_name"))
+
+	// Test case: duplicates are merged into the first tag of the name
+	var migrated []models.FileTag
+	require.NoError(t, db.Unscoped().Where("owner_id = ?", ownerID).Order("id").Find(&migrated).Error)
+	require.Len(t, migrated, 2)
+	assert.Equal(t, ids[0], migrated[0].ID)
+	assert.Equal(t, "invoice", migrated[0].Name)
+	assert.Equal(t, long[:models.MaxTagLength], migrated[1].Name)
+	assert.Equal(t, []string{"invoice", long[:models.MaxTagLength]}, tagNames(t, db, a.ID))
+	assert.Equal(t, []string{"invoice"}, tagNames(t, db, b.ID))
+
+	// Test case: empty and deleted tags are removed with their files
+	var removed int64
+	require.NoError(t, db.Unscoped().Model(&models.FileTag{}).Where("id IN ?", []uint{ids[1], ids[2], ids[3], ids[4]}).Count(&removed).Error)
+	assert.Zero(t, removed)
+	require.NoError(t, db.Table("file_file_tag").Where("file_tag_id IN ?", []uint{ids[1], ids[2], ids[3], ids[4]}).Count(&removed).Error)
+	assert.Zero(t, removed)
+
+	// Test case: a second run does nothing
+	require.NoError(t, tags.MigrateTags())
+}
+
+func TestTagService_RenameTag(t *testing.T) {
+	db := openTestDB(t)
+	tags := services.NewTagService(db)
+	ownerID := testOwnerID()
+
+	file := createFile(t, db, ownerID, "a.txt")
+	require.NoError(t, addTags(t, tags, ownerID, file.ID, "draft", "final"))
+	rename := func(name, newName string) (interface{}, error) {
+		return tags.RenameTag(fileCommand(t, models.FileActionRenameTag, ownerID, 0, models.RenameTagPayload{Name: name, NewName: newName}))
+	}
+
+	// Test case: the tag keeps its files under the new, normalized name
+	info, err := rename("Draft", " Review ")
+	require.NoError(t, err)
+	assert.Equal(t, models.TagInfo{Name: "review", FileCount: 1}, info)
+	assert.Equal(t, []string{"final", "review"}, tagNames(t, db, file.ID))
+
+	// Test case: renaming to an existing tag is a conflict
+	_, err = rename("review", "final")
+	assert.ErrorIs(t, err, utils.ErrTagNameInUse)
+
+	_, err = rename("missing", "other")
+	assert.ErrorIs(t, err, utils.ErrTagNotFound)
+	_, err = rename("review", strings.Repeat("x", models.MaxTagLength+1))
+	assert.ErrorIs(t, err, utils.ErrInvalidCommand)
+
+	// Test case: other owners' tags are not renamed
+	_, err = tags.RenameTag(fileCommand(t, models.FileActionRenameTag, testOwnerID(), 0, models.RenameTagPayload{Name: "review", NewName: "other"}))
+	assert.ErrorIs(t, err, utils.ErrTagNotFound)
+}
+
+func TestTagService_MergeTags(t *testing.T) {
+	db := openTestDB(t)
+	tags := services.NewTagService(db)
+	access := services.NewAccessService(db)
+	ownerID, readerID, writerID := testOwnerID(), testOwnerID(), testOwnerID()
+
+	a := createFile(t, db, ownerID, "a.txt")
+	b := createFile(t, db, ownerID, "b.txt")
+	require.NoError(t, addTags(t, tags, ownerID, a.ID, "2024", "invoices"))
+	require.NoError(t, addTags(t, tags, ownerID, b.ID, "bills"))
+	grantAccess(t, access, ownerID, 0, models.ACLPayload{Tag: "bills", PrincipalType: models.PrincipalUser, PrincipalID: readerID, Permission: models.PermissionRead})
+	grantAccess(t, access, ownerID, 0, models.ACLPayload{Tag: "bills", PrincipalType: models.PrincipalUser, PrincipalID: writerID, Permission: models.PermissionWrite})
+	grantAccess(t, access, ownerID, 0, models.ACLPayload{Tag: "invoices", PrincipalType: models.PrincipalUser, PrincipalID: writerID, Permission: models.PermissionRead})
+	merge := func(target string, sources ...string) (interface{}, error) {
+		return tags.MergeTags(fileCommand(t, models.FileActionMergeTags, ownerID, 0, models.MergeTagsPayload{Sources: sources, Target: target}))
+	}
+
+	// Test case: the files of the sources move to the existing target
+	info, err := merge("invoices", "bills", "Invoices", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, models.TagInfo{Name: "invoices", FileCount: 2}, info)
+	assert.Equal(t, []string{"2024", "invoices"}, tagNames(t, db, a.ID))
+	assert.Equal(t, []string{"invoices"}, tagNames(t, db, b.ID))
+
+	var remaining int64
+	require.NoError(t, db.Unscoped().Model(&models.FileTag{}).Where("owner_id = ? AND name = ?", ownerID, "bills").Count(&remaining).Error)
+	assert.Zero(t, remaining)
+
+	// Test case: access entries follow the merge; the target keeps its own
+	// entry for a principal both tags had
+	entries, err := access.ListAccess(fileCommand(t, models.FileActionListAccess, ownerID, 0, models.ACLPayload{Tag: "invoices"}))
+	require.NoError(t, err)
+	permissions := map[uint]string{}
+	for _, entry := range entries.([]models.ACLEntryInfo) {
+		permissions[entry.PrincipalID] = entry.Permission
+	}
+	assert.Equal(t, map[uint]string{readerID: models.PermissionRead, writerID: models.PermissionRead}, permissions)
+	var orphaned int64
+	require.NoError(t, db.Unscoped().Model(&models.ACLEntry{}).Where("owner_id = ? AND tag_id IS NOT NULL AND tag_id NOT IN (SELECT id FROM file_tags)", ownerID).Count(&orphaned).Error)
+	assert.Zero(t, orphaned)
+
+	// Test case: merging into a new name creates the tag
+	info, err = merge("paperwork", "invoices", "2024")
+	require.NoError(t, err)
+	assert.Equal(t, models.TagInfo{Name: "paperwork", FileCount: 2}, info)
+	assert.Equal(t, []string{"paperwork"}, tagNames(t, db, a.ID))
+
+	_, err = merge("paperwork", "missing")
+	assert.ErrorIs(t, err, utils.ErrTagNotFound)
+	_, err = merge("paperwork", "paperwork")
+	assert.ErrorIs(t, err, utils.ErrInvalidCommand)
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const FileThumbnailsQueue = "file-thumbnails-queue"
+
+// ThumbnailSizes are the sizes in pixels of the longer side of the rendered
+// thumbnails, from small to large. Images are never scaled up.
+var ThumbnailSizes = []int{64, 128, 256, 512}
+
+const defaultThumbnailSize = 256
+
+// maxThumbnailPixels keeps images that would take too much memory to decode
+// from being rendered.
+const maxThumbnailPixels = 50_000_000
+
+// thumbnailTypes are the image types thumbnails are rendered for.
+var thumbnailTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// ThumbnailService renders thumbnails of image uploads. Ingest only queues a
+// job on FileThumbnailsQueue, which a worker takes from with HandleJob. The
+// thumbnails are saved encrypted next to the content of the version.
+type ThumbnailService struct {
+	db              *gorm.DB
+	rabbitMQService RabbitMQService
+	fileSystem      *FileSystemService
+	volumeLimit     *VolumeLimitService
+	filePath        string
+	secretKey       []byte
+	log             *zap.Logger
+}
+
+func NewThumbnailService(db *gorm.DB, rabbitMQService RabbitMQService, fileSystem *FileSystemService, volumeLimit *VolumeLimitService, filePath string, secretKey []byte) *ThumbnailService {
+	log := utils.GetLogger()
+	return &ThumbnailService{db, rabbitMQService, fileSystem, volumeLimit, filePath, secretKey, log}
+}
+
+func (ts *ThumbnailService) RegisterCommands(commands *CommandService) {
+	commands.Register(models.FileActionThumbnail, ts.Thumbnail)
+}
+
+// Queue asks for the thumbnails of the current version of file, if it is an
+// image they can be rendered for.
+func (ts *ThumbnailService) Queue(file *models.File) error {
+	var version models.FileVersion
+	if err := ts.db.Where("file_id = ? AND version = ?", file.ID, file.CurrentVersion).First(&version).Error; err != nil {
+		ts.log.Error("Failed to find version for thumbnails", zap.Uint("fileID", file.ID), zap.Error(err))
+		return err
+	}
+	if !slices.Contains(thumbnailTypes, version.FileType) {
+		return nil
+	}
+	return ts.queue(&version)
+}
+
+// QueueMissing queues the current versions of images uploaded before
+// thumbnails were rendered.
+func (ts *ThumbnailService) QueueMissing() error {
+	var versions []models.FileVersion
+	err := ts.db.Joins("JOIN files ON files.id = file_versions.file_id AND files.current_version = file_versions.version").
+		Where("files.deleted_at IS NULL").
+		Where("file_versions.file_type IN ? AND COALESCE(file_versions.thumbnail_status, '') = ''", thumbnailTypes).
+		Find(&versions).Error
+	if err != nil {
+		ts.log.Error("Failed to find versions without thumbnails", zap.Error(err))
+		return err
+	}
+
+	for i := range versions {
+		if err := ts.queue(&versions[i]); err != nil {
+			return err
+		}
+	}
+	if len(versions) > 0 {
+		ts.log.Info("Queued missing thumbnails", zap.Int("count", len(versions)))
+	}
+	return nil
+}
+
+func (ts *ThumbnailService) queue(version *models.FileVersion) error {
+	if err := ts.db.Model(version).UpdateColumn("thumbnail_status", models.ThumbnailPending).Error; err != nil {
+		return err
+	}
+	job, err := json.Marshal(models.ThumbnailJob{FileVersionID: version.ID})
+	if err != nil {
+		return err
+	}
+	if err := ts.rabbitMQService.PublishToQueue(job, FileThumbnailsQueue); err != nil {
+		ts.log.Error("Failed to queue thumbnails", zap.Uint("versionID", version.ID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// HandleJob renders and saves the thumbnails of a version. Versions that
+// were deleted in the meantime are skipped.
+func (ts *ThumbnailService) HandleJob(body []byte) error {
+	var job models.ThumbnailJob
+	if err := json.Unmarshal(body, &job); err != nil {
+		ts.log.Error("Failed to unmarshal thumbnail job from message", zap.Error(err))
+		return err
+	}
+
+	var version models.FileVersion
+	if err := ts.db.First(&version, job.FileVersionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	thumbnailType, err := ts.render(&version)
+	if err != nil {
+		ts.log.Warn("Failed to render thumbnails", zap.Uint("versionID", version.ID), zap.Error(err))
+		return ts.db.Model(&version).UpdateColumn("thumbnail_status", models.ThumbnailFailed).Error
+	}
+
+	result := ts.db.Model(&version).UpdateColumns(map[string]interface{}{
+		"thumbnail_status": models.ThumbnailReady,
+		"thumbnail_type":   thumbnailType,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	// The version was pruned while its thumbnails were rendered.
+	if result.RowsAffected == 0 {
+		ts.volumeLimit.Remove(removeThumbnails(ts.fileSystem, ts.filePath, version.StorageKey))
+		return nil
+	}
+
+	ts.log.Info("Thumbnails rendered", zap.Uint("fileID", version.FileID), zap.Int("version", version.Version))
+	return nil
+}
+
+// render saves a thumbnail of every size in ThumbnailSizes and returns their
+// MIME type. JPEG photos stay JPEG; other images become PNG, which keeps
+// their transparency.
+func (ts *ThumbnailService) render(version *models.FileVersion) (string, error) {
+	content, err := ts.fileSystem.DecryptFile(filepath.Join(ts.filePath, version.StorageKey), ts.secretKey, version.FileSize)
+	if err != nil {
+		return "", err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return "", errors.New("image is too large for thumbnails")
+	}
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+
+	thumbnailType := "image/png"
+	if version.FileType == "image/jpeg" {
+		thumbnailType = "image/jpeg"
+	}
+
+	// Every size is scaled from the next larger one, so the full image is
+	// only read once.
+	for i := len(ThumbnailSizes) - 1; i >= 0; i-- {
+		img = scaleToFit(img, ThumbnailSizes[i])
+
+		var buf bytes.Buffer
+		if thumbnailType == "image/jpeg" {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, img)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		path := thumbnailPath(ts.filePath, version.StorageKey, ThumbnailSizes[i])
+		if err := ts.fileSystem.EncryptAndSaveFile(buf.Bytes(), path, ts.secretKey); err != nil {
+			return "", err
+		}
+		if info, err := os.Stat(filepath.Join(".", path) + ".encrypted"); err == nil {
+			ts.volumeLimit.Commit(0, info.Size())
+		}
+	}
+	return thumbnailType, nil
+}
+
+// Thumbnail sends the thumbnail of a version in the smallest size that is at
+// least the requested one, or the largest size.
+func (ts *ThumbnailService) Thumbnail(command *models.FileCommand) (interface{}, error) {
+	var payload models.ThumbnailPayload
+	if len(command.Payload) > 0 {
+		if err := json.Unmarshal(command.Payload, &payload); err != nil || payload.Version < 0 || payload.Size < 0 {
+			return nil, utils.ErrInvalidCommand
+		}
+	}
+
+	file, err := findAccessibleFile(ts.db, command, models.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Version == 0 {
+		payload.Version = file.CurrentVersion
+	}
+	var version models.FileVersion
+	if err := ts.db.Where("file_id = ? AND version = ?", file.ID, payload.Version).First(&version).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrVersionNotFound
+		}
+		return nil, err
+	}
+	switch version.ThumbnailStatus {
+	case models.ThumbnailReady:
+	case models.ThumbnailPending:
+		return nil, utils.ErrThumbnailPending
+	default:
+		return nil, utils.ErrThumbnailNotFound
+	}
+
+	size := thumbnailSize(payload.Size)
+	// Thumbnails are saved with AES-CTR, which needs no size.
+	content, err := ts.fileSystem.DecryptFile(thumbnailPath(ts.filePath, version.StorageKey, size), ts.secretKey, -1)
+	if err != nil {
+		return nil, err
+	}
+	return &models.FileContent{
+		OwnerID:    file.OwnerID,
+		FileName:   file.FileName,
+		FileType:   version.ThumbnailType,
+		Version:    version.Version,
+		FileSize:   int64(len(content)),
+		ETag:       version.ETag() + "-" + strconv.Itoa(size),
+		ModifiedAt: version.CreatedAt,
+		Content:    content,
+	}, nil
+}
+
+// removeThumbnails deletes the thumbnails saved for the content under
+// storageKey and returns their size.
+func removeThumbnails(fileSystem *FileSystemService, filePath, storageKey string) int64 {
+	var removed int64
+	for _, size := range ThumbnailSizes {
+		if n, err := fileSystem.RemoveFile(thumbnailPath(filePath, storageKey, size)); err == nil {
+			removed += n
+		}
+	}
+	return removed
+}
+
+func thumbnailPath(filePath, storageKey string, size int) string {
+	return filepath.Join(filePath, storageKey+".thumb"+strconv.Itoa(size))
+}
+
+func thumbnailSize(requested int) int {
+	if requested == 0 {
+		return defaultThumbnailSize
+	}
+	for _, size := range ThumbnailSizes {
+		if size >= requested {
+			return size
+		}
+	}
+	return ThumbnailSizes[len(ThumbnailSizes)-1]
+}
+
+// scaleToFit scales img down so its longer side is at most size pixels.
+// Every pixel of the result is the average of the pixels it covers.
+func scaleToFit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= size && srcH <= size {
+		return img
+	}
+	dstW, dstH := size, size
+	if srcW > srcH {
+		dstH = max(1, srcH*size/srcW)
+	} else {
+		dstW = max(1, srcW*size/srcH)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*srcH/dstH
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcH/dstH)
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*srcW/dstW
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcW/dstW)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,294 @@
+package services_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"store/models"
+	"store/services"
+	"store/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const thumbnailVolume = 1 << 30
+
+var thumbnailKey = []byte(hex.EncodeToString(bytes.Repeat([]byte{0x42}, 32)))
+
+// newThumbnailService saves files in a temporary working directory, as the
+// storage path is relative to it.
+func newThumbnailService(t *testing.T, db *gorm.DB) (*services.ThumbnailService, *services.FileSystemService, *services.VolumeLimitService) {
+	volumeLimit, dir := newVolumeLimit(t, 0, thumbnailVolume)
+	fileSystem := services.NewFileSystemService(utils.GetLogger())
+	return services.NewThumbnailService(db, services.RabbitMQService{}, fileSystem, volumeLimit, dir, thumbnailKey), fileSystem, volumeLimit
+}
+
+// saveImage saves content encrypted like an upload and returns its version.
+func saveImage(t *testing.T, fileSystem *services.FileSystemService, storageKey, fileType string, content []byte) *models.FileVersion {
+	require.NoError(t, fileSystem.EncryptAndSaveFile(content, filepath.Join("files", storageKey), thumbnailKey))
+	return &models.FileVersion{Version: 1, FileType: fileType, FileSize: int64(len(content)), StorageKey: storageKey}
+}
+
+// gradient is an opaque image whose pixels differ, so scaling shows.
+func gradient(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func jpegImage(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, gradient(width, height), nil))
+	return buf.Bytes()
+}
+
+// transparentPNG has a transparent left half.
+func transparentPNG(t *testing.T, width, height int) []byte {
+	img := gradient(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width/2; x++ {
+			img.SetRGBA(x, y, color.RGBA{})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func gifImage(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, gif.Encode(&buf, gradient(width, height), nil))
+	return buf.Bytes()
+}
+
+// hugePNG is a PNG whose header claims width x height pixels. Only the
+// header is valid, which is all that is read before the size is checked.
+func hugePNG(t *testing.T, width, height uint32) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))))
+	data := buf.Bytes()
+	// The IHDR chunk follows the 8 byte signature: length, type, width,
+	// height, 5 more bytes and the CRC of type and data.
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+// thumbnailBounds decodes the saved thumbnails and returns their sizes by
+// thumbnail size, and the format they were saved in.
+func thumbnailBounds(t *testing.T, fileSystem *services.FileSystemService, storageKey string) (map[int]image.Point, string) {
+	bounds := map[int]image.Point{}
+	var format string
+	for _, size := range services.ThumbnailSizes {
+		content, err := fileSystem.DecryptFile(services.ThumbnailPath("files", storageKey, size), thumbnailKey, -1)
+		require.NoError(t, err)
+		img, decoded, err := image.Decode(bytes.NewReader(content))
+		require.NoError(t, err)
+		bounds[size], format = img.Bounds().Size(), decoded
+	}
+	return bounds, format
+}
+
+func thumbnailsOnDisk(t *testing.T, storageKey string) int64 {
+	var total int64
+	for _, size := range services.ThumbnailSizes {
+		info, err := os.Stat(services.ThumbnailPath("files", storageKey, size) + ".encrypted")
+		if err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+func TestThumbnailService_Render(t *testing.T) {
+	thumbnails, fileSystem, volumeLimit := newThumbnailService(t, nil)
+
+	// Test case: JPEG photos stay JPEG and keep their aspect ratio
+	photo := saveImage(t, fileSystem, "photo", "image/jpeg", jpegImage(t, 1000, 500))
+	assertFree(t, volumeLimit, thumbnailVolume-fileSize(t, "photo"))
+	thumbnailType, err := services.RenderThumbnails(thumbnails, photo)
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", thumbnailType)
+	bounds, format := thumbnailBounds(t, fileSystem, "photo")
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, map[int]image.Point{64: {64, 32}, 128: {128, 64}, 256: {256, 128}, 512: {512, 256}}, bounds)
+
+	// Test case: the thumbnails count against the volume
+	assertFree(t, volumeLimit, thumbnailVolume-fileSize(t, "photo")-thumbnailsOnDisk(t, "photo"))
+
+	// Test case: transparent PNGs stay PNG with their transparency, and
+	// small images are not scaled up
+	logo := saveImage(t, fileSystem, "logo", "image/png", transparentPNG(t, 300, 200))
+	thumbnailType, err = services.RenderThumbnails(thumbnails, logo)
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", thumbnailType)
+	bounds, format = thumbnailBounds(t, fileSystem, "logo")
+	assert.Equal(t, "png", format)
+	assert.Equal(t, map[int]image.Point{64: {64, 42}, 128: {128, 85}, 256: {256, 170}, 512: {300, 200}}, bounds)
+	content, err := fileSystem.DecryptFile(services.ThumbnailPath("files", "logo", 64), thumbnailKey, -1)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(content))
+	require.NoError(t, err)
+	_, _, _, alpha := img.At(0, 0).RGBA()
+	assert.Zero(t, alpha)
+	_, _, _, alpha = img.At(63, 41).RGBA()
+	assert.Equal(t, uint32(0xffff), alpha)
+
+	// Test case: GIFs become PNG
+	animation := saveImage(t, fileSystem, "animation", "image/gif", gifImage(t, 100, 40))
+	thumbnailType, err = services.RenderThumbnails(thumbnails, animation)
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", thumbnailType)
+	bounds, format = thumbnailBounds(t, fileSystem, "animation")
+	assert.Equal(t, "png", format)
+	assert.Equal(t, map[int]image.Point{64: {64, 25}, 128: {100, 40}, 256: {100, 40}, 512: {100, 40}}, bounds)
+}
+
+func TestThumbnailService_RenderRejectsLargeImages(t *testing.T) {
+	thumbnails, fileSystem, _ := newThumbnailService(t, nil)
+
+	// Test case: images over maxThumbnailPixels are refused before decoding
+	content := hugePNG(t, 10000, uint32(services.MaxThumbnailPixels/10000+1))
+	config, err := png.DecodeConfig(bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Greater(t, config.Width*config.Height, services.MaxThumbnailPixels)
+	huge := saveImage(t, fileSystem, "huge", "image/png", content)
+	_, err = services.RenderThumbnails(thumbnails, huge)
+	assert.EqualError(t, err, "image is too large for thumbnails")
+	assert.Zero(t, thumbnailsOnDisk(t, "huge"))
+
+	// Test case: content that is no image fails
+	text := saveImage(t, fileSystem, "text", "image/png", []byte("not an image"))
+	_, err = services.RenderThumbnails(thumbnails, text)
+	assert.Error(t, err)
+}
+
+func TestScaleToFit(t *testing.T) {
+	// Test case: small images are returned as they are
+	small := gradient(10, 5)
+	assert.Same(t, small, services.ScaleToFit(small, 64))
+
+	// Test case: the longer side is scaled to the size
+	assert.Equal(t, image.Pt(64, 32), services.ScaleToFit(gradient(200, 100), 64).Bounds().Size())
+	assert.Equal(t, image.Pt(32, 64), services.ScaleToFit(gradient(100, 200), 64).Bounds().Size())
+	assert.Equal(t, image.Pt(64, 1), services.ScaleToFit(gradient(1000, 2), 64).Bounds().Size())
+
+	// Test case: a pixel is the average of the pixels it covers
+	halves := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if x < 2 {
+				halves.SetRGBA(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			} else {
+				halves.SetRGBA(x, y, color.RGBA{A: 255})
+			}
+		}
+	}
+	scaled := services.ScaleToFit(halves, 2)
+	assert.Equal(t, color.RGBAModel.Convert(color.White), color.RGBAModel.Convert(scaled.At(0, 0)))
+	assert.Equal(t, color.RGBAModel.Convert(color.Black), color.RGBAModel.Convert(scaled.At(1, 0)))
+
+	checker := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	checker.SetRGBA(0, 0, color.RGBA{R: 200, A: 255})
+	checker.SetRGBA(1, 1, color.RGBA{R: 200, A: 255})
+	assert.Equal(t, color.RGBA{R: 100, A: 127}, color.RGBAModel.Convert(services.ScaleToFit(checker, 1).At(0, 0)))
+}
+
+func TestThumbnailSize(t *testing.T) {
+	tests := []struct {
+		requested int
+		want      int
+	}{
+		{0, 256},
+		{1, 64},
+		{64, 64},
+		{65, 128},
+		{300, 512},
+		{512, 512},
+		{2000, 512},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, services.ThumbnailSize(tt.requested), "requested %d", tt.requested)
+	}
+}
+
+func TestThumbnailService_HandleJob(t *testing.T) {
+	db := openTestDB(t)
+	thumbnails, fileSystem, volumeLimit := newThumbnailService(t, db)
+	ownerID := testOwnerID()
+
+	file := createFile(t, db, ownerID, "photo.jpg")
+	save := func(storageKey string, content []byte) *models.FileVersion {
+		version := saveImage(t, fileSystem, storageKey, "image/jpeg", content)
+		file.CurrentVersion++
+		version.FileID, version.Version, version.ThumbnailStatus = file.ID, file.CurrentVersion, models.ThumbnailPending
+		require.NoError(t, db.Create(version).Error)
+		return version
+	}
+	handle := func(version *models.FileVersion) *models.FileVersion {
+		job, err := json.Marshal(models.ThumbnailJob{FileVersionID: version.ID})
+		require.NoError(t, err)
+		require.NoError(t, thumbnails.HandleJob(job))
+		var stored models.FileVersion
+		require.NoError(t, db.Unscoped().First(&stored, version.ID).Error)
+		return &stored
+	}
+	// Test case: the thumbnails are saved and the version is ready
+	ready := save("ready", jpegImage(t, 600, 400))
+	stored := handle(ready)
+	assert.Equal(t, models.ThumbnailReady, stored.ThumbnailStatus)
+	assert.Equal(t, "image/jpeg", stored.ThumbnailType)
+	assert.NotZero(t, thumbnailsOnDisk(t, ready.StorageKey))
+
+	// Test case: images that cannot be rendered are marked failed
+	broken := save("broken", []byte("not an image"))
+	assert.Equal(t, models.ThumbnailFailed, handle(broken).ThumbnailStatus)
+
+	// Test case: versions pruned before the job are skipped
+	pruned := save("pruned", jpegImage(t, 600, 400))
+	require.NoError(t, db.Delete(pruned).Error)
+	assert.Equal(t, models.ThumbnailPending, handle(pruned).ThumbnailStatus)
+	assert.Zero(t, thumbnailsOnDisk(t, pruned.StorageKey))
+
+	// Test case: a version pruned while it is rendered loses its thumbnails
+	// and their space
+	racing := save("racing", jpegImage(t, 600, 400))
+	free := thumbnailVolume - fileSize(t, ready.StorageKey) - thumbnailsOnDisk(t, ready.StorageKey) -
+		fileSize(t, broken.StorageKey) - fileSize(t, pruned.StorageKey) - fileSize(t, racing.StorageKey)
+	assertFree(t, volumeLimit, free)
+	err := db.Callback().Update().Before("gorm:update").Register("test:prune_version", func(tx *gorm.DB) {
+		if tx.Statement.Table == "file_versions" {
+			tx.Session(&gorm.Session{NewDB: true}).Delete(&models.FileVersion{}, racing.ID)
+		}
+	})
+	require.NoError(t, err)
+	stored = handle(racing)
+	assert.True(t, stored.DeletedAt.Valid)
+	assert.Equal(t, models.ThumbnailPending, stored.ThumbnailStatus)
+	assert.Zero(t, thumbnailsOnDisk(t, racing.StorageKey))
+	assertFree(t, volumeLimit, free)
+}
+
+func fileSize(t *testing.T, storageKey string) int64 {
+	info, err := os.Stat(filepath.Join("files", storageKey) + ".encrypted")
+	require.NoError(t, err)
+	return info.Size()
+}
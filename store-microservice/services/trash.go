@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// TrashService moves files to the trash (a soft delete), restores them and
+// purges them for good once the retention period is over.
+type TrashService struct {
+	db        *gorm.DB
+	versions  *VersionService
+	usage     *UsageService
+	retention time.Duration
+	log       *zap.Logger
+}
+
+func NewTrashService(db *gorm.DB, versions *VersionService, usage *UsageService, retention time.Duration) *TrashService {
+	log := utils.GetLogger()
+	return &TrashService{db, versions, usage, retention, log}
+}
+
+func (ts *TrashService) RegisterCommands(commands *CommandService) {
+	commands.Register(models.FileActionDelete, ts.Delete)
+	commands.Register(models.FileActionListTrash, ts.ListTrash)
+	commands.Register(models.FileActionRestore, ts.Restore)
+}
+
+func (ts *TrashService) Delete(command *models.FileCommand) (interface{}, error) {
+	var file models.File
+	if err := ts.db.Preload("FileTags").Where("id = ? AND owner_id = ?", command.FileID, command.OwnerID).First(&file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrFileNotFound
+		}
+		return nil, err
+	}
+
+	if err := ts.db.Delete(&file).Error; err != nil {
+		return nil, err
+	}
+	file.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+
+	ts.log.Info("File moved to trash", zap.Uint("fileID", file.ID), zap.Uint("ownerID", file.OwnerID))
+	return models.ConvertFileToFileInfo(file), nil
+}
+
+func (ts *TrashService) ListTrash(command *models.FileCommand) (interface{}, error) {
+	var files []models.File
+	err := ts.db.Unscoped().Preload("FileTags").
+		Where("owner_id = ? AND deleted_at IS NOT NULL", command.OwnerID).
+		Order("deleted_at DESC").
+		Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]models.FileInfo, len(files))
+	for i, file := range files {
+		infos[i] = models.ConvertFileToFileInfo(file)
+	}
+	return infos, nil
+}
+
+func (ts *TrashService) Restore(command *models.FileCommand) (interface{}, error) {
+	var file models.File
+	if err := ts.db.Unscoped().Preload("FileTags").Where("id = ? AND owner_id = ?", command.FileID, command.OwnerID).First(&file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrFileNotFound
+		}
+		return nil, err
+	}
+	if !file.DeletedAt.Valid {
+		return nil, utils.ErrFileNotInTrash
+	}
+
+	restored := map[string]interface{}{"deleted_at": nil}
+	if file.FolderID != nil {
+		// Files of deleted folders come back in the root.
+		var folders int64
+		if err := ts.db.Model(&models.Folder{}).Where("id = ?", *file.FolderID).Count(&folders).Error; err != nil {
+			return nil, err
+		}
+		if folders == 0 {
+			restored["folder_id"] = nil
+			file.FolderID = nil
+		}
+	}
+	// Names are unique in the folder the file comes back to.
+	if err := checkFileName(ts.db, file.OwnerID, file.FolderID, file.FileName, file.ID); err != nil {
+		return nil, err
+	}
+	if err := ts.db.Unscoped().Model(&file).Updates(restored).Error; err != nil {
+		// A file of the name was uploaded since the check.
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, utils.ErrFileNameInUse
+		}
+		return nil, err
+	}
+	file.DeletedAt = gorm.DeletedAt{}
+
+	ts.log.Info("File restored from trash", zap.Uint("fileID", file.ID), zap.Uint("ownerID", file.OwnerID))
+	return models.ConvertFileToFileInfo(file), nil
+}
+
+// Purge permanently removes files that have been in the trash longer than the
+// retention period and gives their space back to the owners.
+func (ts *TrashService) Purge() error {
+	var files []models.File
+	cutoff := time.Now().Add(-ts.retention)
+	if err := ts.db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Find(&files).Error; err != nil {
+		ts.log.Error("Failed to find expired files in trash", zap.Error(err))
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	if _, err := ts.PurgeFiles(files); err != nil {
+		return err
+	}
+
+	owners := make(map[uint]bool)
+	for _, file := range files {
+		if file.OwnerID != 0 {
+			owners[file.OwnerID] = true
+		}
+	}
+	for ownerID := range owners {
+		if err := ts.usage.PublishStatus(&models.UploadStatus{OwnerID: ownerID, Status: models.UploadStatusPurged}); err != nil {
+			ts.log.Warn("Failed to publish usage after purge", zap.Uint("ownerID", ownerID), zap.Error(err))
+		}
+	}
+
+	ts.log.Info("Purged files from trash", zap.Int("count", len(files)))
+	return nil
+}
+
+// PurgeFiles deletes the files with all their versions, tag links, access
+// entries and stored content and returns the deleted versions. The freed
+// space is refunded to the owners in the same transaction, so a failed
+// refund leaves the files in place for the next purge.
+func (ts *TrashService) PurgeFiles(files []models.File) ([]models.FileVersion, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uint, len(files))
+	for i, file := range files {
+		ids[i] = file.ID
+	}
+
+	var versions []models.FileVersion
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("file_id IN ?", ids).Find(&versions).Error; err != nil {
+			return err
+		}
+		for i := range files {
+			if err := tx.Model(&files[i]).Association("FileTags").Clear(); err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Where("file_id IN ?", ids).Delete(&models.FileVersion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("file_id IN ?", ids).Delete(&models.ACLEntry{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&files).Error; err != nil {
+			return err
+		}
+
+		type freed struct {
+			bytes int64
+			count int64
+		}
+		byOwner := make(map[uint]*freed)
+		owners := make(map[uint]uint)
+		for _, file := range files {
+			owners[file.ID] = file.OwnerID
+			if file.OwnerID == 0 {
+				continue
+			}
+			if byOwner[file.OwnerID] == nil {
+				byOwner[file.OwnerID] = &freed{}
+			}
+			byOwner[file.OwnerID].count++
+		}
+		for _, version := range versions {
+			if f := byOwner[owners[version.FileID]]; f != nil {
+				f.bytes += version.FileSize
+			}
+		}
+		for ownerID, f := range byOwner {
+			if err := ts.usage.refund(tx, ownerID, f.bytes, f.count); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		ts.log.Error("Failed to purge files", zap.Error(err))
+		return nil, err
+	}
+
+	ts.versions.RemoveContent(versions)
+	return versions, nil
+}
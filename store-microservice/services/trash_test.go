@@ -0,0 +1,86 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"store/models"
+	"store/services"
+	"store/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrashService_PurgeFilesRefundsUsage(t *testing.T) {
+	db := openTestDB(t)
+	log := utils.GetLogger()
+	dir := t.TempDir()
+	usage := services.NewUsageService(db, services.RabbitMQService{})
+	versions := services.NewVersionService(db, services.NewFileSystemService(log), services.NewVolumeLimitService(log, dir, 0), usage, dir, nil, 0)
+	trash := services.NewTrashService(db, versions, usage, time.Hour)
+	ownerID := testOwnerID()
+
+	file := models.File{OwnerID: ownerID, FileName: "old.txt", CurrentVersion: 2}
+	require.NoError(t, db.Create(&file).Error)
+	for _, version := range []models.FileVersion{
+		{FileID: file.ID, Version: 1, FileSize: 10, StorageKey: "missing-1"},
+		{FileID: file.ID, Version: 2, FileSize: 20, StorageKey: "missing-2"},
+	} {
+		require.NoError(t, db.Create(&version).Error)
+	}
+	require.NoError(t, db.Create(&models.UserUsage{OwnerID: ownerID, UsedBytes: 100, FileCount: 3}).Error)
+
+	purged, err := trash.PurgeFiles([]models.File{file})
+	require.NoError(t, err)
+	assert.Len(t, purged, 2)
+
+	// Test case: the versions' bytes and the file are refunded with the purge
+	stored, err := usage.GetUsage(ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(70), stored.UsedBytes)
+	assert.Equal(t, int64(2), stored.FileCount)
+
+	var remaining int64
+	require.NoError(t, db.Unscoped().Model(&models.File{}).Where("id = ?", file.ID).Count(&remaining).Error)
+	assert.Zero(t, remaining)
+}
+
+func TestTrashService_RestoreChecksNameInFolder(t *testing.T) {
+	db := openTestDB(t)
+	trash := services.NewTrashService(db, nil, nil, time.Hour)
+	folders := services.NewFolderService(db)
+	ownerID := testOwnerID()
+	docs := createFolder(t, folders, ownerID, "docs")
+
+	trashed := models.File{OwnerID: ownerID, FolderID: &docs.ID, FileName: "notes.txt", CurrentVersion: 1}
+	require.NoError(t, db.Create(&trashed).Error)
+	require.NoError(t, db.Delete(&trashed).Error)
+	restore := func() error {
+		_, err := trash.Restore(&models.FileCommand{Action: models.FileActionRestore, OwnerID: ownerID, FileID: trashed.ID})
+		return err
+	}
+
+	// Test case: a file of the name in another folder does not block it
+	root := models.File{OwnerID: ownerID, FileName: "notes.txt", CurrentVersion: 1}
+	require.NoError(t, db.Create(&root).Error)
+	require.NoError(t, restore())
+	require.NoError(t, db.Delete(&trashed).Error)
+
+	// Test case: a file of the name in its folder does
+	inDocs := models.File{OwnerID: ownerID, FolderID: &docs.ID, FileName: "notes.txt", CurrentVersion: 1}
+	require.NoError(t, db.Create(&inDocs).Error)
+	assert.ErrorIs(t, restore(), utils.ErrFileNameInUse)
+
+	// Test case: with the folder deleted it comes back in the root, where
+	// the name is taken too
+	_, err := folders.DeleteFolder(folderCommand(t, models.FileActionDeleteFolder, ownerID, models.FolderPayload{FolderID: docs.ID, Recursive: true}))
+	require.NoError(t, err)
+	assert.ErrorIs(t, restore(), utils.ErrFileNameInUse)
+	require.NoError(t, db.Delete(&root).Error)
+	require.NoError(t, restore())
+
+	var restored models.File
+	require.NoError(t, db.First(&restored, trashed.ID).Error)
+	assert.Nil(t, restored.FolderID)
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UsageService struct {
+	db              *gorm.DB
+	rabbitMQService RabbitMQService
+	log             *zap.Logger
+}
+
+func NewUsageService(db *gorm.DB, rabbitMQService RabbitMQService) *UsageService {
+	log := utils.GetLogger()
+	return &UsageService{db, rabbitMQService, log}
+}
+
+// Charge adds size bytes and count files to the owner's usage if the bytes
+// fit into quota and reports whether they did. A quota of 0 or less is
+// unlimited.
+func (us *UsageService) Charge(ownerID uint, size, count, quota int64) (bool, error) {
+	usage := models.UserUsage{OwnerID: ownerID}
+	if err := us.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "owner_id"}}, DoNothing: true}).Create(&usage).Error; err != nil {
+		return false, err
+	}
+
+	query := us.db.Model(&models.UserUsage{}).Where("owner_id = ?", ownerID)
+	if quota > 0 {
+		query = query.Where("used_bytes + ? <= ?", size, quota)
+	}
+	result := query.Updates(map[string]interface{}{
+		"used_bytes": gorm.Expr("used_bytes + ?", size),
+		"file_count": gorm.Expr("file_count + ?", count),
+	})
+	if result.Error != nil {
+		us.log.Error("Failed to charge storage usage", zap.Uint("ownerID", ownerID), zap.Error(result.Error))
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Refund removes files from the owner's usage again.
+func (us *UsageService) Refund(ownerID uint, size, count int64) error {
+	return us.refund(us.db, ownerID, size, count)
+}
+
+// refund is Refund on db, so callers can give the space back in the
+// transaction that deletes the files.
+func (us *UsageService) refund(db *gorm.DB, ownerID uint, size, count int64) error {
+	err := db.Model(&models.UserUsage{}).Where("owner_id = ?", ownerID).Updates(map[string]interface{}{
+		"used_bytes": gorm.Expr("CASE WHEN used_bytes > ? THEN used_bytes - ? ELSE 0 END", size, size),
+		"file_count": gorm.Expr("CASE WHEN file_count > ? THEN file_count - ? ELSE 0 END", count, count),
+	}).Error
+	if err != nil {
+		us.log.Error("Failed to refund storage usage", zap.Uint("ownerID", ownerID), zap.Error(err))
+	}
+	return err
+}
+
+func (us *UsageService) GetUsage(ownerID uint) (*models.UserUsage, error) {
+	var usage models.UserUsage
+	if err := us.db.Where("owner_id = ?", ownerID).First(&usage).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &models.UserUsage{OwnerID: ownerID}, nil
+		}
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// PublishStatus sends status together with the owner's current usage to the
+// retrieval service.
+func (us *UsageService) PublishStatus(status *models.UploadStatus) error {
+	if status.OwnerID == 0 {
+		return nil
+	}
+
+	usage, err := us.GetUsage(status.OwnerID)
+	if err != nil {
+		us.log.Error("Failed to load storage usage", zap.Uint("ownerID", status.OwnerID), zap.Error(err))
+		return err
+	}
+	status.UsedBytes = usage.UsedBytes
+	status.FileCount = usage.FileCount
+
+	statusJSON, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return us.rabbitMQService.PublishToQueue(statusJSON, FileStatusQueue)
+}
+
+func (us *UsageService) DeleteUsage(ownerID uint) error {
+	return us.db.Unscoped().Where("owner_id = ?", ownerID).Delete(&models.UserUsage{}).Error
+}
+
+// Reconcile recomputes every owner's usage from the stored files. Every
+// version counts, and files in the trash keep counting until they are purged.
+func (us *UsageService) Reconcile() error {
+	err := us.db.Exec(`UPDATE user_usages SET
+		used_bytes = (SELECT COALESCE(SUM(file_versions.file_size), 0) FROM file_versions
+			JOIN files ON files.id = file_versions.file_id
+			WHERE files.owner_id = user_usages.owner_id AND file_versions.deleted_at IS NULL),
+		file_count = (SELECT COUNT(*) FROM files WHERE files.owner_id = user_usages.owner_id)`).Error
+	if err != nil {
+		us.log.Error("Failed to reconcile storage usage", zap.Error(err))
+	}
+	return err
+}
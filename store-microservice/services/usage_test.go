@@ -0,0 +1,115 @@
+package services_test
+
+import (
+	"sync"
+	"testing"
+
+	"store/models"
+	"store/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageService_ChargeWithinQuota(t *testing.T) {
+	db := openTestDB(t)
+	usage := services.NewUsageService(db, services.RabbitMQService{})
+	ownerID := testOwnerID()
+
+	// Test case: the first charge creates the usage
+	charged, err := usage.Charge(ownerID, 60, 1, 100)
+	require.NoError(t, err)
+	assert.True(t, charged)
+
+	// Test case: a charge over the quota changes nothing
+	charged, err = usage.Charge(ownerID, 50, 1, 100)
+	require.NoError(t, err)
+	assert.False(t, charged)
+	stored, err := usage.GetUsage(ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), stored.UsedBytes)
+	assert.Equal(t, int64(1), stored.FileCount)
+
+	// Test case: the quota can be filled exactly
+	charged, err = usage.Charge(ownerID, 40, 0, 100)
+	require.NoError(t, err)
+	assert.True(t, charged)
+
+	// Test case: without a quota every charge passes
+	charged, err = usage.Charge(ownerID, 1000, 1, 0)
+	require.NoError(t, err)
+	assert.True(t, charged)
+	stored, err = usage.GetUsage(ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1100), stored.UsedBytes)
+	assert.Equal(t, int64(2), stored.FileCount)
+
+	// Test case: refunds never go below zero
+	require.NoError(t, usage.Refund(ownerID, 5000, 5))
+	stored, err = usage.GetUsage(ownerID)
+	require.NoError(t, err)
+	assert.Zero(t, stored.UsedBytes)
+	assert.Zero(t, stored.FileCount)
+}
+
+func TestUsageService_ConcurrentCharges(t *testing.T) {
+	db := openTestDB(t)
+	usage := services.NewUsageService(db, services.RabbitMQService{})
+	ownerID := testOwnerID()
+
+	const uploads = 10
+	var wg sync.WaitGroup
+	results := make(chan bool, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			charged, err := usage.Charge(ownerID, 30, 1, 100)
+			assert.NoError(t, err)
+			results <- charged
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	// Test case: the conditional update lets only what fits through
+	var charges int64
+	for charged := range results {
+		if charged {
+			charges++
+		}
+	}
+	assert.Equal(t, int64(3), charges)
+	stored, err := usage.GetUsage(ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(90), stored.UsedBytes)
+	assert.Equal(t, charges, stored.FileCount)
+}
+
+func TestUsageService_Reconcile(t *testing.T) {
+	db := openTestDB(t)
+	usage := services.NewUsageService(db, services.RabbitMQService{})
+	ownerID := testOwnerID()
+
+	live := models.File{OwnerID: ownerID, FileName: "live.txt", CurrentVersion: 2}
+	require.NoError(t, db.Create(&live).Error)
+	trashed := models.File{OwnerID: ownerID, FileName: "trashed.txt", CurrentVersion: 1}
+	require.NoError(t, db.Create(&trashed).Error)
+	for _, version := range []models.FileVersion{
+		{FileID: live.ID, Version: 1, FileSize: 10, StorageKey: "reconcile-1"},
+		{FileID: live.ID, Version: 2, FileSize: 20, StorageKey: "reconcile-2"},
+		{FileID: trashed.ID, Version: 1, FileSize: 5, StorageKey: "reconcile-3"},
+	} {
+		require.NoError(t, db.Create(&version).Error)
+	}
+	require.NoError(t, db.Delete(&trashed).Error)
+	require.NoError(t, db.Create(&models.UserUsage{OwnerID: ownerID, UsedBytes: 999, FileCount: 7}).Error)
+
+	// Test case: the drifted usage is recomputed from every version, with
+	// the trash still counting
+	require.NoError(t, usage.Reconcile())
+	stored, err := usage.GetUsage(ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(35), stored.UsedBytes)
+	assert.Equal(t, int64(2), stored.FileCount)
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type UserEventService struct {
+	db      *gorm.DB
+	trash   *TrashService
+	tags    *TagService
+	usage   *UsageService
+	access  *AccessService
+	folders *FolderService
+	log     *zap.Logger
+}
+
+func NewUserEventService(db *gorm.DB, trash *TrashService, tags *TagService, usage *UsageService, access *AccessService, folders *FolderService) *UserEventService {
+	log := utils.GetLogger()
+	return &UserEventService{db, trash, tags, usage, access, folders, log}
+}
+
+func (us *UserEventService) HandleUserEvent(body []byte) error {
+	var event models.UserEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		us.log.Error("Failed to unmarshal user event from RabbitMQ", zap.Error(err))
+		return err
+	}
+
+	switch event.Event {
+	case models.UserEventDeleted:
+		_, err := us.DeleteUserFiles(event.UserID)
+		return err
+	default:
+		us.log.Warn("Ignoring unknown user event", zap.String("event", event.Event))
+		return nil
+	}
+}
+
+// DeleteUserFiles removes the metadata and the stored content of every file
+// owned by the user, including the trash, and the user's folders and access
+// entries. It returns how many files were deleted.
+func (us *UserEventService) DeleteUserFiles(userID uint) (int, error) {
+	if userID == 0 {
+		// Files uploaded before owners were recorded have owner 0.
+		return 0, errors.New("user id is required")
+	}
+
+	var files []models.File
+	if err := us.db.Unscoped().Where("owner_id = ?", userID).Find(&files).Error; err != nil {
+		us.log.Error("Failed to find files of user", zap.Uint("userID", userID), zap.Error(err))
+		return 0, err
+	}
+
+	if _, err := us.trash.PurgeFiles(files); err != nil {
+		return 0, err
+	}
+	if err := us.tags.DeleteOwnerTags(userID); err != nil {
+		us.log.Error("Failed to delete tags of user", zap.Uint("userID", userID), zap.Error(err))
+		return 0, err
+	}
+	if err := us.folders.DeleteOwnerFolders(userID); err != nil {
+		us.log.Error("Failed to delete folders of user", zap.Uint("userID", userID), zap.Error(err))
+		return 0, err
+	}
+	if err := us.access.DeleteUserAccess(userID); err != nil {
+		us.log.Error("Failed to delete access entries of user", zap.Uint("userID", userID), zap.Error(err))
+		return 0, err
+	}
+	if err := us.usage.DeleteUsage(userID); err != nil {
+		us.log.Error("Failed to delete usage of user", zap.Uint("userID", userID), zap.Error(err))
+		return 0, err
+	}
+
+	us.log.Info("Deleted files of user", zap.Uint("userID", userID), zap.Int("count", len(files)))
+	return len(files), nil
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+
+	"store/models"
+	"store/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// VersionService manages the versions of a file. Every upload of a name the
+// owner already has becomes a new version; only the newest keepLast versions
+// are kept.
+type VersionService struct {
+	db          *gorm.DB
+	fileSystem  *FileSystemService
+	volumeLimit *VolumeLimitService
+	usage       *UsageService
+	filePath    string
+	secretKey   []byte
+	keepLast    int
+	log         *zap.Logger
+}
+
+// NewVersionService creates the service. A keepLast of 0 or less keeps every
+// version.
+func NewVersionService(db *gorm.DB, fileSystem *FileSystemService, volumeLimit *VolumeLimitService, usage *UsageService, filePath string, secretKey []byte, keepLast int) *VersionService {
+	log := utils.GetLogger()
+	return &VersionService{db, fileSystem, volumeLimit, usage, filePath, secretKey, keepLast, log}
+}
+
+func (vs *VersionService) RegisterCommands(commands *CommandService) {
+	commands.Register(models.FileActionListVersions, vs.ListVersions)
+	commands.Register(models.FileActionDownload, vs.Download)
+	commands.Register(models.FileActionRestoreVersion, vs.RestoreVersion)
+}
+
+// MigrateLegacyFiles gives files saved before versioning their first version.
+// Their content was saved under the file name.
+func (vs *VersionService) MigrateLegacyFiles() error {
+	var files []models.File
+	err := vs.db.Unscoped().
+		Where("NOT EXISTS (SELECT 1 FROM file_versions WHERE file_versions.file_id = files.id)").
+		Find(&files).Error
+	if err != nil {
+		vs.log.Error("Failed to find files without versions", zap.Error(err))
+		return err
+	}
+
+	for _, file := range files {
+		version := models.FileVersion{
+			FileID:     file.ID,
+			Version:    1,
+			FileType:   file.FileType,
+			FileSize:   file.FileSize,
+			StorageKey: file.FileName,
+		}
+		version.CreatedAt = file.CreatedAt
+		err := vs.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&version).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Model(&file).UpdateColumn("current_version", 1).Error
+		})
+		if err != nil {
+			vs.log.Error("Failed to migrate file to versions", zap.Uint("fileID", file.ID), zap.Error(err))
+			return err
+		}
+	}
+
+	if len(files) > 0 {
+		vs.log.Info("Migrated files to versions", zap.Int("count", len(files)))
+	}
+	return nil
+}
+
+func (vs *VersionService) ListVersions(command *models.FileCommand) (interface{}, error) {
+	file, err := vs.findFile(command, models.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []models.FileVersion
+	if err := vs.db.Where("file_id = ?", file.ID).Order("version DESC").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+
+	infos := make([]models.FileVersionInfo, len(versions))
+	for i, version := range versions {
+		infos[i] = models.ConvertFileVersionToInfo(version, file.CurrentVersion)
+	}
+	return infos, nil
+}
+
+// Download sends the content of a version, or the range of it the
+// models.DownloadPayload asks for.
+func (vs *VersionService) Download(command *models.FileCommand) (interface{}, error) {
+	file, err := vs.findFile(command, models.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	version, err := vs.findVersion(file, command)
+	if err != nil {
+		return nil, err
+	}
+	var payload models.DownloadPayload
+	if len(command.Payload) > 0 {
+		_ = json.Unmarshal(command.Payload, &payload)
+	}
+
+	content := &models.FileContent{
+		OwnerID:    file.OwnerID,
+		FileName:   file.FileName,
+		FileType:   version.FileType,
+		Version:    version.Version,
+		FileSize:   version.FileSize,
+		ETag:       version.ETag(),
+		ModifiedAt: version.CreatedAt,
+	}
+	filePath := filepath.Join(vs.filePath, version.StorageKey)
+	switch {
+	case payload.InfoOnly:
+	case payload.Range != nil:
+		content.Offset = payload.Range.Offset
+		content.Content, err = vs.fileSystem.DecryptFileRange(filePath, vs.secretKey, payload.Range.Offset, payload.Range.Length, version.FileSize)
+	default:
+		content.Content, err = vs.fileSystem.DecryptFile(filePath, vs.secretKey, version.FileSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// RestoreVersion makes an older version the current one again. Newer versions
+// are kept, so the restore can be undone.
+func (vs *VersionService) RestoreVersion(command *models.FileCommand) (interface{}, error) {
+	file, err := vs.findFile(command, models.PermissionWrite)
+	if err != nil {
+		return nil, err
+	}
+	version, err := vs.findVersion(file, command)
+	if err != nil {
+		return nil, err
+	}
+
+	err = vs.db.Model(file).Updates(map[string]interface{}{
+		"current_version": version.Version,
+		"file_type":       version.FileType,
+		"declared_type":   version.DeclaredType,
+		"file_size":       version.FileSize,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	vs.log.Info("File version restored",
+		zap.Uint("fileID", file.ID),
+		zap.Uint("ownerID", file.OwnerID),
+		zap.Int("version", version.Version),
+	)
+	return models.ConvertFileToFileInfo(*file), nil
+}
+
+// Prune deletes the oldest versions of the file beyond keepLast and gives
+// their space back to the owner. The current version is always kept.
+func (vs *VersionService) Prune(file *models.File) error {
+	if vs.keepLast <= 0 {
+		return nil
+	}
+
+	var versions []models.FileVersion
+	err := vs.db.Where("file_id = ? AND version <> ?", file.ID, file.CurrentVersion).
+		Order("version DESC").
+		Offset(vs.keepLast - 1).
+		Find(&versions).Error
+	if err != nil {
+		vs.log.Error("Failed to find old versions", zap.Uint("fileID", file.ID), zap.Error(err))
+		return err
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+
+	if err := vs.db.Unscoped().Delete(&versions).Error; err != nil {
+		vs.log.Error("Failed to delete old versions", zap.Uint("fileID", file.ID), zap.Error(err))
+		return err
+	}
+	vs.RemoveContent(versions)
+
+	if file.OwnerID != 0 {
+		var freed int64
+		for _, version := range versions {
+			freed += version.FileSize
+		}
+		_ = vs.usage.Refund(file.OwnerID, freed, 0)
+	}
+
+	vs.log.Info("Pruned old versions", zap.Uint("fileID", file.ID), zap.Int("count", len(versions)))
+	return nil
+}
+
+// RemoveContent deletes the saved content and thumbnails of deleted
+// versions. Content that another version still refers to is kept.
+func (vs *VersionService) RemoveContent(versions []models.FileVersion) {
+	for _, version := range versions {
+		var remaining int64
+		if err := vs.db.Model(&models.FileVersion{}).Where("storage_key = ?", version.StorageKey).Count(&remaining).Error; err != nil || remaining > 0 {
+			continue
+		}
+		size, err := vs.fileSystem.RemoveFile(filepath.Join(vs.filePath, version.StorageKey))
+		if err != nil {
+			continue
+		}
+		vs.volumeLimit.Remove(size + removeThumbnails(vs.fileSystem, vs.filePath, version.StorageKey))
+	}
+}
+
+func (vs *VersionService) findFile(command *models.FileCommand, permission string) (*models.File, error) {
+	return findAccessibleFile(vs.db.Preload("FileTags"), command, permission)
+}
+
+// findVersion returns the version selected by the command payload, or the
+// current version.
+func (vs *VersionService) findVersion(file *models.File, command *models.FileCommand) (*models.FileVersion, error) {
+	var payload models.VersionPayload
+	if len(command.Payload) > 0 {
+		if err := json.Unmarshal(command.Payload, &payload); err != nil || payload.Version < 0 {
+			return nil, utils.ErrInvalidCommand
+		}
+	}
+	if payload.Version == 0 {
+		payload.Version = file.CurrentVersion
+	}
+
+	var version models.FileVersion
+	if err := vs.db.Where("file_id = ? AND version = ?", file.ID, payload.Version).First(&version).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrVersionNotFound
+		}
+		return nil, err
+	}
+	return &version, nil
+}
+
+// newStorageKey returns a random name to save a version's content under, so
+// versions never overwrite each other.
+func newStorageKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
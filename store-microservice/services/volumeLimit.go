@@ -3,48 +3,139 @@ package services
 import (
 	"os"
 	"path/filepath"
+	"sync"
 
 	"go.uber.org/zap"
 )
 
+// VolumeLimitService keeps the size of the storage folder in memory. Uploads
+// reserve their size up front, so concurrent uploads cannot together exceed
+// the limit; the folder is only walked once at start and by Reconcile.
 type VolumeLimitService struct {
-	logger *zap.Logger
+	logger     *zap.Logger
+	folderPath string
+	limit      int64
+
+	mu          sync.Mutex
+	initialized bool
+	used        int64
+	reserved    int64
 }
 
-func NewVolumeLimitService(logger *zap.Logger) *VolumeLimitService {
-	return &VolumeLimitService{logger: logger}
+func NewVolumeLimitService(logger *zap.Logger, folderPath string, limit int64) *VolumeLimitService {
+	return &VolumeLimitService{
+		logger:     logger,
+		folderPath: filepath.Join(".", folderPath),
+		limit:      limit,
+	}
 }
 
-func (vs *VolumeLimitService) IsWithinLimit(folderPath string, fileSize int64, limit int) (bool, error) {
-	fullFolderPath := filepath.Join(".", folderPath)
+// Reserve books size bytes if they fit into the limit and reports whether they
+// did. Every successful reservation must be followed by Commit or Release.
+func (vs *VolumeLimitService) Reserve(size int64) (bool, error) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
 
-	if _, err := os.Stat(fullFolderPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(fullFolderPath, 0o755); err != nil {
-			vs.logger.Error("Failed to create folder", zap.Error(err))
-			return false, err
-		}
+	if err := vs.init(); err != nil {
+		return false, err
+	}
+
+	isWithinLimit := vs.used+vs.reserved+size <= vs.limit
+	vs.logger.Info("Folder Size",
+		zap.String("folderPath", vs.folderPath),
+		zap.Int64("currentSize", vs.used),
+		zap.Int64("reservedSize", vs.reserved),
+		zap.Int64("newFileSize", size),
+		zap.Int64("limit", vs.limit),
+		zap.Bool("isWithinLimit", isWithinLimit),
+	)
+	if !isWithinLimit {
+		return false, nil
+	}
+
+	vs.reserved += size
+	return true, nil
+}
+
+// Commit turns a reservation into used space. written is the size that ended
+// up on disk, which may differ from the reserved size.
+func (vs *VolumeLimitService) Commit(reserved, written int64) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	vs.reserved -= reserved
+	vs.used += written
+}
+
+// Release gives back a reservation that was not used.
+func (vs *VolumeLimitService) Release(reserved int64) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	vs.reserved -= reserved
+}
+
+// Remove accounts for deleted content.
+func (vs *VolumeLimitService) Remove(size int64) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	vs.used -= size
+	if vs.used < 0 {
+		vs.used = 0
 	}
+}
 
-	currentSize, err := vs.getFolderSize(fullFolderPath)
+// Reconcile replaces the counter with the real size of the folder. Files
+// written while the folder is walked may be missed; the next run fixes that.
+func (vs *VolumeLimitService) Reconcile() error {
+	size, err := vs.measure()
 	if err != nil {
-		vs.logger.Error("Failed to get folder size", zap.Error(err))
-		return false, err
+		return err
 	}
-	int64limit := int64(limit)
 
-	totalSize := currentSize + fileSize
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
 
-	isWithinLimit := totalSize <= int64limit
+	if vs.initialized && vs.used != size {
+		vs.logger.Warn("Volume usage drifted from disk",
+			zap.Int64("counted", vs.used),
+			zap.Int64("onDisk", size),
+		)
+	}
+	vs.used = size
+	vs.initialized = true
+	return nil
+}
 
-	vs.logger.Info("Folder Size",
-		zap.String("folderPath", fullFolderPath),
-		zap.Int64("currentSize", currentSize),
-		zap.Int64("newFileSize", fileSize),
-		zap.Int64("limit", int64limit),
-		zap.Bool("isWithinLimit", isWithinLimit),
-	)
+func (vs *VolumeLimitService) init() error {
+	if vs.initialized {
+		return nil
+	}
+
+	size, err := vs.measure()
+	if err != nil {
+		return err
+	}
+	vs.used = size
+	vs.initialized = true
+	return nil
+}
+
+func (vs *VolumeLimitService) measure() (int64, error) {
+	if _, err := os.Stat(vs.folderPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(vs.folderPath, 0o755); err != nil {
+			vs.logger.Error("Failed to create folder", zap.Error(err))
+			return 0, err
+		}
+	}
 
-	return isWithinLimit, nil
+	size, err := vs.getFolderSize(vs.folderPath)
+	if err != nil {
+		vs.logger.Error("Failed to get folder size", zap.Error(err))
+		return 0, err
+	}
+	return size, nil
 }
 
 func (vs *VolumeLimitService) getFolderSize(folderPath string) (int64, error) {
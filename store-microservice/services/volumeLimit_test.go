@@ -0,0 +1,145 @@
+package services_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"store/services"
+	"store/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newVolumeLimit returns a service for a folder that already holds used
+// bytes, in a temporary working directory as the path is relative to it.
+func newVolumeLimit(t *testing.T, used int, limit int64) (*services.VolumeLimitService, string) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	dir := "files"
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	if used > 0 {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "existing"), make([]byte, used), 0o600))
+	}
+	return services.NewVolumeLimitService(utils.GetLogger(), dir, limit), dir
+}
+
+// assertFree checks that exactly free bytes can still be reserved.
+func assertFree(t *testing.T, vs *services.VolumeLimitService, free int64) {
+	t.Helper()
+	if free > 0 {
+		ok, err := vs.Reserve(free)
+		require.NoError(t, err)
+		require.True(t, ok, "%d bytes should be free", free)
+		defer vs.Release(free)
+	}
+	ok, err := vs.Reserve(1)
+	require.NoError(t, err)
+	if ok {
+		vs.Release(1)
+	}
+	assert.False(t, ok, "more than %d bytes are free", free)
+}
+
+func TestVolumeLimitService_ConcurrentReserve(t *testing.T) {
+	vs, _ := newVolumeLimit(t, 100, 1000)
+
+	const uploads = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := vs.Reserve(100)
+			assert.NoError(t, err)
+			if ok {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Test case: the reservations together stay within the limit
+	assert.Equal(t, 9, reserved)
+	assertFree(t, vs, 0)
+}
+
+func TestVolumeLimitService_ReleaseAndCommit(t *testing.T) {
+	vs, _ := newVolumeLimit(t, 100, 1000)
+
+	// Test case: a rejected upload gives its reservation back
+	ok, err := vs.Reserve(500)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assertFree(t, vs, 400)
+	vs.Release(500)
+	assertFree(t, vs, 900)
+
+	// Test case: a saved upload counts with the size written to disk
+	ok, err = vs.Reserve(300)
+	require.NoError(t, err)
+	require.True(t, ok)
+	vs.Commit(300, 332)
+	assertFree(t, vs, 568)
+
+	// Test case: deleted content frees space, but never below an empty folder
+	vs.Remove(332)
+	assertFree(t, vs, 900)
+	vs.Remove(5000)
+	assertFree(t, vs, 1000)
+
+	// Test case: a reservation over the limit is refused
+	ok, err = vs.Reserve(1001)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestVolumeLimitService_Reconcile(t *testing.T) {
+	vs, dir := newVolumeLimit(t, 100, 1000)
+	assertFree(t, vs, 900)
+
+	// The counter drifts from the folder: a file is written without a
+	// commit and another one is committed with the wrong size.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "untracked"), make([]byte, 200), 0o600))
+	ok, err := vs.Reserve(50)
+	require.NoError(t, err)
+	require.True(t, ok)
+	vs.Commit(50, 50)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "committed"), make([]byte, 150), 0o600))
+	assertFree(t, vs, 850)
+
+	// Test case: reconciling counts what is on disk
+	require.NoError(t, vs.Reconcile())
+	assertFree(t, vs, 550)
+
+	// Test case: files in subfolders count and reservations survive
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "thumbnails"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "thumbnails", "small"), make([]byte, 50), 0o600))
+	ok, err = vs.Reserve(100)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, vs.Reconcile())
+	assertFree(t, vs, 400)
+	vs.Release(100)
+	assertFree(t, vs, 500)
+}
+
+func TestVolumeLimitService_CreatesFolder(t *testing.T) {
+	_, dir := newVolumeLimit(t, 0, 10)
+	require.NoError(t, os.Remove(dir))
+	vs := services.NewVolumeLimitService(utils.GetLogger(), dir, 10)
+
+	ok, err := vs.Reserve(10)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.DirExists(t, dir)
+}
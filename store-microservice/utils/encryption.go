@@ -1,31 +1,43 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"errors"
+	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 )
 
+// ctrMagic starts files encrypted with AES-CTR, which can be decrypted from
+// any offset. Files without it were encrypted with AES-CBC before and are
+// still read as a whole.
+var ctrMagic = []byte("AESCTR\x00\x01")
+
+var ctrHeaderSize = int64(len(ctrMagic) + aes.BlockSize)
+
+// EncryptFileBytes saves fileBytes to filePath.encrypted as the magic, a
+// random IV and the AES-CTR ciphertext.
 func EncryptFileBytes(fileBytes []byte, key []byte, filePath string) error {
-	block, err := aes.NewCipher(key)
-	if err != nil {
+	header := make([]byte, ctrHeaderSize)
+	copy(header, ctrMagic)
+	iv := header[len(ctrMagic):]
+	if _, err := rand.Read(iv); err != nil {
 		return err
 	}
-	fullFolderPath := filepath.Join(".", filePath)
-
-	ciphertext := make([]byte, aes.BlockSize+len(fileBytes))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := rand.Read(iv); err != nil {
+	stream, err := NewCTRAt(key, iv, 0)
+	if err != nil {
 		return err
 	}
 
-	mode := cipher.NewCBCEncrypter(block, iv)
-	mode.CryptBlocks(ciphertext[aes.BlockSize:], fileBytes)
+	ciphertext := make([]byte, len(header)+len(fileBytes))
+	copy(ciphertext, header)
+	stream.XORKeyStream(ciphertext[len(header):], fileBytes)
 
-	encryptedFilePath := fullFolderPath + ".encrypted"
+	encryptedFilePath := filepath.Join(".", filePath) + ".encrypted"
 
 	if err := ioutil.WriteFile(encryptedFilePath, ciphertext, 0o644); err != nil {
 		return err
@@ -34,26 +46,138 @@ func EncryptFileBytes(fileBytes []byte, key []byte, filePath string) error {
 	return nil
 }
 
-func DecryptFile(filePath string, key []byte) ([]byte, error) {
+// DecryptFile decrypts a whole file. size is the plaintext size recorded for
+// the file, or -1 when it is not known; it tells the unpadded AES-CBC files
+// of the first releases from the PKCS#7 padded ones that came after them.
+func DecryptFile(filePath string, key []byte, size int64) ([]byte, error) {
 	ciphertext, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
 
+	if bytes.HasPrefix(ciphertext, ctrMagic) && int64(len(ciphertext)) >= ctrHeaderSize {
+		stream, err := NewCTRAt(key, ciphertext[len(ctrMagic):ctrHeaderSize], 0)
+		if err != nil {
+			return nil, err
+		}
+		plaintext := ciphertext[ctrHeaderSize:]
+		stream.XORKeyStream(plaintext, plaintext)
+		return plaintext, nil
+	}
+	return decryptCBC(ciphertext, key, size)
+}
+
+// DecryptFileRange decrypts length bytes from offset, or less at the end of
+// the file. Only the range is read from CTR files; CBC files are decrypted
+// as a whole, with size as for DecryptFile.
+func DecryptFileRange(filePath string, key []byte, offset, length, size int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, ctrHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil || !bytes.HasPrefix(header, ctrMagic) {
+		plaintext, err := DecryptFile(filePath, key, size)
+		if err != nil {
+			return nil, err
+		}
+		if offset > int64(len(plaintext)) {
+			return nil, ErrInvalidRange
+		}
+		return plaintext[offset:min(offset+length, int64(len(plaintext)))], nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	stored := info.Size() - ctrHeaderSize
+	if offset > stored {
+		return nil, ErrInvalidRange
+	}
+	length = min(length, stored-offset)
+
+	ciphertext := make([]byte, length)
+	if _, err := file.ReadAt(ciphertext, ctrHeaderSize+offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	stream, err := NewCTRAt(key, header[len(ctrMagic):], offset)
+	if err != nil {
+		return nil, err
+	}
+	stream.XORKeyStream(ciphertext, ciphertext)
+	return ciphertext, nil
+}
+
+func decryptCBC(ciphertext, key []byte, size int64) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(ciphertext) < aes.BlockSize {
+	if len(ciphertext) < aes.BlockSize || len(ciphertext)%aes.BlockSize != 0 {
 		return nil, errors.New("invalid ciphertext")
 	}
 
 	iv := ciphertext[:aes.BlockSize]
 	ciphertext = ciphertext[aes.BlockSize:]
+	if len(ciphertext) == 0 {
+		// Files saved before padding was added have no content.
+		return ciphertext, nil
+	}
 
 	mode := cipher.NewCBCDecrypter(block, iv)
 	mode.CryptBlocks(ciphertext, ciphertext)
 
-	return ciphertext, nil
+	// Padding makes the plaintext longer than the file, so a plaintext of the
+	// recorded size was saved without padding.
+	if int64(len(ciphertext)) == size {
+		return ciphertext, nil
+	}
+	return unpad(ciphertext, aes.BlockSize)
+}
+
+func unpad(data []byte, blockSize int) ([]byte, error) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > blockSize || n > len(data) {
+		return nil, errors.New("invalid padding")
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, errors.New("invalid padding")
+		}
+	}
+	return data[:len(data)-n], nil
+}
+
+// NewCTRAt returns an AES-CTR stream that starts offset bytes into the key
+// stream for iv, so any part of the data can be encrypted or decrypted on
+// its own.
+func NewCTRAt(key, iv []byte, offset int64) (cipher.Stream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize || offset < 0 {
+		return nil, errors.New("invalid counter")
+	}
+
+	counter := append([]byte{}, iv...)
+	carry := uint64(offset / aes.BlockSize)
+	for i := len(counter) - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(counter[i]) + carry&0xff
+		counter[i] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+
+	stream := cipher.NewCTR(block, counter)
+	skip := make([]byte, offset%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	return stream, nil
 }
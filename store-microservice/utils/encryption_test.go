@@ -0,0 +1,279 @@
+package utils_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"store/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+// sizes are around the AES block size, where padding and counters change.
+var sizes = []int{0, 1, 15, 16, 17, 31, 32, 33, 1000}
+
+func content(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + 3)
+	}
+	return data
+}
+
+// inTempDir runs the test in a temporary folder, because files are saved
+// relative to the working directory.
+func inTempDir(t *testing.T) {
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { _ = os.Chdir(dir) })
+}
+
+// writeCBC saves data the way files were encrypted before AES-CTR: a random
+// IV and the AES-CBC ciphertext of the PKCS#7 padded data.
+func writeCBC(t *testing.T, path string, data []byte) {
+	block, err := aes.NewCipher(testKey)
+	require.NoError(t, err)
+	n := aes.BlockSize - len(data)%aes.BlockSize
+	plaintext := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(n)}, n)...)
+
+	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
+	copy(ciphertext, bytes.Repeat([]byte{0x07}, aes.BlockSize))
+	cipher.NewCBCEncrypter(block, ciphertext[:aes.BlockSize]).CryptBlocks(ciphertext[aes.BlockSize:], plaintext)
+	require.NoError(t, os.WriteFile(path, ciphertext, 0o600))
+}
+
+func TestEncryptFileBytes_RoundTrip(t *testing.T) {
+	inTempDir(t)
+
+	for _, size := range sizes {
+		data := content(size)
+		require.NoError(t, utils.EncryptFileBytes(data, testKey, "file"))
+
+		decrypted, err := utils.DecryptFile("file.encrypted", testKey, int64(size))
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, data, decrypted, "size %d", size)
+	}
+}
+
+func TestDecryptFile_LegacyCBC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.encrypted")
+
+	for _, size := range sizes {
+		data := content(size)
+		writeCBC(t, path, data)
+
+		decrypted, err := utils.DecryptFile(path, testKey, int64(size))
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, data, decrypted, "size %d", size)
+	}
+
+	// Test case: files saved before padding are only an IV
+	require.NoError(t, os.WriteFile(path, make([]byte, aes.BlockSize), 0o600))
+	decrypted, err := utils.DecryptFile(path, testKey, 0)
+	require.NoError(t, err)
+	assert.Empty(t, decrypted)
+
+	// Test case: a cut off file is rejected
+	writeCBC(t, path, content(20))
+	ciphertext, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, ciphertext[:len(ciphertext)-1], 0o600))
+	_, err = utils.DecryptFile(path, testKey, 20)
+	assert.Error(t, err)
+}
+
+// writeUnpaddedCBC saves data the way the first releases did, before
+// padding: a random IV and the AES-CBC ciphertext of the data, which had to
+// be a whole number of blocks.
+func writeUnpaddedCBC(t *testing.T, path string, data []byte) {
+	require.Zero(t, len(data)%aes.BlockSize)
+	block, err := aes.NewCipher(testKey)
+	require.NoError(t, err)
+
+	ciphertext := make([]byte, aes.BlockSize+len(data))
+	_, err = rand.Read(ciphertext[:aes.BlockSize])
+	require.NoError(t, err)
+	cipher.NewCBCEncrypter(block, ciphertext[:aes.BlockSize]).CryptBlocks(ciphertext[aes.BlockSize:], data)
+	require.NoError(t, os.WriteFile(path, ciphertext, 0o600))
+}
+
+// unpaddedContent are block aligned files; the last one ends in a byte that
+// reads as valid padding.
+var unpaddedContent = [][]byte{
+	content(16),
+	content(32),
+	content(1008),
+	append(content(31), 0x01),
+}
+
+func TestDecryptFile_UnpaddedCBC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unpadded.encrypted")
+
+	for _, data := range unpaddedContent {
+		writeUnpaddedCBC(t, path, data)
+
+		decrypted, err := utils.DecryptFile(path, testKey, int64(len(data)))
+		require.NoError(t, err, "size %d", len(data))
+		assert.Equal(t, data, decrypted, "size %d", len(data))
+	}
+}
+
+// ctrAt is the AES-CTR stream of iv offset bytes in, with the counter
+// computed as a 128 bit number.
+func ctrAt(t *testing.T, iv []byte, offset int64) cipher.Stream {
+	block, err := aes.NewCipher(testKey)
+	require.NoError(t, err)
+
+	counter := new(big.Int).SetBytes(iv)
+	counter.Add(counter, big.NewInt(offset/aes.BlockSize))
+	counter.Mod(counter, new(big.Int).Lsh(big.NewInt(1), 128))
+	stream := cipher.NewCTR(block, counter.FillBytes(make([]byte, aes.BlockSize)))
+	skip := make([]byte, offset%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	return stream
+}
+
+func TestNewCTRAt(t *testing.T) {
+	iv := func(tail ...byte) []byte {
+		return append(bytes.Repeat([]byte{0x10}, aes.BlockSize-len(tail)), tail...)
+	}
+
+	tests := []struct {
+		name   string
+		iv     []byte
+		offset int64
+	}{
+		{"start", iv(), 0},
+		{"within the first block", iv(), 5},
+		{"second block", iv(), 16},
+		{"last byte carries at 2^8", iv(0xff), 16},
+		{"carry at 2^8 mid block", iv(0xfe), 2*aes.BlockSize + 3},
+		{"carry over two bytes at 2^16", iv(0xff, 0xff), 16},
+		{"sum of byte and carry over 0xff", iv(0x80, 0xf0), 0x1234 * aes.BlockSize},
+		{"large offset", iv(0x01, 0x02, 0x03), 1<<40 + 7},
+		{"carry at 2^64 into the upper half", iv(0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff), 16},
+		{"carry at 2^64 from a large offset", iv(0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00), 0x200*aes.BlockSize + 9},
+		{"whole counter wraps around", bytes.Repeat([]byte{0xff}, aes.BlockSize), 16},
+	}
+	data := content(100)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := make([]byte, len(data))
+			ctrAt(t, tt.iv, tt.offset).XORKeyStream(want, data)
+
+			stream, err := utils.NewCTRAt(testKey, tt.iv, tt.offset)
+			require.NoError(t, err)
+			got := make([]byte, len(data))
+			stream.XORKeyStream(got, data)
+			assert.Equal(t, want, got)
+		})
+	}
+
+	// Test case: seeking matches streaming from the start
+	full := make([]byte, 70*aes.BlockSize)
+	stream, err := utils.NewCTRAt(testKey, iv(0xff, 0xfe), 0)
+	require.NoError(t, err)
+	stream.XORKeyStream(full, full)
+	for _, offset := range []int64{0, 1, 15, 16, 17, 33 * aes.BlockSize, 69*aes.BlockSize + 15} {
+		stream, err := utils.NewCTRAt(testKey, iv(0xff, 0xfe), offset)
+		require.NoError(t, err)
+		part := make([]byte, int64(len(full))-offset)
+		stream.XORKeyStream(part, part)
+		assert.Equal(t, full[offset:], part, "offset %d", offset)
+	}
+
+	// Test case: invalid counters are rejected
+	_, err = utils.NewCTRAt(testKey, iv()[:8], 0)
+	assert.Error(t, err)
+	_, err = utils.NewCTRAt(testKey, iv(), -1)
+	assert.Error(t, err)
+}
+
+// rangeTests are ranges of a 1000 byte file.
+var rangeTests = []struct {
+	name   string
+	offset int64
+	length int64
+	want   []byte
+	err    error
+}{
+	{"start", 0, 10, content(1000)[:10], nil},
+	{"across a block", 15, 2, content(1000)[15:17], nil},
+	{"middle", 333, 100, content(1000)[333:433], nil},
+	{"empty", 17, 0, []byte{}, nil},
+	{"cut at the end", 995, 10, content(1000)[995:], nil},
+	{"whole file", 0, 1000, content(1000), nil},
+	{"past the end", 0, 5000, content(1000), nil},
+	{"at the end", 1000, 10, []byte{}, nil},
+	{"after the end", 1001, 1, nil, utils.ErrInvalidRange},
+	{"negative offset", -1, 10, nil, utils.ErrInvalidRange},
+	{"negative length", 0, -1, nil, utils.ErrInvalidRange},
+}
+
+func TestDecryptFileRange(t *testing.T) {
+	inTempDir(t)
+	require.NoError(t, utils.EncryptFileBytes(content(1000), testKey, "file"))
+
+	for _, tt := range rangeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := utils.DecryptFileRange("file.encrypted", testKey, tt.offset, tt.length, 1000)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// Test case: a file without content has only the empty range
+	require.NoError(t, utils.EncryptFileBytes(nil, testKey, "empty"))
+	got, err := utils.DecryptFileRange("empty.encrypted", testKey, 0, 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	_, err = utils.DecryptFileRange("empty.encrypted", testKey, 1, 10, 0)
+	assert.Equal(t, utils.ErrInvalidRange, err)
+}
+
+func TestDecryptFileRange_LegacyCBC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.encrypted")
+	writeCBC(t, path, content(1000))
+
+	for _, tt := range rangeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := utils.DecryptFileRange(path, testKey, tt.offset, tt.length, 1000)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// Test case: files shorter than the CTR header are read as CBC
+	for _, size := range []int{0, 1, 7} {
+		writeCBC(t, path, content(size))
+		got, err := utils.DecryptFileRange(path, testKey, 0, 100, int64(size))
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, content(size), got, "size %d", size)
+	}
+	require.NoError(t, os.WriteFile(path, make([]byte, aes.BlockSize), 0o600))
+	got, err := utils.DecryptFileRange(path, testKey, 0, 100, 0)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	// Test case: unpadded files are read whole by their recorded size
+	for _, data := range unpaddedContent {
+		writeUnpaddedCBC(t, path, data)
+		got, err := utils.DecryptFileRange(path, testKey, 0, 5000, int64(len(data)))
+		require.NoError(t, err, "size %d", len(data))
+		assert.Equal(t, data, got, "size %d", len(data))
+	}
+	writeUnpaddedCBC(t, path, content(1008))
+	got, err = utils.DecryptFileRange(path, testKey, 1000, 100, 1008)
+	require.NoError(t, err)
+	assert.Equal(t, content(1008)[1000:], got)
+}
@@ -0,0 +1,28 @@
+package utils
+
+import "errors"
+
+var (
+	ErrFileNotFound    = errors.New("file not found")
+	ErrUnknownAction   = errors.New("unknown file action")
+	ErrInvalidCommand  = errors.New("invalid file command")
+	ErrFileNotInTrash  = errors.New("file is not in trash")
+	ErrFileNameInUse   = errors.New("a file with this name already exists")
+	ErrVersionNotFound = errors.New("file version not found")
+	ErrTagNotFound     = errors.New("tag not found")
+	ErrTagNameInUse    = errors.New("a tag with this name already exists")
+
+	ErrInvalidArchive  = errors.New("invalid or unsupported archive")
+	ErrArchiveTooLarge = errors.New("archive exceeds the extraction limits")
+
+	ErrInvalidRange = errors.New("range is outside the file")
+
+	ErrAccessEntryNotFound = errors.New("access entry not found")
+
+	ErrFolderNotFound  = errors.New("folder not found")
+	ErrFolderNameInUse = errors.New("a folder with this name already exists")
+	ErrFolderNotEmpty  = errors.New("folder is not empty")
+
+	ErrThumbnailNotFound = errors.New("thumbnail not found")
+	ErrThumbnailPending  = errors.New("thumbnail is not ready yet")
+)